@@ -4,12 +4,16 @@ import (
 	"github.com/gin-gonic/gin"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"log/slog"
+	"os"
 	docs "sapphire-server/cmd/docs"
 	"sapphire-server/internal/conf"
 	"sapphire-server/internal/cron"
+	"sapphire-server/internal/domain"
 	"sapphire-server/internal/infra"
 	"sapphire-server/internal/middleware"
 	"sapphire-server/internal/router"
+	"sapphire-server/internal/storage"
+	"sapphire-server/pkg/util"
 )
 
 import "github.com/swaggo/files" // swagger embed files
@@ -26,16 +30,46 @@ func main() {
 	// 初始化并读取配置
 	conf.InitConfig()
 	slog.Info("Server started")
+	err = util.InitJWT(conf.GetAuthConfig())
+	if err != nil {
+		panic(err)
+	}
 
 	// 连接数据库
 	err = infra.InitDB()
 	if err != nil {
 		panic(err)
 	}
+
+	// 数据库迁移子命令，执行完直接退出
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = runMigrate(os.Args[2:])
+		if err != nil {
+			slog.Error("migrate failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	err = infra.InitRedis()
 	if err != nil {
 		panic(err)
 	}
+	err = storage.InitStorage()
+	if err != nil {
+		panic(err)
+	}
+	uploadConf := conf.GetUploadConfig()
+	err = domain.InitResumableStore(uploadConf.ResumableDir, uploadConf.ResumableTTL)
+	if err != nil {
+		panic(err)
+	}
+	embeddingConf := conf.GetEmbeddingConfig()
+	domain.InitTaskQueue(domain.TaskQueueOptions{
+		LeaseTTL:    embeddingConf.LeaseTTL,
+		MaxLeaseTTL: embeddingConf.MaxLeaseTTL,
+		MaxAttempts: embeddingConf.MaxAttempts,
+	})
 
 	// init gin
 	engine := gin.Default()
@@ -46,14 +80,22 @@ func main() {
 	// 调用 internal/router/user.go 中的 NewUserRouter 方法
 	router.NewUserRouter(engine)
 	router.NewTaskRouter(engine)
+	router.NewAPIKeyRouter(engine)
+	router.NewSamRouter(engine)
 	router.NewImgRouter(engine)
 	router.NewDatasetRouter(engine)
+	router.NewTusRouter(engine)
 	router.NewAnnotationRouter(engine)
 	router.NewTestRouter(engine)
 	router.NewScoreRouter(engine)
 	router.NewMessageRouter(engine)
 	router.NewDiscussionRouter(engine)
 
+	// 本地存储时由服务自身提供文件访问
+	if local, ok := storage.Default.(*storage.LocalStore); ok {
+		engine.Static("/storage", local.Root)
+	}
+
 	engine.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	// 启动定时任务
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sapphire-server/internal/infra"
+	"sapphire-server/internal/migrate"
+	"strconv"
+)
+
+const migrateUsage = `usage: sapphire-server migrate <command>
+
+commands:
+  up          执行所有未执行的迁移
+  down [n]    回滚最近的 n 个迁移，默认为 1
+  status      查看迁移状态`
+
+// runMigrate 处理 migrate 子命令
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		fmt.Println(migrateUsage)
+		return nil
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrate.Up(infra.DB)
+		for _, m := range applied {
+			fmt.Printf("applied  %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step count: %s", args[1])
+			}
+			steps = n
+		}
+		rolledBack, err := migrate.Down(infra.DB, steps)
+		for _, m := range rolledBack {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(rolledBack) == 0 {
+			fmt.Println("no applied migrations")
+		}
+	case "status":
+		statuses, err := migrate.List(infra.DB)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if s.Applied {
+				fmt.Printf("[x] %04d_%s  (%s)\n", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("[ ] %04d_%s\n", s.Version, s.Name)
+			}
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return fmt.Errorf("unknown migrate command: %s", args[0])
+	}
+	return nil
+}
@@ -5,12 +5,21 @@ import (
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 	"log"
+	"os"
+	"path/filepath"
+	"time"
 )
 
 type Config struct {
 	Server     ServerConfig
 	Datasource DataSourceConfig
 	Image      ImgConfig
+	Storage    StorageConfig
+	Auth       AuthConfig
+	Upload     UploadConfig
+	Thumbnail  ThumbnailConfig
+	Embedding  EmbeddingConfig
+	Consensus  ConsensusConfig
 }
 
 type ServerConfig struct {
@@ -45,6 +54,122 @@ type ImgConfig struct {
 	Auth      string
 }
 
+// StorageConfig 对象存储配置
+type StorageConfig struct {
+	// Driver 存储驱动，可选 local、webdav、s3，默认为 webdav
+	Driver string
+	Local  LocalStorageConfig
+	S3     S3StorageConfig
+	// FetchHosts 除存储自身的域名外，允许服务端下载图片的域名，用于通过地址添加的外部图片
+	FetchHosts []string
+}
+
+type LocalStorageConfig struct {
+	// Root 文件保存的根目录
+	Root string
+	// BaseUrl 对外访问的地址前缀
+	BaseUrl string
+}
+
+type S3StorageConfig struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// BaseUrl 对外访问的地址前缀，为空时使用 Endpoint/Bucket
+	BaseUrl string
+	// PathStyle 是否使用 path-style 的地址，MinIO 等自建服务一般需要开启
+	PathStyle bool
+}
+
+// AuthConfig 登录凭证配置
+type AuthConfig struct {
+	Issuer string
+	// AccessTokenTTL access token 有效期，默认 15 分钟
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL refresh token 有效期，默认 7 天
+	RefreshTokenTTL time.Duration
+	// ActiveKid 签发新 token 使用的 key，其余 key 只用于校验，便于轮换
+	ActiveKid string
+	Keys      []SigningKeyConfig
+}
+
+type SigningKeyConfig struct {
+	Kid string
+	// Algorithm 可选 HS256、RS256
+	Algorithm string
+	// Secret HS256 使用的密钥
+	Secret string
+	// PrivateKeyFile RS256 私钥，PEM 格式，只用于校验的旧 key 可以不配置
+	PrivateKeyFile string
+	// PublicKeyFile RS256 公钥，PEM 格式
+	PublicKeyFile string
+}
+
+// UploadConfig 上传压缩包的限制
+type UploadConfig struct {
+	// TempDir 解压使用的临时目录，为空时使用系统临时目录
+	TempDir string
+	// MaxArchiveSize 上传的压缩包大小上限，默认 2GB
+	MaxArchiveSize int64
+	// MaxEntries 压缩包中文件数量上限，默认 10000
+	MaxEntries int
+	// MaxFileSize 单个文件解压后的大小上限，默认 50MB
+	MaxFileSize int64
+	// MaxTotalSize 解压后的总大小上限，默认 4GB
+	MaxTotalSize int64
+	// Workers 同时处理的上传任务数，默认 2
+	Workers int
+	// WorkspaceTTL 任务结束后临时目录的保留时间，过期后不能重试，默认 24 小时
+	WorkspaceTTL time.Duration
+	// ResumableDir 可续传上传保存分片的目录，默认为 TempDir 下的 sapphire-tus
+	ResumableDir string
+	// ResumableTTL 可续传上传最后一次写入后的保留时间，默认 24 小时
+	ResumableTTL time.Duration
+}
+
+// ThumbnailConfig 缩略图和预览图配置
+type ThumbnailConfig struct {
+	// Sizes 缩略图尺寸，第一个作为默认缩略图，默认为 small 256、medium 512
+	Sizes []ThumbnailSize
+	// PreviewSize 预览图的最大边长，默认 1600
+	PreviewSize int
+	// Quality JPEG 质量，默认 80
+	Quality int
+	// Workers 同时处理的图片数，默认 4
+	Workers int
+}
+
+// EmbeddingConfig 嵌入任务队列配置
+type EmbeddingConfig struct {
+	// LeaseTTL worker 认领任务后的租约时长，超过时间没有心跳时任务重新排队，默认 2 分钟
+	LeaseTTL time.Duration
+	// MaxLeaseTTL worker 可以申请的最长租约，默认 30 分钟
+	MaxLeaseTTL time.Duration
+	// MaxAttempts 每个任务最多执行的次数，默认 3
+	MaxAttempts int
+	// BatchSize 每次为未嵌入的图片创建的任务数，默认 100
+	BatchSize int
+	// OnnxID 数据集没有选用模型时使用的默认模型，为 0 时使用最新注册且没有废弃的模型
+	OnnxID uint
+}
+
+// ConsensusConfig 多人标注合并配置
+type ConsensusConfig struct {
+	// IoU 同一个标签的形状 IoU 达到该值时才能匹配为同一个目标，默认 0.5
+	IoU float64
+	// Agreement 目标的标注员权重占比达到该值时才保留，默认 0.5
+	Agreement float64
+}
+
+type ThumbnailSize struct {
+	Name string
+	// Width 和 Height 为最大宽高，等比缩放
+	Width  int
+	Height int
+}
+
 var Conf *Config
 
 func InitConfig() {
@@ -95,9 +220,102 @@ func GetRedisConfig() string {
 
 }
 
-func GetImgConfig() string {
-	return fmt.Sprintf("svrUrl: %s; directUrl: %s; auth string: %s;",
-		Conf.Image.SvrUrl,
-		Conf.Image.DirectUrl,
-		Conf.Image.Auth)
+func GetImgConfig() ImgConfig {
+	return Conf.Image
+}
+
+func GetAuthConfig() AuthConfig {
+	return Conf.Auth
+}
+
+func GetStorageConfig() StorageConfig {
+	return Conf.Storage
+}
+
+// GetUploadConfig 返回上传配置，未配置的项使用默认值
+func GetUploadConfig() UploadConfig {
+	c := Conf.Upload
+	if c.MaxArchiveSize <= 0 {
+		c.MaxArchiveSize = 2 << 30
+	}
+	if c.MaxEntries <= 0 {
+		c.MaxEntries = 10000
+	}
+	if c.MaxFileSize <= 0 {
+		c.MaxFileSize = 50 << 20
+	}
+	if c.MaxTotalSize <= 0 {
+		c.MaxTotalSize = 4 << 30
+	}
+	if c.Workers <= 0 {
+		c.Workers = 2
+	}
+	if c.WorkspaceTTL <= 0 {
+		c.WorkspaceTTL = 24 * time.Hour
+	}
+	if c.ResumableDir == "" {
+		tempDir := c.TempDir
+		if tempDir == "" {
+			tempDir = os.TempDir()
+		}
+		c.ResumableDir = filepath.Join(tempDir, "sapphire-tus")
+	}
+	if c.ResumableTTL <= 0 {
+		c.ResumableTTL = 24 * time.Hour
+	}
+	return c
+}
+
+// GetThumbnailConfig 返回缩略图配置，未配置的项使用默认值
+func GetThumbnailConfig() ThumbnailConfig {
+	c := Conf.Thumbnail
+	if len(c.Sizes) == 0 {
+		c.Sizes = []ThumbnailSize{
+			{Name: "small", Width: 256, Height: 256},
+			{Name: "medium", Width: 512, Height: 512},
+		}
+	}
+	if c.PreviewSize <= 0 {
+		c.PreviewSize = 1600
+	}
+	if c.Quality <= 0 || c.Quality > 100 {
+		c.Quality = 80
+	}
+	if c.Workers <= 0 {
+		c.Workers = 4
+	}
+	return c
+}
+
+// GetEmbeddingConfig 返回嵌入任务队列配置，未配置的项使用默认值
+func GetEmbeddingConfig() EmbeddingConfig {
+	c := Conf.Embedding
+	if c.LeaseTTL <= 0 {
+		c.LeaseTTL = 2 * time.Minute
+	}
+	if c.MaxLeaseTTL <= 0 {
+		c.MaxLeaseTTL = 30 * time.Minute
+	}
+	if c.MaxLeaseTTL < c.LeaseTTL {
+		c.MaxLeaseTTL = c.LeaseTTL
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 3
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	return c
+}
+
+// GetConsensusConfig 返回多人标注合并配置，未配置的项使用默认值
+func GetConsensusConfig() ConsensusConfig {
+	c := Conf.Consensus
+	if c.IoU <= 0 || c.IoU > 1 {
+		c.IoU = 0.5
+	}
+	if c.Agreement <= 0 || c.Agreement > 1 {
+		c.Agreement = 0.5
+	}
+	return c
 }
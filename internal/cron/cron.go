@@ -3,24 +3,36 @@ package cron
 type Service struct {
 	// 保存各类 cron
 	EmbeddingCron *EmbeddingCron
+	UploadCron    *UploadCron
+	// DerivativeCron 生成缩略图
+	DerivativeCron *DerivativeCron
 }
 
 func NewCronService() *Service {
 	embeddingCron := NewEmbeddingCron()
 
 	return &Service{
-		EmbeddingCron: embeddingCron,
+		EmbeddingCron:  embeddingCron,
+		UploadCron:     NewUploadCron(),
+		DerivativeCron: NewDerivativeCron(),
 	}
 }
 
 func (c *Service) Init() {
 	c.EmbeddingCron.Init()
+	c.UploadCron.Init()
+	c.DerivativeCron.Init()
 }
 
 func (c *Service) Stop() {
 	// 销毁各类 cron
+	c.EmbeddingCron.Stop()
+	c.UploadCron.Stop()
+	c.DerivativeCron.Stop()
 }
 
 func (c *Service) Start() {
 	c.EmbeddingCron.Start()
+	c.UploadCron.Start()
+	c.DerivativeCron.Start()
 }
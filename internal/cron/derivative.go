@@ -0,0 +1,99 @@
+package cron
+
+import (
+	"context"
+	"github.com/robfig/cron/v3"
+	"log/slog"
+	"sapphire-server/internal/conf"
+	"sapphire-server/internal/domain"
+	"sync"
+)
+
+// DerivativeCron 为新加入的图片生成缩略图和预览图
+// 所有加入数据集的图片都会被处理，包括上传、导入和通过 URL 添加的图片
+type DerivativeCron struct {
+	Cron *cron.Cron
+
+	mu      sync.Mutex
+	running bool
+}
+
+func NewDerivativeCron() *DerivativeCron {
+	return &DerivativeCron{}
+}
+
+func (d *DerivativeCron) Init() {
+	slog.Info("Derivative cron is initializing")
+	thumbConf := conf.GetThumbnailConfig()
+	opts := domain.DerivativeOptions{
+		PreviewSize: thumbConf.PreviewSize,
+		Quality:     thumbConf.Quality,
+	}
+	for _, size := range thumbConf.Sizes {
+		opts.Sizes = append(opts.Sizes, domain.DerivativeSize{Name: size.Name, Width: size.Width, Height: size.Height})
+	}
+
+	d.Cron = cron.New(cron.WithSeconds())
+	d.Cron.AddFunc("@every 5s", func() {
+		// 上一轮还没有结束时跳过
+		d.mu.Lock()
+		if d.running {
+			d.mu.Unlock()
+			return
+		}
+		d.running = true
+		d.mu.Unlock()
+		defer func() {
+			d.mu.Lock()
+			d.running = false
+			d.mu.Unlock()
+		}()
+
+		d.process(opts, thumbConf.Workers)
+	})
+}
+
+// process 认领一批图片并发处理，直到没有待处理的图片
+func (d *DerivativeCron) process(opts domain.DerivativeOptions, workers int) {
+	ctx := context.Background()
+	for {
+		images, err := domain.ClaimPendingDerivatives(ctx, workers*4)
+		if err != nil {
+			slog.Error("Failed to claim images for derivatives", "err", err)
+			return
+		}
+		if len(images) == 0 {
+			return
+		}
+
+		var wg sync.WaitGroup
+		ch := make(chan int)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range ch {
+					err := domain.GenerateDerivatives(ctx, &images[i], opts)
+					if err != nil {
+						continue
+					}
+					slog.Debug("Derivatives generated", "imageID", images[i].ID)
+				}
+			}()
+		}
+		for i := range images {
+			ch <- i
+		}
+		close(ch)
+		wg.Wait()
+	}
+}
+
+func (d *DerivativeCron) Start() {
+	slog.Info("Derivative cron is starting")
+	d.Cron.Start()
+}
+
+func (d *DerivativeCron) Stop() {
+	d.Cron.Stop()
+}
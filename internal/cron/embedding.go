@@ -1,70 +1,89 @@
 package cron
 
 import (
+	"context"
 	"github.com/robfig/cron/v3"
 	"log/slog"
+	"sapphire-server/internal/conf"
 	"sapphire-server/internal/domain"
-	"time"
+	"sync"
 )
 
+// EmbeddingCron 为未嵌入的图片创建嵌入任务，回收租约过期的任务，并结束已经完成的重新嵌入活动
+// 嵌入由 worker 通过 /task/claim 认领后执行，可以有多个 worker 同时工作
 type EmbeddingCron struct {
-	// Interval of the embedding cron
-	Interval int
-	// 启动等待时间
-	WaitTime      int
-	Cron          *cron.Cron
-	DatasetDomain *domain.Dataset
-	IsEmbedding   bool
+	Cron *cron.Cron
+
+	mu      sync.Mutex
+	running bool
 }
 
 func NewEmbeddingCron() *EmbeddingCron {
-	cron := &EmbeddingCron{
-		Interval:      60,
-		WaitTime:      10,
-		DatasetDomain: domain.NewDatasetDomain(),
-	}
-
-	return cron
+	return &EmbeddingCron{}
 }
 
 func (e *EmbeddingCron) Init() {
 	slog.Info("Embedding cron is initializing")
 	e.Cron = cron.New(cron.WithSeconds())
 	e.Cron.AddFunc("@every 10s", func() {
-		images, err := e.DatasetDomain.ListAllNotEmbeddedImg(1)
-		if err != nil {
-			slog.Error("Failed to list all not embedded images")
-			return
-		}
-		if len(images) == 0 {
+		// 上一轮还没有结束时跳过
+		e.mu.Lock()
+		if e.running {
+			e.mu.Unlock()
 			return
 		}
-		if e.IsEmbedding {
-			slog.Debug("Embedding is running")
-			return
-		}
-		// Start embedding
-		e.IsEmbedding = true
-		go func() {
-			// 等待几分钟, 模拟embedding过程
-			slog.Info("Start embedding image", images[0].ID)
-			// 线程休眠
-			time.Sleep(1 * time.Minute)
-			for _, img := range images {
-				slog.Info("Start embedding image", img.ID)
-				err := e.DatasetDomain.EmbeddingImg(img.ID)
-				if err != nil {
-					slog.Error("Failed to embedding image", img.ID)
-					continue
-				}
-				slog.Info("Embedding image", img.ID, "successfully")
-			}
-			e.IsEmbedding = false
+		e.running = true
+		e.mu.Unlock()
+		defer func() {
+			e.mu.Lock()
+			e.running = false
+			e.mu.Unlock()
 		}()
+
+		e.process(conf.GetEmbeddingConfig())
 	})
 }
 
+func (e *EmbeddingCron) process(embeddingConf conf.EmbeddingConfig) {
+	ctx := context.Background()
+	count, err := domain.RequeueExpiredTasks(ctx)
+	if err != nil {
+		slog.Error("Failed to requeue expired tasks", "err", err)
+	} else if count > 0 {
+		slog.Info("Requeued expired tasks", "count", count)
+	}
+
+	finished, err := domain.FinishEmbeddingCampaigns(ctx)
+	if err != nil {
+		slog.Error("Failed to finish embedding campaigns", "err", err)
+	} else if finished > 0 {
+		slog.Info("Finished embedding campaigns", "count", finished)
+	}
+
+	// 选用了模型的数据集使用自己的模型，没有可用的默认模型时只处理这些数据集
+	onnxID := embeddingConf.OnnxID
+	if onnxID == 0 {
+		onnxID, err = domain.LatestSamID(ctx)
+		if err != nil {
+			slog.Error("Failed to load sam model", "err", err)
+			return
+		}
+	}
+	created, err := domain.EnqueueEmbeddingTasks(ctx, onnxID, embeddingConf.BatchSize)
+	if err != nil {
+		slog.Error("Failed to create embedding tasks", "err", err)
+		return
+	}
+	if created > 0 {
+		slog.Info("Created embedding tasks", "count", created)
+	}
+}
+
 func (e *EmbeddingCron) Start() {
 	slog.Info("Embedding cron is starting")
 	e.Cron.Start()
 }
+
+func (e *EmbeddingCron) Stop() {
+	e.Cron.Stop()
+}
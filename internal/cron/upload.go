@@ -0,0 +1,110 @@
+package cron
+
+import (
+	"context"
+	"github.com/robfig/cron/v3"
+	"log/slog"
+	"sapphire-server/internal/conf"
+	"sapphire-server/internal/domain"
+	"sapphire-server/pkg/util"
+	"sync"
+	"time"
+)
+
+// UploadCron 处理上传任务的后台 worker，并定期清理过期的临时目录和可续传上传
+type UploadCron struct {
+	Cron *cron.Cron
+	// Workers 同时处理的任务数
+	Workers int
+
+	mu      sync.Mutex
+	running int
+}
+
+func NewUploadCron() *UploadCron {
+	return &UploadCron{}
+}
+
+func (u *UploadCron) Init() {
+	slog.Info("Upload cron is initializing")
+	uploadConf := conf.GetUploadConfig()
+	u.Workers = uploadConf.Workers
+	limits := util.UnzipLimits{
+		MaxEntries:   uploadConf.MaxEntries,
+		MaxFileSize:  uploadConf.MaxFileSize,
+		MaxTotalSize: uploadConf.MaxTotalSize,
+	}
+
+	// 上次退出时中断的任务重新排队
+	err := domain.RecoverUploadJobs(context.Background())
+	if err != nil {
+		slog.Error("Failed to recover upload jobs", "err", err)
+	}
+
+	u.Cron = cron.New(cron.WithSeconds())
+	u.Cron.AddFunc("@every 2s", func() {
+		u.dispatch(limits)
+	})
+	u.Cron.AddFunc("@every 1h", func() {
+		err := domain.CleanupUploadJobs(context.Background(), uploadConf.WorkspaceTTL)
+		if err != nil {
+			slog.Error("Failed to cleanup upload jobs", "err", err)
+		}
+	})
+	// 清理过期未完成的可续传上传
+	u.Cron.AddFunc("@every 10m", func() {
+		if domain.DefaultResumableStore == nil {
+			return
+		}
+		count, err := domain.DefaultResumableStore.ExpireStale(time.Now())
+		if err != nil {
+			slog.Error("Failed to expire resumable uploads", "err", err)
+			return
+		}
+		if count > 0 {
+			slog.Info("Expired resumable uploads", "count", count)
+		}
+	})
+}
+
+// dispatch 认领排队中的任务，直到达到并发上限
+func (u *UploadCron) dispatch(limits util.UnzipLimits) {
+	for {
+		u.mu.Lock()
+		if u.running >= u.Workers {
+			u.mu.Unlock()
+			return
+		}
+		u.running++
+		u.mu.Unlock()
+
+		job, err := domain.ClaimUploadJob(context.Background())
+		if err != nil || job == nil {
+			if err != nil {
+				slog.Error("Failed to claim upload job", "err", err)
+			}
+			u.release()
+			return
+		}
+		slog.Info("Start upload job", "jobID", job.ID, "datasetID", job.DatasetID)
+		go func() {
+			defer u.release()
+			job.Run(context.Background(), limits)
+		}()
+	}
+}
+
+func (u *UploadCron) release() {
+	u.mu.Lock()
+	u.running--
+	u.mu.Unlock()
+}
+
+func (u *UploadCron) Start() {
+	slog.Info("Upload cron is starting")
+	u.Cron.Start()
+}
+
+func (u *UploadCron) Stop() {
+	u.Cron.Stop()
+}
@@ -1,16 +1,23 @@
 package dao
 
 import (
+	"context"
 	"sapphire-server/internal/infra"
 )
 
-func Save[T any](data T) error {
+// WithTx 在事务中执行 fn，fn 中应使用传入的 ctx 调用 dao 函数
+// fn 返回错误时整个事务回滚
+func WithTx(ctx context.Context, fn func(tx context.Context) error) error {
+	return infra.WithTx(ctx, fn)
+}
+
+func Save[T any](ctx context.Context, data T) error {
 	var err error
 	// 根据有无id字段判断是插入还是更新
 	if infra.HasID(data) {
-		err = infra.Update(data)
+		err = infra.Update(ctx, data)
 	} else {
-		err = infra.Insert(data)
+		err = infra.Insert(ctx, data)
 	}
 	if err != nil {
 		return err
@@ -18,63 +25,86 @@ func Save[T any](data T) error {
 	return nil
 }
 
-func SaveAll[T any](data []T) error {
+func SaveAll[T any](ctx context.Context, data []T) error {
 	var err error
-	err = infra.InsertMany(data)
+	err = infra.InsertMany(ctx, data)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func Delete[T any](data T) error {
-	err := infra.Delete(data)
+func Delete[T any](ctx context.Context, data T) error {
+	err := infra.Delete(ctx, data)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func FindOne[T any](conditions ...interface{}) (*T, error) {
-	result, err := infra.FindOne[T](conditions...)
+func FindOne[T any](ctx context.Context, conditions ...interface{}) (*T, error) {
+	result, err := infra.FindOne[T](ctx, conditions...)
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-func First[T any](conditions ...interface{}) (*T, error) {
-	result, err := infra.First[T](conditions...)
+func First[T any](ctx context.Context, conditions ...interface{}) (*T, error) {
+	result, err := infra.First[T](ctx, conditions...)
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-func FindAll[T any](conditions ...interface{}) ([]T, error) {
-	all, err := infra.FindAll[T](conditions...)
+func FindAll[T any](ctx context.Context, conditions ...interface{}) ([]T, error) {
+	all, err := infra.FindAll[T](ctx, conditions...)
 	if err != nil {
 		return nil, err
 	}
 	return all, nil
 }
 
-func FindPage[T any](page int, pageSize int, conditions ...interface{}) ([]T, error) {
-	return nil, nil
-}
-
-func Query[T any](sql string, args ...interface{}) ([]T, error) {
-	result, err := infra.Query[T](sql, args...)
+func Query[T any](ctx context.Context, sql string, args ...interface{}) ([]T, error) {
+	result, err := infra.Query[T](ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-func Modify[T any](data T, column string, value string) error {
-	err := infra.UpdateSingleColumn(data, column, value)
+// Exec 执行原生 SQL，返回受影响的行数
+func Exec(ctx context.Context, sql string, args ...interface{}) (int64, error) {
+	rows, err := infra.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
+
+func Modify[T any](ctx context.Context, data T, column string, value string) error {
+	err := infra.UpdateSingleColumn(ctx, data, column, value)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// UpdateWhere 按条件更新多列，返回受影响的行数，可用于状态的条件更新
+func UpdateWhere[T any](ctx context.Context, values map[string]interface{}, query interface{}, args ...interface{}) (int64, error) {
+	rows, err := infra.UpdateWhere[T](ctx, values, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
+
+// DeleteWhere 按条件删除，返回受影响的行数
+func DeleteWhere[T any](ctx context.Context, query interface{}, args ...interface{}) (int64, error) {
+	rows, err := infra.DeleteWhere[T](ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,336 @@
+package dao
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"sapphire-server/internal/data/dto"
+	"sapphire-server/internal/infra"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// 过滤条件支持的操作符
+const (
+	OpEq   = "eq"
+	OpNe   = "ne"
+	OpGt   = "gt"
+	OpGte  = "gte"
+	OpLt   = "lt"
+	OpLte  = "lte"
+	OpLike = "like"
+	OpIn   = "in"
+)
+
+var opSQL = map[string]string{
+	OpEq:   "=",
+	OpNe:   "<>",
+	OpGt:   ">",
+	OpGte:  ">=",
+	OpLt:   "<",
+	OpLte:  "<=",
+	OpLike: "LIKE",
+	OpIn:   "IN",
+}
+
+// ErrInvalidPageRequest 分页参数不合法
+var ErrInvalidPageRequest = errors.New("invalid page request")
+
+// Page 分页查询结果
+type Page[T any] struct {
+	Items []T
+	dto.PageMeta
+}
+
+// SortField 排序字段
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Filter 过滤条件
+type Filter struct {
+	Field string
+	Op    string
+	Value string
+}
+
+// PageRequest 分页请求
+// Cursor 不为 nil 时使用游标分页，否则使用页码分页
+type PageRequest struct {
+	Page     int
+	PageSize int
+	Sort     []SortField
+	Cursor   *string
+	Filters  []Filter
+}
+
+// PageOptions 每个列表允许的排序和过滤字段，key 为接口中的字段名，value 为数据库列名
+type PageOptions struct {
+	SortFields   map[string]string
+	FilterFields map[string]string
+	// DefaultSort 未指定排序时使用的排序
+	DefaultSort []SortField
+}
+
+// NewPageRequest 将接口参数转换为分页请求
+// sort 形如 "-createdAt,name"，filter 形如 "status:eq:1"，in 操作的多个值使用 | 分隔
+func NewPageRequest(query dto.PageQuery) (PageRequest, error) {
+	req := PageRequest{
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Cursor:   query.Cursor,
+	}
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	if req.PageSize < 1 {
+		req.PageSize = DefaultPageSize
+	}
+	if req.PageSize > MaxPageSize {
+		req.PageSize = MaxPageSize
+	}
+
+	if query.Sort != "" {
+		for _, part := range strings.Split(query.Sort, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			desc := strings.HasPrefix(part, "-")
+			req.Sort = append(req.Sort, SortField{
+				Field: strings.TrimLeft(part, "-+"),
+				Desc:  desc,
+			})
+		}
+	}
+
+	for _, f := range query.Filter {
+		parts := strings.SplitN(f, ":", 3)
+		if len(parts) != 3 {
+			return req, fmt.Errorf("%w: filter %q should be field:op:value", ErrInvalidPageRequest, f)
+		}
+		req.Filters = append(req.Filters, Filter{
+			Field: parts[0],
+			Op:    parts[1],
+			Value: parts[2],
+		})
+	}
+	return req, nil
+}
+
+// cursorValue 游标中保存的上一页最后一条记录的排序值和 ID
+type cursorValue struct {
+	Value json.RawMessage `json:"v"`
+	ID    uint64          `json:"id"`
+}
+
+// FindPage 分页查询，conditions 与 FindAll 的用法相同
+func FindPage[T any](ctx context.Context, req PageRequest, opts PageOptions, conditions ...interface{}) (*Page[T], error) {
+	var err error
+	if req.PageSize < 1 {
+		req.PageSize = DefaultPageSize
+	}
+	if req.Page < 1 {
+		req.Page = 1
+	}
+
+	// 基础条件和过滤条件
+	where := []infra.Scope{func(db *gorm.DB) *gorm.DB {
+		if len(conditions) > 0 {
+			return db.Where(conditions[0], conditions[1:]...)
+		}
+		return db
+	}}
+	for _, f := range req.Filters {
+		scope, err := filterScope(f, opts)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, scope)
+	}
+
+	total, err := infra.Count[T](ctx, where...)
+	if err != nil {
+		return nil, err
+	}
+
+	// 排序字段
+	sorts := req.Sort
+	if len(sorts) == 0 {
+		sorts = opts.DefaultSort
+	}
+	if len(sorts) == 0 {
+		sorts = []SortField{{Field: "id", Desc: true}}
+	}
+	var columns []string
+	for _, s := range sorts {
+		column, ok := sortColumn(s.Field, opts)
+		if !ok {
+			return nil, fmt.Errorf("%w: unsupported sort field %s", ErrInvalidPageRequest, s.Field)
+		}
+		columns = append(columns, column)
+	}
+
+	page := &Page[T]{
+		PageMeta: dto.PageMeta{
+			Total:    total,
+			PageSize: req.PageSize,
+		},
+	}
+
+	query := append([]infra.Scope{}, where...)
+	if req.Cursor != nil {
+		// 游标分页只支持一个排序字段，使用 id 保证顺序稳定
+		if len(sorts) > 1 {
+			return nil, fmt.Errorf("%w: cursor pagination supports only one sort field", ErrInvalidPageRequest)
+		}
+		column, desc := columns[0], sorts[0].Desc
+		if *req.Cursor != "" {
+			scope, err := cursorScope[T](*req.Cursor, column, desc)
+			if err != nil {
+				return nil, err
+			}
+			query = append(query, scope)
+		}
+		query = append(query, func(db *gorm.DB) *gorm.DB {
+			db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: desc})
+			if column != "id" {
+				db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Desc: desc})
+			}
+			// 多查一条用于判断是否还有下一页
+			return db.Limit(req.PageSize + 1)
+		})
+	} else {
+		page.Page = req.Page
+		query = append(query, func(db *gorm.DB) *gorm.DB {
+			for i, column := range columns {
+				db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: sorts[i].Desc})
+			}
+			return db.Offset((req.Page - 1) * req.PageSize).Limit(req.PageSize + 1)
+		})
+	}
+
+	items, err := infra.FindByScopes[T](ctx, query...)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) > req.PageSize {
+		items = items[:req.PageSize]
+		page.HasMore = true
+	}
+	if items == nil {
+		items = make([]T, 0)
+	}
+	page.Items = items
+
+	if req.Cursor != nil && page.HasMore {
+		page.NextCursor, err = encodeCursor(&items[len(items)-1], columns[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+func sortColumn(field string, opts PageOptions) (string, bool) {
+	if field == "id" {
+		return "id", true
+	}
+	column, ok := opts.SortFields[field]
+	return column, ok
+}
+
+func filterScope(f Filter, opts PageOptions) (infra.Scope, error) {
+	column, ok := opts.FilterFields[f.Field]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported filter field %s", ErrInvalidPageRequest, f.Field)
+	}
+	op, ok := opSQL[f.Op]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported filter operator %s", ErrInvalidPageRequest, f.Op)
+	}
+
+	var value interface{} = f.Value
+	switch f.Op {
+	case OpIn:
+		value = strings.Split(f.Value, "|")
+	case OpLike:
+		value = "%" + f.Value + "%"
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		// column 和 op 都来自白名单，可以直接拼接
+		return db.Where(fmt.Sprintf("%s %s (?)", column, op), value)
+	}, nil
+}
+
+func encodeCursor[T any](last *T, column string) (string, error) {
+	value, err := infra.ColumnValue(last, column)
+	if err != nil {
+		return "", err
+	}
+	id, err := infra.ColumnValue(last, "id")
+	if err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	// 部分模型的 ID 不是 uint，统一转换
+	idValue, err := strconv.ParseUint(fmt.Sprint(id), 10, 64)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(cursorValue{Value: raw, ID: idValue})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func cursorScope[T any](cursor string, column string, desc bool) (infra.Scope, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPageRequest)
+	}
+	var c cursorValue
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPageRequest)
+	}
+
+	// 按字段类型还原游标中的值
+	field, err := infra.LookUpField[T](column)
+	if err != nil {
+		return nil, err
+	}
+	ptr := reflect.New(field.FieldType)
+	err = json.Unmarshal(c.Value, ptr.Interface())
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPageRequest)
+	}
+	value := ptr.Elem().Interface()
+
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		if column == "id" {
+			return db.Where(fmt.Sprintf("id %s ?", op), c.ID)
+		}
+		return db.Where(fmt.Sprintf("((%s %s ?) OR (%s = ? AND id %s ?))", column, op, column, op), value, value, c.ID)
+	}, nil
+}
@@ -111,6 +111,7 @@ type JSONQueryExpression struct {
 	hasKeys     bool
 	equals      bool
 	likes       bool
+	contains    bool
 	equalsValue interface{}
 	extract     bool
 	path        string
@@ -151,12 +152,29 @@ func (jsonQuery *JSONQueryExpression) Likes(value interface{}, keys ...string) *
 	return jsonQuery
 }
 
+// Contains checks that the column contains value encoded as json,
+// objects inside arrays match when they contain the given fields (postgres @>, mysql JSON_CONTAINS)
+func (jsonQuery *JSONQueryExpression) Contains(value interface{}) *JSONQueryExpression {
+	jsonQuery.contains = true
+	jsonQuery.equalsValue = value
+	return jsonQuery
+}
+
 // Build implements clause.Expression
 func (jsonQuery *JSONQueryExpression) Build(builder clause.Builder) {
 	if stmt, ok := builder.(*gorm.Statement); ok {
 		switch stmt.Dialector.Name() {
 		case "mysql", "sqlite":
 			switch {
+			case jsonQuery.contains:
+				if stmt.Dialector.Name() == "mysql" {
+					data, _ := json.Marshal(jsonQuery.equalsValue)
+					builder.WriteString("JSON_CONTAINS(")
+					builder.WriteQuoted(jsonQuery.column)
+					builder.WriteByte(',')
+					builder.AddVar(stmt, string(data))
+					builder.WriteString(")")
+				}
 			case jsonQuery.extract:
 				builder.WriteString("JSON_EXTRACT(")
 				builder.WriteQuoted(jsonQuery.column)
@@ -200,6 +218,12 @@ func (jsonQuery *JSONQueryExpression) Build(builder clause.Builder) {
 			}
 		case "postgres":
 			switch {
+			case jsonQuery.contains:
+				data, _ := json.Marshal(jsonQuery.equalsValue)
+				stmt.WriteQuoted(jsonQuery.column)
+				stmt.WriteString("::jsonb @> ")
+				stmt.AddVar(builder, string(data))
+				stmt.WriteString("::jsonb")
 			case jsonQuery.extract:
 				builder.WriteString(fmt.Sprintf("json_extract_path_text(%v::json,", stmt.Quote(jsonQuery.column)))
 				stmt.AddVar(builder, jsonQuery.path)
@@ -41,6 +41,10 @@ func (j *JSONType[T]) Scan(value interface{}) error {
 		bytes = v
 	case string:
 		bytes = []byte(v)
+	case nil:
+		var zero T
+		j.data = zero
+		return nil
 	default:
 		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
 	}
@@ -6,10 +6,58 @@ type NewAnnotation struct {
 	DatasetID uint               `json:"datasetId"`
 }
 
+// 标注的形状类型
+const (
+	// ShapeBBox 矩形框，使用中心点和宽高
+	ShapeBBox = "bbox"
+	// ShapeRotatedBBox 旋转矩形框，在矩形框的基础上绕中心点顺时针旋转 Angle 度
+	ShapeRotatedBBox = "rbox"
+	// ShapePolygon 多边形，使用 Points
+	ShapePolygon = "polygon"
+	// ShapeMask 掩码，使用 COCO 格式的未压缩 RLE
+	ShapeMask = "mask"
+	// ShapeKeypoints 关键点和骨架
+	ShapeKeypoints = "keypoints"
+	// ShapeClass 整张图片的类别，没有几何信息
+	ShapeClass = "class"
+)
+
+// AnnotationResult 一个标注，坐标为原图像素坐标
+// ID 为数据集中的标签 ID
+// Type 为空时是旧版本的矩形框；多边形、掩码和关键点的 center_x、center_y、w、h 为外接矩形，保存时计算
 type AnnotationResult struct {
+	Type    string  `json:"type"`
 	CenterX float64 `json:"center_x"`
 	CenterY float64 `json:"center_y"`
 	Width   float64 `json:"w"`
 	Height  float64 `json:"h"`
 	ID      uint    `json:"id"`
+	// Angle 旋转矩形框的角度，单位为度
+	Angle float64 `json:"angle,omitempty"`
+	// Points 多边形的顶点，每个点为 [x, y]
+	Points [][]float64 `json:"points,omitempty"`
+	Mask   *MaskRLE    `json:"mask,omitempty"`
+	// Keypoints 关键点，Skeleton 中的下标从 0 开始
+	Keypoints []Keypoint `json:"keypoints,omitempty"`
+	Skeleton  [][2]int   `json:"skeleton,omitempty"`
+	// Attributes 按标签定义填写的属性值，bool 属性为 true/false，其余为字符串
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	// Confidence 多人标注合并后目标的置信度，0 到 1，只出现在合并结果中
+	Confidence float64 `json:"confidence,omitempty"`
+}
+
+// MaskRLE 按列优先展开的游程编码，从 0 的游程开始，与 COCO 的未压缩 RLE 相同
+type MaskRLE struct {
+	// Size [高, 宽]，需要与图片一致
+	Size   [2]int `json:"size"`
+	Counts []int  `json:"counts"`
+}
+
+// Keypoint 一个关键点
+type Keypoint struct {
+	Name string  `json:"name,omitempty"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+	// Visibility 0 未标注，1 被遮挡，2 可见
+	Visibility int `json:"v"`
 }
@@ -0,0 +1,13 @@
+package dto
+
+import "time"
+
+// NewAPIKey 签发 API key 请求参数
+type NewAPIKey struct {
+	Name   string   `json:"name" binding:"required,max=64"`
+	Scopes []string `json:"scopes" binding:"required,min=1,dive,required"`
+	// DatasetIDs 可以访问的数据集，包含 dataset:read 等数据集级的 scope 时必填
+	DatasetIDs []uint `json:"datasetIds" binding:"dive,required"`
+	// ExpiresAt 过期时间，为空时不过期
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
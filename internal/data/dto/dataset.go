@@ -1,11 +1,27 @@
 package dto
 
+import "time"
+
 type NewDataset struct {
 	Name        string   `json:"name"`
 	Description string   `json:"description"`
 	EndTime     string   `json:"endTime"`
 	Cover       string   `json:"cover"`
 	Tags        []string `json:"tags"`
+	// IsPublic 公开数据集所有人可见、可以直接加入，创建时默认公开
+	IsPublic *bool `json:"isPublic"`
+	// DuplicatePolicy 上传重复图片时的处理方式，allow、skip 或 link，默认 allow
+	DuplicatePolicy *string `json:"duplicatePolicy" binding:"omitempty,oneof=allow skip link"`
+	// NearDuplicateThreshold 感知哈希的汉明距离阈值，大于 0 时标记近似重复的图片
+	NearDuplicateThreshold *int `json:"nearDuplicateThreshold" binding:"omitempty,min=0,max=32"`
+	// SamID 选用的嵌入模型，为 0 时使用默认模型
+	SamID *uint `json:"samId"`
+}
+
+// SetMemberRole 设置数据集成员角色
+type SetMemberRole struct {
+	UserID uint   `json:"userId" binding:"required"`
+	Role   string `json:"role" binding:"required,oneof=owner reviewer annotator viewer"`
 }
 
 type DatasetQuery struct {
@@ -26,3 +42,25 @@ type AddImage struct {
 	DatasetID uint     `json:"datasetId"`
 	Images    []string `json:"images"`
 }
+
+// ImageFilter 批量操作选择的图片，多个条件同时满足，至少需要一个条件
+type ImageFilter struct {
+	ImageIDs      []uint     `json:"imageIds" binding:"max=10000"`
+	Status        *int       `json:"status"`
+	CreatedBefore *time.Time `json:"createdBefore"`
+	CreatedAfter  *time.Time `json:"createdAfter"`
+}
+
+// ResetImageStatus 重置图片的状态
+type ResetImageStatus struct {
+	ImageFilter
+	// Target 重置后的状态，只能回到未嵌入（0）或已嵌入（1）
+	Target int `json:"target" binding:"oneof=0 1"`
+	// ClearAnnotations 同时删除图片上的标注
+	ClearAnnotations bool `json:"clearAnnotations"`
+}
+
+// ReorderImages 按给定的顺序排列图片，只调整这些图片之间的相对顺序
+type ReorderImages struct {
+	ImageIDs []uint `json:"imageIds" binding:"required,min=1,max=10000"`
+}
@@ -0,0 +1,31 @@
+package dto
+
+// 标签属性的类型
+const (
+	// AttributeEnum 从 Options 中选择一个值
+	AttributeEnum = "enum"
+	AttributeBool = "bool"
+	AttributeText = "text"
+)
+
+// LabelAttribute 标签的属性定义，标注时为每个标注填写
+type LabelAttribute struct {
+	Name string `json:"name" binding:"required,max=64"`
+	Type string `json:"type" binding:"required,oneof=enum bool text"`
+	// Options enum 类型可选的值
+	Options  []string `json:"options,omitempty"`
+	Required bool     `json:"required"`
+}
+
+// NewLabel 创建或修改标签
+type NewLabel struct {
+	Name  string `json:"name" binding:"required,max=64"`
+	Color string `json:"color" binding:"omitempty,hexcolor"`
+	// ParentID 上级标签，为 0 时是顶层标签
+	ParentID uint `json:"parentId"`
+	// Shapes 允许使用的形状类型，为空时不限制
+	Shapes     []string         `json:"shapes" binding:"omitempty,dive,oneof=bbox rbox polygon mask keypoints class"`
+	Attributes []LabelAttribute `json:"attributes" binding:"omitempty,dive"`
+	// Position 导出时类别的顺序，创建时为空则排在最后
+	Position *int `json:"position" binding:"omitempty,min=0"`
+}
@@ -0,0 +1,19 @@
+package dto
+
+// PageQuery 列表接口通用的分页参数
+type PageQuery struct {
+	Page     int      `form:"page"`
+	PageSize int      `form:"pageSize"`
+	Sort     string   `form:"sort"`
+	Cursor   *string  `form:"cursor"`
+	Filter   []string `form:"filter"`
+}
+
+// PageMeta 分页信息
+type PageMeta struct {
+	Total      int64  `json:"total"`
+	Page       int    `json:"page,omitempty"`
+	PageSize   int    `json:"pageSize"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	HasMore    bool   `json:"hasMore"`
+}
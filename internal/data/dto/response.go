@@ -4,6 +4,7 @@ type Response struct {
 	Code    int         `json:"code"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data"`
+	Page    *PageMeta   `json:"page,omitempty"`
 }
 
 func NewResponse(code int, message string, data interface{}) *Response {
@@ -25,3 +26,10 @@ func NewSuccessResponse(data interface{}) *Response {
 func NewFailResponse(message string) *Response {
 	return NewResponse(500, message, nil)
 }
+
+// NewPageResponse 返回带分页信息的结果
+func NewPageResponse(data interface{}, page PageMeta) *Response {
+	res := NewResponse(200, "success", data)
+	res.Page = &page
+	return res
+}
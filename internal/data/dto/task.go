@@ -4,3 +4,48 @@ type NewJob struct {
 	ImgURL string `json:"img_url" binding:"required"`
 	OnnxId int    `json:"onnx_id" binding:"required"`
 }
+
+// ClaimTask worker 认领任务
+type ClaimTask struct {
+	WorkerID string `json:"workerId" binding:"required,max=128"`
+	// LeaseSeconds 申请的租约时长，为 0 时使用服务端的默认值
+	LeaseSeconds int `json:"leaseSeconds" binding:"min=0"`
+}
+
+// TaskHeartbeat worker 在租约到期前发送心跳
+type TaskHeartbeat struct {
+	LeaseToken   string `json:"leaseToken" binding:"required"`
+	LeaseSeconds int    `json:"leaseSeconds" binding:"min=0"`
+}
+
+// CompleteTask worker 汇报嵌入结果
+type CompleteTask struct {
+	LeaseToken   string `json:"leaseToken" binding:"required"`
+	EmbeddingURL string `json:"embeddingUrl" binding:"required"`
+}
+
+// FailTask worker 汇报失败
+type FailTask struct {
+	LeaseToken string `json:"leaseToken" binding:"required"`
+	Error      string `json:"error"`
+	// Retryable 是否可以重试，默认为 true，图片无法解码等错误应设置为 false
+	Retryable *bool `json:"retryable"`
+}
+
+// NewSam 注册模型请求参数
+type NewSam struct {
+	Name    string `json:"name" binding:"required,max=128"`
+	Version string `json:"version" binding:"required,max=64"`
+	// Checksum 模型文件的 sha256
+	Checksum   string `json:"checksum" binding:"required,len=64,hexadecimal"`
+	InputSize  int    `json:"inputSize" binding:"required,min=1"`
+	StorageURL string `json:"storageUrl" binding:"required,url"`
+}
+
+// NewEmbeddingCampaign 启动重新嵌入活动，过滤条件为空时处理数据集中的所有图片
+type NewEmbeddingCampaign struct {
+	ImageFilter
+	SamID uint `json:"samId" binding:"required"`
+	// OnlyOutdated 跳过已经由该模型嵌入的图片
+	OnlyOutdated bool `json:"onlyOutdated"`
+}
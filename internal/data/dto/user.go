@@ -26,3 +26,13 @@ type ChangeUserInfo struct {
 	Email       string `json:"email"`
 	Description string `json:"description"`
 }
+
+// RefreshToken 刷新 token 请求参数
+type RefreshToken struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// Logout 登出请求参数，refreshToken 为空时只吊销 access token
+type Logout struct {
+	RefreshToken string `json:"refreshToken"`
+}
@@ -1,27 +1,32 @@
 package domain
 
 import (
-	"github.com/goccy/go-json"
+	"context"
+	"errors"
+	"fmt"
 	"gorm.io/gorm"
 	"log/slog"
 	"sapphire-server/internal/dao"
 	"sapphire-server/internal/data/datatypes"
 	"sapphire-server/internal/data/dto"
+	"strconv"
 )
 
 var annotationDomain = NewAnnotationDomain()
 
 type Annotation struct {
 	gorm.Model
-	Status         int            `gorm:"column:status"`
-	Content        datatypes.JSON `gorm:"column:content"`
-	DatasetID      uint           `gorm:"column:dataset_id"`
-	ImageID        uint           `gorm:"column:image_id"`
-	UserID         uint           `gorm:"column:user_id"`
-	IsQualified    bool           `gorm:"column:is_qualified"`
-	ReplicaCount   int            `gorm:"column:replica_count"`
-	QualifiedCount int            `gorm:"column:qualified_count"`
-	DeliveredCount int            `gorm:"column:delivered_count"`
+	Status      int                                        `gorm:"column:status"`
+	Content     datatypes.JSONType[[]dto.AnnotationResult] `gorm:"column:content"`
+	DatasetID   uint                                       `gorm:"column:dataset_id"`
+	ImageID     uint                                       `gorm:"column:image_id"`
+	UserID      uint                                       `gorm:"column:user_id"`
+	IsQualified bool                                       `gorm:"column:is_qualified"`
+	// Imported 是否为导入的标注，而不是在平台上标注的
+	Imported       bool `gorm:"column:imported"`
+	ReplicaCount   int  `gorm:"column:replica_count"`
+	QualifiedCount int  `gorm:"column:qualified_count"`
+	DeliveredCount int  `gorm:"column:delivered_count"`
 }
 
 type AnnotationUser struct {
@@ -33,21 +38,31 @@ type AnnotationUser struct {
 	// NOTE: 关于 Result 这边原来设置为 JSON 格式的，嫌麻烦先改成 string 了
 }
 
+// annotationPageOptions 标注列表允许的排序和过滤字段
+var annotationPageOptions = dao.PageOptions{
+	SortFields: map[string]string{
+		"createdAt": "created_at",
+		"userId":    "user_id",
+	},
+	FilterFields: map[string]string{
+		"userId":      "user_id",
+		"status":      "status",
+		"isQualified": "is_qualified",
+	},
+}
+
 func NewAnnotationDomain() *Annotation {
 	return &Annotation{}
 }
 
 func newAnnotationFromDTO(userID uint, anno dto.NewAnnotation) *Annotation {
-	// 将 Marks 从 JSON 转为 string
-	marks, _ := json.Marshal(anno.Marks)
-	if len(marks) == 0 {
-		marks = []byte("[]")
+	marks := anno.Marks
+	if marks == nil {
+		marks = []dto.AnnotationResult{}
 	}
-	marksStr := string(marks)
-	slog.Info("marksStr", marksStr)
 
 	return &Annotation{
-		Content:        datatypes.JSON(marksStr),
+		Content:        datatypes.NewJSONType(marks),
 		DatasetID:      anno.DatasetID,
 		UserID:         userID,
 		ImageID:        anno.ImgID,
@@ -61,20 +76,50 @@ func newAnnotationFromDTO(userID uint, anno dto.NewAnnotation) *Annotation {
 func (a *Annotation) CreateAnnotation(userID uint, anno dto.NewAnnotation) (*Annotation, error) {
 	var err error
 
+	// 图片必须属于请求中的数据集，权限是按数据集检查的
+	img, err := dao.FindOne[ImgDataset](context.Background(), anno.ImgID)
+	if err != nil {
+		return nil, err
+	}
+	if img == nil || img.DatasetId != anno.DatasetID {
+		return nil, errors.New("image not found in dataset")
+	}
+	categories, err := datasetCategories(context.Background(), anno.DatasetID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 标注的 id 必须是该数据集的标签；缩略图生成之前宽高为 0，此时不检查坐标范围
+	err = normalizeMarks(anno.Marks, categories, img.Width, img.Height)
+	if err != nil {
+		return nil, err
+	}
+
 	// 创建并保存标注
 	annotation := newAnnotationFromDTO(userID, anno)
-	err = dao.Save(annotation)
+	err = dao.Save(context.Background(), annotation)
 	if err != nil {
 		return nil, err
 	}
-	slog.Info("Create Annotation Success", annotation)
+	slog.Info("Create Annotation Success", "annotation", annotation)
+	PublishDatasetEvent(context.Background(), annotation.DatasetID, EventAnnotation, &AnnotationEvent{
+		AnnotationID: annotation.ID,
+		ImageID:      annotation.ImageID,
+		UserID:       annotation.UserID,
+	})
+
+	// 重新计算图片的一致性，失败时不影响标注的提交
+	err = RefreshImageQuality(context.Background(), annotation.ImageID)
+	if err != nil {
+		slog.Error("RefreshImageQuality", "imageID", annotation.ImageID, "err", err)
+	}
 
 	// 读出已经保存的标注，检查是否符合要求
 	annotations, err := a.ListAnnotationsByImageID(annotation.ImageID)
 	if err != nil {
 		return nil, err
 	}
-	slog.Debug("annotations", annotations)
+	slog.Debug("CreateAnnotation", "annotations", annotations)
 
 	return annotation, nil
 }
@@ -82,7 +127,7 @@ func (a *Annotation) CreateAnnotation(userID uint, anno dto.NewAnnotation) (*Ann
 // ListAnnotationsByUserID 根据用户 ID 获取该用户的所有标注
 func (a *Annotation) ListAnnotationsByUserID(userID uint) ([]Annotation, error) {
 	var err error
-	annotations, err := dao.FindAll[Annotation]("user_id = ?", userID)
+	annotations, err := dao.FindAll[Annotation](context.Background(), "user_id = ?", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -92,17 +137,63 @@ func (a *Annotation) ListAnnotationsByUserID(userID uint) ([]Annotation, error)
 // ListAnnotationsByImageID 根据图片 ID 获取该图片的所有标注
 func (a *Annotation) ListAnnotationsByImageID(imageID uint) ([]Annotation, error) {
 	var err error
-	annotations, err := dao.FindAll[Annotation]("image_id = ?", imageID)
+	annotations, err := dao.FindAll[Annotation](context.Background(), "image_id = ?", imageID)
 	if err != nil {
 		return nil, err
 	}
 	return annotations, nil
 }
 
+// ListAnnotationPageByImageID 根据图片 ID 分页获取该图片的标注
+func (a *Annotation) ListAnnotationPageByImageID(imageID uint, req dao.PageRequest) (*dao.Page[Annotation], error) {
+	page, err := dao.FindPage[Annotation](context.Background(), req, annotationPageOptions, "image_id = ?", imageID)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// ListAnnotationPageByAttributes 分页查询数据集中含有指定标签和属性值的标注
+// attrs 的值按标签的属性定义转换，bool 属性为 true 或 false
+func ListAnnotationPageByAttributes(ctx context.Context, datasetID uint, labelID uint, attrs map[string]string, req dao.PageRequest) (*dao.Page[Annotation], error) {
+	label, err := GetLabel(ctx, datasetID, labelID)
+	if err != nil {
+		return nil, err
+	}
+	defs := make(map[string]dto.LabelAttribute)
+	for _, def := range label.Attributes.Data() {
+		defs[def.Name] = def
+	}
+	values := make(map[string]interface{}, len(attrs))
+	for name, raw := range attrs {
+		def, ok := defs[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown attribute %s for label %s", ErrInvalidLabel, name, label.Name)
+		}
+		if def.Type != dto.AttributeBool {
+			values[name] = raw
+			continue
+		}
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: attribute %s should be bool", ErrInvalidLabel, name)
+		}
+		values[name] = value
+	}
+
+	// 标注中任意一个 mark 包含这些字段即匹配
+	mark := map[string]interface{}{"id": labelID}
+	if len(values) > 0 {
+		mark["attributes"] = values
+	}
+	contains := datatypes.JSONQuery("content").Contains([]interface{}{mark})
+	return dao.FindPage[Annotation](ctx, req, annotationPageOptions, "dataset_id = ? AND ?", datasetID, contains)
+}
+
 // GetAnnotationByImageID 根据图片 ID 获取该图片的标注
 func (a *Annotation) GetAnnotationByImageID(imageID uint) (*Annotation, error) {
 	var err error
-	annotations, err := dao.FindAll[Annotation]("image_id = ?", imageID)
+	annotations, err := dao.FindAll[Annotation](context.Background(), "image_id = ?", imageID)
 	if err != nil {
 		return nil, err
 	}
@@ -119,39 +210,22 @@ func (a *Annotation) GetAnnotationByImageID(imageID uint) (*Annotation, error) {
 		if anno.IsQualified {
 			candidates = append(candidates, anno)
 		} else {
-			slog.Debug("Remove Unqualified Annotation", anno)
+			slog.Debug("Remove Unqualified Annotation", "annotation", anno)
 		}
 	}
 
-	// 接收最后的结果
-	var centerX, centerY, width, height float64
-	length := len(candidates)
-	for _, anno := range candidates {
-		content := anno.Content
-		var marks []dto.AnnotationResult
-		err = json.Unmarshal([]byte(content), &marks)
-		if err != nil {
-			return nil, err
-		}
-
-		// 计算中心点和宽高
-		for _, mark := range marks {
-			centerX += mark.CenterX
-			centerY += mark.CenterY
-			width += mark.Width
-			height += mark.Height
-		}
+	if len(candidates) == 0 {
+		return nil, nil
 	}
-	mark := dto.AnnotationResult{
-		CenterX: centerX / float64(length),
-		CenterY: centerY / float64(length),
-		Width:   width / float64(length),
-		Height:  height / float64(length),
+
+	// 按标签和 IoU 匹配各标注员的标注后融合，每个标注员取最新的一次标注，权重相同
+	candidates = latestByUser(candidates)
+	inputs := make([]consensusInput, len(candidates))
+	for i, anno := range candidates {
+		inputs[i] = consensusInput{marks: anno.Content.Data(), weight: 1}
 	}
-	markJSON, _ := json.Marshal(mark)
-	slog.Debug("Final Mark", string(markJSON))
 	res := &Annotation{
-		Content:   datatypes.JSON(markJSON),
+		Content:   datatypes.NewJSONType(consensusMarks(inputs, defaultConsensusOptions())),
 		DatasetID: candidates[0].DatasetID,
 		ImageID:   candidates[0].ImageID,
 		UserID:    candidates[0].UserID,
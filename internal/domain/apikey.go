@@ -0,0 +1,206 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"log/slog"
+	"sapphire-server/internal/dao"
+	"sapphire-server/internal/data/datatypes"
+	"strings"
+	"time"
+)
+
+// apiKeyPrefix 密钥的固定前缀，便于在日志和配置中识别
+const apiKeyPrefix = "sk_"
+
+// apiKeyLastUsedInterval 最近使用时间的更新间隔，避免每个请求都写数据库
+const apiKeyLastUsedInterval = time.Minute
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	ErrInvalidScope  = errors.New("invalid api key scope")
+)
+
+// APIKeyScopes 可以授予 API key 的权限
+var APIKeyScopes = []Permission{PermEmbeddingWrite, PermTaskManage, PermDatasetRead, PermDatasetExport}
+
+// APIKey 管理员为 worker 等机器签发的密钥，只保存哈希
+type APIKey struct {
+	gorm.Model
+	Name string `gorm:"column:name" json:"name"`
+	// Prefix 密钥的前几位，用于在列表中识别
+	Prefix  string                       `gorm:"column:prefix" json:"prefix"`
+	KeyHash string                       `gorm:"column:key_hash" json:"-"`
+	Scopes  datatypes.JSONType[[]string] `gorm:"column:scopes" json:"scopes"`
+	// DatasetIDs 数据集级的 scope 只对这些数据集生效，为空时不能访问任何数据集
+	DatasetIDs datatypes.JSONType[[]uint] `gorm:"column:dataset_ids" json:"datasetIds"`
+	// CreatorID 签发密钥的管理员
+	CreatorID  uint       `gorm:"column:creator_id" json:"creatorId"`
+	ExpiresAt  *time.Time `gorm:"column:expires_at" json:"expiresAt"`
+	RevokedAt  *time.Time `gorm:"column:revoked_at" json:"revokedAt"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at" json:"lastUsedAt"`
+}
+
+// IssuedAPIKey 签发的密钥，明文只在签发时返回一次
+type IssuedAPIKey struct {
+	*APIKey
+	Key string `json:"key"`
+}
+
+// apiKeyPageOptions API key 列表允许的排序和过滤字段
+var apiKeyPageOptions = dao.PageOptions{
+	SortFields: map[string]string{
+		"createdAt":  "created_at",
+		"lastUsedAt": "last_used_at",
+		"name":       "name",
+	},
+	FilterFields: map[string]string{
+		"name":      "name",
+		"creatorId": "creator_id",
+	},
+}
+
+// HasScope 判断密钥是否拥有权限
+func (k *APIKey) HasScope(perm Permission) bool {
+	for _, scope := range k.Scopes.Data() {
+		if scope == string(perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsDataset 判断密钥是否可以访问数据集
+func (k *APIKey) AllowsDataset(datasetID uint) bool {
+	for _, id := range k.DatasetIDs.Data() {
+		if id == datasetID {
+			return true
+		}
+	}
+	return false
+}
+
+// Active 密钥是否可以使用
+func (k *APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// IsValidAPIKeyScope 判断是否可以授予 API key
+func IsValidAPIKeyScope(scope string) bool {
+	for _, s := range APIKeyScopes {
+		if string(s) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey 签发新的密钥，expiresAt 为 nil 时不过期
+// 包含数据集级的 scope 时需要指定可以访问的数据集
+func CreateAPIKey(ctx context.Context, creatorID uint, name string, scopes []string, datasetIDs []uint, expiresAt *time.Time) (*IssuedAPIKey, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	datasetScope := false
+	for _, scope := range scopes {
+		if !IsValidAPIKeyScope(scope) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+		datasetScope = datasetScope || IsDatasetPermission(Permission(scope))
+	}
+	if datasetScope && len(datasetIDs) == 0 {
+		return nil, fmt.Errorf("%w: dataset scopes require datasetIds", ErrInvalidScope)
+	}
+	if datasetIDs == nil {
+		datasetIDs = []uint{}
+	}
+	if len(datasetIDs) > 0 {
+		datasets, err := dao.FindAll[Dataset](ctx, "id IN ?", datasetIDs)
+		if err != nil {
+			return nil, err
+		}
+		found := make(map[uint]bool)
+		for _, d := range datasets {
+			found[d.ID] = true
+		}
+		for _, id := range datasetIDs {
+			if !found[id] {
+				return nil, fmt.Errorf("%w: dataset %d not found", ErrInvalidScope, id)
+			}
+		}
+	}
+
+	buf := make([]byte, 24)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(buf)
+	apiKey := &APIKey{
+		Name:       name,
+		Prefix:     key[:len(apiKeyPrefix)+8],
+		KeyHash:    hashAPIKey(key),
+		Scopes:     datatypes.NewJSONType(scopes),
+		DatasetIDs: datatypes.NewJSONType(datasetIDs),
+		CreatorID:  creatorID,
+		ExpiresAt:  expiresAt,
+	}
+	err = dao.Save(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return &IssuedAPIKey{APIKey: apiKey, Key: key}, nil
+}
+
+// AuthenticateAPIKey 校验密钥，返回对应的记录，并更新最近使用时间
+func AuthenticateAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+	apiKey, err := dao.First[APIKey](ctx, "key_hash = ?", hashAPIKey(key))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if apiKey == nil || !apiKey.Active(now) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyLastUsedInterval {
+		_, err = dao.UpdateWhere[APIKey](ctx, map[string]interface{}{"last_used_at": now}, "id = ?", apiKey.ID)
+		if err != nil {
+			// 只影响列表中的显示，不拒绝请求
+			slog.Warn("update api key last used failed", "id", apiKey.ID, "err", err)
+		} else {
+			apiKey.LastUsedAt = &now
+		}
+	}
+	return apiKey, nil
+}
+
+// ListAPIKeyPage 分页列出所有密钥，包括已经吊销和过期的
+func ListAPIKeyPage(ctx context.Context, req dao.PageRequest) (*dao.Page[APIKey], error) {
+	return dao.FindPage[APIKey](ctx, req, apiKeyPageOptions)
+}
+
+// RevokeAPIKey 吊销密钥，立即生效，不存在时返回 nil
+func RevokeAPIKey(ctx context.Context, id uint) (*APIKey, error) {
+	_, err := dao.UpdateWhere[APIKey](ctx, map[string]interface{}{"revoked_at": time.Now()}, "id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		return nil, err
+	}
+	return dao.FindOne[APIKey](ctx, id)
+}
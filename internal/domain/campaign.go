@@ -0,0 +1,305 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"sapphire-server/internal/dao"
+	"sapphire-server/internal/data/dto"
+	"time"
+)
+
+// 重新嵌入活动的状态
+const (
+	CampaignRunning   = "running"
+	CampaignCompleted = "completed"
+	// CampaignCancelled 取消时排队中的任务一起取消，执行中的任务照常完成
+	CampaignCancelled = "cancelled"
+)
+
+var (
+	ErrDatasetNotFound  = errors.New("dataset not found")
+	ErrCampaignNotFound = errors.New("campaign not found")
+	// ErrCampaignRunning 每个数据集同时只能有一个进行中的活动
+	ErrCampaignRunning = errors.New("dataset already has a running campaign")
+	// ErrCampaignFinished 活动已经完成或取消
+	ErrCampaignFinished = errors.New("campaign already finished")
+)
+
+// EmbeddingCampaign 更换模型后重新嵌入数据集中的图片
+// 图片保留原来的嵌入结果，新的结果完成后再替换
+type EmbeddingCampaign struct {
+	gorm.Model
+	DatasetID uint `gorm:"column:dataset_id" json:"datasetId"`
+	// SamID 重新嵌入使用的模型，启动时数据集切换到该模型
+	SamID uint `gorm:"column:sam_id" json:"samId"`
+	// PreviousSamID 启动前数据集选用的模型
+	PreviousSamID uint   `gorm:"column:previous_sam_id" json:"previousSamId"`
+	CreatorID     uint   `gorm:"column:creator_id" json:"creatorId"`
+	Status        string `gorm:"column:status" json:"status"`
+	// Total 活动创建的任务数，已有执行中任务的图片不计入
+	Total      int64      `gorm:"column:total" json:"total"`
+	FinishedAt *time.Time `gorm:"column:finished_at" json:"finishedAt"`
+}
+
+// CampaignProgress 按任务状态统计的活动进度，取消的任务计入 Failed
+type CampaignProgress struct {
+	Queued    int64   `json:"queued"`
+	Running   int64   `json:"running"`
+	Succeeded int64   `json:"succeeded"`
+	Failed    int64   `json:"failed"`
+	Percent   float64 `json:"percent"`
+}
+
+// CampaignDetail 活动和进度
+type CampaignDetail struct {
+	*EmbeddingCampaign
+	Progress CampaignProgress `json:"progress"`
+}
+
+// campaignTaskCount 统计活动的任务使用
+type campaignTaskCount struct {
+	CampaignID uint
+	Status     int
+	Count      int64
+}
+
+// campaignPageOptions 活动列表允许的排序和过滤字段
+var campaignPageOptions = dao.PageOptions{
+	SortFields: map[string]string{
+		"createdAt": "created_at",
+	},
+	FilterFields: map[string]string{
+		"status": "status",
+		"samId":  "sam_id",
+	},
+}
+
+// StartEmbeddingCampaign 将数据集切换到新模型，并为满足条件的图片创建重新嵌入的任务
+// 过滤条件为空时处理数据集中的所有图片，onlyOutdated 时跳过已经由该模型嵌入的图片
+func StartEmbeddingCampaign(ctx context.Context, datasetID uint, creatorID uint, req dto.NewEmbeddingCampaign) (*CampaignDetail, error) {
+	_, err := ActiveSam(ctx, req.SamID)
+	if err != nil {
+		return nil, err
+	}
+	query, args, err := imageFilterSQL(datasetID, req.ImageFilter)
+	if errors.Is(err, ErrEmptyImageFilter) {
+		query, args = "dataset_id = ? AND deleted_at IS NULL", []interface{}{datasetID}
+	} else if err != nil {
+		return nil, err
+	}
+	if req.OnlyOutdated {
+		query += " AND embedding_sam_id <> ?"
+		args = append(args, req.SamID)
+	}
+
+	var campaign *EmbeddingCampaign
+	err = dao.WithTx(ctx, func(tx context.Context) error {
+		// 锁住数据集，避免同时启动两个活动
+		datasets, err := dao.Query[Dataset](tx, "SELECT * FROM datasets WHERE id = ? AND deleted_at IS NULL FOR UPDATE", datasetID)
+		if err != nil {
+			return err
+		}
+		if len(datasets) == 0 {
+			return ErrDatasetNotFound
+		}
+		running, err := dao.First[EmbeddingCampaign](tx, "dataset_id = ? AND status = ?", datasetID, CampaignRunning)
+		if err != nil {
+			return err
+		}
+		if running != nil {
+			return ErrCampaignRunning
+		}
+
+		campaign = &EmbeddingCampaign{
+			DatasetID:     datasetID,
+			SamID:         req.SamID,
+			PreviousSamID: datasets[0].SamID,
+			CreatorID:     creatorID,
+			Status:        CampaignRunning,
+		}
+		err = dao.Save(tx, campaign)
+		if err != nil {
+			return err
+		}
+		_, err = dao.UpdateWhere[Dataset](tx, map[string]interface{}{"sam_id": req.SamID}, "id = ?", datasetID)
+		if err != nil {
+			return err
+		}
+
+		// 排队中的旧任务由活动的任务代替
+		images := "SELECT id FROM img_datasets WHERE " + query
+		_, err = dao.UpdateWhere[Task](tx, map[string]interface{}{
+			"status":      FAILED,
+			"error":       "superseded by campaign",
+			"finished_at": time.Now(),
+		}, "status = ? AND image_id IN ("+images+")", append([]interface{}{READY}, args...)...)
+		if err != nil {
+			return err
+		}
+
+		// 执行中的任务无法打断，这些图片不加入活动
+		insertArgs := append([]interface{}{READY, req.SamID, taskQueueOptions.MaxAttempts, campaign.ID}, args...)
+		insertArgs = append(insertArgs, RUNNING)
+		campaign.Total, err = dao.Exec(tx, `INSERT INTO tasks (created_at, updated_at, img_url, embedding_url, status, onnx_id, image_id, attempts, max_attempts, campaign_id)
+SELECT now(), now(), img_url, '', ?, ?, id, 0, ?, ? FROM img_datasets
+WHERE `+query+`
+AND NOT EXISTS (SELECT 1 FROM tasks t WHERE t.image_id = img_datasets.id AND t.status = ? AND t.deleted_at IS NULL)
+ORDER BY position, id
+ON CONFLICT DO NOTHING`, insertArgs...)
+		if err != nil {
+			return err
+		}
+
+		values := map[string]interface{}{"total": campaign.Total}
+		if campaign.Total == 0 {
+			now := time.Now()
+			values["status"] = CampaignCompleted
+			values["finished_at"] = now
+			campaign.Status = CampaignCompleted
+			campaign.FinishedAt = &now
+		}
+		_, err = dao.UpdateWhere[EmbeddingCampaign](tx, values, "id = ?", campaign.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	details, err := campaignDetails(ctx, []EmbeddingCampaign{*campaign})
+	if err != nil {
+		return nil, err
+	}
+	PublishDatasetEvent(ctx, datasetID, EventCampaign, &details[0])
+	return &details[0], nil
+}
+
+// GetEmbeddingCampaign 读取数据集的活动和进度
+func GetEmbeddingCampaign(ctx context.Context, datasetID uint, id uint) (*CampaignDetail, error) {
+	campaign, err := dao.First[EmbeddingCampaign](ctx, "id = ? AND dataset_id = ?", id, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	if campaign == nil {
+		return nil, ErrCampaignNotFound
+	}
+	details, err := campaignDetails(ctx, []EmbeddingCampaign{*campaign})
+	if err != nil {
+		return nil, err
+	}
+	return &details[0], nil
+}
+
+// ListEmbeddingCampaignPage 分页列出数据集的活动和进度
+func ListEmbeddingCampaignPage(ctx context.Context, datasetID uint, req dao.PageRequest) ([]CampaignDetail, *dao.Page[EmbeddingCampaign], error) {
+	page, err := dao.FindPage[EmbeddingCampaign](ctx, req, campaignPageOptions, "dataset_id = ?", datasetID)
+	if err != nil {
+		return nil, nil, err
+	}
+	details, err := campaignDetails(ctx, page.Items)
+	if err != nil {
+		return nil, nil, err
+	}
+	return details, page, nil
+}
+
+// CancelEmbeddingCampaign 取消活动和排队中的任务，数据集不会切换回原来的模型
+func CancelEmbeddingCampaign(ctx context.Context, datasetID uint, id uint) (*CampaignDetail, error) {
+	err := dao.WithTx(ctx, func(tx context.Context) error {
+		rows, err := dao.UpdateWhere[EmbeddingCampaign](tx, map[string]interface{}{
+			"status":      CampaignCancelled,
+			"finished_at": time.Now(),
+		}, "id = ? AND dataset_id = ? AND status = ?", id, datasetID, CampaignRunning)
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			campaign, err := dao.First[EmbeddingCampaign](tx, "id = ? AND dataset_id = ?", id, datasetID)
+			if err != nil {
+				return err
+			}
+			if campaign == nil {
+				return ErrCampaignNotFound
+			}
+			return ErrCampaignFinished
+		}
+		_, err = dao.UpdateWhere[Task](tx, map[string]interface{}{
+			"status":      FAILED,
+			"error":       "campaign cancelled",
+			"finished_at": time.Now(),
+		}, "campaign_id = ? AND status = ?", id, READY)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	detail, err := GetEmbeddingCampaign(ctx, datasetID, id)
+	if err != nil {
+		return nil, err
+	}
+	PublishDatasetEvent(ctx, datasetID, EventCampaign, detail)
+	return detail, nil
+}
+
+// FinishEmbeddingCampaigns 将没有排队中和执行中任务的活动标记为完成，返回完成的活动数
+func FinishEmbeddingCampaigns(ctx context.Context) (int, error) {
+	finished, err := dao.Query[EmbeddingCampaign](ctx, `UPDATE embedding_campaigns c SET status = ?, finished_at = now(), updated_at = now()
+WHERE c.status = ? AND c.deleted_at IS NULL
+AND NOT EXISTS (SELECT 1 FROM tasks t WHERE t.campaign_id = c.id AND t.status IN (?, ?) AND t.deleted_at IS NULL)
+RETURNING c.*`,
+		CampaignCompleted, CampaignRunning, READY, RUNNING)
+	if err != nil {
+		return 0, err
+	}
+	details, err := campaignDetails(ctx, finished)
+	if err != nil {
+		return 0, err
+	}
+	for i := range details {
+		PublishDatasetEvent(ctx, details[i].DatasetID, EventCampaign, &details[i])
+	}
+	return len(finished), nil
+}
+
+// campaignDetails 统计活动的任务，计算进度
+func campaignDetails(ctx context.Context, campaigns []EmbeddingCampaign) ([]CampaignDetail, error) {
+	details := make([]CampaignDetail, len(campaigns))
+	if len(campaigns) == 0 {
+		return details, nil
+	}
+	ids := make([]uint, len(campaigns))
+	index := make(map[uint]int, len(campaigns))
+	for i := range campaigns {
+		details[i].EmbeddingCampaign = &campaigns[i]
+		ids[i] = campaigns[i].ID
+		index[campaigns[i].ID] = i
+	}
+
+	counts, err := dao.Query[campaignTaskCount](ctx,
+		"SELECT campaign_id, status, count(*) AS count FROM tasks WHERE campaign_id IN ? AND deleted_at IS NULL GROUP BY campaign_id, status", ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		progress := &details[index[c.CampaignID]].Progress
+		switch c.Status {
+		case READY:
+			progress.Queued += c.Count
+		case RUNNING:
+			progress.Running += c.Count
+		case SUCCESS:
+			progress.Succeeded += c.Count
+		default:
+			progress.Failed += c.Count
+		}
+	}
+	for i := range details {
+		progress := &details[i].Progress
+		total := progress.Queued + progress.Running + progress.Succeeded + progress.Failed
+		if total == 0 {
+			progress.Percent = 100
+			continue
+		}
+		progress.Percent = float64(progress.Succeeded+progress.Failed) * 100 / float64(total)
+	}
+	return details, nil
+}
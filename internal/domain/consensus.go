@@ -0,0 +1,406 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"sapphire-server/internal/conf"
+	"sapphire-server/internal/data/dto"
+)
+
+// consensusShapes 参与匹配的形状类型，按该顺序输出
+var consensusShapes = []string{dto.ShapeBBox, dto.ShapeRotatedBBox, dto.ShapePolygon, dto.ShapeMask, dto.ShapeKeypoints}
+
+// consensusOptions 合并参数
+type consensusOptions struct {
+	// iou 同一个标签的形状 IoU 达到该值时才能匹配
+	iou float64
+	// agreement 目标的标注员权重占比达到该值时才保留
+	agreement float64
+}
+
+func defaultConsensusOptions() consensusOptions {
+	c := conf.GetConsensusConfig()
+	return consensusOptions{iou: c.IoU, agreement: c.Agreement}
+}
+
+// consensusInput 一个标注员的全部标注，weight 为该标注员的权重
+type consensusInput struct {
+	marks  []dto.AnnotationResult
+	weight float64
+}
+
+// latestByUser 每个标注员只保留 ID 最大的一次标注，按标注员第一次出现的顺序返回
+// 同一个人多次提交不会在合并和质量指标中获得更高的权重
+func latestByUser(annotations []Annotation) []Annotation {
+	var latest []Annotation
+	index := make(map[uint]int)
+	for _, anno := range annotations {
+		i, ok := index[anno.UserID]
+		if !ok {
+			index[anno.UserID] = len(latest)
+			latest = append(latest, anno)
+			continue
+		}
+		if anno.ID > latest[i].ID {
+			latest[i] = anno
+		}
+	}
+	return latest
+}
+
+// consensusMember 一个标注员标出的形状
+type consensusMember struct {
+	annotator int
+	weight    float64
+	mark      dto.AnnotationResult
+	extent    extent
+}
+
+// consensusCluster 被认为是同一个目标的形状，每个标注员最多一个
+type consensusCluster struct {
+	label   uint
+	members []consensusMember
+	// extent 成员外接矩形的加权平均，用于和后面的标注员匹配
+	extent extent
+}
+
+func (c *consensusCluster) add(m consensusMember) {
+	c.members = append(c.members, m)
+	var e extent
+	var total float64
+	for _, member := range c.members {
+		e.X0 += member.extent.X0 * member.weight
+		e.Y0 += member.extent.Y0 * member.weight
+		e.X1 += member.extent.X1 * member.weight
+		e.Y1 += member.extent.Y1 * member.weight
+		total += member.weight
+	}
+	c.extent = extent{X0: e.X0 / total, Y0: e.Y0 / total, X1: e.X1 / total, Y1: e.Y1 / total}
+}
+
+func (c *consensusCluster) weight() float64 {
+	var w float64
+	for _, m := range c.members {
+		w += m.weight
+	}
+	return w
+}
+
+// consensusMarks 合并多个标注员的标注
+// 按标注员的顺序，每个标注员的形状与已有的目标按同标签的 IoU 做匈牙利匹配，没有匹配上的形状作为新目标
+// 标注员权重占比低于 agreement 的目标丢弃；矩形框和关键点按权重融合，多边形和掩码取与其他人最接近的一个
+// Confidence 为权重占比乘以成员与融合结果的平均 IoU，图片类别的 Confidence 为权重占比
+func consensusMarks(inputs []consensusInput, opts consensusOptions) []dto.AnnotationResult {
+	var total float64
+	for _, in := range inputs {
+		total += in.weight
+	}
+	result := make([]dto.AnnotationResult, 0)
+	if total <= 0 {
+		return result
+	}
+
+	for _, shape := range consensusShapes {
+		var clusters []*consensusCluster
+		for annotator, in := range inputs {
+			var members []consensusMember
+			for _, mark := range in.marks {
+				if markType(&mark) == shape {
+					members = append(members, consensusMember{annotator: annotator, weight: in.weight, mark: mark, extent: markExtent(&mark)})
+				}
+			}
+			if len(members) == 0 {
+				continue
+			}
+
+			scores := make([][]float64, len(members))
+			for i, m := range members {
+				scores[i] = make([]float64, len(clusters))
+				for j, c := range clusters {
+					if c.label != m.mark.ID {
+						continue
+					}
+					if iou := m.extent.iou(c.extent); iou >= opts.iou {
+						scores[i][j] = iou
+					}
+				}
+			}
+			assign := hungarian(scores)
+			for i, m := range members {
+				if j := assign[i]; j >= 0 && scores[i][j] > 0 {
+					clusters[j].add(m)
+					continue
+				}
+				c := &consensusCluster{label: m.mark.ID}
+				c.add(m)
+				clusters = append(clusters, c)
+			}
+		}
+
+		for _, c := range clusters {
+			agreement := c.weight() / total
+			if agreement < opts.agreement {
+				continue
+			}
+			merged := mergeCluster(shape, c.members)
+			merged.Confidence = agreement * meanIoU(c.members, markExtent(&merged))
+			result = append(result, merged)
+		}
+	}
+
+	// 图片类别每个标注员每个类别只计一票
+	votes := make(map[uint][]consensusMember)
+	var order []uint
+	for annotator, in := range inputs {
+		seen := make(map[uint]bool)
+		for _, mark := range in.marks {
+			if markType(&mark) != dto.ShapeClass || seen[mark.ID] {
+				continue
+			}
+			seen[mark.ID] = true
+			if len(votes[mark.ID]) == 0 {
+				order = append(order, mark.ID)
+			}
+			votes[mark.ID] = append(votes[mark.ID], consensusMember{annotator: annotator, weight: in.weight, mark: mark})
+		}
+	}
+	for _, id := range order {
+		var weight float64
+		for _, m := range votes[id] {
+			weight += m.weight
+		}
+		if agreement := weight / total; agreement >= opts.agreement {
+			result = append(result, dto.AnnotationResult{
+				Type:       dto.ShapeClass,
+				ID:         id,
+				Attributes: voteAttributes(votes[id]),
+				Confidence: agreement,
+			})
+		}
+	}
+	return result
+}
+
+// mergeCluster 融合同一个目标的多个形状
+func mergeCluster(shape string, members []consensusMember) dto.AnnotationResult {
+	var merged dto.AnnotationResult
+	var ok bool
+	switch shape {
+	case dto.ShapeBBox, dto.ShapeRotatedBBox:
+		merged, ok = fuseBoxes(members), true
+	case dto.ShapeKeypoints:
+		merged, ok = fuseKeypoints(members)
+	}
+	if !ok {
+		merged = medoid(members)
+	}
+	merged.Attributes = voteAttributes(members)
+	return merged
+}
+
+// voteAttributes 每个属性取权重最高的值，填写的标注员权重不到一半的属性不保留
+func voteAttributes(members []consensusMember) map[string]interface{} {
+	type ballot struct {
+		value  interface{}
+		weight float64
+	}
+	ballots := make(map[string]map[string]*ballot)
+	var names []string
+	var total float64
+	for _, m := range members {
+		total += m.weight
+		for name, value := range m.mark.Attributes {
+			if ballots[name] == nil {
+				ballots[name] = make(map[string]*ballot)
+				names = append(names, name)
+			}
+			key := fmt.Sprintf("%T:%v", value, value)
+			if ballots[name][key] == nil {
+				ballots[name][key] = &ballot{value: value}
+			}
+			ballots[name][key].weight += m.weight
+		}
+	}
+
+	var result map[string]interface{}
+	for _, name := range names {
+		var best *ballot
+		var weight float64
+		for _, b := range ballots[name] {
+			weight += b.weight
+			if best == nil || b.weight > best.weight || (b.weight == best.weight && fmt.Sprint(b.value) < fmt.Sprint(best.value)) {
+				best = b
+			}
+		}
+		if weight*2 < total {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]interface{})
+		}
+		result[name] = best.value
+	}
+	return result
+}
+
+// fuseBoxes 按权重融合矩形框，与 weighted box fusion 相同，旋转角按 180 度周期取平均
+func fuseBoxes(members []consensusMember) dto.AnnotationResult {
+	merged := members[0].mark
+	var cx, cy, w, h, angle, total float64
+	ref := merged.Angle
+	for _, m := range members {
+		cx += m.mark.CenterX * m.weight
+		cy += m.mark.CenterY * m.weight
+		w += m.mark.Width * m.weight
+		h += m.mark.Height * m.weight
+		angle += math.Remainder(m.mark.Angle-ref, 180) * m.weight
+		total += m.weight
+	}
+	merged.CenterX, merged.CenterY = cx/total, cy/total
+	merged.Width, merged.Height = w/total, h/total
+	merged.Angle = ref + angle/total
+	return merged
+}
+
+// fuseKeypoints 按权重融合已标注的关键点，关键点数量不一致时返回 false
+func fuseKeypoints(members []consensusMember) (dto.AnnotationResult, bool) {
+	merged := members[0].mark
+	count := len(merged.Keypoints)
+	var total float64
+	for _, m := range members {
+		if len(m.mark.Keypoints) != count {
+			return dto.AnnotationResult{}, false
+		}
+		total += m.weight
+	}
+
+	merged.Keypoints = make([]dto.Keypoint, count)
+	var labeled [][]float64
+	for i := range merged.Keypoints {
+		kp := dto.Keypoint{Name: members[0].mark.Keypoints[i].Name}
+		var weight float64
+		for _, m := range members {
+			p := m.mark.Keypoints[i]
+			if p.Visibility == 0 {
+				continue
+			}
+			kp.X += p.X * m.weight
+			kp.Y += p.Y * m.weight
+			kp.Visibility = max(kp.Visibility, p.Visibility)
+			weight += m.weight
+		}
+		// 标出的标注员权重不到一半的关键点视为未标注
+		if weight == 0 || weight*2 < total {
+			merged.Keypoints[i] = dto.Keypoint{Name: kp.Name}
+			continue
+		}
+		kp.X, kp.Y = kp.X/weight, kp.Y/weight
+		merged.Keypoints[i] = kp
+		labeled = append(labeled, []float64{kp.X, kp.Y})
+	}
+	if len(labeled) == 0 {
+		return dto.AnnotationResult{}, false
+	}
+	setExtent(&merged, pointsExtent(labeled))
+	return merged, true
+}
+
+// medoid 与其他形状加权 IoU 之和最大的形状
+func medoid(members []consensusMember) dto.AnnotationResult {
+	best, bestScore := 0, -1.0
+	for i, a := range members {
+		var score float64
+		for j, b := range members {
+			if i != j {
+				score += a.extent.iou(b.extent) * b.weight
+			}
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return members[best].mark
+}
+
+// meanIoU 成员与融合结果外接矩形的平均 IoU
+func meanIoU(members []consensusMember, e extent) float64 {
+	var sum float64
+	for _, m := range members {
+		sum += m.extent.iou(e)
+	}
+	return sum / float64(len(members))
+}
+
+// hungarian 求使 scores 之和最大的一一匹配，返回每一行匹配的列，没有匹配时为 -1
+func hungarian(scores [][]float64) []int {
+	rows := len(scores)
+	assign := make([]int, rows)
+	for i := range assign {
+		assign[i] = -1
+	}
+	if rows == 0 || len(scores[0]) == 0 {
+		return assign
+	}
+	cols := len(scores[0])
+
+	// 补成方阵后求最小代价，下标从 1 开始
+	n := max(rows, cols)
+	cost := func(i, j int) float64 {
+		if i <= rows && j <= cols {
+			return -scores[i-1][j-1]
+		}
+		return 0
+	}
+	u := make([]float64, n+1)
+	v := make([]float64, n+1)
+	p := make([]int, n+1)
+	way := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]float64, n+1)
+		used := make([]bool, n+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
+		}
+		for {
+			used[j0] = true
+			i0, delta, j1 := p[j0], math.Inf(1), 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				cur := cost(i0, j) - u[i0] - v[j]
+				if cur < minv[j] {
+					minv[j], way[j] = cur, j0
+				}
+				if minv[j] < delta {
+					delta, j1 = minv[j], j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+	for j := 1; j <= n; j++ {
+		if p[j] >= 1 && p[j] <= rows && j <= cols {
+			assign[p[j]-1] = j - 1
+		}
+	}
+	return assign
+}
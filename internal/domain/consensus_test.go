@@ -0,0 +1,157 @@
+package domain
+
+import (
+	"math"
+	"reflect"
+	"sapphire-server/internal/data/dto"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestHungarian(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores [][]float64
+		want   []int
+	}{
+		{name: "empty", scores: nil, want: []int{}},
+		{name: "no columns", scores: [][]float64{{}, {}}, want: []int{-1, -1}},
+		{name: "square", scores: [][]float64{{0.9, 0.8}, {0.85, 0}}, want: []int{1, 0}},
+		{name: "more columns", scores: [][]float64{{0.1, 0.9, 0.3}, {0.8, 0.85, 0.2}}, want: []int{1, 0}},
+		{name: "more rows", scores: [][]float64{{0.6, 0}, {0.9, 0.7}, {0, 0.8}}, want: []int{-1, 0, 1}},
+		{name: "single column", scores: [][]float64{{0.5}, {0.7}}, want: []int{-1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hungarian(tt.scores)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hungarian(%v) = %v, want %v", tt.scores, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuseBoxes(t *testing.T) {
+	box := func(cx, cy, w, h, angle float64) dto.AnnotationResult {
+		return dto.AnnotationResult{Type: dto.ShapeRotatedBBox, ID: 1, CenterX: cx, CenterY: cy, Width: w, Height: h, Angle: angle}
+	}
+	tests := []struct {
+		name    string
+		members []consensusMember
+		want    dto.AnnotationResult
+	}{
+		{
+			name: "equal weights",
+			members: []consensusMember{
+				{weight: 1, mark: box(10, 10, 4, 4, 0)},
+				{weight: 1, mark: box(14, 12, 8, 6, 0)},
+			},
+			want: box(12, 11, 6, 5, 0),
+		},
+		{
+			name: "weighted",
+			members: []consensusMember{
+				{weight: 1, mark: box(10, 10, 4, 4, 0)},
+				{weight: 3, mark: box(14, 10, 8, 4, 0)},
+			},
+			want: box(13, 10, 7, 4, 0),
+		},
+		{
+			name: "angle wraps at 180",
+			members: []consensusMember{
+				{weight: 1, mark: box(10, 10, 4, 2, 170)},
+				{weight: 1, mark: box(10, 10, 4, 2, -170)},
+			},
+			want: box(10, 10, 4, 2, 180),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fuseBoxes(tt.members)
+			if got.Type != tt.want.Type || got.ID != tt.want.ID ||
+				!approx(got.CenterX, tt.want.CenterX) || !approx(got.CenterY, tt.want.CenterY) ||
+				!approx(got.Width, tt.want.Width) || !approx(got.Height, tt.want.Height) ||
+				!approx(got.Angle, tt.want.Angle) {
+				t.Errorf("fuseBoxes() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsensusMarks(t *testing.T) {
+	a := dto.AnnotationResult{Type: dto.ShapeBBox, ID: 1, CenterX: 10, CenterY: 10, Width: 10, Height: 10}
+	b := dto.AnnotationResult{Type: dto.ShapeBBox, ID: 2, CenterX: 50, CenterY: 50, Width: 10, Height: 10}
+	cat := dto.AnnotationResult{Type: dto.ShapeClass, ID: 3}
+	inputs := []consensusInput{
+		{marks: []dto.AnnotationResult{a, b, cat}, weight: 1},
+		{marks: []dto.AnnotationResult{a, cat}, weight: 1},
+		{marks: []dto.AnnotationResult{a}, weight: 1},
+	}
+	tests := []struct {
+		name      string
+		inputs    []consensusInput
+		agreement float64
+		// want 保留的标签和 Confidence
+		want map[uint]float64
+	}{
+		{name: "no annotators", inputs: nil, agreement: 0.5, want: map[uint]float64{}},
+		{name: "majority", inputs: inputs, agreement: 0.5, want: map[uint]float64{1: 1, 3: 2.0 / 3}},
+		{name: "unanimous", inputs: inputs, agreement: 1, want: map[uint]float64{1: 1}},
+		{name: "any", inputs: inputs, agreement: 0.3, want: map[uint]float64{1: 1, 2: 1.0 / 3, 3: 2.0 / 3}},
+		{
+			name: "label mismatch",
+			inputs: []consensusInput{
+				{marks: []dto.AnnotationResult{a}, weight: 1},
+				{marks: []dto.AnnotationResult{{Type: dto.ShapeBBox, ID: 2, CenterX: 10, CenterY: 10, Width: 10, Height: 10}}, weight: 1},
+			},
+			agreement: 0.6,
+			want:      map[uint]float64{},
+		},
+		{
+			name: "weighted annotator",
+			inputs: []consensusInput{
+				{marks: []dto.AnnotationResult{b}, weight: 3},
+				{marks: []dto.AnnotationResult{a}, weight: 1},
+			},
+			agreement: 0.5,
+			want:      map[uint]float64{2: 0.75},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := consensusMarks(tt.inputs, consensusOptions{iou: 0.5, agreement: tt.agreement})
+			if len(got) != len(tt.want) {
+				t.Fatalf("consensusMarks() returned %d marks, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for _, m := range got {
+				confidence, ok := tt.want[m.ID]
+				if !ok {
+					t.Errorf("unexpected mark %+v", m)
+					continue
+				}
+				if !approx(m.Confidence, confidence) {
+					t.Errorf("mark %d confidence = %v, want %v", m.ID, m.Confidence, confidence)
+				}
+			}
+		})
+	}
+}
+
+func TestLatestByUser(t *testing.T) {
+	anno := func(id, user uint) Annotation {
+		return Annotation{Model: gorm.Model{ID: id}, UserID: user}
+	}
+	got := latestByUser([]Annotation{anno(3, 1), anno(1, 2), anno(2, 1), anno(5, 2), anno(4, 1)})
+	var ids []uint
+	for _, a := range got {
+		ids = append(ids, a.ID)
+	}
+	if want := []uint{4, 5}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("latestByUser() ids = %v, want %v", ids, want)
+	}
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
@@ -1,18 +1,11 @@
 package domain
 
 import (
-	"bytes"
-	"crypto/sha1"
+	"context"
 	"fmt"
 	"gorm.io/gorm"
-	"io"
-	"log/slog"
-	"mime/multipart"
-	"net/http"
-	"os"
-	"regexp"
-	"sapphire-server/internal/conf"
 	"sapphire-server/internal/dao"
+	"sapphire-server/internal/data/datatypes"
 	"sapphire-server/internal/data/dto"
 	"time"
 )
@@ -27,10 +20,15 @@ type Dataset struct {
 	Cover       string    `gorm:"column:cover"`
 	TypeID      int       `gorm:"column:type_id"`
 	Format      string    `gorm:"column:format"`
-	Tags        string    `gorm:"column:tags"`
 	Size        int       `gorm:"column:size"`
 	EndTime     time.Time `gorm:"column:end_time"`
 	IsPublic    bool      `gorm:"column:is_public"`
+	// DuplicatePolicy 上传重复图片时的处理方式，见 DuplicatePolicy 常量
+	DuplicatePolicy string `gorm:"column:duplicate_policy"`
+	// NearDuplicateThreshold 感知哈希的汉明距离阈值，为 0 时不检查近似重复
+	NearDuplicateThreshold int `gorm:"column:near_duplicate_threshold"`
+	// SamID 数据集选用的嵌入模型，为 0 时使用默认模型
+	SamID uint `gorm:"column:sam_id"`
 }
 
 type DatasetTag struct {
@@ -50,56 +48,160 @@ type ImgDataset struct {
 	DatasetId    uint   `gorm:"column:dataset_id" json:"datasetId"`
 	Status       int    `gorm:"column:status" json:"status"`
 	EmbeddingUrl string `gorm:"column:embedding_url" json:"embeddingUrl"`
+	// EmbeddingSamID 生成当前嵌入结果的模型
+	EmbeddingSamID uint `gorm:"column:embedding_sam_id" json:"embeddingSamId"`
+	// Position 图片在数据集中的顺序，相同时按 ID 排序
+	Position int `gorm:"column:position" json:"position"`
+	// 以下字段由后台生成缩略图时填写，生成之前为空
+	Width  int    `gorm:"column:width" json:"width"`
+	Height int    `gorm:"column:height" json:"height"`
+	Format string `gorm:"column:format" json:"format"`
+	Bytes  int64  `gorm:"column:bytes" json:"bytes"`
+	// ThumbnailUrl 默认尺寸的缩略图
+	ThumbnailUrl string `gorm:"column:thumbnail_url" json:"thumbnailUrl"`
+	// Thumbnails 各尺寸缩略图的地址，key 为尺寸名
+	Thumbnails datatypes.JSONMap `gorm:"column:thumbnails" json:"thumbnails"`
+	PreviewUrl string            `gorm:"column:preview_url" json:"previewUrl"`
+	// Sha256 图片内容的哈希，用于去重
+	Sha256 string `gorm:"column:sha256" json:"sha256"`
+	// PHash 感知哈希，用于发现近似重复的图片
+	PHash string `gorm:"column:phash" json:"-"`
+	// DerivativeStatus 缩略图的生成状态
+	DerivativeStatus    int        `gorm:"column:derivative_status" json:"-"`
+	DerivativeClaimedAt *time.Time `gorm:"column:derivative_claimed_at" json:"-"`
+	// DerivativeAttempts 下载或上传失败的次数，DerivativeRetryAt 之前不会重新认领
+	DerivativeAttempts int        `gorm:"column:derivative_attempts" json:"-"`
+	DerivativeRetryAt  *time.Time `gorm:"column:derivative_retry_at" json:"-"`
 }
 
+// 上传重复图片时的处理方式
+const (
+	// DuplicatePolicyAllow 照常加入，只在上传结果中报告
+	DuplicatePolicyAllow = "allow"
+	// DuplicatePolicySkip 跳过数据集中已有的图片
+	DuplicatePolicySkip = "skip"
+	// DuplicatePolicyLink 跳过数据集中已有的图片，其他数据集中已有的图片直接引用，不再上传
+	DuplicatePolicyLink = "link"
+)
+
 const (
 	ImgStatusDefault   = 0
 	ImgStatusEmbedded  = 1
 	ImgStatusAnnotated = 2
+	// ImgStatusEmbeddingFailed 嵌入任务超过重试次数，重置状态后重新嵌入
+	ImgStatusEmbeddingFailed = -1
 )
 
 type DatasetUser struct {
 	gorm.Model
 	UserID    uint `gorm:"column:user_id"`
 	DatasetID uint `gorm:"column:dataset_id"`
+	// Role 用户在数据集中的角色，见 DatasetRole 常量
+	Role string `gorm:"column:role"`
+}
+
+// datasetPageOptions 数据集列表允许的排序和过滤字段
+var datasetPageOptions = dao.PageOptions{
+	SortFields: map[string]string{
+		"createdAt": "created_at",
+		"updatedAt": "updated_at",
+		"name":      "name",
+		"endTime":   "end_time",
+		"size":      "size",
+	},
+	FilterFields: map[string]string{
+		"name":      "name",
+		"creatorId": "creator_id",
+		"typeId":    "type_id",
+		"format":    "format",
+		"isPublic":  "is_public",
+	},
 }
 
 func NewDatasetDomain() *Dataset {
 	return &Dataset{}
 }
 
-// AddUserToDataset 添加用户到数据集
-func (d *Dataset) AddUserToDataset(userID uint, datasetID uint) error {
-	var err error
-	exist, err := dao.FindOne[DatasetUser]("user_id = ? and dataset_id = ?", userID, datasetID)
+// JoinDataset 用户主动加入数据集，只能加入公开的数据集，加入后为标注员
+func (d *Dataset) JoinDataset(ctx context.Context, userID uint, datasetID uint) error {
+	dataset, err := d.GetDatasetByID(datasetID)
 	if err != nil {
 		return err
 	}
-	if exist != nil {
-		return nil
+	if dataset == nil {
+		return fmt.Errorf("dataset not found")
 	}
-
-	datasetUser := &DatasetUser{
-		UserID:    userID,
-		DatasetID: datasetID,
+	if !dataset.IsPublic {
+		return ErrPermissionDenied
 	}
-	err = dao.Save(datasetUser)
+	return d.AddUserToDataset(ctx, userID, datasetID, DatasetRoleAnnotator)
+}
+
+// AddUserToDataset 添加用户到数据集，已经是成员时不修改角色
+func (d *Dataset) AddUserToDataset(ctx context.Context, userID uint, datasetID uint, role string) error {
+	return dao.WithTx(ctx, func(tx context.Context) error {
+		var err error
+		exist, err := dao.FindOne[DatasetUser](tx, "user_id = ? and dataset_id = ?", userID, datasetID)
+		if err != nil {
+			return err
+		}
+		if exist != nil {
+			return nil
+		}
+
+		datasetUser := &DatasetUser{
+			UserID:    userID,
+			DatasetID: datasetID,
+			Role:      role,
+		}
+		err = dao.Save(tx, datasetUser)
+		if err != nil {
+			return err
+		}
+
+		// 发送消息
+		content := fmt.Sprintf("您已成功加入数据集 %d", datasetID)
+		_, err = messageDomain.SendMessage(tx, content, "加入数据集", MessageTypeTREND, userID)
+		return err
+	})
+}
 
+// SetMemberRole 设置成员在数据集中的角色，不是成员时直接加入
+func (d *Dataset) SetMemberRole(ctx context.Context, datasetID uint, userID uint, role string) error {
+	if !IsValidDatasetRole(role) {
+		return fmt.Errorf("invalid role %s", role)
+	}
+	user, err := dao.FindOne[User](ctx, userID)
 	if err != nil {
 		return err
 	}
+	if user == nil {
+		return fmt.Errorf("user not found")
+	}
 
-	// 发送消息
-	content := fmt.Sprintf("您已成功加入数据集 %d", datasetID)
-	messageDomain.SendMessage(content, "加入数据集", MessageTypeTREND, userID)
+	return dao.WithTx(ctx, func(tx context.Context) error {
+		member, err := dao.FindOne[DatasetUser](tx, "user_id = ? and dataset_id = ?", userID, datasetID)
+		if err != nil {
+			return err
+		}
+		if member == nil {
+			return d.AddUserToDataset(tx, userID, datasetID, role)
+		}
+		err = dao.Modify(tx, member, "role", role)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		content := fmt.Sprintf("您在数据集 %d 中的角色已变更为 %s", datasetID, role)
+		_, err = messageDomain.SendMessage(tx, content, "角色变更", MessageTypeTREND, userID)
+		return err
+	})
 }
 
 // RemoveUserFromDataset 移除用户从数据集
 func (d *Dataset) RemoveUserFromDataset(userID uint, datasetID uint) error {
 	var err error
-	record, err := dao.First[DatasetUser]("user_id = ? and dataset_id = ?", userID, datasetID)
+	record, err := dao.First[DatasetUser](context.Background(), "user_id = ? and dataset_id = ?", userID, datasetID)
 	if err != nil {
 		return err
 	}
@@ -107,7 +209,7 @@ func (d *Dataset) RemoveUserFromDataset(userID uint, datasetID uint) error {
 		return nil
 	}
 
-	err = dao.Delete(record)
+	err = dao.Delete(context.Background(), record)
 	if err != nil {
 		return err
 	}
@@ -116,7 +218,7 @@ func (d *Dataset) RemoveUserFromDataset(userID uint, datasetID uint) error {
 
 // ListJoinedUserByDatasetID 列出数据集的用户
 func (d *Dataset) ListJoinedUserByDatasetID(datasetID uint) ([]DatasetUser, error) {
-	res, err := dao.FindAll[DatasetUser]("dataset_id = ?", datasetID)
+	res, err := dao.FindAll[DatasetUser](context.Background(), "dataset_id = ?", datasetID)
 	if err != nil {
 		return nil, err
 	}
@@ -125,7 +227,7 @@ func (d *Dataset) ListJoinedUserByDatasetID(datasetID uint) ([]DatasetUser, erro
 
 // IsUserClaimDataset 判断用户是否拥有数据集
 func (d *Dataset) IsUserClaimDataset(userID uint, datasetID uint) bool {
-	record, err := dao.FindOne[DatasetUser]("user_id = ? and dataset_id = ?", userID, datasetID)
+	record, err := dao.FindOne[DatasetUser](context.Background(), "user_id = ? and dataset_id = ?", userID, datasetID)
 	if err != nil {
 		return false
 	}
@@ -136,13 +238,29 @@ func (d *Dataset) IsUserClaimDataset(userID uint, datasetID uint) bool {
 }
 
 // CreateDataset 创建数据集
-func (d *Dataset) CreateDataset(creatorId uint, dto dto.NewDataset) (*Dataset, error) {
+func (d *Dataset) CreateDataset(ctx context.Context, creatorId uint, dto dto.NewDataset) (*Dataset, error) {
 	// 创建数据集记录
 	datasetInfo := &Dataset{
 		Name:        dto.Name,
 		CreatorID:   creatorId,
 		Description: dto.Description,
 		Cover:       dto.Cover,
+		// 未指定时默认公开，与之前的行为保持一致
+		IsPublic:        dto.IsPublic == nil || *dto.IsPublic,
+		DuplicatePolicy: DuplicatePolicyAllow,
+	}
+	if dto.DuplicatePolicy != nil {
+		datasetInfo.DuplicatePolicy = *dto.DuplicatePolicy
+	}
+	if dto.NearDuplicateThreshold != nil {
+		datasetInfo.NearDuplicateThreshold = *dto.NearDuplicateThreshold
+	}
+	if dto.SamID != nil && *dto.SamID != 0 {
+		_, err := ActiveSam(ctx, *dto.SamID)
+		if err != nil {
+			return nil, err
+		}
+		datasetInfo.SamID = *dto.SamID
 	}
 
 	scheduleTime := time.Now()
@@ -151,26 +269,30 @@ func (d *Dataset) CreateDataset(creatorId uint, dto dto.NewDataset) (*Dataset, e
 	}
 	datasetInfo.EndTime = scheduleTime
 
-	// 添加标注tag的记录
-	tags := dto.Tags
-	tagStr := ""
-	for _, tag := range tags {
-		tagStr += tag + ","
-	}
-	datasetInfo.Tags = tagStr
+	err := dao.WithTx(ctx, func(tx context.Context) error {
+		err := dao.Save(tx, datasetInfo)
+		if err != nil {
+			return err
+		}
+		// tags 按顺序创建为标签
+		err = addLabelsByName(tx, datasetInfo.ID, dto.Tags)
+		if err != nil {
+			return err
+		}
 
-	err := dao.Save(datasetInfo)
+		// 发送消息
+		content := fmt.Sprintf("您已成功创建数据集 %s", datasetInfo.Name)
+		_, err = messageDomain.SendMessage(tx, content, "创建数据集", MessageTypeTREND, creatorId)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	// 发送消息
-	content := fmt.Sprintf("您已成功创建数据集 %s", datasetInfo.Name)
-	messageDomain.SendMessage(content, "创建数据集", MessageTypeTREND, creatorId)
 	return datasetInfo, nil
 }
 
-func (d *Dataset) UpdateDataset(creatorID uint, id uint, dto dto.NewDataset) (*Dataset, error) {
+// UpdateDataset 更新数据集，调用方需要先检查 PermDatasetUpdate 权限
+func (d *Dataset) UpdateDataset(id uint, dto dto.NewDataset) (*Dataset, error) {
 	var err error
 	dataset, err := d.GetDatasetByID(id)
 	if err != nil {
@@ -180,20 +302,37 @@ func (d *Dataset) UpdateDataset(creatorID uint, id uint, dto dto.NewDataset) (*D
 		return nil, fmt.Errorf("dataset not found")
 	}
 
-	if creatorID != dataset.CreatorID {
-		return nil, fmt.Errorf("no permission")
+	if dto.IsPublic != nil {
+		dataset.IsPublic = *dto.IsPublic
+	}
+	if dto.DuplicatePolicy != nil {
+		dataset.DuplicatePolicy = *dto.DuplicatePolicy
+	}
+	if dto.NearDuplicateThreshold != nil {
+		dataset.NearDuplicateThreshold = *dto.NearDuplicateThreshold
+	}
+	// 已经选用的模型废弃后仍然可以保留
+	if dto.SamID != nil && *dto.SamID != dataset.SamID {
+		if *dto.SamID != 0 {
+			_, err = ActiveSam(context.Background(), *dto.SamID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		dataset.SamID = *dto.SamID
 	}
 	dataset.Name = dto.Name
 	dataset.Description = dto.Description
 	dataset.Cover = dto.Cover
 	dataset.EndTime, _ = time.Parse("2006-01-02 15:04:05", dto.EndTime)
-	tagStr := ""
-	for _, tag := range dto.Tags {
-		tagStr += tag + ","
-	}
-	dataset.Tags = tagStr
-
-	err = dao.Save(dataset)
+	err = dao.WithTx(context.Background(), func(tx context.Context) error {
+		err := dao.Save(tx, dataset)
+		if err != nil {
+			return err
+		}
+		// 已有的标签可能被标注使用，这里只添加新的 tags，修改和删除使用标签接口
+		return addLabelsByName(tx, dataset.ID, dto.Tags)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -204,7 +343,7 @@ func (d *Dataset) UpdateDataset(creatorID uint, id uint, dto dto.NewDataset) (*D
 
 // DeleteDataset 删除数据集
 func (d *Dataset) DeleteDataset() error {
-	err := dao.Delete(d)
+	err := dao.Delete(context.Background(), d)
 	if err != nil {
 		return err
 	}
@@ -213,164 +352,53 @@ func (d *Dataset) DeleteDataset() error {
 
 // GetDatasetByID 根据 ID 获取数据集
 func (d *Dataset) GetDatasetByID(id uint) (*Dataset, error) {
-	res, err := dao.First[Dataset]("id = ?", id)
+	res, err := dao.First[Dataset](context.Background(), "id = ?", id)
 	if err != nil {
 		return nil, err
 	}
 	return res, nil
 }
 
-// GetResultArchive 获取结果归档
-func (d *Dataset) GetResultArchive(id uint) (string, error) {
-	annotations, err := dao.FindAll[Annotation]("dataset_id = ?", id)
-	if err != nil {
-		return "", err
-	}
-
-	var imgIDs []uint
-	for _, a := range annotations {
-		imgIDs = append(imgIDs, a.ImageID)
-	}
-	images, err := d.ListImagesByIDs(imgIDs)
-
-	// 将img构建为以id为key的map，方便后续查找
-	imgMap := make(map[uint]ImgDataset)
-	for _, img := range images {
-		imgMap[img.ID] = img
-	}
-
-	// 将数据写入一个.txt文件
-	// 保存到本地
-	// 返回文件路径
-	fileName := fmt.Sprintf("result_%d.txt", id)
-	f, err := os.Create(fileName)
-	if err != nil {
-		return "", err
-	}
-	defer func(f *os.File) {
-		err := f.Close()
-		if err != nil {
-			slog.Error("close file failed", err)
-		}
-	}(f)
-
-	for _, anno := range annotations {
-		img := imgMap[anno.ImageID]
-		strPattern := "id: %d, content: %s, deliveredCount: %d, qualifiedCount: %d, replicaCount: %d, status: %d, imageUrl: %s\n"
-		str := fmt.Sprintf(strPattern, anno.ID, anno.Content, anno.DeliveredCount, anno.QualifiedCount, anno.ReplicaCount, anno.Status, img.ImgUrl)
-		_, err := f.WriteString(str)
-		if err != nil {
-			return "", err
-		}
-	}
-
-	// 结束写入
-	err = f.Sync()
-	if err != nil {
-		return "", err
-	}
-
-	// 将文件上传到OSS
-	// 返回文件路径
-	info := conf.GetImgConfig()
-	re := regexp.MustCompile(`svrUrl: (.*?); directUrl: (.*?); auth string: (.*?);`)
-	matches := re.FindStringSubmatch(info)
-	var svrUrl, directUrl, auth string
-	if len(matches) == 4 {
-		svrUrl = matches[1]
-		directUrl = matches[2]
-		auth = matches[3]
-	} else {
-		fmt.Println("String format is not valid.")
-	}
-
-	// 打开文件
-	file, err := os.Open(fileName)
-	if err != nil {
-		return "", err
-	}
-	defer func(file multipart.File) {
-		err := file.Close()
-		if err != nil {
-			fmt.Println("Failed to close file:", err)
-		}
-	}(file)
-
-	// 删除文件
-	defer func() {
-		err := os.Remove(fileName)
-		if err != nil {
-			fmt.Println("Failed to remove file:", err)
-		}
-	}()
-
-	fileBytes, err := io.ReadAll(file)
-	if err != nil {
-		return "", err
-	}
-
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	// 生成一个随机文件名
-	// 对当前时间戳进行哈希
-	timeStr := time.Now().String()
-	h := sha1.New()
-	h.Write([]byte(timeStr))
-	hashedTimeStr := fmt.Sprintf("%x", h.Sum(nil)) // 使用SHA256哈希
-	// 使用SHA256哈希
-	fileName = fileName + "_" + hashedTimeStr + ".txt"
-
-	req, err := http.NewRequest("PUT", svrUrl+fileName, bytes.NewReader(fileBytes))
+// GetDatasetList 获取数据集列表
+func (d *Dataset) GetDatasetList() ([]Dataset, error) {
+	res, err := dao.FindAll[Dataset](context.Background())
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return res, nil
+}
 
-	req.Header.Set("Authorization", auth)
+// visibleDatasetSQL 用户可见的数据集：公开的、自己创建的和加入的
+const visibleDatasetSQL = "(is_public = true or creator_id = ? or id in (select dataset_id from dataset_users where user_id = ? and deleted_at is null))"
 
-	// 发送请求
-	resp, err := client.Do(req)
+// ListDatasetPage 分页列出用户可见的数据集，管理员可以看到全部数据集
+func (d *Dataset) ListDatasetPage(userID uint, req dao.PageRequest) (*dao.Page[Dataset], error) {
+	ctx := context.Background()
+	admin, err := IsPlatformAdmin(ctx, userID)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			fmt.Println("Failed to close response body:", err)
-		}
-	}(resp.Body)
 
-	// 检查响应状态
-	if resp.StatusCode != http.StatusCreated {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	var conditions []interface{}
+	if !admin {
+		conditions = []interface{}{visibleDatasetSQL, userID, userID}
 	}
-
-	// 读取响应体
-	_, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	return directUrl + fileName, nil
-}
-
-// GetDatasetList 获取数据集列表
-func (d *Dataset) GetDatasetList() ([]Dataset, error) {
-	res, err := dao.FindAll[Dataset]()
+	page, err := dao.FindPage[Dataset](ctx, req, datasetPageOptions, conditions...)
 	if err != nil {
 		return nil, err
 	}
-	return res, nil
+	return page, nil
 }
 
-// ListByKeywords 根据关键字列出数据集
-func (d *Dataset) ListByKeywords(keywords []string) ([]Dataset, error) {
-	sql := "select * from datasets where name like ?"
-	for i := 1; i < len(keywords); i++ {
+// ListByKeywords 根据关键字列出用户可见的数据集
+func (d *Dataset) ListByKeywords(userID uint, keywords []string) ([]Dataset, error) {
+	sql := "select * from datasets where deleted_at is null and " + visibleDatasetSQL
+	args := []interface{}{userID, userID}
+	for _, keyword := range keywords {
 		sql += " and name like ?"
+		args = append(args, "%"+keyword+"%")
 	}
-	res, err := dao.Query[Dataset](sql, keywords[0])
+	res, err := dao.Query[Dataset](context.Background(), sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -379,7 +407,7 @@ func (d *Dataset) ListByKeywords(keywords []string) ([]Dataset, error) {
 
 // ListAllDataset 列出所有记录
 func (d *Dataset) ListAllDataset() ([]Dataset, error) {
-	res, err := dao.FindAll[Dataset]()
+	res, err := dao.FindAll[Dataset](context.Background())
 	if err != nil {
 		return nil, err
 	}
@@ -389,7 +417,7 @@ func (d *Dataset) ListAllDataset() ([]Dataset, error) {
 // ListUserJoinedDatasetList 列出用户加入的数据集
 func (d *Dataset) ListUserJoinedDatasetList(userID uint) ([]Dataset, error) {
 	sql := "select * from datasets where id in (select dataset_id from dataset_users where user_id = ?) and creator_id != ?"
-	res, err := dao.Query[Dataset](sql, userID, userID)
+	res, err := dao.Query[Dataset](context.Background(), sql, userID, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -398,7 +426,7 @@ func (d *Dataset) ListUserJoinedDatasetList(userID uint) ([]Dataset, error) {
 
 // ListUserCreatedDatasets 列出用户创建的数据集
 func (d *Dataset) ListUserCreatedDatasets(createdID uint) ([]Dataset, error) {
-	res, err := dao.FindAll[Dataset]("creator_id = ?", createdID)
+	res, err := dao.FindAll[Dataset](context.Background(), "creator_id = ?", createdID)
 	if err != nil {
 		return nil, err
 	}
@@ -407,7 +435,7 @@ func (d *Dataset) ListUserCreatedDatasets(createdID uint) ([]Dataset, error) {
 
 // GetDatasetTypeByID 根据 ID 获取数据集类型
 func (d *Dataset) GetDatasetTypeByID(id uint) (*DatasetType, error) {
-	res, err := dao.First[DatasetType]("id = ?", id)
+	res, err := dao.First[DatasetType](context.Background(), "id = ?", id)
 	if err != nil {
 		return nil, err
 	}
@@ -416,7 +444,7 @@ func (d *Dataset) GetDatasetTypeByID(id uint) (*DatasetType, error) {
 
 // ListImagesByIDs 根据 ID 列出图片
 func (d *Dataset) ListImagesByIDs(ids []uint) ([]ImgDataset, error) {
-	res, err := dao.FindAll[ImgDataset]("id in ?", ids)
+	res, err := dao.FindAll[ImgDataset](context.Background(), "id in ?", ids)
 	if err != nil {
 		return nil, err
 	}
@@ -428,13 +456,13 @@ func (d *Dataset) ListImagesByStatusAndDatasetID(datasetID uint, status int) ([]
 	var err error
 	var res []ImgDataset
 	if status == -1 {
-		res, err = dao.FindAll[ImgDataset]("dataset_id = ?", datasetID)
+		res, err = dao.FindAll[ImgDataset](context.Background(), "dataset_id = ?", datasetID)
 		if err != nil {
 			return nil, err
 		}
 
 	} else {
-		res, err = dao.FindAll[ImgDataset]("dataset_id = ? and status = ?", datasetID, status)
+		res, err = dao.FindAll[ImgDataset](context.Background(), "dataset_id = ? and status = ?", datasetID, status)
 		if err != nil {
 			return nil, err
 		}
@@ -449,38 +477,67 @@ func (d *Dataset) RegisterImage(imgUrl string, datasetID uint) {
 		ImgUrl:    imgUrl,
 		DatasetId: datasetID,
 	}
-	err := dao.Save(img)
+	err := dao.Save(context.Background(), img)
 	if err != nil {
 		return
 	}
 }
 
-// AddImageList 添加图片列表
-func (d *Dataset) AddImageList(dataset *Dataset, images []string) error {
+// AddImageList 添加图片列表，返回保存后的图片记录
+func (d *Dataset) AddImageList(ctx context.Context, dataset *Dataset, images []string) ([]ImgDataset, error) {
 	var err error
+	if len(images) == 0 {
+		return nil, nil
+	}
 
 	var imageList []ImgDataset
 	for _, img := range images {
 		image := ImgDataset{
-			ImgUrl:    img,
-			DatasetId: dataset.ID,
-			Status:    ImgStatusDefault,
+			ImgUrl: img,
 		}
 		imageList = append(imageList, image)
 	}
 
-	println("imageList", imageList)
-	err = dao.SaveAll[ImgDataset](imageList)
+	imageList, err = d.AddImages(ctx, dataset, imageList)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return imageList, nil
+}
+
+// AddImages 添加图片记录到数据集，可以带上已经计算好的哈希等信息
+func (d *Dataset) AddImages(ctx context.Context, dataset *Dataset, images []ImgDataset) ([]ImgDataset, error) {
+	if len(images) == 0 {
+		return nil, nil
+	}
+	// 新图片排在数据集的最后
+	last, err := dao.Query[imagePosition](ctx,
+		"SELECT COALESCE(MAX(position), 0) AS position FROM img_datasets WHERE dataset_id = ? AND deleted_at IS NULL", dataset.ID)
+	if err != nil {
+		return nil, err
+	}
+	position := 0
+	if len(last) > 0 {
+		position = last[0].Position
+	}
+	for i := range images {
+		images[i].DatasetId = dataset.ID
+		images[i].Status = ImgStatusDefault
+		images[i].Position = position + i + 1
+	}
+
+	err = dao.SaveAll[ImgDataset](ctx, images)
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
 }
 
 // GetDatasetDataList 获取数据集数据列表
 func (d *Dataset) GetDatasetDataList(id uint) ([]ImgDataset, error) {
-	res, err := dao.FindAll[ImgDataset]("dataset_id = ?", id)
+	res, err := dao.Query[ImgDataset](context.Background(),
+		"select * from img_datasets where dataset_id = ? and deleted_at is null order by position, id", id)
 	if err != nil {
 		return nil, err
 	}
@@ -488,7 +545,7 @@ func (d *Dataset) GetDatasetDataList(id uint) ([]ImgDataset, error) {
 }
 
 func (d *Dataset) GetImgByDatasetID(id uint, size int) ([]ImgDataset, error) {
-	res, err := dao.Query[ImgDataset]("select * from img_datasets where dataset_id = ? limit ?", id, size)
+	res, err := dao.Query[ImgDataset](context.Background(), "select * from img_datasets where dataset_id = ? and deleted_at is null order by position, id limit ?", id, size)
 	if err != nil {
 		return nil, err
 	}
@@ -497,8 +554,8 @@ func (d *Dataset) GetImgByDatasetID(id uint, size int) ([]ImgDataset, error) {
 
 // ListNotEmbeddedImgByDatasetID 获取未嵌入的图片
 func (d *Dataset) ListNotEmbeddedImgByDatasetID(id uint, size int) ([]ImgDataset, error) {
-	sql := "select * from img_datasets where dataset_id = ? and status = ? limit ?"
-	res, err := dao.Query[ImgDataset](sql, id, ImgStatusDefault, size)
+	sql := "select * from img_datasets where dataset_id = ? and status = ? and deleted_at is null limit ?"
+	res, err := dao.Query[ImgDataset](context.Background(), sql, id, ImgStatusDefault, size)
 	if err != nil {
 		return nil, err
 	}
@@ -507,8 +564,8 @@ func (d *Dataset) ListNotEmbeddedImgByDatasetID(id uint, size int) ([]ImgDataset
 
 // ListAllNotEmbeddedImg 列出所有未嵌入的图片
 func (d *Dataset) ListAllNotEmbeddedImg(size int) ([]ImgDataset, error) {
-	sql := "select * from img_datasets where  status = ? order by created_at desc limit ?"
-	res, err := dao.Query[ImgDataset](sql, ImgStatusDefault, size)
+	sql := "select * from img_datasets where status = ? and deleted_at is null order by created_at desc limit ?"
+	res, err := dao.Query[ImgDataset](context.Background(), sql, ImgStatusDefault, size)
 	if err != nil {
 		return nil, err
 	}
@@ -517,7 +574,7 @@ func (d *Dataset) ListAllNotEmbeddedImg(size int) ([]ImgDataset, error) {
 
 // EmbeddingImg 嵌入图片
 func (d *Dataset) EmbeddingImg(id uint) error {
-	img, err := dao.FindOne[ImgDataset]("id = ?", id)
+	img, err := dao.FindOne[ImgDataset](context.Background(), "id = ?", id)
 	if err != nil {
 		return err
 	}
@@ -526,7 +583,7 @@ func (d *Dataset) EmbeddingImg(id uint) error {
 	}
 
 	img.Status = ImgStatusEmbedded
-	err = dao.Save(img)
+	err = dao.Save(context.Background(), img)
 	if err != nil {
 		return err
 	}
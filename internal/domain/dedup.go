@@ -0,0 +1,241 @@
+package domain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sapphire-server/internal/dao"
+	"sapphire-server/pkg/misc"
+	"sync"
+)
+
+// dedupQueryBatchSize 按哈希查询已有图片时每次带上的哈希数
+const dedupQueryBatchSize = 1000
+
+// imageHash 已有图片的哈希
+type imageHash struct {
+	ID        uint
+	DatasetId uint
+	ImgUrl    string
+	Sha256    string
+	PHash     string `gorm:"column:phash"`
+}
+
+// hashJobFiles 并发计算待上传文件的哈希，感知哈希失败时留空，不影响上传
+func hashJobFiles(files []UploadJobFile, perceptual bool) {
+	var wg sync.WaitGroup
+	ch := make(chan int)
+	for w := 0; w < fetchWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				hashJobFile(&files[i], perceptual)
+			}
+		}()
+	}
+	for i := range files {
+		if files[i].Status == UploadFilePending {
+			ch <- i
+		}
+	}
+	close(ch)
+	wg.Wait()
+}
+
+func hashJobFile(file *UploadJobFile, perceptual bool) {
+	if !perceptual {
+		// 只需要内容哈希时流式读取，不把整个文件读入内存
+		f, err := os.Open(file.Path)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return
+		}
+		file.Sha256 = hex.EncodeToString(h.Sum(nil))
+		return
+	}
+
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		return
+	}
+	file.Sha256 = misc.ContentHash(data)
+	file.PHash, _ = misc.PerceptualHash(data)
+}
+
+// duplicateMatch 一个文件的去重结果
+type duplicateMatch struct {
+	// duplicate 重复的类型，不重复时为空
+	duplicate string
+	// of 重复的已有图片，与压缩包中前面的文件重复时为 nil
+	of *imageHash
+	// file 内容相同的压缩包中前面的文件
+	file string
+	// skip 按数据集的去重策略跳过该文件
+	skip bool
+}
+
+// duplicateChecker 按数据集的去重策略判断文件是否重复，上传任务和导入压缩包共用
+type duplicateChecker struct {
+	policy    string
+	inDataset map[string]imageHash
+	elsewhere map[string]imageHash
+	// seen 压缩包中每个哈希第一次出现的文件
+	seen map[string]string
+}
+
+// newDuplicateChecker 查询数据集中已有的与 hashes 相同的图片，link 策略下同时查询其他数据集
+func newDuplicateChecker(ctx context.Context, dataset *Dataset, hashes []string) (*duplicateChecker, error) {
+	c := &duplicateChecker{policy: dataset.DuplicatePolicy, elsewhere: map[string]imageHash{}, seen: make(map[string]string)}
+	var err error
+	c.inDataset, err = findImagesBySha256(ctx, "dataset_id = ?", dataset.ID, hashes)
+	if err != nil {
+		return nil, err
+	}
+	if dataset.DuplicatePolicy == DuplicatePolicyLink {
+		c.elsewhere, err = findImagesBySha256(ctx, "dataset_id <> ?", dataset.ID, hashes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// check 需要按压缩包中的顺序调用
+// 与数据集或压缩包中前面的文件内容相同时，skip 和 link 策略跳过该文件，allow 策略照常上传
+// link 策略下其他数据集中已有的图片直接引用，不再上传
+func (c *duplicateChecker) check(hash string, name string) duplicateMatch {
+	skip := c.policy != DuplicatePolicyAllow && c.policy != ""
+	if img, ok := c.inDataset[hash]; ok {
+		return duplicateMatch{duplicate: DuplicateExact, of: &img, skip: skip}
+	}
+	if first, ok := c.seen[hash]; ok {
+		return duplicateMatch{duplicate: DuplicateExact, file: first, skip: skip}
+	}
+	c.seen[hash] = name
+	if img, ok := c.elsewhere[hash]; ok {
+		return duplicateMatch{duplicate: DuplicateLinked, of: &img}
+	}
+	return duplicateMatch{}
+}
+
+// dedup 按数据集的去重策略处理待上传的文件，所有策略都会在结果中报告重复
+// 设置了感知哈希阈值时，再标记与已有图片近似重复的文件，这些文件照常上传
+func (j *UploadJob) dedup(ctx context.Context, dataset *Dataset) error {
+	files, err := dao.FindAll[UploadJobFile](ctx, "job_id = ? AND status = ? AND sha256 <> ''", j.ID, UploadFilePending)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	var hashes []string
+	for _, file := range files {
+		hashes = append(hashes, file.Sha256)
+	}
+	checker, err := newDuplicateChecker(ctx, dataset, hashes)
+	if err != nil {
+		return err
+	}
+
+	var unique []*UploadJobFile
+	for i := range files {
+		file := &files[i]
+		m := checker.check(file.Sha256, file.Name)
+		var values map[string]interface{}
+		switch {
+		case m.duplicate == DuplicateLinked:
+			values = map[string]interface{}{
+				"duplicate":    DuplicateLinked,
+				"duplicate_of": m.of.ID,
+				"status":       UploadFileUploaded,
+				"url":          m.of.ImgUrl,
+				"object_key":   "",
+			}
+		case m.of != nil:
+			values = map[string]interface{}{"duplicate": DuplicateExact, "duplicate_of": m.of.ID}
+		case m.file != "":
+			values = map[string]interface{}{"duplicate": DuplicateExact, "duplicate_file": m.file}
+		default:
+			unique = append(unique, file)
+			continue
+		}
+		if m.skip {
+			values["status"] = UploadFileDuplicate
+		}
+		_, err = dao.UpdateWhere[UploadJobFile](ctx, values, "id = ?", file.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if dataset.NearDuplicateThreshold > 0 {
+		return j.markNearDuplicates(ctx, dataset, unique)
+	}
+	return nil
+}
+
+// markNearDuplicates 标记与数据集或压缩包中前面的文件感知哈希接近的文件
+func (j *UploadJob) markNearDuplicates(ctx context.Context, dataset *Dataset, files []*UploadJobFile) error {
+	existing, err := dao.Query[imageHash](ctx,
+		"SELECT id, phash FROM img_datasets WHERE dataset_id = ? AND phash <> '' AND deleted_at IS NULL", dataset.ID)
+	if err != nil {
+		return err
+	}
+
+	var previous []*UploadJobFile
+	for _, file := range files {
+		if file.PHash == "" {
+			continue
+		}
+		best := -1
+		var values map[string]interface{}
+		for _, img := range existing {
+			d := misc.HammingDistance(file.PHash, img.PHash)
+			if d >= 0 && d <= dataset.NearDuplicateThreshold && (best < 0 || d < best) {
+				best = d
+				values = map[string]interface{}{"duplicate": DuplicateNear, "duplicate_of": img.ID, "distance": d}
+			}
+		}
+		for _, prev := range previous {
+			d := misc.HammingDistance(file.PHash, prev.PHash)
+			if d >= 0 && d <= dataset.NearDuplicateThreshold && (best < 0 || d < best) {
+				best = d
+				values = map[string]interface{}{"duplicate": DuplicateNear, "duplicate_file": prev.Name, "distance": d}
+			}
+		}
+		previous = append(previous, file)
+		if values == nil {
+			continue
+		}
+		_, err = dao.UpdateWhere[UploadJobFile](ctx, values, "id = ?", file.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findImagesBySha256 按内容哈希查找满足 condition 的图片，同一个哈希只保留最早的一张
+func findImagesBySha256(ctx context.Context, condition string, arg interface{}, hashes []string) (map[string]imageHash, error) {
+	result := make(map[string]imageHash)
+	for start := 0; start < len(hashes); start += dedupQueryBatchSize {
+		end := min(start+dedupQueryBatchSize, len(hashes))
+		images, err := dao.Query[imageHash](ctx,
+			"SELECT id, dataset_id, img_url, sha256 FROM img_datasets WHERE "+condition+" AND sha256 IN ? AND deleted_at IS NULL ORDER BY id",
+			arg, hashes[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, img := range images {
+			if _, ok := result[img.Sha256]; !ok {
+				result[img.Sha256] = img
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,221 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sapphire-server/internal/dao"
+	"sapphire-server/internal/data/datatypes"
+	"sapphire-server/pkg/misc"
+	"time"
+)
+
+// 缩略图的生成状态
+const (
+	DerivativePending = 0
+	// DerivativeProcessing 已被 worker 认领，超过 derivativeClaimTimeout 未完成时可以重新认领
+	DerivativeProcessing = 1
+	DerivativeDone       = 2
+	// DerivativeFailed 图片无法解码或格式不支持，或者下载多次失败，不再自动重试
+	DerivativeFailed = -1
+)
+
+// derivativeClaimTimeout 认领后超过该时间仍未完成，视为 worker 已退出
+const derivativeClaimTimeout = 10 * time.Minute
+
+// 下载原图或上传缩略图失败时的重试次数和退避时间，退避时间每次翻倍
+const (
+	derivativeMaxAttempts = 5
+	derivativeRetryDelay  = time.Minute
+	derivativeMaxDelay    = time.Hour
+)
+
+// derivativeFetchTimeout 下载一张原图的最长时间，避免无响应的图床卡住 worker
+const derivativeFetchTimeout = time.Minute
+
+// errDerivativeRetry 存储或网络导致的失败，稍后重试
+var errDerivativeRetry = errors.New("derivative temporarily failed")
+
+// DerivativeSize 一种缩略图尺寸
+type DerivativeSize struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+// DerivativeOptions 生成缩略图的参数
+type DerivativeOptions struct {
+	// Sizes 缩略图尺寸，第一个作为默认缩略图
+	Sizes       []DerivativeSize
+	PreviewSize int
+	Quality     int
+}
+
+// ClaimPendingDerivatives 认领最多 limit 张待生成缩略图的图片
+func ClaimPendingDerivatives(ctx context.Context, limit int) ([]ImgDataset, error) {
+	now := time.Now()
+	stale := now.Add(-derivativeClaimTimeout)
+	candidates, err := dao.Query[ImgDataset](ctx,
+		"SELECT * FROM img_datasets WHERE deleted_at IS NULL AND ((derivative_status = ? AND (derivative_retry_at IS NULL OR derivative_retry_at <= ?)) OR (derivative_status = ? AND derivative_claimed_at < ?)) ORDER BY id LIMIT ?",
+		DerivativePending, now, DerivativeProcessing, stale, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := make([]ImgDataset, 0, len(candidates))
+	for _, img := range candidates {
+		// 条件更新，多个节点不会认领同一张图片
+		claimedAt := time.Now()
+		rows, err := dao.UpdateWhere[ImgDataset](ctx, map[string]interface{}{
+			"derivative_status":     DerivativeProcessing,
+			"derivative_claimed_at": claimedAt,
+		}, "id = ? AND ((derivative_status = ? AND (derivative_retry_at IS NULL OR derivative_retry_at <= ?)) OR (derivative_status = ? AND derivative_claimed_at < ?))",
+			img.ID, DerivativePending, now, DerivativeProcessing, stale)
+		if err != nil {
+			return nil, err
+		}
+		if rows == 1 {
+			img.DerivativeStatus = DerivativeProcessing
+			img.DerivativeClaimedAt = &claimedAt
+			claimed = append(claimed, img)
+		}
+	}
+	return claimed, nil
+}
+
+// GenerateDerivatives 下载原图，记录宽高、格式、大小和哈希，生成缩略图和预览图
+// 下载或上传失败时退回待生成状态稍后重试，图片无法解码时标记为失败
+func GenerateDerivatives(ctx context.Context, img *ImgDataset, opts DerivativeOptions) error {
+	fetchCtx, cancel := context.WithTimeout(ctx, derivativeFetchTimeout)
+	data, err := misc.FetchImage(fetchCtx, img.ImgUrl)
+	cancel()
+	if err != nil {
+		markDerivativeFailed(ctx, img, fetchFailure(err))
+		return err
+	}
+
+	info, err := misc.ProbeImage(data)
+	values := map[string]interface{}{
+		"width":  info.Width,
+		"height": info.Height,
+		"format": info.Format,
+		"bytes":  info.Bytes,
+	}
+	// 补齐去重用的哈希，上传任务之外加入的图片没有计算过
+	if img.Sha256 == "" {
+		values["sha256"] = misc.ContentHash(data)
+	}
+	if err == nil && img.PHash == "" {
+		if phash, err := misc.PerceptualHash(data); err == nil {
+			values["phash"] = phash
+		}
+	}
+	if err != nil {
+		// 当前的图片处理后端不支持该格式，只记录格式和大小，前端使用原图
+		values["derivative_status"] = DerivativeFailed
+		_, updateErr := dao.UpdateWhere[ImgDataset](ctx, values, "id = ? AND img_url = ?", img.ID, img.ImgUrl)
+		if updateErr != nil {
+			return updateErr
+		}
+		return err
+	}
+
+	var keys []string
+	thumbnails := make(datatypes.JSONMap)
+	var thumbnailUrl string
+	for i, size := range opts.Sizes {
+		key, url, err := uploadDerivative(data, img, size.Name, size.Width, size.Height, opts.Quality)
+		if err != nil {
+			misc.RemoveImages(keys)
+			markDerivativeFailed(ctx, img, err)
+			return err
+		}
+		keys = append(keys, key)
+		thumbnails[size.Name] = url
+		if i == 0 {
+			thumbnailUrl = url
+		}
+	}
+	key, previewUrl, err := uploadDerivative(data, img, "preview", opts.PreviewSize, opts.PreviewSize, opts.Quality)
+	if err != nil {
+		misc.RemoveImages(keys)
+		markDerivativeFailed(ctx, img, err)
+		return err
+	}
+	keys = append(keys, key)
+
+	values["thumbnail_url"] = thumbnailUrl
+	values["thumbnails"] = thumbnails
+	values["preview_url"] = previewUrl
+	values["derivative_status"] = DerivativeDone
+	values["derivative_attempts"] = 0
+	values["derivative_retry_at"] = nil
+	// 图片在生成期间被替换时 img_url 已经变化，结果作废，由替换后的重新生成
+	rows, err := dao.UpdateWhere[ImgDataset](ctx, values, "id = ? AND img_url = ?", img.ID, img.ImgUrl)
+	if err != nil {
+		misc.RemoveImages(keys)
+		return err
+	}
+	if rows == 0 {
+		slog.Info("derivatives superseded by image replacement", "imageID", img.ID)
+		misc.RemoveImages(keys)
+	}
+	return nil
+}
+
+// RequeueDerivatives 重新生成图片的缩略图，用于替换图片或者修改了缩略图尺寸
+func RequeueDerivatives(ctx context.Context, imageIDs []uint) error {
+	if len(imageIDs) == 0 {
+		return nil
+	}
+	_, err := dao.UpdateWhere[ImgDataset](ctx, map[string]interface{}{
+		"derivative_status":     DerivativePending,
+		"derivative_claimed_at": nil,
+		"derivative_attempts":   0,
+		"derivative_retry_at":   nil,
+	}, "id IN ?", imageIDs)
+	return err
+}
+
+func uploadDerivative(data []byte, img *ImgDataset, name string, width int, height int, quality int) (string, string, error) {
+	resized, err := misc.ResizeImage(data, width, height, quality)
+	if err != nil {
+		return "", "", err
+	}
+	key, url, err := misc.UploadImage(resized, fmt.Sprintf("%d_%s.jpg", img.ID, name))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", errDerivativeRetry, err)
+	}
+	return key, url, nil
+}
+
+// fetchFailure 地址不允许或图片过大时重试也不会成功，其余下载错误稍后重试
+func fetchFailure(err error) error {
+	if errors.Is(err, misc.ErrImageURLNotAllowed) || errors.Is(err, misc.ErrImageTooLarge) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", errDerivativeRetry, err)
+}
+
+// markDerivativeFailed 记录失败，可以重试的失败在次数用完之前退回待生成状态
+func markDerivativeFailed(ctx context.Context, img *ImgDataset, cause error) {
+	values := map[string]interface{}{"derivative_status": DerivativeFailed}
+	attempts := img.DerivativeAttempts + 1
+	if errors.Is(cause, errDerivativeRetry) && attempts < derivativeMaxAttempts {
+		retryAt := time.Now().Add(min(derivativeRetryDelay<<(attempts-1), derivativeMaxDelay))
+		values = map[string]interface{}{
+			"derivative_status":     DerivativePending,
+			"derivative_claimed_at": nil,
+			"derivative_attempts":   attempts,
+			"derivative_retry_at":   retryAt,
+		}
+		slog.Warn("generate derivatives failed, will retry", "imageID", img.ID, "attempts", attempts, "retryAt", retryAt, "err", cause)
+	} else {
+		slog.Warn("generate derivatives failed", "imageID", img.ID, "attempts", attempts, "err", cause)
+	}
+	_, err := dao.UpdateWhere[ImgDataset](ctx, values, "id = ? AND img_url = ?", img.ID, img.ImgUrl)
+	if err != nil {
+		slog.Error("mark derivative failed", "imageID", img.ID, "err", err)
+	}
+}
@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"context"
 	"gorm.io/gorm"
 	"log/slog"
 	"sapphire-server/internal/dao"
@@ -28,6 +29,17 @@ type DiscussionResult struct {
 	Time     string             `json:"time"`
 }
 
+// discussionPageOptions 讨论列表允许的排序和过滤字段
+var discussionPageOptions = dao.PageOptions{
+	SortFields: map[string]string{
+		"createdAt": "created_at",
+	},
+	FilterFields: map[string]string{
+		"userId":  "user_id",
+		"replyId": "reply_id",
+	},
+}
+
 func NewDiscussionDomain() *Discussion {
 	return &Discussion{}
 }
@@ -50,7 +62,7 @@ func buildResult(discussion Discussion, user User) *DiscussionResult {
 
 func (d *Discussion) CreateDiscussion(userID uint, dto dto.NewDiscussion) *DiscussionResult {
 	var err error
-	slog.Info("create discussion, userID: %d, dto: %+v", userID, dto)
+	slog.Info("create discussion", "userID", userID, "dto", dto)
 
 	//timeStr ：= util.FormatTimeStr(time.Now())
 	discussion := Discussion{
@@ -60,17 +72,17 @@ func (d *Discussion) CreateDiscussion(userID uint, dto dto.NewDiscussion) *Discu
 		UserID:    userID,
 		ReplyID:   dto.ReplyID,
 	}
-	slog.Info("create discussion: %+v", discussion)
+	slog.Info("create discussion", "discussion", discussion)
 
-	err = dao.Save(&discussion)
+	err = dao.Save(context.Background(), &discussion)
 	if err != nil {
-		slog.Error("create discussion failed: %s", err.Error())
+		slog.Error("create discussion failed", "err", err)
 		return nil
 	}
 
 	user, err := userDomain.GetUserInfo(discussion.UserID)
 	if err != nil {
-		slog.Error("get user info failed: %s", err.Error())
+		slog.Error("get user info failed", "err", err)
 		return nil
 	}
 
@@ -81,17 +93,17 @@ func (d *Discussion) CreateDiscussion(userID uint, dto dto.NewDiscussion) *Discu
 
 func (d *Discussion) GetDiscussion(id uint) *DiscussionResult {
 	var err error
-	slog.Info("get discussion, id: %d", id)
+	slog.Info("get discussion", "id", id)
 
-	discussion, err := dao.FindOne[Discussion]("id = ?", id)
+	discussion, err := dao.FindOne[Discussion](context.Background(), "id = ?", id)
 	if err != nil {
-		slog.Error("get discussion failed: %s", err.Error())
+		slog.Error("get discussion failed", "err", err)
 		return nil
 	}
 
 	user, err := userDomain.GetUserInfo(discussion.UserID)
 	if err != nil {
-		slog.Error("get user info failed: %s", err.Error())
+		slog.Error("get user info failed", "err", err)
 		return nil
 	}
 
@@ -99,27 +111,28 @@ func (d *Discussion) GetDiscussion(id uint) *DiscussionResult {
 	return res
 }
 
-func (d *Discussion) ListDiscussionsByDatasetID(userID uint, datasetID uint) []DiscussionResult {
+// ListDiscussionPageByDatasetID 分页列出数据集的讨论
+func (d *Discussion) ListDiscussionPageByDatasetID(datasetID uint, req dao.PageRequest) ([]DiscussionResult, dto.PageMeta, error) {
 	var err error
-	slog.Info("list discussions by datasetID: %d", datasetID)
+	slog.Info("list discussions by datasetID", "datasetID", datasetID)
 
-	discussions, err := dao.FindAll[Discussion]("dataset_id = ?", datasetID)
+	page, err := dao.FindPage[Discussion](context.Background(), req, discussionPageOptions, "dataset_id = ?", datasetID)
 	if err != nil {
-		slog.Error("list discussions failed: %s", err.Error())
-		return nil
+		slog.Error("list discussions failed", "err", err)
+		return nil, dto.PageMeta{}, err
 	}
 
-	var results []DiscussionResult
-	for _, discussion := range discussions {
+	results := make([]DiscussionResult, 0)
+	for _, discussion := range page.Items {
 		user, err := userDomain.GetUserInfo(discussion.UserID)
 		if err != nil {
-			slog.Error("get user info failed: %s", err.Error())
-			return nil
+			slog.Error("get user info failed", "err", err)
+			return nil, dto.PageMeta{}, err
 		}
 
 		result := buildResult(discussion, *user)
 		results = append(results, *result)
 	}
 
-	return results
+	return results, page.PageMeta, nil
 }
@@ -0,0 +1,190 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/goccy/go-json"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"sapphire-server/internal/dao"
+	"sapphire-server/internal/infra"
+	"time"
+)
+
+// 数据集事件的类型
+const (
+	// EventProgress 图片数量或嵌入、标注进度变化
+	EventProgress = "progress"
+	// EventStatus 数据集状态变化，如 default 变为 Ready
+	EventStatus = "status"
+	// EventUpload 上传任务的进度
+	EventUpload = "upload"
+	// EventAnnotation 新的标注
+	EventAnnotation = "annotation"
+	// EventCampaign 重新嵌入活动开始或结束
+	EventCampaign = "campaign"
+)
+
+// 数据集状态，与数据集详情中的 status 一致
+const (
+	DatasetStatusDefault   = "default"
+	DatasetStatusReady     = "Ready"
+	DatasetStatusAnnotated = "annotationSuccess"
+)
+
+// datasetStatusTTL 记录最近一次状态的键的过期时间，过期后下一次变化时不发送状态事件
+const datasetStatusTTL = 7 * 24 * time.Hour
+
+// DatasetEvent 推送给订阅者的数据集事件，通过 Redis 发布，所有节点的订阅者都能收到
+type DatasetEvent struct {
+	Type      string      `json:"type"`
+	DatasetID uint        `json:"datasetId"`
+	Data      interface{} `json:"data"`
+	Time      time.Time   `json:"time"`
+}
+
+// DatasetProgress 数据集中各状态的图片数
+type DatasetProgress struct {
+	Total     int64  `json:"total"`
+	Pending   int64  `json:"pending"`
+	Embedded  int64  `json:"embedded"`
+	Annotated int64  `json:"annotated"`
+	Failed    int64  `json:"failed"`
+	Status    string `json:"status"`
+}
+
+// StatusChange 数据集状态事件的内容
+type StatusChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// AnnotationEvent 标注事件的内容
+type AnnotationEvent struct {
+	AnnotationID uint `json:"annotationId"`
+	ImageID      uint `json:"imageId"`
+	UserID       uint `json:"userId"`
+}
+
+// imageStatusCount 统计图片状态使用
+type imageStatusCount struct {
+	Status int
+	Count  int64
+}
+
+// DatasetStatus 根据图片数计算数据集状态
+func DatasetStatus(total int64, embedded int64, annotated int64) string {
+	if total == annotated {
+		return DatasetStatusAnnotated
+	}
+	if embedded+annotated == total {
+		return DatasetStatusReady
+	}
+	return DatasetStatusDefault
+}
+
+func datasetEventChannel(datasetID uint) string {
+	return fmt.Sprintf("sapphire:dataset:%d:events", datasetID)
+}
+
+func datasetStatusKey(datasetID uint) string {
+	return fmt.Sprintf("sapphire:dataset:%d:status", datasetID)
+}
+
+// GetDatasetProgress 统计数据集中各状态的图片数
+func GetDatasetProgress(ctx context.Context, datasetID uint) (*DatasetProgress, error) {
+	counts, err := dao.Query[imageStatusCount](ctx,
+		"SELECT status, count(*) AS count FROM img_datasets WHERE dataset_id = ? AND deleted_at IS NULL GROUP BY status", datasetID)
+	if err != nil {
+		return nil, err
+	}
+	progress := &DatasetProgress{}
+	for _, c := range counts {
+		progress.Total += c.Count
+		switch c.Status {
+		case ImgStatusDefault:
+			progress.Pending += c.Count
+		case ImgStatusEmbedded:
+			progress.Embedded += c.Count
+		case ImgStatusAnnotated:
+			progress.Annotated += c.Count
+		case ImgStatusEmbeddingFailed:
+			progress.Failed += c.Count
+		}
+	}
+	progress.Status = DatasetStatus(progress.Total, progress.Embedded, progress.Annotated)
+	return progress, nil
+}
+
+// PublishDatasetEvent 发布数据集事件，失败时只记录日志，不影响调用方
+func PublishDatasetEvent(ctx context.Context, datasetID uint, eventType string, data interface{}) {
+	payload, err := json.Marshal(&DatasetEvent{Type: eventType, DatasetID: datasetID, Data: data, Time: time.Now()})
+	if err != nil {
+		slog.Warn("marshal dataset event failed", "datasetID", datasetID, "type", eventType, "err", err)
+		return
+	}
+	err = infra.Redis.Publish(ctx, datasetEventChannel(datasetID), payload).Err()
+	if err != nil {
+		slog.Warn("publish dataset event failed", "datasetID", datasetID, "type", eventType, "err", err)
+	}
+}
+
+// NotifyDatasetProgress 发布数据集的最新进度，状态变化时同时发布状态事件
+// 上一次的状态记录在 Redis 中，多个节点同时更新时只有一个节点发布状态事件
+func NotifyDatasetProgress(ctx context.Context, datasetID uint) {
+	progress, err := GetDatasetProgress(ctx, datasetID)
+	if err != nil {
+		slog.Warn("get dataset progress failed", "datasetID", datasetID, "err", err)
+		return
+	}
+	PublishDatasetEvent(ctx, datasetID, EventProgress, progress)
+
+	previous, err := infra.Redis.SetArgs(ctx, datasetStatusKey(datasetID), progress.Status, redis.SetArgs{TTL: datasetStatusTTL, Get: true}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		slog.Warn("record dataset status failed", "datasetID", datasetID, "err", err)
+		return
+	}
+	if previous != "" && previous != progress.Status {
+		PublishDatasetEvent(ctx, datasetID, EventStatus, &StatusChange{From: previous, To: progress.Status})
+	}
+}
+
+// notifyImageProgress 发布图片所在数据集的最新进度
+func notifyImageProgress(ctx context.Context, imageID uint) {
+	img, err := dao.FindOne[ImgDataset](ctx, imageID)
+	if err != nil || img == nil {
+		return
+	}
+	NotifyDatasetProgress(ctx, img.DatasetId)
+}
+
+// SubscribeDatasetEvents 订阅数据集事件，ctx 结束或调用返回的函数后停止订阅
+func SubscribeDatasetEvents(ctx context.Context, datasetID uint) (<-chan DatasetEvent, func(), error) {
+	pubsub := infra.Redis.Subscribe(ctx, datasetEventChannel(datasetID))
+	// 等待订阅生效，之后发布的事件不会丢失
+	_, err := pubsub.Receive(ctx)
+	if err != nil {
+		pubsub.Close()
+		return nil, nil, err
+	}
+
+	events := make(chan DatasetEvent, 16)
+	go func() {
+		defer close(events)
+		for msg := range pubsub.Channel() {
+			var event DatasetEvent
+			err := json.Unmarshal([]byte(msg.Payload), &event)
+			if err != nil {
+				slog.Warn("unmarshal dataset event failed", "datasetID", datasetID, "err", err)
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, func() { pubsub.Close() }, nil
+}
@@ -0,0 +1,409 @@
+package domain
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"sapphire-server/internal/dao"
+	"sapphire-server/internal/data/dto"
+	"sapphire-server/internal/storage"
+	"sapphire-server/pkg/misc"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 导出时使用的标注结果
+const (
+	// ExportModeConsensus 每张图片使用多人标注合并后的结果
+	ExportModeConsensus = "consensus"
+	// ExportModeRaw 保留每个标注员的原始结果
+	ExportModeRaw = "raw"
+)
+
+// defaultCategory 创建数据集时没有 tags 使用的标签
+const defaultCategory = "object"
+
+// fetchWorkers 并发读取图片信息的数量
+const fetchWorkers = 8
+
+// 导出格式
+const (
+	ExportFormatCOCO = "coco"
+	ExportFormatYOLO = "yolo"
+	ExportFormatVOC  = "voc"
+)
+
+// ExportOptions 导出参数
+type ExportOptions struct {
+	Mode   string
+	Format string
+	// Split train/val/test 的比例，为空时不划分
+	Split []float64
+	// Seed 划分数据集使用的随机种子，相同种子得到相同的划分
+	Seed int64
+}
+
+// exportBox 导出的一个标注框
+type exportBox struct {
+	Mark dto.AnnotationResult
+	// AnnotationID 原始标注的 ID，consensus 模式下为 0
+	AnnotationID uint
+	AnnotatorID  uint
+}
+
+// exportImage 导出的一张图片及其标注
+type exportImage struct {
+	Img    ImgDataset
+	Width  int
+	Height int
+	Boxes  []exportBox
+}
+
+// COCODataset COCO 格式的标注文件
+type COCODataset struct {
+	Info        COCOInfo         `json:"info"`
+	Images      []COCOImage      `json:"images"`
+	Annotations []COCOAnnotation `json:"annotations"`
+	Categories  []COCOCategory   `json:"categories"`
+}
+
+type COCOInfo struct {
+	Description string `json:"description"`
+	Version     string `json:"version"`
+	DateCreated string `json:"date_created"`
+}
+
+type COCOImage struct {
+	ID       uint   `json:"id"`
+	FileName string `json:"file_name"`
+	CocoURL  string `json:"coco_url"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	// Classes 整张图片的类别
+	Classes []COCOImageClass `json:"classes,omitempty"`
+}
+
+// COCOImageClass 整张图片的一个类别和属性
+type COCOImageClass struct {
+	CategoryID uint                   `json:"category_id"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+type COCOAnnotation struct {
+	ID         uint      `json:"id"`
+	ImageID    uint      `json:"image_id"`
+	CategoryID uint      `json:"category_id"`
+	BBox       []float64 `json:"bbox"`
+	Area       float64   `json:"area"`
+	IsCrowd    int       `json:"iscrowd"`
+	// Segmentation 多边形为 [[x1, y1, x2, y2, ...]]，掩码为 {"size": [h, w], "counts": [...]}
+	Segmentation interface{} `json:"segmentation"`
+	// Keypoints 按类别的关键点顺序排列的 [x, y, v, ...]
+	Keypoints    []float64 `json:"keypoints,omitempty"`
+	NumKeypoints int       `json:"num_keypoints,omitempty"`
+	// Attributes 标签定义的属性值
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	// AnnotatorID raw 模式下标注员的用户 ID
+	AnnotatorID uint `json:"annotator_id,omitempty"`
+}
+
+type COCOCategory struct {
+	ID            uint   `json:"id"`
+	Name          string `json:"name"`
+	Supercategory string `json:"supercategory"`
+	// Keypoints 和 Skeleton 只在类别有关键点标注时输出，Skeleton 的下标从 1 开始
+	Keypoints []string `json:"keypoints,omitempty"`
+	Skeleton  [][2]int `json:"skeleton,omitempty"`
+}
+
+// keypointLayout 一个类别的关键点定义，取该类别关键点最多的标注
+type keypointLayout struct {
+	names    []string
+	skeleton [][2]int
+}
+
+// IsValidExportMode 判断导出模式是否合法
+func IsValidExportMode(mode string) bool {
+	return mode == ExportModeConsensus || mode == ExportModeRaw
+}
+
+// IsValidExportFormat 判断导出格式是否合法
+func IsValidExportFormat(format string) bool {
+	return format == ExportFormatCOCO || format == ExportFormatYOLO || format == ExportFormatVOC
+}
+
+// collectExportImages 读取数据集的图片和标注，按导出模式整理
+// withSize 为 true 时读取图片的宽高
+func collectExportImages(ctx context.Context, dataset *Dataset, mode string, withSize bool) ([]exportImage, error) {
+	images, err := dao.FindAll[ImgDataset](ctx, "dataset_id = ?", dataset.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]exportImage, len(images))
+	for i, img := range images {
+		items[i] = exportImage{Img: img}
+	}
+
+	if mode == ExportModeRaw {
+		annotations, err := dao.FindAll[Annotation](ctx, "dataset_id = ?", dataset.ID)
+		if err != nil {
+			return nil, err
+		}
+		index := make(map[uint]int)
+		for i, img := range images {
+			index[img.ID] = i
+		}
+		for _, anno := range annotations {
+			i, ok := index[anno.ImageID]
+			if !ok {
+				continue
+			}
+			for _, mark := range anno.Content.Data() {
+				items[i].Boxes = append(items[i].Boxes, exportBox{Mark: mark, AnnotationID: anno.ID, AnnotatorID: anno.UserID})
+			}
+		}
+	} else {
+		for i, img := range images {
+			anno, err := annotationDomain.GetAnnotationByImageID(img.ID)
+			if err != nil {
+				slog.Warn("skip malformed annotation", "imageID", img.ID, "err", err)
+				continue
+			}
+			if anno == nil {
+				continue
+			}
+			for _, mark := range anno.Content.Data() {
+				items[i].Boxes = append(items[i].Boxes, exportBox{Mark: mark})
+			}
+		}
+	}
+
+	if withSize {
+		fillImageSize(ctx, items)
+	}
+	return items, nil
+}
+
+// fillImageSize 填写图片宽高，优先使用生成缩略图时记录的宽高
+// 还没有记录的图片并发下载读取，读取失败时保持为 0
+func fillImageSize(ctx context.Context, items []exportImage) {
+	var pending []int
+	for i := range items {
+		if items[i].Img.Width > 0 && items[i].Img.Height > 0 {
+			items[i].Width = items[i].Img.Width
+			items[i].Height = items[i].Img.Height
+			continue
+		}
+		pending = append(pending, i)
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	ch := make(chan int)
+	for w := 0; w < fetchWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				cfg, _, err := misc.FetchImageConfig(ctx, items[i].Img.ImgUrl)
+				if err != nil {
+					slog.Warn("read image size failed", "imageID", items[i].Img.ID, "err", err)
+					continue
+				}
+				items[i].Width = cfg.Width
+				items[i].Height = cfg.Height
+			}
+		}()
+	}
+	for _, i := range pending {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+}
+
+// exportFileName 图片在导出结果中的文件名，使用图片 ID 避免重名
+func exportFileName(img ImgDataset) string {
+	ext := path.Ext(path.Base(img.ImgUrl))
+	if i := strings.IndexAny(ext, "?#"); i >= 0 {
+		ext = ext[:i]
+	}
+	if ext == "" {
+		ext = ".jpg"
+	}
+	return fmt.Sprintf("%d%s", img.ID, strings.ToLower(ext))
+}
+
+// BuildCOCO 构建数据集的 COCO 标注
+func (d *Dataset) BuildCOCO(ctx context.Context, id uint, opts ExportOptions) (*COCODataset, error) {
+	dataset, err := dao.FindOne[Dataset](ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if dataset == nil {
+		return nil, fmt.Errorf("dataset not found")
+	}
+	items, err := collectExportImages(ctx, dataset, opts.Mode, true)
+	if err != nil {
+		return nil, err
+	}
+
+	categories, err := datasetCategories(ctx, dataset.ID)
+	if err != nil {
+		return nil, err
+	}
+	coco := &COCODataset{
+		Info: COCOInfo{
+			Description: dataset.Name,
+			Version:     opts.Mode,
+			DateCreated: time.Now().Format(time.RFC3339),
+		},
+		Images:      make([]COCOImage, 0, len(items)),
+		Annotations: make([]COCOAnnotation, 0),
+		Categories:  make([]COCOCategory, 0, len(categories.labels)),
+	}
+	layouts := keypointLayouts(items)
+	for i, label := range categories.labels {
+		// 上级标签作为 supercategory
+		category := COCOCategory{ID: uint(i + 1), Name: label.Name, Supercategory: defaultCategory}
+		if parent := categories.label(label.ParentID); parent != nil {
+			category.Supercategory = parent.Name
+		}
+		if layout, ok := layouts[label.ID]; ok {
+			category.Keypoints = layout.names
+			for _, edge := range layout.skeleton {
+				category.Skeleton = append(category.Skeleton, [2]int{edge[0] + 1, edge[1] + 1})
+			}
+		}
+		coco.Categories = append(coco.Categories, category)
+	}
+
+	var annotationID uint
+	for _, item := range items {
+		image := COCOImage{
+			ID:       item.Img.ID,
+			FileName: exportFileName(item.Img),
+			CocoURL:  item.Img.ImgUrl,
+			Width:    item.Width,
+			Height:   item.Height,
+		}
+		for _, box := range item.Boxes {
+			categoryID, ok := boxCategory(box.Mark, categories)
+			if !ok {
+				slog.Warn("skip mark with unknown category", "imageID", item.Img.ID, "category", box.Mark.ID)
+				continue
+			}
+			if markType(&box.Mark) == dto.ShapeClass {
+				image.Classes = append(image.Classes, COCOImageClass{CategoryID: categoryID, Attributes: box.Mark.Attributes})
+				continue
+			}
+			annotationID++
+			anno := cocoAnnotation(box.Mark, layouts[box.Mark.ID])
+			anno.ID = annotationID
+			anno.ImageID = item.Img.ID
+			anno.CategoryID = categoryID
+			anno.AnnotatorID = box.AnnotatorID
+			coco.Annotations = append(coco.Annotations, anno)
+		}
+		coco.Images = append(coco.Images, image)
+	}
+	return coco, nil
+}
+
+// cocoAnnotation 按形状类型转换为 COCO 标注，bbox 为外接矩形
+func cocoAnnotation(mark dto.AnnotationResult, layout keypointLayout) COCOAnnotation {
+	e := markExtent(&mark)
+	anno := COCOAnnotation{
+		BBox:         []float64{e.X0, e.Y0, e.X1 - e.X0, e.Y1 - e.Y0},
+		Area:         markArea(&mark),
+		Segmentation: [][]float64{},
+		Attributes:   mark.Attributes,
+	}
+	switch markType(&mark) {
+	case dto.ShapeRotatedBBox:
+		anno.Segmentation = [][]float64{flattenPoints(rboxCorners(&mark))}
+	case dto.ShapePolygon:
+		anno.Segmentation = [][]float64{flattenPoints(mark.Points)}
+	case dto.ShapeMask:
+		anno.Segmentation = mark.Mask
+	case dto.ShapeKeypoints:
+		anno.Keypoints = make([]float64, 3*len(layout.names))
+		for i, kp := range mark.Keypoints {
+			if i >= len(layout.names) || kp.Visibility == 0 {
+				continue
+			}
+			anno.Keypoints[3*i] = kp.X
+			anno.Keypoints[3*i+1] = kp.Y
+			anno.Keypoints[3*i+2] = float64(kp.Visibility)
+			anno.NumKeypoints++
+		}
+	}
+	return anno
+}
+
+// keypointLayouts 每个类别的关键点定义
+func keypointLayouts(items []exportImage) map[uint]keypointLayout {
+	layouts := make(map[uint]keypointLayout)
+	for _, item := range items {
+		for _, box := range item.Boxes {
+			m := box.Mark
+			if markType(&m) != dto.ShapeKeypoints || len(m.Keypoints) <= len(layouts[m.ID].names) {
+				continue
+			}
+			names := make([]string, len(m.Keypoints))
+			for i, kp := range m.Keypoints {
+				names[i] = kp.Name
+				if names[i] == "" {
+					names[i] = fmt.Sprint(i)
+				}
+			}
+			layouts[m.ID] = keypointLayout{names: names, skeleton: m.Skeleton}
+		}
+	}
+	return layouts
+}
+
+func flattenPoints(points [][]float64) []float64 {
+	flat := make([]float64, 0, 2*len(points))
+	for _, p := range points {
+		flat = append(flat, p[0], p[1])
+	}
+	return flat
+}
+
+// boxCategory 返回标注框的 COCO 类别 ID，从 1 开始
+func boxCategory(mark dto.AnnotationResult, categories *categorySet) (uint, bool) {
+	idx, ok := categories.indexOf(mark.ID)
+	if !ok {
+		return 0, false
+	}
+	return uint(idx + 1), true
+}
+
+// GetResultArchive 导出数据集的 COCO 标注文件，返回访问地址
+func (d *Dataset) GetResultArchive(id uint, opts ExportOptions) (string, error) {
+	ctx := context.Background()
+	coco, err := d.BuildCOCO(ctx, id, opts)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(coco)
+	if err != nil {
+		return "", err
+	}
+
+	// 对当前时间戳进行哈希，生成一个随机文件名
+	h := sha1.New()
+	h.Write([]byte(time.Now().String()))
+	fileName := fmt.Sprintf("result_%d_%s_%x.json", id, opts.Mode, h.Sum(nil))
+
+	// 将文件上传到存储，返回访问地址
+	return storage.PutBytes(ctx, fileName, data, "application/json")
+}
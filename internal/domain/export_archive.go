@@ -0,0 +1,569 @@
+package domain
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"path"
+	"sapphire-server/internal/dao"
+	"sapphire-server/internal/data/dto"
+	"sapphire-server/pkg/misc"
+	"sort"
+	"strings"
+)
+
+// 数据集划分
+const (
+	SplitTrain = "train"
+	SplitVal   = "val"
+	SplitTest  = "test"
+)
+
+var splitNames = []string{SplitTrain, SplitVal, SplitTest}
+
+// ErrInvalidSplit 划分比例不合法
+var ErrInvalidSplit = errors.New("split should be three finite non-negative train,val,test ratios with a positive sum")
+
+// ValidateSplit 检查划分比例，为空时不划分
+func ValidateSplit(ratios []float64) error {
+	if len(ratios) == 0 {
+		return nil
+	}
+	if len(ratios) != len(splitNames) {
+		return ErrInvalidSplit
+	}
+	var total float64
+	for _, r := range ratios {
+		if math.IsNaN(r) || math.IsInf(r, 0) || r < 0 {
+			return ErrInvalidSplit
+		}
+		total += r
+	}
+	if total <= 0 || math.IsInf(total, 0) {
+		return ErrInvalidSplit
+	}
+	return nil
+}
+
+// Archive 准备好的导出压缩包，图片在写入时才下载
+type Archive struct {
+	dataset    *Dataset
+	opts       ExportOptions
+	categories *categorySet
+	items      []exportImage
+	// splits 每张图片所属的划分，不划分时为空字符串
+	splits []string
+	// keypoints 关键点最多的标注的关键点数，YOLO 的每行按该数量补齐
+	keypoints int
+	// classes 已写入图片的整图类别，每行为文件名、类别名和属性
+	classes []string
+	// attributes YOLO 标注行的属性，每行为文件名、标注文件中的行号和属性
+	attributes []string
+}
+
+// fetchedImage 下载好的图片
+type fetchedImage struct {
+	data []byte
+	err  error
+}
+
+// vocAnnotation VOC 格式的标注文件
+type vocAnnotation struct {
+	XMLName  xml.Name    `xml:"annotation"`
+	Folder   string      `xml:"folder"`
+	Filename string      `xml:"filename"`
+	Size     vocSize     `xml:"size"`
+	Objects  []vocObject `xml:"object"`
+}
+
+type vocSize struct {
+	Width  int `xml:"width"`
+	Height int `xml:"height"`
+	Depth  int `xml:"depth"`
+}
+
+type vocObject struct {
+	Name      string    `xml:"name"`
+	Pose      string    `xml:"pose"`
+	Truncated int       `xml:"truncated"`
+	Difficult int       `xml:"difficult"`
+	BndBox    vocBndBox `xml:"bndbox"`
+	// RoBndBox 旋转矩形框，与 roLabelImg 相同，角度为弧度
+	RoBndBox  *vocRoBndBox  `xml:"robndbox,omitempty"`
+	Polygon   *vocPolygon   `xml:"polygon,omitempty"`
+	Keypoints *vocKeypoints `xml:"keypoints,omitempty"`
+	// Attributes 标签定义的属性值，与 CVAT 导出的 VOC 相同
+	Attributes *vocAttributes `xml:"attributes,omitempty"`
+}
+
+type vocAttributes struct {
+	Items []vocAttribute `xml:"attribute"`
+}
+
+type vocAttribute struct {
+	Name  string `xml:"name"`
+	Value string `xml:"value"`
+}
+
+type vocRoBndBox struct {
+	CX    float64 `xml:"cx"`
+	CY    float64 `xml:"cy"`
+	W     float64 `xml:"w"`
+	H     float64 `xml:"h"`
+	Angle float64 `xml:"angle"`
+}
+
+type vocPolygon struct {
+	Points []vocPoint `xml:"pt"`
+}
+
+type vocPoint struct {
+	X float64 `xml:"x"`
+	Y float64 `xml:"y"`
+}
+
+type vocKeypoints struct {
+	Points []vocKeypoint `xml:"keypoint"`
+}
+
+type vocKeypoint struct {
+	Name       string  `xml:"name"`
+	X          float64 `xml:"x"`
+	Y          float64 `xml:"y"`
+	Visibility int     `xml:"visibility"`
+}
+
+type vocBndBox struct {
+	XMin int `xml:"xmin"`
+	YMin int `xml:"ymin"`
+	XMax int `xml:"xmax"`
+	YMax int `xml:"ymax"`
+}
+
+// PrepareArchive 读取导出所需的数据，在写入响应之前发现错误
+func (d *Dataset) PrepareArchive(ctx context.Context, id uint, opts ExportOptions) (*Archive, error) {
+	if opts.Format != ExportFormatYOLO && opts.Format != ExportFormatVOC {
+		return nil, fmt.Errorf("unsupported archive format %s", opts.Format)
+	}
+	err := ValidateSplit(opts.Split)
+	if err != nil {
+		return nil, err
+	}
+
+	dataset, err := dao.FindOne[Dataset](ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if dataset == nil {
+		return nil, ErrDatasetNotFound
+	}
+	// 宽高在下载图片后读取
+	items, err := collectExportImages(ctx, dataset, opts.Mode, false)
+	if err != nil {
+		return nil, err
+	}
+	categories, err := datasetCategories(ctx, dataset.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	keypoints := 0
+	for _, item := range items {
+		for _, box := range item.Boxes {
+			if markType(&box.Mark) == dto.ShapeKeypoints {
+				keypoints = max(keypoints, len(box.Mark.Keypoints))
+			}
+		}
+	}
+
+	return &Archive{
+		dataset:    dataset,
+		opts:       opts,
+		categories: categories,
+		items:      items,
+		splits:     splitImages(len(items), opts.Split, opts.Seed),
+		keypoints:  keypoints,
+	}, nil
+}
+
+// FileName 压缩包的文件名
+func (a *Archive) FileName() string {
+	return fmt.Sprintf("dataset_%d_%s.zip", a.dataset.ID, a.opts.Format)
+}
+
+// splitImages 按比例随机划分，相同的种子得到相同的结果
+func splitImages(count int, ratios []float64, seed int64) []string {
+	splits := make([]string, count)
+	if len(ratios) == 0 {
+		return splits
+	}
+
+	var total float64
+	for _, r := range ratios {
+		total += r
+	}
+	order := rand.New(rand.NewSource(seed)).Perm(count)
+	trainEnd := int(math.Round(float64(count) * ratios[0] / total))
+	valEnd := trainEnd + int(math.Round(float64(count)*ratios[1]/total))
+	for i, idx := range order {
+		switch {
+		case i < trainEnd:
+			splits[idx] = SplitTrain
+		case i < valEnd:
+			splits[idx] = SplitVal
+		default:
+			splits[idx] = SplitTest
+		}
+	}
+	return splits
+}
+
+// WriteTo 将压缩包写入 w，图片边下载边写入，不在本地落盘
+func (a *Archive) WriteTo(ctx context.Context, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	// 按顺序消费，最多同时下载 fetchWorkers 张图片
+	results := make([]chan fetchedImage, len(a.items))
+	for i := range results {
+		results[i] = make(chan fetchedImage, 1)
+	}
+	sem := make(chan struct{}, fetchWorkers)
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		for i := range a.items {
+			select {
+			case sem <- struct{}{}:
+			case <-fetchCtx.Done():
+				return
+			}
+			go func(i int) {
+				data, err := misc.FetchImage(fetchCtx, a.items[i].Img.ImgUrl)
+				results[i] <- fetchedImage{data: data, err: err}
+			}(i)
+		}
+	}()
+
+	var skipped []string
+	for i := range a.items {
+		var fetched fetchedImage
+		select {
+		case fetched = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		<-sem
+
+		item := &a.items[i]
+		if fetched.err != nil {
+			slog.Warn("export image failed", "imageID", item.Img.ID, "err", fetched.err)
+			skipped = append(skipped, fmt.Sprintf("%d\t%s\t%v", item.Img.ID, item.Img.ImgUrl, fetched.err))
+			continue
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(fetched.data))
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%d\t%s\t%v", item.Img.ID, item.Img.ImgUrl, err))
+			continue
+		}
+		item.Width, item.Height = cfg.Width, cfg.Height
+
+		err = a.writeImage(zw, item, a.splits[i], fetched.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := a.writeMeta(zw, skipped)
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeImage 写入图片和对应的标注文件
+func (a *Archive) writeImage(zw *zip.Writer, item *exportImage, split string, data []byte) error {
+	fileName := exportFileName(item.Img)
+	base := strings.TrimSuffix(fileName, path.Ext(fileName))
+
+	var imageDir, labelPath string
+	var label []byte
+	var err error
+	switch a.opts.Format {
+	case ExportFormatYOLO:
+		imageDir = path.Join("images", split)
+		labelPath = path.Join("labels", split, base+".txt")
+		label = a.yoloLabel(item)
+	case ExportFormatVOC:
+		imageDir = "JPEGImages"
+		labelPath = path.Join("Annotations", base+".xml")
+		label, err = a.vocLabel(item, fileName)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, box := range item.Boxes {
+		if label := a.categories.label(box.Mark.ID); label != nil && markType(&box.Mark) == dto.ShapeClass {
+			a.classes = append(a.classes, fmt.Sprintf("%s,%s,%s", fileName, csvField(label.Name), csvField(attributesJSON(box.Mark.Attributes))))
+		}
+	}
+
+	err = writeZipFile(zw, path.Join(imageDir, fileName), data)
+	if err != nil {
+		return err
+	}
+	return writeZipFile(zw, labelPath, label)
+}
+
+// yoloLabel 每行一个标注，坐标按图片宽高归一化
+// 矩形框为 class cx cy w h，旋转矩形框为四个角点，多边形为各顶点
+// 有关键点时每个矩形框后接 x y v，按最多的关键点数补齐；掩码使用外接矩形，整图类别写入 image_classes.csv
+// 属性按行号写入 attributes.csv
+func (a *Archive) yoloLabel(item *exportImage) []byte {
+	var buf bytes.Buffer
+	fileName := exportFileName(item.Img)
+	w, h := float64(item.Width), float64(item.Height)
+	line := 0
+	for _, box := range item.Boxes {
+		class, ok := a.categories.indexOf(box.Mark.ID)
+		if !ok {
+			continue
+		}
+		m := box.Mark
+		switch markType(&m) {
+		case dto.ShapeClass:
+			continue
+		case dto.ShapeRotatedBBox:
+			fmt.Fprintf(&buf, "%d", class)
+			writeYOLOPoints(&buf, rboxCorners(&m), w, h)
+		case dto.ShapePolygon:
+			fmt.Fprintf(&buf, "%d", class)
+			writeYOLOPoints(&buf, m.Points, w, h)
+		default:
+			fmt.Fprintf(&buf, "%d %.6f %.6f %.6f %.6f", class,
+				clamp01(m.CenterX/w), clamp01(m.CenterY/h),
+				clamp01(m.Width/w), clamp01(m.Height/h))
+			if a.keypoints > 0 {
+				for i := 0; i < a.keypoints; i++ {
+					if i >= len(m.Keypoints) || m.Keypoints[i].Visibility == 0 {
+						buf.WriteString(" 0 0 0")
+						continue
+					}
+					kp := m.Keypoints[i]
+					fmt.Fprintf(&buf, " %.6f %.6f %d", clamp01(kp.X/w), clamp01(kp.Y/h), kp.Visibility)
+				}
+			}
+		}
+		buf.WriteByte('\n')
+		line++
+		if len(m.Attributes) > 0 {
+			a.attributes = append(a.attributes, fmt.Sprintf("%s,%d,%s", fileName, line, csvField(attributesJSON(m.Attributes))))
+		}
+	}
+	return buf.Bytes()
+}
+
+func writeYOLOPoints(buf *bytes.Buffer, points [][]float64, w float64, h float64) {
+	for _, p := range points {
+		fmt.Fprintf(buf, " %.6f %.6f", clamp01(p[0]/w), clamp01(p[1]/h))
+	}
+}
+
+// vocLabel VOC XML，坐标为像素值，bndbox 为外接矩形
+func (a *Archive) vocLabel(item *exportImage, fileName string) ([]byte, error) {
+	anno := vocAnnotation{
+		Folder:   "JPEGImages",
+		Filename: fileName,
+		Size:     vocSize{Width: item.Width, Height: item.Height, Depth: 3},
+	}
+	for _, box := range item.Boxes {
+		label := a.categories.label(box.Mark.ID)
+		if label == nil {
+			continue
+		}
+		m := box.Mark
+		if markType(&m) == dto.ShapeClass {
+			continue
+		}
+		e := markExtent(&m)
+		obj := vocObject{
+			Name: label.Name,
+			Pose: "Unspecified",
+			BndBox: vocBndBox{
+				XMin: clampInt(e.X0, item.Width),
+				YMin: clampInt(e.Y0, item.Height),
+				XMax: clampInt(e.X1, item.Width),
+				YMax: clampInt(e.Y1, item.Height),
+			},
+		}
+		if len(m.Attributes) > 0 {
+			obj.Attributes = &vocAttributes{}
+			for _, name := range sortedKeys(m.Attributes) {
+				value := fmt.Sprint(m.Attributes[name])
+				obj.Attributes.Items = append(obj.Attributes.Items, vocAttribute{Name: name, Value: value})
+				// 与 VOC 自带字段同名的 bool 属性同时写入对应字段
+				switch {
+				case name == "truncated" && value == "true":
+					obj.Truncated = 1
+				case name == "difficult" && value == "true":
+					obj.Difficult = 1
+				}
+			}
+		}
+		switch markType(&m) {
+		case dto.ShapeRotatedBBox:
+			obj.RoBndBox = &vocRoBndBox{CX: m.CenterX, CY: m.CenterY, W: m.Width, H: m.Height, Angle: m.Angle * math.Pi / 180}
+		case dto.ShapePolygon:
+			obj.Polygon = &vocPolygon{}
+			for _, p := range m.Points {
+				obj.Polygon.Points = append(obj.Polygon.Points, vocPoint{X: p[0], Y: p[1]})
+			}
+		case dto.ShapeKeypoints:
+			obj.Keypoints = &vocKeypoints{}
+			for _, kp := range m.Keypoints {
+				obj.Keypoints.Points = append(obj.Keypoints.Points, vocKeypoint{Name: kp.Name, X: kp.X, Y: kp.Y, Visibility: kp.Visibility})
+			}
+		}
+		anno.Objects = append(anno.Objects, obj)
+	}
+	data, err := xml.MarshalIndent(anno, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// writeMeta 写入类别列表、划分文件和跳过的图片
+func (a *Archive) writeMeta(zw *zip.Writer, skipped []string) error {
+	var err error
+	switch a.opts.Format {
+	case ExportFormatYOLO:
+		err = writeZipFile(zw, "data.yaml", a.yoloDataYAML())
+	case ExportFormatVOC:
+		err = writeZipFile(zw, "labels.txt", []byte(strings.Join(a.categories.names(), "\n")+"\n"))
+		if err == nil {
+			err = a.writeVOCImageSets(zw, skipped)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(a.classes) > 0 {
+		err = writeZipFile(zw, "image_classes.csv", []byte("file_name,class,attributes\n"+strings.Join(a.classes, "\n")+"\n"))
+		if err != nil {
+			return err
+		}
+	}
+	if len(a.attributes) > 0 {
+		err = writeZipFile(zw, "attributes.csv", []byte("file_name,line,attributes\n"+strings.Join(a.attributes, "\n")+"\n"))
+		if err != nil {
+			return err
+		}
+	}
+	if len(skipped) > 0 {
+		return writeZipFile(zw, "skipped.txt", []byte(strings.Join(skipped, "\n")+"\n"))
+	}
+	return nil
+}
+
+func (a *Archive) yoloDataYAML() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("path: .\n")
+	if len(a.opts.Split) > 0 {
+		for _, name := range splitNames {
+			fmt.Fprintf(&buf, "%s: images/%s\n", name, name)
+		}
+	} else {
+		buf.WriteString("train: images\nval: images\n")
+	}
+	fmt.Fprintf(&buf, "nc: %d\n", len(a.categories.labels))
+	if a.keypoints > 0 {
+		fmt.Fprintf(&buf, "kpt_shape: [%d, 3]\n", a.keypoints)
+	}
+	buf.WriteString("names:\n")
+	for i, name := range a.categories.names() {
+		fmt.Fprintf(&buf, "  %d: %q\n", i, name)
+	}
+	return buf.Bytes()
+}
+
+// writeVOCImageSets 写入 ImageSets/Main 下的划分文件
+func (a *Archive) writeVOCImageSets(zw *zip.Writer, skipped []string) error {
+	skippedIDs := make(map[string]bool)
+	for _, line := range skipped {
+		skippedIDs[strings.SplitN(line, "\t", 2)[0]] = true
+	}
+
+	sets := make(map[string][]string)
+	for i, item := range a.items {
+		if skippedIDs[fmt.Sprint(item.Img.ID)] {
+			continue
+		}
+		name := a.splits[i]
+		if name == "" {
+			name = "trainval"
+		}
+		fileName := exportFileName(item.Img)
+		sets[name] = append(sets[name], strings.TrimSuffix(fileName, path.Ext(fileName)))
+	}
+	for name, ids := range sets {
+		err := writeZipFile(zw, path.Join("ImageSets", "Main", name+".txt"), []byte(strings.Join(ids, "\n")+"\n"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// attributesJSON 属性值编码为 JSON，没有属性时为空字符串
+func attributesJSON(attrs map[string]interface{}) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(attrs)
+	return string(data)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// csvField 按 CSV 规则转义字段
+func csvField(s string) string {
+	if strings.ContainsAny(s, ",\"\n") {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return s
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+func clampInt(v float64, max int) int {
+	return int(math.Max(0, math.Min(float64(max), math.Round(v))))
+}
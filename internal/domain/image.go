@@ -0,0 +1,268 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sapphire-server/internal/dao"
+	"sapphire-server/internal/data/dto"
+	"sapphire-server/pkg/misc"
+	"sort"
+	"time"
+)
+
+var (
+	ErrImageNotFound    = errors.New("image not found")
+	ErrEmptyImageFilter = errors.New("image filter should have at least one condition")
+	ErrDuplicateImageID = errors.New("duplicate image id")
+)
+
+// imagePosition 查询图片顺序使用
+type imagePosition struct {
+	ID       uint
+	Position int
+}
+
+// GetDatasetImage 读取数据集中的图片，不存在或不属于该数据集时返回 nil
+func GetDatasetImage(ctx context.Context, datasetID uint, imageID uint) (*ImgDataset, error) {
+	return dao.First[ImgDataset](ctx, "id = ? AND dataset_id = ?", imageID, datasetID)
+}
+
+// imageFilterSQL 将过滤条件转换为 img_datasets 上的查询条件
+func imageFilterSQL(datasetID uint, filter dto.ImageFilter) (string, []interface{}, error) {
+	if len(filter.ImageIDs) == 0 && filter.Status == nil && filter.CreatedBefore == nil && filter.CreatedAfter == nil {
+		return "", nil, ErrEmptyImageFilter
+	}
+	query := "dataset_id = ? AND deleted_at IS NULL"
+	args := []interface{}{datasetID}
+	if len(filter.ImageIDs) > 0 {
+		query += " AND id IN ?"
+		args = append(args, filter.ImageIDs)
+	}
+	if filter.Status != nil {
+		query += " AND status = ?"
+		args = append(args, *filter.Status)
+	}
+	if filter.CreatedBefore != nil {
+		query += " AND created_at < ?"
+		args = append(args, *filter.CreatedBefore)
+	}
+	if filter.CreatedAfter != nil {
+		query += " AND created_at >= ?"
+		args = append(args, *filter.CreatedAfter)
+	}
+	return query, args, nil
+}
+
+// deleteImageAnnotations 删除满足条件的图片上的标注，以及标注的分配记录、评分和一致性
+func deleteImageAnnotations(tx context.Context, imageQuery string, args ...interface{}) error {
+	images := "SELECT id FROM img_datasets WHERE " + imageQuery
+	_, err := dao.DeleteWhere[AnnotationUser](tx, "annotation_id IN (SELECT id FROM annotations WHERE image_id IN ("+images+"))", args...)
+	if err != nil {
+		return err
+	}
+	_, err = dao.DeleteWhere[Annotation](tx, "image_id IN ("+images+")", args...)
+	if err != nil {
+		return err
+	}
+	_, err = dao.DeleteWhere[Score](tx, "img_id IN ("+images+")", args...)
+	if err != nil {
+		return err
+	}
+	_, err = dao.DeleteWhere[AnnotatorQuality](tx, "image_id IN ("+images+")", args...)
+	if err != nil {
+		return err
+	}
+	_, err = dao.DeleteWhere[ImageQuality](tx, "image_id IN ("+images+")", args...)
+	return err
+}
+
+// DeleteImages 删除数据集中满足条件的图片和图片上的标注，返回删除的图片数
+// 存储中的文件不删除，link 策略下其他数据集可能引用同一个文件
+func (d *Dataset) DeleteImages(ctx context.Context, datasetID uint, filter dto.ImageFilter) (int64, error) {
+	query, args, err := imageFilterSQL(datasetID, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	err = dao.WithTx(ctx, func(tx context.Context) error {
+		err := deleteImageAnnotations(tx, query, args...)
+		if err != nil {
+			return err
+		}
+		// 取消图片的嵌入任务，避免 worker 继续处理已删除的图片，也不再计入活动进度
+		taskArgs := append(append([]interface{}{}, args...), []int{READY, RUNNING})
+		_, err = dao.UpdateWhere[Task](tx, map[string]interface{}{
+			"status":      FAILED,
+			"lease_token": "",
+			"error":       "image deleted",
+			"finished_at": time.Now(),
+		}, "image_id IN (SELECT id FROM img_datasets WHERE "+query+") AND status IN ?", taskArgs...)
+		if err != nil {
+			return err
+		}
+		deleted, err = dao.DeleteWhere[ImgDataset](tx, query, args...)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if deleted > 0 {
+		NotifyDatasetProgress(ctx, datasetID)
+	}
+	return deleted, nil
+}
+
+// DeleteImage 删除数据集中的一张图片
+func (d *Dataset) DeleteImage(ctx context.Context, datasetID uint, imageID uint) error {
+	deleted, err := d.DeleteImages(ctx, datasetID, dto.ImageFilter{ImageIDs: []uint{imageID}})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrImageNotFound
+	}
+	return nil
+}
+
+// ReplaceImage 替换图片文件，图片回到未嵌入状态，重新生成缩略图和嵌入
+// keepAnnotations 为 false 时删除图片上已有的标注，新图片的内容可能与原来的标注对不上
+func (d *Dataset) ReplaceImage(ctx context.Context, img *ImgDataset, data []byte, keepAnnotations bool) (*ImgDataset, error) {
+	_, ext, ok := misc.SniffImage(data)
+	if !ok {
+		return nil, misc.ErrUnsupportedImage
+	}
+	key, url, err := misc.UploadImage(data, fmt.Sprintf("%d_replace%s", img.ID, ext))
+	if err != nil {
+		return nil, err
+	}
+
+	// 原来的文件不删除，可能被其他数据集引用
+	values := map[string]interface{}{
+		"img_url":               url,
+		"status":                ImgStatusDefault,
+		"embedding_url":         "",
+		"embedding_sam_id":      0,
+		"width":                 0,
+		"height":                0,
+		"format":                "",
+		"bytes":                 0,
+		"thumbnail_url":         "",
+		"thumbnails":            nil,
+		"preview_url":           "",
+		"sha256":                misc.ContentHash(data),
+		"phash":                 "",
+		"derivative_status":     DerivativePending,
+		"derivative_claimed_at": nil,
+		"derivative_attempts":   0,
+		"derivative_retry_at":   nil,
+	}
+	err = dao.WithTx(ctx, func(tx context.Context) error {
+		if !keepAnnotations {
+			err := deleteImageAnnotations(tx, "id = ?", img.ID)
+			if err != nil {
+				return err
+			}
+		}
+		rows, err := dao.UpdateWhere[ImgDataset](tx, values, "id = ?", img.ID)
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrImageNotFound
+		}
+		// 取消原图片的嵌入任务，下一轮为新图片创建任务
+		_, err = dao.UpdateWhere[Task](tx, map[string]interface{}{
+			"status":      FAILED,
+			"lease_token": "",
+			"error":       "image replaced",
+			"finished_at": time.Now(),
+		}, "image_id = ? AND status IN ?", img.ID, []int{READY, RUNNING})
+		return err
+	})
+	if err != nil {
+		misc.RemoveImages([]string{key})
+		return nil, err
+	}
+	NotifyDatasetProgress(ctx, img.DatasetId)
+	return dao.FindOne[ImgDataset](ctx, img.ID)
+}
+
+// ResetImageStatus 将满足条件的图片重置为未嵌入或已嵌入，返回重置的图片数
+// 重置为未嵌入时清空嵌入结果，由嵌入任务重新处理
+func (d *Dataset) ResetImageStatus(ctx context.Context, datasetID uint, req dto.ResetImageStatus) (int64, error) {
+	if req.Target != ImgStatusDefault && req.Target != ImgStatusEmbedded {
+		return 0, fmt.Errorf("invalid target status %d", req.Target)
+	}
+	query, args, err := imageFilterSQL(datasetID, req.ImageFilter)
+	if err != nil {
+		return 0, err
+	}
+
+	values := map[string]interface{}{"status": req.Target}
+	if req.Target == ImgStatusDefault {
+		values["embedding_url"] = ""
+		values["embedding_sam_id"] = 0
+	}
+	var rows int64
+	err = dao.WithTx(ctx, func(tx context.Context) error {
+		if req.ClearAnnotations {
+			err := deleteImageAnnotations(tx, query, args...)
+			if err != nil {
+				return err
+			}
+		}
+		rows, err = dao.UpdateWhere[ImgDataset](tx, values, query, args...)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if rows > 0 {
+		NotifyDatasetProgress(ctx, datasetID)
+	}
+	return rows, nil
+}
+
+// ReorderImages 按 imageIDs 的顺序排列这些图片，它们占用的位置不变，其他图片的顺序不受影响
+func (d *Dataset) ReorderImages(ctx context.Context, datasetID uint, imageIDs []uint) error {
+	seen := make(map[uint]bool, len(imageIDs))
+	for _, id := range imageIDs {
+		if seen[id] {
+			return fmt.Errorf("%w %d", ErrDuplicateImageID, id)
+		}
+		seen[id] = true
+	}
+
+	return dao.WithTx(ctx, func(tx context.Context) error {
+		images, err := dao.Query[imagePosition](tx,
+			"SELECT id, position FROM img_datasets WHERE dataset_id = ? AND id IN ? AND deleted_at IS NULL ORDER BY position, id FOR UPDATE",
+			datasetID, imageIDs)
+		if err != nil {
+			return err
+		}
+		if len(images) != len(imageIDs) {
+			return ErrImageNotFound
+		}
+
+		// 位置相同的图片原来按 ID 排序，重新编号避免排序后仍然相同
+		positions := make([]int, len(images))
+		for i, img := range images {
+			positions[i] = img.Position
+		}
+		sort.Ints(positions)
+		for i := 1; i < len(positions); i++ {
+			if positions[i] <= positions[i-1] {
+				positions[i] = positions[i-1] + 1
+			}
+		}
+		for i, id := range imageIDs {
+			_, err = dao.UpdateWhere[ImgDataset](tx, map[string]interface{}{"position": positions[i]}, "id = ?", id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,658 @@
+package domain
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"path"
+	"sapphire-server/internal/dao"
+	"sapphire-server/internal/data/datatypes"
+	"sapphire-server/internal/data/dto"
+	"sapphire-server/pkg/misc"
+	"sapphire-server/pkg/util"
+	"strconv"
+	"strings"
+)
+
+// 导入支持的格式
+const (
+	ImportFormatAuto = "auto"
+	ImportFormatCOCO = "coco"
+	ImportFormatYOLO = "yolo"
+)
+
+// ImportIssue 导入时无法处理的文件或标注
+type ImportIssue struct {
+	File   string `json:"file"`
+	Line   int    `json:"line,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// ImportReport 导入结果
+type ImportReport struct {
+	Format              string        `json:"format"`
+	ImageCount          int           `json:"imageCount"`
+	AnnotationCount     int           `json:"annotationCount"`
+	BoxCount            int           `json:"boxCount"`
+	UnmatchedFiles      []ImportIssue `json:"unmatchedFiles"`
+	UnmatchedCategories []string      `json:"unmatchedCategories"`
+	Malformed           []ImportIssue `json:"malformed"`
+	// Duplicates 按数据集的去重策略处理的重复图片
+	Duplicates []ImportDuplicate `json:"duplicates"`
+}
+
+// ImportDuplicate 与已有图片或压缩包中前面的图片内容相同的图片，含义与上传任务的文件记录相同
+type ImportDuplicate struct {
+	File string `json:"file"`
+	// Duplicate 重复的类型，见 DuplicateExact 等常量
+	Duplicate string `json:"duplicate"`
+	// DuplicateOf 重复的数据集图片
+	DuplicateOf uint `json:"duplicateOf,omitempty"`
+	// DuplicateFile 重复的压缩包中的文件
+	DuplicateFile string `json:"duplicateFile,omitempty"`
+	// Skipped 被跳过的图片不加入数据集，其中的标注也不导入
+	Skipped bool `json:"skipped"`
+}
+
+// importImage 压缩包中的一张图片及其标注
+type importImage struct {
+	file   *zip.File
+	width  int
+	height int
+	marks  []dto.AnnotationResult
+	// 读取图片时记录的格式、大小和哈希
+	ext    string
+	size   int64
+	sha256 string
+	phash  string
+}
+
+// importArchive 解析中的压缩包
+type importArchive struct {
+	report     *ImportReport
+	categories *categorySet
+	// images key 为不带扩展名的文件名
+	images map[string]*importImage
+	// order 保持图片在压缩包中的顺序
+	order  []string
+	labels []*zip.File
+	other  []*zip.File
+	// limits 与上传压缩包相同的大小限制，total 为已经读取的字节数
+	limits util.UnzipLimits
+	total  int64
+	// tooBig 读取的文件总大小超过限制，需要中止导入
+	tooBig bool
+}
+
+func isImportImage(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".webp", ".bmp":
+		return true
+	}
+	return false
+}
+
+func isLabelFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json", ".txt", ".yaml", ".yml", ".names":
+		return true
+	}
+	return false
+}
+
+// isYOLOClassFile 保存 YOLO 类别名的文件
+func isYOLOClassFile(name string) bool {
+	switch strings.ToLower(path.Base(name)) {
+	case "data.yaml", "data.yml", "classes.txt", "obj.names":
+		return true
+	}
+	return false
+}
+
+func baseName(name string) string {
+	base := path.Base(name)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// readZipFile 读取压缩包中的文件，maxSize 大于 0 时最多读取 maxSize+1 字节
+func readZipFile(f *zip.File, maxSize int64) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	r := io.Reader(rc)
+	if maxSize > 0 {
+		r = io.LimitReader(rc, maxSize+1)
+	}
+	return io.ReadAll(r)
+}
+
+// readFile 读取压缩包中的文件，按实际读取的字节数检查单个文件和总大小的限制
+func (a *importArchive) readFile(f *zip.File) ([]byte, error) {
+	maxSize := a.limits.MaxFileSize
+	if maxSize > 0 && f.UncompressedSize64 > uint64(maxSize) {
+		return nil, fmt.Errorf("file larger than %d bytes", maxSize)
+	}
+	if a.limits.MaxTotalSize > 0 && (maxSize <= 0 || a.limits.MaxTotalSize-a.total < maxSize) {
+		maxSize = a.limits.MaxTotalSize - a.total
+	}
+	data, err := readZipFile(f, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		if a.limits.MaxTotalSize > 0 && a.total+int64(len(data)) > a.limits.MaxTotalSize {
+			a.tooBig = true
+			return nil, util.ErrArchiveTooBig
+		}
+		return nil, fmt.Errorf("file larger than %d bytes", maxSize)
+	}
+	a.total += int64(len(data))
+	return data, nil
+}
+
+// newImportArchive 按文件类型整理压缩包，文件数量或记录的解压大小超过限制时返回错误
+func newImportArchive(zr *zip.Reader, categories *categorySet, limits util.UnzipLimits) (*importArchive, error) {
+	a := &importArchive{
+		report: &ImportReport{
+			UnmatchedFiles:      make([]ImportIssue, 0),
+			UnmatchedCategories: make([]string, 0),
+			Malformed:           make([]ImportIssue, 0),
+			Duplicates:          make([]ImportDuplicate, 0),
+		},
+		categories: categories,
+		images:     make(map[string]*importImage),
+		limits:     limits,
+	}
+	var entries int
+	var declared uint64
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || strings.Contains(f.Name, "__MACOSX") || strings.HasPrefix(path.Base(f.Name), ".") {
+			continue
+		}
+		entries++
+		if limits.MaxEntries > 0 && entries > limits.MaxEntries {
+			return nil, util.ErrTooManyEntries
+		}
+		declared += f.UncompressedSize64
+		if limits.MaxTotalSize > 0 && declared > uint64(limits.MaxTotalSize) {
+			return nil, util.ErrArchiveTooBig
+		}
+		switch {
+		case isImportImage(f.Name):
+			key := baseName(f.Name)
+			if _, ok := a.images[key]; ok {
+				a.report.UnmatchedFiles = append(a.report.UnmatchedFiles, ImportIssue{File: f.Name, Reason: "duplicate image name"})
+				continue
+			}
+			a.images[key] = &importImage{file: f}
+			a.order = append(a.order, key)
+		case isLabelFile(f.Name):
+			a.labels = append(a.labels, f)
+		default:
+			a.other = append(a.other, f)
+		}
+	}
+	for _, f := range a.other {
+		a.report.UnmatchedFiles = append(a.report.UnmatchedFiles, ImportIssue{File: f.Name, Reason: "unsupported file type"})
+	}
+	return a, nil
+}
+
+// detectFormat 压缩包中有 json 文件时按 COCO 解析，否则按 YOLO 解析
+func (a *importArchive) detectFormat() string {
+	for _, f := range a.labels {
+		if strings.HasSuffix(strings.ToLower(f.Name), ".json") {
+			return ImportFormatCOCO
+		}
+	}
+	return ImportFormatYOLO
+}
+
+func (a *importArchive) malformed(file string, line int, format string, args ...interface{}) {
+	a.report.Malformed = append(a.report.Malformed, ImportIssue{File: file, Line: line, Reason: fmt.Sprintf(format, args...)})
+}
+
+// loadImageSize 读取图片宽高，只解析头部
+func (a *importArchive) loadImageSize(img *importImage) error {
+	if img.width > 0 {
+		return nil
+	}
+	rc, err := img.file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	cfg, _, err := image.DecodeConfig(rc)
+	if err != nil {
+		return err
+	}
+	img.width, img.height = cfg.Width, cfg.Height
+	return nil
+}
+
+// addDuplicate 在报告中记录重复的图片
+func (a *importArchive) addDuplicate(file string, m duplicateMatch) {
+	d := ImportDuplicate{File: file, Duplicate: m.duplicate, DuplicateFile: m.file, Skipped: m.skip}
+	if m.of != nil {
+		d.DuplicateOf = m.of.ID
+	}
+	a.report.Duplicates = append(a.report.Duplicates, d)
+}
+
+// categoryLabel 按名称匹配数据集的标签，返回标签 ID
+func categoryLabel(categories *categorySet, name string) (uint, bool) {
+	for _, l := range categories.labels {
+		if strings.EqualFold(strings.TrimSpace(l.Name), strings.TrimSpace(name)) {
+			return l.ID, true
+		}
+	}
+	return 0, false
+}
+
+func (a *importArchive) addUnmatchedCategory(name string) {
+	for _, c := range a.report.UnmatchedCategories {
+		if c == name {
+			return
+		}
+	}
+	a.report.UnmatchedCategories = append(a.report.UnmatchedCategories, name)
+}
+
+// parseCOCO 解析 COCO 标注文件，bbox 为左上角坐标和宽高
+func (a *importArchive) parseCOCO() {
+	for _, f := range a.labels {
+		if !strings.HasSuffix(strings.ToLower(f.Name), ".json") {
+			a.report.UnmatchedFiles = append(a.report.UnmatchedFiles, ImportIssue{File: f.Name, Reason: "not a COCO annotation file"})
+			continue
+		}
+		data, err := a.readFile(f)
+		if err != nil {
+			a.malformed(f.Name, 0, "read failed: %v", err)
+			continue
+		}
+		var coco COCODataset
+		err = json.Unmarshal(data, &coco)
+		if err != nil {
+			a.malformed(f.Name, 0, "invalid COCO json: %v", err)
+			continue
+		}
+
+		categories := make(map[uint]uint)
+		for _, c := range coco.Categories {
+			labelID, ok := categoryLabel(a.categories, c.Name)
+			if !ok {
+				a.addUnmatchedCategory(c.Name)
+				continue
+			}
+			categories[c.ID] = labelID
+		}
+		images := make(map[uint]*importImage)
+		for _, img := range coco.Images {
+			target, ok := a.images[baseName(img.FileName)]
+			if !ok {
+				a.report.UnmatchedFiles = append(a.report.UnmatchedFiles, ImportIssue{File: img.FileName, Reason: "image not found in archive"})
+				continue
+			}
+			images[img.ID] = target
+		}
+
+		for i, anno := range coco.Annotations {
+			target, ok := images[anno.ImageID]
+			if !ok {
+				a.malformed(f.Name, 0, "annotation %d: unknown image_id %d", i, anno.ImageID)
+				continue
+			}
+			labelID, ok := categories[anno.CategoryID]
+			if !ok {
+				a.malformed(f.Name, 0, "annotation %d: unmatched category_id %d", i, anno.CategoryID)
+				continue
+			}
+			if len(anno.BBox) != 4 || anno.BBox[2] <= 0 || anno.BBox[3] <= 0 {
+				a.malformed(f.Name, 0, "annotation %d: invalid bbox %v", i, anno.BBox)
+				continue
+			}
+			err = a.loadImageSize(target)
+			if err != nil {
+				a.malformed(target.file.Name, 0, "invalid image: %v", err)
+				continue
+			}
+			x, y, w, h := anno.BBox[0], anno.BBox[1], anno.BBox[2], anno.BBox[3]
+			if x < 0 || y < 0 || x+w > float64(target.width)+1 || y+h > float64(target.height)+1 {
+				a.malformed(f.Name, 0, "annotation %d: bbox %v outside image", i, anno.BBox)
+				continue
+			}
+			target.marks = append(target.marks, dto.AnnotationResult{
+				Type:    dto.ShapeBBox,
+				CenterX: x + w/2,
+				CenterY: y + h/2,
+				Width:   w,
+				Height:  h,
+				ID:      labelID,
+			})
+		}
+	}
+}
+
+// yoloClassNames 读取 data.yaml 或 classes.txt 中的类别名，没有时使用数据集标签的顺序
+func (a *importArchive) yoloClassNames() []string {
+	for _, f := range a.labels {
+		if !isYOLOClassFile(f.Name) {
+			continue
+		}
+		name := strings.ToLower(path.Base(f.Name))
+		data, err := a.readFile(f)
+		if err != nil {
+			a.malformed(f.Name, 0, "read failed: %v", err)
+			continue
+		}
+		if strings.HasSuffix(name, ".txt") || strings.HasSuffix(name, ".names") {
+			var names []string
+			for _, line := range strings.Split(string(data), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					names = append(names, line)
+				}
+			}
+			return names
+		}
+		return parseYAMLNames(string(data))
+	}
+	return a.categories.names()
+}
+
+// parseYAMLNames 解析 data.yaml 中的 names，支持列表和 `0: name` 两种写法
+func parseYAMLNames(content string) []string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "names:") {
+			continue
+		}
+		rest := strings.TrimSpace(strings.TrimPrefix(trimmed, "names:"))
+		if strings.HasPrefix(rest, "[") {
+			rest = strings.Trim(rest, "[]")
+			var names []string
+			for _, n := range strings.Split(rest, ",") {
+				names = append(names, strings.Trim(strings.TrimSpace(n), `"'`))
+			}
+			return names
+		}
+
+		names := make(map[int]string)
+		maxIndex := -1
+		next := 0
+		for _, item := range lines[i+1:] {
+			if item == "" || (item[0] != ' ' && item[0] != '\t') {
+				break
+			}
+			item = strings.TrimSpace(item)
+			idx := next
+			if strings.HasPrefix(item, "- ") {
+				item = strings.TrimPrefix(item, "- ")
+			} else if parts := strings.SplitN(item, ":", 2); len(parts) == 2 {
+				n, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+				if err != nil {
+					continue
+				}
+				idx, item = n, parts[1]
+			}
+			names[idx] = strings.Trim(strings.TrimSpace(item), `"'`)
+			if idx > maxIndex {
+				maxIndex = idx
+			}
+			next = idx + 1
+		}
+		res := make([]string, maxIndex+1)
+		for idx, n := range names {
+			res[idx] = n
+		}
+		return res
+	}
+	return nil
+}
+
+// parseYOLO 解析 YOLO 标注，每行为 class cx cy w h，坐标按图片宽高归一化
+func (a *importArchive) parseYOLO() {
+	names := a.yoloClassNames()
+	classes := make(map[int]uint)
+	for i, name := range names {
+		labelID, ok := categoryLabel(a.categories, name)
+		if !ok {
+			a.addUnmatchedCategory(name)
+			continue
+		}
+		classes[i] = labelID
+	}
+
+	for _, f := range a.labels {
+		if isYOLOClassFile(f.Name) {
+			continue
+		}
+		if !strings.HasSuffix(strings.ToLower(f.Name), ".txt") {
+			a.report.UnmatchedFiles = append(a.report.UnmatchedFiles, ImportIssue{File: f.Name, Reason: "not a YOLO label file"})
+			continue
+		}
+		target, ok := a.images[baseName(f.Name)]
+		if !ok {
+			a.report.UnmatchedFiles = append(a.report.UnmatchedFiles, ImportIssue{File: f.Name, Reason: "no image with the same name"})
+			continue
+		}
+		err := a.loadImageSize(target)
+		if err != nil {
+			a.malformed(target.file.Name, 0, "invalid image: %v", err)
+			continue
+		}
+		data, err := a.readFile(f)
+		if err != nil {
+			a.malformed(f.Name, 0, "read failed: %v", err)
+			continue
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		line := 0
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			fields := strings.Fields(text)
+			if len(fields) != 5 {
+				a.malformed(f.Name, line, "expected 5 fields, got %d", len(fields))
+				continue
+			}
+			class, err := strconv.Atoi(fields[0])
+			if err != nil {
+				a.malformed(f.Name, line, "invalid class %q", fields[0])
+				continue
+			}
+			labelID, ok := classes[class]
+			if !ok {
+				a.malformed(f.Name, line, "unmatched class %d", class)
+				continue
+			}
+			var values [4]float64
+			valid := true
+			for i := 0; i < 4; i++ {
+				values[i], err = strconv.ParseFloat(fields[i+1], 64)
+				if err != nil || values[i] < 0 || values[i] > 1 {
+					valid = false
+				}
+			}
+			if !valid || values[2] == 0 || values[3] == 0 {
+				a.malformed(f.Name, line, "box should be 4 normalized values in (0, 1]")
+				continue
+			}
+			w, h := float64(target.width), float64(target.height)
+			target.marks = append(target.marks, dto.AnnotationResult{
+				Type:    dto.ShapeBBox,
+				CenterX: values[0] * w,
+				CenterY: values[1] * h,
+				Width:   values[2] * w,
+				Height:  values[3] * h,
+				ID:      labelID,
+			})
+		}
+	}
+}
+
+// ImportArchive 导入带标注的压缩包，图片注册到数据集，标注记在 sourceUserID 名下
+// 超过 limits 中文件数量或总大小的限制时返回 util.ErrTooManyEntries 或 util.ErrArchiveTooBig
+func (d *Dataset) ImportArchive(ctx context.Context, dataset *Dataset, sourceUserID uint, zr *zip.Reader, format string, limits util.UnzipLimits) (*ImportReport, error) {
+	categories, err := datasetCategories(ctx, dataset.ID)
+	if err != nil {
+		return nil, err
+	}
+	a, err := newImportArchive(zr, categories, limits)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" || format == ImportFormatAuto {
+		format = a.detectFormat()
+	}
+	a.report.Format = format
+	switch format {
+	case ImportFormatCOCO:
+		a.parseCOCO()
+	case ImportFormatYOLO:
+		a.parseYOLO()
+	default:
+		return nil, fmt.Errorf("unsupported import format %s", format)
+	}
+	if a.tooBig {
+		return nil, util.ErrArchiveTooBig
+	}
+
+	// 检查图片并计算哈希，失败的图片记录到报告中
+	var valid []*importImage
+	for _, key := range a.order {
+		img := a.images[key]
+		err := a.loadImageSize(img)
+		if err != nil {
+			a.malformed(img.file.Name, 0, "invalid image: %v", err)
+			continue
+		}
+		data, err := a.readFile(img.file)
+		if a.tooBig {
+			return nil, err
+		}
+		if err != nil {
+			a.malformed(img.file.Name, 0, "read failed: %v", err)
+			continue
+		}
+		// 与上传图片相同，按文件内容判断格式，不信任扩展名
+		contentType, ext, ok := misc.SniffImage(data)
+		if !ok {
+			a.malformed(img.file.Name, 0, "unsupported image type %s", contentType)
+			continue
+		}
+		img.ext = ext
+		img.size = int64(len(data))
+		img.sha256 = misc.ContentHash(data)
+		if dataset.NearDuplicateThreshold > 0 {
+			img.phash, _ = misc.PerceptualHash(data)
+		}
+		valid = append(valid, img)
+	}
+
+	// 与上传压缩包相同，按数据集的去重策略处理重复的图片
+	hashes := make([]string, len(valid))
+	for i, img := range valid {
+		hashes[i] = img.sha256
+	}
+	checker, err := newDuplicateChecker(ctx, dataset, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	// 上传图片，引用其他数据集的图片不需要上传
+	var images []ImgDataset
+	var keys []string
+	var uploaded []*importImage
+	for _, img := range valid {
+		m := checker.check(img.sha256, img.file.Name)
+		if m.duplicate != "" {
+			a.addDuplicate(img.file.Name, m)
+		}
+		if m.skip {
+			continue
+		}
+		var url string
+		if m.duplicate == DuplicateLinked {
+			url = m.of.ImgUrl
+		} else {
+			// 计算哈希时没有保留内容，避免整个压缩包的图片同时在内存中
+			data, err := readZipFile(img.file, img.size)
+			if err != nil {
+				misc.RemoveImages(keys)
+				return nil, err
+			}
+			var objectKey string
+			objectKey, url, err = misc.UploadImage(data, baseName(img.file.Name)+img.ext)
+			if err != nil {
+				misc.RemoveImages(keys)
+				return nil, err
+			}
+			keys = append(keys, objectKey)
+		}
+		images = append(images, ImgDataset{ImgUrl: url, Sha256: img.sha256, PHash: img.phash})
+		uploaded = append(uploaded, img)
+	}
+
+	var annotations []Annotation
+	err = dao.WithTx(ctx, func(tx context.Context) error {
+		saved, err := d.AddImages(tx, dataset, images)
+		if err != nil {
+			return err
+		}
+
+		for i, img := range uploaded {
+			if len(img.marks) == 0 {
+				continue
+			}
+			annotations = append(annotations, Annotation{
+				Content:     datatypes.NewJSONType(img.marks),
+				DatasetID:   dataset.ID,
+				ImageID:     saved[i].ID,
+				UserID:      sourceUserID,
+				IsQualified: true,
+				Imported:    true,
+			})
+			a.report.BoxCount += len(img.marks)
+		}
+		if len(annotations) > 0 {
+			err = dao.SaveAll(tx, annotations)
+			if err != nil {
+				return err
+			}
+		}
+		a.report.ImageCount = len(saved)
+		a.report.AnnotationCount = len(annotations)
+		return nil
+	})
+	if err != nil {
+		misc.RemoveImages(keys)
+		return nil, err
+	}
+
+	// 提交后重新计算收到标注的图片的一致性，失败时不影响导入结果
+	for _, anno := range annotations {
+		err = RefreshImageQuality(ctx, anno.ImageID)
+		if err != nil {
+			slog.Error("RefreshImageQuality", "imageID", anno.ImageID, "err", err)
+		}
+	}
+
+	NotifyDatasetProgress(ctx, dataset.ID)
+	slog.Info("ImportArchive", "datasetID", dataset.ID, "format", format, "images", a.report.ImageCount,
+		"boxes", a.report.BoxCount, "duplicates", len(a.report.Duplicates))
+	return a.report, nil
+}
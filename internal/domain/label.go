@@ -0,0 +1,319 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"sapphire-server/internal/dao"
+	"sapphire-server/internal/data/datatypes"
+	"sapphire-server/internal/data/dto"
+	"strings"
+)
+
+var (
+	ErrLabelNotFound = errors.New("label not found")
+	// ErrLabelExists 同一个数据集中的标签不能重名
+	ErrLabelExists  = errors.New("label already exists")
+	ErrInvalidLabel = errors.New("invalid label")
+	// ErrLabelInUse 标签已经被标注使用或有下级标签
+	ErrLabelInUse = errors.New("label is in use")
+)
+
+// Label 数据集的标签，标注中的 id 为标签 ID
+type Label struct {
+	gorm.Model
+	DatasetID uint   `gorm:"column:dataset_id" json:"datasetId"`
+	Name      string `gorm:"column:name" json:"name"`
+	Color     string `gorm:"column:color" json:"color"`
+	// ParentID 上级标签，为 0 时是顶层标签
+	ParentID uint `gorm:"column:parent_id" json:"parentId"`
+	// Position 导出时类别的顺序，相同时按 ID 排序
+	Position int `gorm:"column:position" json:"position"`
+	// Shapes 允许使用的形状类型，为空时不限制
+	Shapes     datatypes.JSONType[[]string]             `gorm:"column:shapes" json:"shapes"`
+	Attributes datatypes.JSONType[[]dto.LabelAttribute] `gorm:"column:attributes" json:"attributes"`
+}
+
+// AllowShape 标签是否允许使用该形状
+func (l *Label) AllowShape(shape string) bool {
+	shapes := l.Shapes.Data()
+	if len(shapes) == 0 {
+		return true
+	}
+	for _, s := range shapes {
+		if s == shape {
+			return true
+		}
+	}
+	return false
+}
+
+// categorySet 数据集的标签，按导出顺序排列，导出时类别的下标即为该顺序
+type categorySet struct {
+	labels []Label
+	// index 标签 ID 到下标
+	index map[uint]int
+}
+
+func newCategorySet(labels []Label) *categorySet {
+	c := &categorySet{labels: labels, index: make(map[uint]int, len(labels))}
+	for i, l := range labels {
+		c.index[l.ID] = i
+	}
+	return c
+}
+
+// names 按顺序排列的标签名
+func (c *categorySet) names() []string {
+	names := make([]string, len(c.labels))
+	for i, l := range c.labels {
+		names[i] = l.Name
+	}
+	return names
+}
+
+// indexOf 标签在导出类别中的下标
+func (c *categorySet) indexOf(labelID uint) (int, bool) {
+	i, ok := c.index[labelID]
+	return i, ok
+}
+
+// label 读取数据集中的标签，不属于该数据集时返回 nil
+func (c *categorySet) label(labelID uint) *Label {
+	i, ok := c.index[labelID]
+	if !ok {
+		return nil
+	}
+	return &c.labels[i]
+}
+
+// ListLabels 按导出顺序列出数据集的标签
+func ListLabels(ctx context.Context, datasetID uint) ([]Label, error) {
+	return dao.Query[Label](ctx, `SELECT * FROM "labels" WHERE "dataset_id" = ? AND "deleted_at" IS NULL ORDER BY "position", "id"`, datasetID)
+}
+
+// datasetCategories 读取数据集的标签
+func datasetCategories(ctx context.Context, datasetID uint) (*categorySet, error) {
+	labels, err := ListLabels(ctx, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	return newCategorySet(labels), nil
+}
+
+// GetLabel 读取数据集中的标签
+func GetLabel(ctx context.Context, datasetID uint, labelID uint) (*Label, error) {
+	label, err := dao.First[Label](ctx, "id = ? AND dataset_id = ?", labelID, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	if label == nil {
+		return nil, ErrLabelNotFound
+	}
+	return label, nil
+}
+
+// CreateLabel 创建标签，没有指定位置时排在最后
+func CreateLabel(ctx context.Context, datasetID uint, req dto.NewLabel) (*Label, error) {
+	var label *Label
+	err := dao.WithTx(ctx, func(tx context.Context) error {
+		err := lockDataset(tx, datasetID)
+		if err != nil {
+			return err
+		}
+		labels, err := ListLabels(tx, datasetID)
+		if err != nil {
+			return err
+		}
+		label = &Label{DatasetID: datasetID}
+		if req.Position == nil {
+			for _, l := range labels {
+				label.Position = max(label.Position, l.Position+1)
+			}
+		}
+		err = applyLabel(label, labels, req)
+		if err != nil {
+			return err
+		}
+		return dao.Save(tx, label)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return label, nil
+}
+
+// UpdateLabel 修改标签，位置为空时保持不变
+func UpdateLabel(ctx context.Context, datasetID uint, labelID uint, req dto.NewLabel) (*Label, error) {
+	var label *Label
+	err := dao.WithTx(ctx, func(tx context.Context) error {
+		err := lockDataset(tx, datasetID)
+		if err != nil {
+			return err
+		}
+		label, err = GetLabel(tx, datasetID, labelID)
+		if err != nil {
+			return err
+		}
+		labels, err := ListLabels(tx, datasetID)
+		if err != nil {
+			return err
+		}
+		err = applyLabel(label, labels, req)
+		if err != nil {
+			return err
+		}
+		return dao.Save(tx, label)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return label, nil
+}
+
+// DeleteLabel 删除没有被使用的标签
+func DeleteLabel(ctx context.Context, datasetID uint, labelID uint) error {
+	return dao.WithTx(ctx, func(tx context.Context) error {
+		err := lockDataset(tx, datasetID)
+		if err != nil {
+			return err
+		}
+		_, err = GetLabel(tx, datasetID, labelID)
+		if err != nil {
+			return err
+		}
+		child, err := dao.First[Label](tx, "dataset_id = ? AND parent_id = ?", datasetID, labelID)
+		if err != nil {
+			return err
+		}
+		if child != nil {
+			return fmt.Errorf("%w: has child label %s", ErrLabelInUse, child.Name)
+		}
+		used, err := dao.First[Annotation](tx, "dataset_id = ? AND content @> ?::jsonb", datasetID, fmt.Sprintf(`[{"id": %d}]`, labelID))
+		if err != nil {
+			return err
+		}
+		if used != nil {
+			return fmt.Errorf("%w: used by annotation %d", ErrLabelInUse, used.ID)
+		}
+		_, err = dao.DeleteWhere[Label](tx, "id = ?", labelID)
+		return err
+	})
+}
+
+// lockDataset 锁定数据集，避免同时修改标签时重名
+func lockDataset(ctx context.Context, datasetID uint) error {
+	rows, err := dao.Query[Dataset](ctx, `SELECT * FROM "datasets" WHERE "id" = ? AND "deleted_at" IS NULL FOR UPDATE`, datasetID)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return ErrDatasetNotFound
+	}
+	return nil
+}
+
+// applyLabel 校验请求并写入标签，labels 为数据集现有的标签
+func applyLabel(label *Label, labels []Label, req dto.NewLabel) error {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidLabel)
+	}
+	parents := make(map[uint]uint, len(labels))
+	for _, l := range labels {
+		parents[l.ID] = l.ParentID
+		if l.ID != label.ID && strings.EqualFold(l.Name, name) {
+			return fmt.Errorf("%w: %s", ErrLabelExists, name)
+		}
+	}
+
+	// 上级标签必须属于同一个数据集，并且不能形成环
+	if req.ParentID != 0 {
+		if _, ok := parents[req.ParentID]; !ok {
+			return fmt.Errorf("%w: parent %d not found in dataset", ErrInvalidLabel, req.ParentID)
+		}
+		for id := req.ParentID; id != 0; id = parents[id] {
+			if id == label.ID {
+				return fmt.Errorf("%w: parent would form a cycle", ErrInvalidLabel)
+			}
+		}
+	}
+
+	attributes := req.Attributes
+	if attributes == nil {
+		attributes = []dto.LabelAttribute{}
+	}
+	seen := make(map[string]bool)
+	for i := range attributes {
+		attr := &attributes[i]
+		attr.Name = strings.TrimSpace(attr.Name)
+		if attr.Name == "" || seen[attr.Name] {
+			return fmt.Errorf("%w: attribute name should be unique and not empty", ErrInvalidLabel)
+		}
+		seen[attr.Name] = true
+		if attr.Type == dto.AttributeEnum && len(attr.Options) == 0 {
+			return fmt.Errorf("%w: enum attribute %s needs options", ErrInvalidLabel, attr.Name)
+		}
+		if attr.Type != dto.AttributeEnum {
+			attr.Options = nil
+		}
+	}
+	shapes := req.Shapes
+	if shapes == nil {
+		shapes = []string{}
+	}
+
+	label.Name = name
+	label.Color = req.Color
+	label.ParentID = req.ParentID
+	if req.Position != nil {
+		label.Position = *req.Position
+	}
+	label.Shapes = datatypes.NewJSONType(shapes)
+	label.Attributes = datatypes.NewJSONType(attributes)
+	return nil
+}
+
+// addLabelsByName 为数据集添加还没有的标签，用于兼容按 tags 创建数据集
+// 数据集没有任何标签时添加默认类别
+func addLabelsByName(ctx context.Context, datasetID uint, names []string) error {
+	labels, err := ListLabels(ctx, datasetID)
+	if err != nil {
+		return err
+	}
+	existed := make(map[string]bool)
+	position := 0
+	for _, l := range labels {
+		existed[strings.ToLower(l.Name)] = true
+		position = max(position, l.Position+1)
+	}
+
+	var added []Label
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || existed[strings.ToLower(name)] {
+			continue
+		}
+		existed[strings.ToLower(name)] = true
+		added = append(added, newLabel(datasetID, name, position))
+		position++
+	}
+	if len(labels) == 0 && len(added) == 0 {
+		added = append(added, newLabel(datasetID, defaultCategory, 0))
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	return dao.SaveAll(ctx, added)
+}
+
+func newLabel(datasetID uint, name string, position int) Label {
+	return Label{
+		DatasetID:  datasetID,
+		Name:       name,
+		Position:   position,
+		Shapes:     datatypes.NewJSONType([]string{}),
+		Attributes: datatypes.NewJSONType([]dto.LabelAttribute{}),
+	}
+}
@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"context"
 	"gorm.io/gorm"
 	"log/slog"
 	"sapphire-server/internal/dao"
@@ -29,11 +30,22 @@ const (
 	SYSTEM = 3
 )
 
+// messagePageOptions 消息列表允许的排序和过滤字段
+var messagePageOptions = dao.PageOptions{
+	SortFields: map[string]string{
+		"createdAt": "created_at",
+	},
+	FilterFields: map[string]string{
+		"type":      "type",
+		"creatorId": "creator_id",
+	},
+}
+
 func NewMessageDomain() *Message {
 	return &Message{}
 }
 
-func (m *Message) SendMessage(content, title string, messageType int, receiverID uint) *Message {
+func (m *Message) SendMessage(ctx context.Context, content, title string, messageType int, receiverID uint) (*Message, error) {
 	var err error
 	message := Message{
 		CreatorID:  0,
@@ -42,53 +54,57 @@ func (m *Message) SendMessage(content, title string, messageType int, receiverID
 		Title:      title,
 		Type:       messageType,
 	}
-	err = dao.Save(&message)
+	err = dao.Save(ctx, &message)
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &message
+	return &message, nil
 }
 
-// CreateMessage 创建消息
-func (m *Message) CreateMessage(creatorID uint, dto dto.NewMessage) *Message {
-	var err error
-
-	message := Message{
-		CreatorID: creatorID,
-		Content:   dto.Content,
-		Title:     dto.Title,
-		Type:      dto.Type,
-	}
+// CreateMessage 创建消息，每个接收者一条，全部成功或全部失败
+func (m *Message) CreateMessage(ctx context.Context, creatorID uint, dto dto.NewMessage) ([]Message, error) {
+	messages := make([]Message, 0, len(dto.ReceiverID))
 	for _, receiverID := range dto.ReceiverID {
-		message.ReceiverID = receiverID
+		messages = append(messages, Message{
+			CreatorID:  creatorID,
+			ReceiverID: receiverID,
+			Content:    dto.Content,
+			Title:      dto.Title,
+			Type:       dto.Type,
+		})
+	}
+	if len(messages) == 0 {
+		return messages, nil
 	}
 
-	err = dao.Save(&message)
+	err := dao.WithTx(ctx, func(tx context.Context) error {
+		return dao.SaveAll(tx, messages)
+	})
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &message
+	return messages, nil
 }
 
-// ListMessageByReceiverID 获取接收者的消息
-func (m *Message) ListMessageByReceiverID(receiverID uint) []Message {
-	messages, err := dao.FindAll[Message]("receiver_id = ?", receiverID)
+// ListMessagePageByReceiverID 分页获取接收者的消息
+func (m *Message) ListMessagePageByReceiverID(receiverID uint, req dao.PageRequest) (*dao.Page[Message], error) {
+	page, err := dao.FindPage[Message](context.Background(), req, messagePageOptions, "receiver_id = ?", receiverID)
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return messages
+	return page, nil
 }
 
 // ReadMessage 标记消息为已读
 func (m *Message) ReadMessage(messageID uint) {
 	var err error
-	message, err := dao.FindOne[Message]("id = ?", messageID)
+	message, err := dao.FindOne[Message](context.Background(), "id = ?", messageID)
 	if err != nil {
 		return
 	}
 
-	slog.Debug("message", message)
-	err = dao.Delete(message)
+	slog.Debug("ReadMessage", "message", message)
+	err = dao.Delete(context.Background(), message)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,145 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"sapphire-server/internal/dao"
+)
+
+// Permission 权限点
+type Permission string
+
+const (
+	// 平台级权限，只有平台管理员拥有
+	PermUserManage Permission = "user:manage"
+	PermTaskManage Permission = "task:manage"
+	// PermEmbeddingWrite 认领嵌入任务并汇报结果，用于 worker
+	PermEmbeddingWrite Permission = "embedding:write"
+
+	// 数据集级权限，由用户在数据集中的角色决定
+	PermDatasetRead          Permission = "dataset:read"
+	PermDatasetUpdate        Permission = "dataset:update"
+	PermDatasetDelete        Permission = "dataset:delete"
+	PermDatasetExport        Permission = "dataset:export"
+	PermDatasetManageMembers Permission = "dataset:members"
+	PermImageWrite           Permission = "image:write"
+	PermAnnotate             Permission = "annotation:write"
+	PermReview               Permission = "annotation:review"
+	PermDiscuss              Permission = "discussion:write"
+)
+
+// 用户在数据集中的角色
+const (
+	DatasetRoleOwner     = "owner"
+	DatasetRoleReviewer  = "reviewer"
+	DatasetRoleAnnotator = "annotator"
+	DatasetRoleViewer    = "viewer"
+)
+
+// 平台角色，对应 user_roles 表
+const (
+	PlatformRoleAdmin = "ADMIN"
+	PlatformRoleUser  = "USER"
+)
+
+var ErrPermissionDenied = errors.New("permission denied")
+
+// datasetRolePermissions 数据集角色拥有的权限
+var datasetRolePermissions = map[string][]Permission{
+	DatasetRoleOwner: {
+		PermDatasetRead, PermDatasetUpdate, PermDatasetDelete, PermDatasetExport, PermDatasetManageMembers,
+		PermImageWrite, PermAnnotate, PermReview, PermDiscuss,
+	},
+	DatasetRoleReviewer:  {PermDatasetRead, PermDatasetExport, PermAnnotate, PermReview, PermDiscuss},
+	DatasetRoleAnnotator: {PermDatasetRead, PermAnnotate, PermDiscuss},
+	DatasetRoleViewer:    {PermDatasetRead},
+}
+
+// IsValidDatasetRole 判断数据集角色是否合法
+func IsValidDatasetRole(role string) bool {
+	_, ok := datasetRolePermissions[role]
+	return ok
+}
+
+// IsDatasetPermission 判断是否为数据集级权限
+func IsDatasetPermission(perm Permission) bool {
+	return perm != PermUserManage && perm != PermTaskManage && perm != PermEmbeddingWrite
+}
+
+func roleHasPermission(role string, perm Permission) bool {
+	for _, p := range datasetRolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPlatformAdmin 判断用户是否为平台管理员
+func IsPlatformAdmin(ctx context.Context, userID uint) (bool, error) {
+	user, err := dao.FindOne[User](ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if user == nil {
+		return false, nil
+	}
+	role, err := dao.FindOne[UserRole](ctx, "role_name = ?", PlatformRoleAdmin)
+	if err != nil {
+		return false, err
+	}
+	return role != nil && user.Role == role.ID, nil
+}
+
+// GetDatasetRole 获取用户在数据集中的角色，不是成员时返回空字符串
+// 公开数据集的非成员视为 viewer
+func GetDatasetRole(ctx context.Context, userID uint, datasetID uint) (string, error) {
+	dataset, err := dao.FindOne[Dataset](ctx, datasetID)
+	if err != nil {
+		return "", err
+	}
+	if dataset == nil {
+		return "", nil
+	}
+	if dataset.CreatorID == userID {
+		return DatasetRoleOwner, nil
+	}
+
+	member, err := dao.FindOne[DatasetUser](ctx, "user_id = ? and dataset_id = ?", userID, datasetID)
+	if err != nil {
+		return "", err
+	}
+	if member != nil {
+		if member.Role == "" {
+			return DatasetRoleAnnotator, nil
+		}
+		return member.Role, nil
+	}
+	if dataset.IsPublic {
+		return DatasetRoleViewer, nil
+	}
+	return "", nil
+}
+
+// Authorize 判断用户是否拥有权限，datasetID 只对数据集级权限有效
+func Authorize(ctx context.Context, userID uint, datasetID uint, perm Permission) error {
+	admin, err := IsPlatformAdmin(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if admin {
+		return nil
+	}
+	if !IsDatasetPermission(perm) {
+		return ErrPermissionDenied
+	}
+
+	role, err := GetDatasetRole(ctx, userID, datasetID)
+	if err != nil {
+		return err
+	}
+	if !roleHasPermission(role, perm) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
@@ -0,0 +1,428 @@
+package domain
+
+import (
+	"context"
+	"sapphire-server/internal/dao"
+	"sapphire-server/internal/data/dto"
+	"time"
+)
+
+// 质量指标以多人标注的合并结果为参考：标注员的形状与合并结果按同标签的 IoU 匹配，
+// 匹配上的记为正确，多标的记为误检，漏标的记为漏检；图片类别按标签计算 Krippendorff's alpha
+// 每张图片的结果在新标注提交时重新计算，数据集和标注员的指标由各图片的结果求和得到
+
+// QualityCounts 与合并结果比较的计数，可以直接相加
+type QualityCounts struct {
+	Matched        int64 `gorm:"column:matched" json:"matched"`
+	FalsePositives int64 `gorm:"column:false_positives" json:"falsePositives"`
+	FalseNegatives int64 `gorm:"column:false_negatives" json:"falseNegatives"`
+	// IoUSum 匹配上的形状与合并结果的 IoU 之和，图片类别不计入
+	IoUSum   float64 `gorm:"column:iou_sum" json:"-"`
+	IoUCount int64   `gorm:"column:iou_count" json:"-"`
+}
+
+func (c *QualityCounts) add(o QualityCounts) {
+	c.Matched += o.Matched
+	c.FalsePositives += o.FalsePositives
+	c.FalseNegatives += o.FalseNegatives
+	c.IoUSum += o.IoUSum
+	c.IoUCount += o.IoUCount
+}
+
+func (c *QualityCounts) meanIoU() float64 {
+	return ratio(c.IoUSum, float64(c.IoUCount))
+}
+
+func (c *QualityCounts) precision() float64 {
+	return ratio(float64(c.Matched), float64(c.Matched+c.FalsePositives))
+}
+
+func (c *QualityCounts) recall() float64 {
+	return ratio(float64(c.Matched), float64(c.Matched+c.FalseNegatives))
+}
+
+// ImageQuality 图片上各标注员的一致性，至少两个标注员标注后才有记录
+type ImageQuality struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updatedAt"`
+	DatasetID uint      `gorm:"column:dataset_id" json:"datasetId"`
+	ImageID   uint      `gorm:"column:image_id" json:"imageId"`
+	// Annotators 参与比较的标注员数，每个标注员取最新的一次标注
+	Annotators int `gorm:"column:annotators" json:"annotators"`
+	// Objects 合并结果中的目标数，包括图片类别
+	Objects int `gorm:"column:objects" json:"objects"`
+	QualityCounts
+	// 以下三项为计算 alpha 的中间量，数据集的 alpha 由各图片求和后计算
+	AlphaDisagreement float64  `gorm:"column:alpha_disagreement" json:"-"`
+	AlphaPresent      float64  `gorm:"column:alpha_present" json:"-"`
+	AlphaAbsent       float64  `gorm:"column:alpha_absent" json:"-"`
+	MeanIoU           float64  `gorm:"column:mean_iou" json:"meanIou"`
+	Precision         float64  `gorm:"column:precision" json:"precision"`
+	Recall            float64  `gorm:"column:recall" json:"recall"`
+	Alpha             *float64 `gorm:"column:alpha" json:"alpha"`
+}
+
+// AnnotatorQuality 一个标注员在一张图片上与合并结果的比较
+type AnnotatorQuality struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UpdatedAt    time.Time `gorm:"column:updated_at" json:"updatedAt"`
+	DatasetID    uint      `gorm:"column:dataset_id" json:"datasetId"`
+	ImageID      uint      `gorm:"column:image_id" json:"imageId"`
+	UserID       uint      `gorm:"column:user_id" json:"userId"`
+	AnnotationID uint      `gorm:"column:annotation_id" json:"annotationId"`
+	QualityCounts
+}
+
+// QualityMetrics 汇总后的指标
+type QualityMetrics struct {
+	// Images 参与统计的图片数
+	Images int64 `json:"images"`
+	QualityCounts
+	MeanIoU   float64 `json:"meanIou"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	// Accuracy 正确数占正确、误检和漏检之和的比例
+	Accuracy float64 `json:"accuracy"`
+}
+
+// AnnotatorMetrics 标注员在数据集中的指标
+type AnnotatorMetrics struct {
+	UserID uint `json:"userId"`
+	QualityMetrics
+}
+
+// DatasetMetrics 标注员在某个数据集中的指标
+type DatasetMetrics struct {
+	DatasetID uint `json:"datasetId"`
+	QualityMetrics
+}
+
+// DatasetQuality 数据集的一致性和各标注员的指标
+type DatasetQuality struct {
+	DatasetID uint  `json:"datasetId"`
+	Objects   int64 `json:"objects"`
+	QualityMetrics
+	// Alpha 图片类别的 Krippendorff's alpha，没有图片类别时为空
+	Alpha      *float64           `json:"alpha"`
+	Annotators []AnnotatorMetrics `json:"annotators"`
+}
+
+// UserQuality 标注员在所有数据集中的指标
+type UserQuality struct {
+	UserID uint `json:"userId"`
+	QualityMetrics
+	Datasets []DatasetMetrics `json:"datasets"`
+}
+
+// qualitySums 按数据集或标注员求和的结果
+type qualitySums struct {
+	DatasetID uint  `gorm:"column:dataset_id"`
+	UserID    uint  `gorm:"column:user_id"`
+	Images    int64 `gorm:"column:images"`
+	Objects   int64 `gorm:"column:objects"`
+	QualityCounts
+	AlphaDisagreement float64 `gorm:"column:alpha_disagreement"`
+	AlphaPresent      float64 `gorm:"column:alpha_present"`
+	AlphaAbsent       float64 `gorm:"column:alpha_absent"`
+}
+
+func (s *qualitySums) metrics() QualityMetrics {
+	c := s.QualityCounts
+	return QualityMetrics{
+		Images:        s.Images,
+		QualityCounts: c,
+		MeanIoU:       c.meanIoU(),
+		Precision:     c.precision(),
+		Recall:        c.recall(),
+		Accuracy:      ratio(float64(c.Matched), float64(c.Matched+c.FalsePositives+c.FalseNegatives)),
+	}
+}
+
+// imageQualityPageOptions 图片一致性列表允许的排序和过滤字段
+var imageQualityPageOptions = dao.PageOptions{
+	SortFields: map[string]string{
+		"updatedAt":  "updated_at",
+		"annotators": "annotators",
+		"meanIou":    "mean_iou",
+		"precision":  "precision",
+		"recall":     "recall",
+	},
+	FilterFields: map[string]string{
+		"imageId":    "image_id",
+		"annotators": "annotators",
+		"meanIou":    "mean_iou",
+		"recall":     "recall",
+	},
+}
+
+const qualityCountColumns = `COALESCE(SUM("matched"), 0) AS "matched", COALESCE(SUM("false_positives"), 0) AS "false_positives",
+	COALESCE(SUM("false_negatives"), 0) AS "false_negatives", COALESCE(SUM("iou_sum"), 0) AS "iou_sum",
+	COALESCE(SUM("iou_count"), 0) AS "iou_count"`
+
+// GetDatasetQuality 汇总数据集的一致性和各标注员的指标
+func GetDatasetQuality(ctx context.Context, datasetID uint) (*DatasetQuality, error) {
+	rows, err := dao.Query[qualitySums](ctx, `SELECT COUNT(*) AS "images", COALESCE(SUM("objects"), 0) AS "objects", `+qualityCountColumns+`,
+	COALESCE(SUM("alpha_disagreement"), 0) AS "alpha_disagreement", COALESCE(SUM("alpha_present"), 0) AS "alpha_present",
+	COALESCE(SUM("alpha_absent"), 0) AS "alpha_absent"
+FROM "image_qualities" WHERE "dataset_id" = ?`, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	annotators, err := dao.Query[qualitySums](ctx, `SELECT "user_id", COUNT(*) AS "images", `+qualityCountColumns+`
+FROM "annotator_qualities" WHERE "dataset_id" = ? GROUP BY "user_id" ORDER BY "user_id"`, datasetID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DatasetQuality{DatasetID: datasetID, Annotators: make([]AnnotatorMetrics, 0, len(annotators))}
+	if len(rows) > 0 {
+		sums := rows[0]
+		result.Objects = sums.Objects
+		result.QualityMetrics = sums.metrics()
+		result.Alpha = krippendorffAlpha(sums.AlphaDisagreement, sums.AlphaPresent, sums.AlphaAbsent)
+	}
+	for _, a := range annotators {
+		result.Annotators = append(result.Annotators, AnnotatorMetrics{UserID: a.UserID, QualityMetrics: a.metrics()})
+	}
+	return result, nil
+}
+
+// GetUserQuality 汇总标注员在各数据集中的指标，已删除的数据集不计入
+func GetUserQuality(ctx context.Context, userID uint) (*UserQuality, error) {
+	datasets, err := dao.Query[qualitySums](ctx, `SELECT "q"."dataset_id", COUNT(*) AS "images", `+qualityCountColumns+`
+FROM "annotator_qualities" "q" JOIN "datasets" "d" ON "d"."id" = "q"."dataset_id" AND "d"."deleted_at" IS NULL
+WHERE "q"."user_id" = ? GROUP BY "q"."dataset_id" ORDER BY "q"."dataset_id"`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &UserQuality{UserID: userID, Datasets: make([]DatasetMetrics, 0, len(datasets))}
+	var total qualitySums
+	for _, d := range datasets {
+		total.Images += d.Images
+		total.add(d.QualityCounts)
+		result.Datasets = append(result.Datasets, DatasetMetrics{DatasetID: d.DatasetID, QualityMetrics: d.metrics()})
+	}
+	result.QualityMetrics = total.metrics()
+	return result, nil
+}
+
+// ListImageQualityPage 分页获取数据集中各图片的一致性
+func ListImageQualityPage(ctx context.Context, datasetID uint, req dao.PageRequest) (*dao.Page[ImageQuality], error) {
+	return dao.FindPage[ImageQuality](ctx, req, imageQualityPageOptions, "dataset_id = ?", datasetID)
+}
+
+// RefreshImageQuality 重新计算图片的一致性，标注员少于两人时只删除旧的记录
+func RefreshImageQuality(ctx context.Context, imageID uint) error {
+	return dao.WithTx(ctx, func(tx context.Context) error {
+		// 锁定图片，同时提交的标注依次计算
+		images, err := dao.Query[ImgDataset](tx, `SELECT * FROM "img_datasets" WHERE "id" = ? AND "deleted_at" IS NULL FOR UPDATE`, imageID)
+		if err != nil {
+			return err
+		}
+		_, err = dao.DeleteWhere[AnnotatorQuality](tx, "image_id = ?", imageID)
+		if err != nil {
+			return err
+		}
+		_, err = dao.DeleteWhere[ImageQuality](tx, "image_id = ?", imageID)
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			return nil
+		}
+
+		annotations, err := dao.Query[Annotation](tx, `SELECT * FROM "annotations" WHERE "image_id" = ? AND "is_qualified" AND "deleted_at" IS NULL ORDER BY "id"`, imageID)
+		if err != nil {
+			return err
+		}
+		image, annotators := evaluateImage(annotations, defaultConsensusOptions())
+		if image == nil {
+			return nil
+		}
+		image.DatasetID = images[0].DatasetId
+		image.ImageID = imageID
+		for i := range annotators {
+			annotators[i].DatasetID = image.DatasetID
+			annotators[i].ImageID = imageID
+		}
+		err = dao.Save(tx, image)
+		if err != nil {
+			return err
+		}
+		return dao.SaveAll(tx, annotators)
+	})
+}
+
+// RecomputeDatasetQuality 重新计算数据集中所有有标注的图片，用于修改合并参数之后，返回计算的图片数
+func RecomputeDatasetQuality(ctx context.Context, datasetID uint) (int, error) {
+	type annotatedImage struct {
+		ImageID uint `gorm:"column:image_id"`
+	}
+	images, err := dao.Query[annotatedImage](ctx, `SELECT DISTINCT "image_id" FROM "annotations" WHERE "dataset_id" = ? AND "deleted_at" IS NULL ORDER BY "image_id"`, datasetID)
+	if err != nil {
+		return 0, err
+	}
+
+	// 已经没有标注的图片直接删除记录
+	stale := `dataset_id = ? AND image_id NOT IN (SELECT image_id FROM annotations WHERE dataset_id = ? AND deleted_at IS NULL)`
+	_, err = dao.DeleteWhere[AnnotatorQuality](ctx, stale, datasetID, datasetID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = dao.DeleteWhere[ImageQuality](ctx, stale, datasetID, datasetID)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, img := range images {
+		err = RefreshImageQuality(ctx, img.ImageID)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(images), nil
+}
+
+// evaluateImage 比较图片上各标注员与合并结果，每个标注员取最新的一次标注，少于两人时返回 nil
+func evaluateImage(annotations []Annotation, opts consensusOptions) (*ImageQuality, []AnnotatorQuality) {
+	latest := latestByUser(annotations)
+	if len(latest) < 2 {
+		return nil, nil
+	}
+
+	marks := make([][]dto.AnnotationResult, len(latest))
+	inputs := make([]consensusInput, len(latest))
+	for i, anno := range latest {
+		marks[i] = anno.Content.Data()
+		inputs[i] = consensusInput{marks: marks[i], weight: 1}
+	}
+	reference := consensusMarks(inputs, opts)
+
+	image := &ImageQuality{Annotators: len(latest), Objects: len(reference)}
+	annotators := make([]AnnotatorQuality, len(latest))
+	for i, anno := range latest {
+		counts := compareMarks(marks[i], reference, opts.iou)
+		annotators[i] = AnnotatorQuality{UserID: anno.UserID, AnnotationID: anno.ID, QualityCounts: counts}
+		image.add(counts)
+	}
+	image.AlphaDisagreement, image.AlphaPresent, image.AlphaAbsent = classCoincidences(marks)
+	image.MeanIoU = image.meanIoU()
+	image.Precision = image.precision()
+	image.Recall = image.recall()
+	image.Alpha = krippendorffAlpha(image.AlphaDisagreement, image.AlphaPresent, image.AlphaAbsent)
+	return image, annotators
+}
+
+// compareMarks 按同标签的 IoU 将标注员的形状与合并结果做匈牙利匹配，图片类别按标签比较
+func compareMarks(marks []dto.AnnotationResult, reference []dto.AnnotationResult, threshold float64) QualityCounts {
+	var counts QualityCounts
+	for _, shape := range consensusShapes {
+		var own, ref []dto.AnnotationResult
+		for _, m := range marks {
+			if markType(&m) == shape {
+				own = append(own, m)
+			}
+		}
+		for _, m := range reference {
+			if markType(&m) == shape {
+				ref = append(ref, m)
+			}
+		}
+
+		scores := make([][]float64, len(own))
+		for i := range own {
+			scores[i] = make([]float64, len(ref))
+			e := markExtent(&own[i])
+			for j := range ref {
+				if own[i].ID != ref[j].ID {
+					continue
+				}
+				if iou := e.iou(markExtent(&ref[j])); iou >= threshold {
+					scores[i][j] = iou
+				}
+			}
+		}
+		var matched int64
+		for i, j := range hungarian(scores) {
+			if j >= 0 && scores[i][j] > 0 {
+				matched++
+				counts.IoUSum += scores[i][j]
+			}
+		}
+		counts.Matched += matched
+		counts.IoUCount += matched
+		counts.FalsePositives += int64(len(own)) - matched
+		counts.FalseNegatives += int64(len(ref)) - matched
+	}
+
+	own := classSet(marks)
+	ref := classSet(reference)
+	for id := range own {
+		if ref[id] {
+			counts.Matched++
+		} else {
+			counts.FalsePositives++
+		}
+	}
+	for id := range ref {
+		if !own[id] {
+			counts.FalseNegatives++
+		}
+	}
+	return counts
+}
+
+// classSet 标注中的图片类别
+func classSet(marks []dto.AnnotationResult) map[uint]bool {
+	set := make(map[uint]bool)
+	for _, m := range marks {
+		if markType(&m) == dto.ShapeClass {
+			set[m.ID] = true
+		}
+	}
+	return set
+}
+
+// classCoincidences 计算图片类别的 alpha 中间量
+// 每个被至少一个标注员选中的类别为一个单元，各标注员的取值为是否选中
+// 返回不一致的配对数，以及选中和未选中的取值数
+func classCoincidences(marks [][]dto.AnnotationResult) (disagreement, present, absent float64) {
+	m := float64(len(marks))
+	if m < 2 {
+		return 0, 0, 0
+	}
+	counts := make(map[uint]float64)
+	for _, annotator := range marks {
+		for id := range classSet(annotator) {
+			counts[id]++
+		}
+	}
+	for _, n1 := range counts {
+		n0 := m - n1
+		disagreement += n0 * n1 / (m - 1)
+		present += n1
+		absent += n0
+	}
+	return disagreement, present, absent
+}
+
+// krippendorffAlpha 名义尺度的 Krippendorff's alpha，没有取值时返回 nil，取值完全一致时为 1
+func krippendorffAlpha(disagreement, present, absent float64) *float64 {
+	n := present + absent
+	if n == 0 {
+		return nil
+	}
+	alpha := 1.0
+	if present > 0 && absent > 0 {
+		alpha = 1 - (n-1)*disagreement/(present*absent)
+	}
+	return &alpha
+}
+
+func ratio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
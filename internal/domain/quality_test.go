@@ -0,0 +1,94 @@
+package domain
+
+import (
+	"sapphire-server/internal/data/dto"
+	"testing"
+)
+
+func TestKrippendorffAlpha(t *testing.T) {
+	tests := []struct {
+		name                          string
+		disagreement, present, absent float64
+		// want 为 nil 时期望没有结果
+		want *float64
+	}{
+		{name: "no values", want: nil},
+		{name: "all present", present: 6, want: ptr(1.0)},
+		{name: "all absent", absent: 6, want: ptr(1.0)},
+		// Krippendorff, Computing Krippendorff's Alpha-Reliability (2011), 两个观察者十个单元的二值数据
+		// A: 0 1 0 0 0 0 0 0 1 0
+		// B: 1 1 1 0 0 1 0 0 0 0
+		// o01 = 4, n0 = 14, n1 = 6, alpha = 1 - 19*4/(14*6) ≈ 0.095
+		{name: "binary textbook", disagreement: 4, present: 6, absent: 14, want: ptr(1 - 19.0*4/(14*6))},
+		// 三个观察者，两个单元各有一人不同：o01 = 2*(1*2/2) = 2, n0 = 2, n1 = 4
+		{name: "three observers", disagreement: 2, present: 4, absent: 2, want: ptr(1 - 5.0*2/(4*2))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := krippendorffAlpha(tt.disagreement, tt.present, tt.absent)
+			switch {
+			case tt.want == nil && got != nil:
+				t.Errorf("krippendorffAlpha() = %v, want nil", *got)
+			case tt.want != nil && got == nil:
+				t.Errorf("krippendorffAlpha() = nil, want %v", *tt.want)
+			case tt.want != nil && !approx(*got, *tt.want):
+				t.Errorf("krippendorffAlpha() = %v, want %v", *got, *tt.want)
+			}
+		})
+	}
+
+	// 教科书的结果保留三位小数
+	got := krippendorffAlpha(4, 6, 14)
+	if *got < 0.0945 || *got >= 0.0955 {
+		t.Errorf("binary textbook alpha = %v, want 0.095", *got)
+	}
+}
+
+func TestClassCoincidences(t *testing.T) {
+	class := func(ids ...uint) []dto.AnnotationResult {
+		marks := []dto.AnnotationResult{{Type: dto.ShapeBBox, ID: 99, Width: 1, Height: 1}}
+		for _, id := range ids {
+			marks = append(marks, dto.AnnotationResult{Type: dto.ShapeClass, ID: id})
+		}
+		return marks
+	}
+	tests := []struct {
+		name                          string
+		marks                         [][]dto.AnnotationResult
+		disagreement, present, absent float64
+	}{
+		{name: "single annotator", marks: [][]dto.AnnotationResult{class(1)}},
+		{name: "agree", marks: [][]dto.AnnotationResult{class(1, 2), class(2, 1)}, present: 4},
+		{
+			// 上面教科书的例子，每个单元作为一个类别；两人都未选中的单元不出现在标注里，不计入
+			name: "binary textbook",
+			marks: [][]dto.AnnotationResult{
+				class(2, 9),
+				class(1, 2, 3, 6),
+			},
+			disagreement: 4, present: 6, absent: 4,
+		},
+		{
+			name:         "three annotators",
+			marks:        [][]dto.AnnotationResult{class(1, 2), class(1), class(2)},
+			disagreement: 2, present: 4, absent: 2,
+		},
+		{
+			name:         "repeated class counts once",
+			marks:        [][]dto.AnnotationResult{class(1, 1), class()},
+			disagreement: 1, present: 1, absent: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, p, a := classCoincidences(tt.marks)
+			if !approx(d, tt.disagreement) || !approx(p, tt.present) || !approx(a, tt.absent) {
+				t.Errorf("classCoincidences() = (%v, %v, %v), want (%v, %v, %v)", d, p, a, tt.disagreement, tt.present, tt.absent)
+			}
+		})
+	}
+}
+
+func ptr(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,328 @@
+package domain
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrResumableNotFound       = errors.New("upload not found")
+	ErrResumableOffsetMismatch = errors.New("upload offset mismatch")
+	ErrResumableTooLarge       = errors.New("upload exceeds declared length")
+)
+
+// ResumableUpload 可续传的上传，状态保存在本地磁盘的 .info 文件中，数据追加到 .bin 文件
+type ResumableUpload struct {
+	ID        string `json:"id"`
+	DatasetID uint   `json:"datasetId"`
+	UserID    uint   `json:"userId"`
+	// Length 上传的总大小
+	Length int64 `json:"length"`
+	// Offset 已经收到的字节数
+	Offset int64 `json:"offset"`
+	// Metadata 客户端通过 Upload-Metadata 传入的键值对
+	Metadata  map[string]string `json:"metadata"`
+	CreatedAt time.Time         `json:"createdAt"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+	// JobID 上传完成后创建的上传任务
+	JobID uint `json:"jobId,omitempty"`
+}
+
+// FileName 客户端传入的文件名
+func (u *ResumableUpload) FileName() string {
+	if name := u.Metadata["filename"]; name != "" {
+		return name
+	}
+	return u.ID + ".zip"
+}
+
+// Completed 是否已经收到全部数据
+func (u *ResumableUpload) Completed() bool {
+	return u.Offset == u.Length
+}
+
+// ResumableStore 可续传上传的本地存储，只在接收上传的节点上可用
+type ResumableStore struct {
+	Dir string
+	// TTL 上传在最后一次写入之后保留的时间
+	TTL time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// DefaultResumableStore 服务使用的可续传上传存储，由 InitResumableStore 初始化
+var DefaultResumableStore *ResumableStore
+
+// InitResumableStore 初始化 DefaultResumableStore
+func InitResumableStore(dir string, ttl time.Duration) error {
+	store, err := NewResumableStore(dir, ttl)
+	if err != nil {
+		return err
+	}
+	DefaultResumableStore = store
+	return nil
+}
+
+// NewResumableStore 创建存储目录
+func NewResumableStore(dir string, ttl time.Duration) (*ResumableStore, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &ResumableStore{Dir: dir, TTL: ttl, locks: make(map[string]*sync.Mutex)}, nil
+}
+
+// ParseUploadMetadata 解析 tus 的 Upload-Metadata，格式为逗号分隔的 "key base64(value)"
+func ParseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, fmt.Errorf("invalid metadata %q", pair)
+		}
+		var value []byte
+		if len(parts) == 2 {
+			var err error
+			value, err = base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid metadata value for %s", parts[0])
+			}
+		}
+		metadata[parts[0]] = string(value)
+	}
+	return metadata, nil
+}
+
+// Create 创建一个新的上传
+func (s *ResumableStore) Create(datasetID uint, userID uint, length int64, metadata map[string]string) (*ResumableUpload, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	upload := &ResumableUpload{
+		ID:        hex.EncodeToString(buf),
+		DatasetID: datasetID,
+		UserID:    userID,
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.TTL),
+	}
+
+	f, err := os.OpenFile(s.dataPath(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	err = s.saveInfo(upload)
+	if err != nil {
+		os.Remove(s.dataPath(upload.ID))
+		return nil, err
+	}
+	return upload, nil
+}
+
+// Get 读取上传的状态，不存在或已过期时返回 ErrResumableNotFound
+func (s *ResumableStore) Get(id string) (*ResumableUpload, error) {
+	if !isUploadID(id) {
+		return nil, ErrResumableNotFound
+	}
+	data, err := os.ReadFile(s.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrResumableNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var upload ResumableUpload
+	err = json.Unmarshal(data, &upload)
+	if err != nil {
+		return nil, err
+	}
+	if upload.JobID == 0 && time.Now().After(upload.ExpiresAt) {
+		return nil, ErrResumableNotFound
+	}
+	return &upload, nil
+}
+
+// WriteChunk 从 offset 处追加数据，offset 必须等于已收到的字节数
+// 连接中断时保留已经写入的部分，客户端通过 HEAD 查询 offset 后继续
+func (s *ResumableStore) WriteChunk(id string, offset int64, r io.Reader) (*ResumableUpload, error) {
+	lock := s.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Offset != offset {
+		return upload, ErrResumableOffsetMismatch
+	}
+	if upload.Completed() {
+		return upload, nil
+	}
+
+	f, err := os.OpenFile(s.dataPath(id), os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	// 以 info 中记录的 offset 为准，丢弃上次中断时可能多写的数据
+	err = f.Truncate(upload.Offset)
+	if err == nil {
+		_, err = f.Seek(upload.Offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// 多读一个字节用于判断是否超过声明的长度
+	n, copyErr := io.Copy(f, io.LimitReader(r, upload.Length-upload.Offset+1))
+	closeErr := f.Close()
+	if n > upload.Length-upload.Offset {
+		return upload, ErrResumableTooLarge
+	}
+	if closeErr != nil {
+		return nil, closeErr
+	}
+
+	upload.Offset += n
+	upload.ExpiresAt = time.Now().Add(s.TTL)
+	err = s.saveInfo(upload)
+	if err != nil {
+		return nil, err
+	}
+	if copyErr != nil {
+		slog.Info("resumable upload interrupted", "id", id, "offset", upload.Offset, "err", copyErr)
+	}
+	return upload, nil
+}
+
+// Complete 将上传完成的文件移动到上传任务的工作目录
+func (s *ResumableStore) Complete(upload *ResumableUpload, ws *UploadWorkspace) error {
+	lock := s.lock(upload.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return os.Rename(s.dataPath(upload.ID), ws.ArchivePath())
+}
+
+// SetJob 记录上传完成后创建的任务
+func (s *ResumableStore) SetJob(upload *ResumableUpload, jobID uint) error {
+	upload.JobID = jobID
+	upload.ExpiresAt = time.Now().Add(s.TTL)
+	return s.saveInfo(upload)
+}
+
+// Terminate 删除上传
+func (s *ResumableStore) Terminate(id string) error {
+	lock := s.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	s.remove(id)
+	return nil
+}
+
+// ExpireStale 删除过期的上传，返回删除的数量
+func (s *ResumableStore) ExpireStale(now time.Time) (int, error) {
+	infos, err := filepath.Glob(filepath.Join(s.Dir, "*.info"))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, info := range infos {
+		id := strings.TrimSuffix(filepath.Base(info), ".info")
+		data, err := os.ReadFile(info)
+		if err != nil {
+			continue
+		}
+		var upload ResumableUpload
+		err = json.Unmarshal(data, &upload)
+		// 无法解析的 info 文件也一并清理
+		if err == nil && now.Before(upload.ExpiresAt) {
+			continue
+		}
+		lock := s.lock(id)
+		if !lock.TryLock() {
+			continue
+		}
+		s.remove(id)
+		lock.Unlock()
+		count++
+	}
+	return count, nil
+}
+
+func (s *ResumableStore) remove(id string) {
+	for _, p := range []string{s.dataPath(id), s.infoPath(id)} {
+		err := os.Remove(p)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("remove resumable upload failed", "path", p, "err", err)
+		}
+	}
+	s.mu.Lock()
+	delete(s.locks, id)
+	s.mu.Unlock()
+}
+
+// lock 每个上传一把锁，避免同一个上传的并发写入
+func (s *ResumableStore) lock(id string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[id] = l
+	}
+	return l
+}
+
+// saveInfo 先写临时文件再重命名，避免中断时留下不完整的 info
+func (s *ResumableStore) saveInfo(upload *ResumableUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	tmp := s.infoPath(upload.ID) + ".tmp"
+	err = os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.infoPath(upload.ID))
+}
+
+func (s *ResumableStore) dataPath(id string) string {
+	return filepath.Join(s.Dir, id+".bin")
+}
+
+func (s *ResumableStore) infoPath(id string) string {
+	return filepath.Join(s.Dir, id+".info")
+}
+
+// isUploadID 上传 ID 为 32 位十六进制，拒绝其他输入以免拼出其他路径
+func isUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
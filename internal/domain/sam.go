@@ -1,25 +1,66 @@
 package domain
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"gorm.io/gorm"
 	"sapphire-server/internal/dao"
+	"sapphire-server/internal/data/dto"
+	"time"
 )
 
+// 模型状态
+const (
+	SamStatusActive = "active"
+	// SamStatusDeprecated 不再作为默认模型，也不能被数据集选用，已选用的数据集继续使用
+	SamStatusDeprecated = "deprecated"
+)
+
+var (
+	ErrSamNotFound   = errors.New("model not found")
+	ErrSamExists     = errors.New("model version already exists")
+	ErrSamDeprecated = errors.New("model is deprecated")
+	// ErrSamInUse 仍有数据集选用该模型
+	ErrSamInUse = errors.New("model is used by datasets")
+)
+
+// Sam 注册的 SAM/ONNX 嵌入模型，同名模型的不同版本分别注册
 type Sam struct {
 	gorm.Model
-	Onnxname string `gorm:"column:onnxname"`
+	// Onnxname 旧版本只记录模型文件名，保留用于兼容
+	Onnxname string `gorm:"column:onnxname" json:"onnxname"`
+	Name     string `gorm:"column:name" json:"name"`
+	Version  string `gorm:"column:version" json:"version"`
+	// Checksum 模型文件的 sha256，worker 下载后校验
+	Checksum string `gorm:"column:checksum" json:"checksum"`
+	// InputSize 模型输入图片的边长
+	InputSize  int    `gorm:"column:input_size" json:"inputSize"`
+	StorageURL string `gorm:"column:storage_url" json:"storageUrl"`
+	Status     string `gorm:"column:status" json:"status"`
+	// DeprecatedAt 标记为废弃的时间
+	DeprecatedAt *time.Time `gorm:"column:deprecated_at" json:"deprecatedAt"`
+}
+
+// samPageOptions 模型列表允许的排序和过滤字段
+var samPageOptions = dao.PageOptions{
+	SortFields: map[string]string{
+		"createdAt": "created_at",
+		"name":      "name",
+		"version":   "version",
+	},
+	FilterFields: map[string]string{
+		"name":   "name",
+		"status": "status",
+	},
 }
 
 func NewSam() *Sam {
 	return &Sam{}
 }
 
-//func (f *Sam) registerSam(onnxname string) {
-//	existedSAM := f.loadSAM(map[string]interface{}{"name": register.Name})
-//}
-
 func (f *Sam) loadSAM(param map[string]interface{}) *Sam {
-	sam, err := dao.First[Sam]("onnx_name = ?", param["onnx_name"])
+	sam, err := dao.First[Sam](context.Background(), "onnxname = ?", param["onnxname"])
 	if err != nil {
 		return nil
 	}
@@ -27,9 +68,120 @@ func (f *Sam) loadSAM(param map[string]interface{}) *Sam {
 }
 
 func (f *Sam) loadSAMByID(id int) *Sam {
-	sam, err := dao.First[Sam]("id = ?", id)
+	sam, err := dao.First[Sam](context.Background(), "id = ?", id)
 	if err != nil {
 		return nil
 	}
 	return sam
 }
+
+// RegisterSam 注册模型，同名同版本的模型已存在时返回 ErrSamExists
+func RegisterSam(ctx context.Context, req dto.NewSam) (*Sam, error) {
+	existed, err := dao.First[Sam](ctx, "name = ? AND version = ?", req.Name, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	if existed != nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrSamExists, req.Name, req.Version)
+	}
+
+	sam := &Sam{
+		Onnxname:   req.Name,
+		Name:       req.Name,
+		Version:    req.Version,
+		Checksum:   req.Checksum,
+		InputSize:  req.InputSize,
+		StorageURL: req.StorageURL,
+		Status:     SamStatusActive,
+	}
+	err = dao.Save(ctx, sam)
+	if err != nil {
+		return nil, err
+	}
+	return sam, nil
+}
+
+// GetSam 读取模型，不存在时返回 nil
+func GetSam(ctx context.Context, id uint) (*Sam, error) {
+	return dao.First[Sam](ctx, "id = ?", id)
+}
+
+// ListSamPage 分页列出模型，包括已废弃的模型
+func ListSamPage(ctx context.Context, req dao.PageRequest) (*dao.Page[Sam], error) {
+	return dao.FindPage[Sam](ctx, req, samPageOptions)
+}
+
+// DeprecateSam 将模型标记为废弃，重复废弃时保留第一次的时间
+func DeprecateSam(ctx context.Context, id uint) (*Sam, error) {
+	_, err := dao.UpdateWhere[Sam](ctx, map[string]interface{}{
+		"status":        SamStatusDeprecated,
+		"deprecated_at": time.Now(),
+	}, "id = ? AND status <> ?", id, SamStatusDeprecated)
+	if err != nil {
+		return nil, err
+	}
+	sam, err := GetSam(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sam == nil {
+		return nil, ErrSamNotFound
+	}
+	return sam, nil
+}
+
+// DeleteSam 删除模型，仍有数据集选用时返回 ErrSamInUse
+// 排队中的任务一起取消，图片在下一轮使用默认模型重新创建任务
+func DeleteSam(ctx context.Context, id uint) error {
+	return dao.WithTx(ctx, func(tx context.Context) error {
+		sam, err := GetSam(tx, id)
+		if err != nil {
+			return err
+		}
+		if sam == nil {
+			return ErrSamNotFound
+		}
+		datasets, err := dao.FindAll[Dataset](tx, "sam_id = ?", id)
+		if err != nil {
+			return err
+		}
+		if len(datasets) > 0 {
+			return fmt.Errorf("%w: %d datasets", ErrSamInUse, len(datasets))
+		}
+
+		_, err = dao.UpdateWhere[Task](tx, map[string]interface{}{
+			"status":      FAILED,
+			"error":       "model deleted",
+			"finished_at": time.Now(),
+		}, "onnx_id = ? AND status = ?", id, READY)
+		if err != nil {
+			return err
+		}
+		_, err = dao.DeleteWhere[Sam](tx, "id = ?", id)
+		return err
+	})
+}
+
+// ActiveSam 读取可以被数据集选用的模型
+func ActiveSam(ctx context.Context, id uint) (*Sam, error) {
+	sam, err := GetSam(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sam == nil {
+		return nil, ErrSamNotFound
+	}
+	if sam.Status == SamStatusDeprecated {
+		return nil, ErrSamDeprecated
+	}
+	return sam, nil
+}
+
+// LatestSamID 最新注册且没有废弃的模型，没有模型时返回 0
+func LatestSamID(ctx context.Context) (uint, error) {
+	sams, err := dao.Query[Sam](ctx, "SELECT * FROM sams WHERE deleted_at IS NULL AND status <> ? ORDER BY id DESC LIMIT 1", SamStatusDeprecated)
+	if err != nil || len(sams) == 0 {
+		return 0, err
+	}
+	return sams[0].ID, nil
+}
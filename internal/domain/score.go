@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"context"
 	"gorm.io/gorm"
 	"sapphire-server/internal/dao"
 )
@@ -26,7 +27,7 @@ func NewScoreDomain() *Score {
 
 // CreateScore 创建一个新的 Score 记录
 func (s *Score) CreateScore(score *Score) error {
-	err := dao.Save(score)
+	err := dao.Save(context.Background(), score)
 	if err != nil {
 		return err
 	}
@@ -37,7 +38,7 @@ func (s *Score) CreateScore(score *Score) error {
 func (s *Score) ListScoreRecordsInDays(userID uint, days int) ([]ScoreResult, error) {
 	var err error
 	sql := "SELECT * FROM scores WHERE user_id = ? AND created_at >= now() - make_interval(days := ?)"
-	scores, err := dao.Query[Score](sql, userID, days)
+	scores, err := dao.Query[Score](context.Background(), sql, userID, days)
 	if err != nil {
 		return nil, err
 	}
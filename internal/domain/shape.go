@@ -0,0 +1,340 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sapphire-server/internal/data/dto"
+)
+
+var ErrInvalidMark = errors.New("invalid mark")
+
+// 单个标注的大小限制
+const (
+	maxMarksPerAnnotation = 1000
+	maxPolygonPoints      = 10000
+	maxKeypoints          = 256
+	maxAttributeLength    = 1024
+)
+
+// boundsTolerance 坐标允许超出图片边界的像素数
+const boundsTolerance = 1
+
+// extent 外接矩形
+type extent struct {
+	X0, Y0, X1, Y1 float64
+}
+
+func (e extent) area() float64 {
+	return math.Max(0, e.X1-e.X0) * math.Max(0, e.Y1-e.Y0)
+}
+
+func (e extent) iou(o extent) float64 {
+	inter := extent{X0: math.Max(e.X0, o.X0), Y0: math.Max(e.Y0, o.Y0), X1: math.Min(e.X1, o.X1), Y1: math.Min(e.Y1, o.Y1)}
+	if inter.X1 <= inter.X0 || inter.Y1 <= inter.Y0 {
+		return 0
+	}
+	overlap := inter.area()
+	return overlap / (e.area() + o.area() - overlap)
+}
+
+// markType 旧数据没有类型，按矩形框处理
+func markType(m *dto.AnnotationResult) string {
+	if m.Type == "" {
+		return dto.ShapeBBox
+	}
+	return m.Type
+}
+
+// normalizeMarks 校验标注，补齐类型和外接矩形
+// categories 为数据集的标签，图片宽高未知时为 0，此时不检查坐标范围
+func normalizeMarks(marks []dto.AnnotationResult, categories *categorySet, width int, height int) error {
+	if len(marks) > maxMarksPerAnnotation {
+		return fmt.Errorf("%w: at most %d marks", ErrInvalidMark, maxMarksPerAnnotation)
+	}
+	for i := range marks {
+		err := normalizeMark(&marks[i], categories, width, height)
+		if err != nil {
+			return fmt.Errorf("%w: mark %d: %v", ErrInvalidMark, i, err)
+		}
+	}
+	return nil
+}
+
+func normalizeMark(m *dto.AnnotationResult, categories *categorySet, width int, height int) error {
+	m.Type = markType(m)
+	label := categories.label(m.ID)
+	if label == nil {
+		return fmt.Errorf("label %d does not belong to dataset", m.ID)
+	}
+	if !label.AllowShape(m.Type) {
+		return fmt.Errorf("label %s does not allow %s", label.Name, m.Type)
+	}
+	err := validateAttributes(m.Attributes, label)
+	if err != nil {
+		return err
+	}
+
+	switch m.Type {
+	case dto.ShapeBBox, dto.ShapeRotatedBBox:
+		if m.Width <= 0 || m.Height <= 0 {
+			return errors.New("width and height should be positive")
+		}
+		if m.Type == dto.ShapeBBox {
+			m.Angle = 0
+		}
+		// 旋转矩形框按四个角的外接矩形检查
+		if !insideImage(markExtent(m), width, height) {
+			return errors.New("box outside image")
+		}
+		m.Points, m.Mask, m.Keypoints, m.Skeleton = nil, nil, nil, nil
+	case dto.ShapePolygon:
+		if len(m.Points) < 3 || len(m.Points) > maxPolygonPoints {
+			return fmt.Errorf("polygon should have 3 to %d points", maxPolygonPoints)
+		}
+		for _, p := range m.Points {
+			if len(p) != 2 {
+				return errors.New("point should be [x, y]")
+			}
+		}
+		e := pointsExtent(m.Points)
+		if !insideImage(e, width, height) {
+			return errors.New("polygon outside image")
+		}
+		setExtent(m, e)
+		m.Angle, m.Mask, m.Keypoints, m.Skeleton = 0, nil, nil, nil
+	case dto.ShapeMask:
+		if m.Mask == nil {
+			return errors.New("mask is required")
+		}
+		e, err := validateMask(m.Mask, width, height)
+		if err != nil {
+			return err
+		}
+		setExtent(m, e)
+		m.Angle, m.Points, m.Keypoints, m.Skeleton = 0, nil, nil, nil
+	case dto.ShapeKeypoints:
+		e, err := validateKeypoints(m.Keypoints, m.Skeleton)
+		if err != nil {
+			return err
+		}
+		if !insideImage(e, width, height) {
+			return errors.New("keypoints outside image")
+		}
+		setExtent(m, e)
+		m.Angle, m.Points, m.Mask = 0, nil, nil
+	case dto.ShapeClass:
+		*m = dto.AnnotationResult{Type: dto.ShapeClass, ID: m.ID, Attributes: m.Attributes}
+	default:
+		return fmt.Errorf("unknown type %s", m.Type)
+	}
+	return nil
+}
+
+// validateAttributes 按标签的定义检查属性值，不允许未定义的属性
+func validateAttributes(values map[string]interface{}, label *Label) error {
+	defs := label.Attributes.Data()
+	known := make(map[string]bool, len(defs))
+	for _, def := range defs {
+		known[def.Name] = true
+		value, ok := values[def.Name]
+		if !ok || value == nil {
+			if def.Required {
+				return fmt.Errorf("attribute %s is required", def.Name)
+			}
+			delete(values, def.Name)
+			continue
+		}
+		switch def.Type {
+		case dto.AttributeBool:
+			if _, ok := value.(bool); !ok {
+				return fmt.Errorf("attribute %s should be bool", def.Name)
+			}
+		case dto.AttributeEnum:
+			s, ok := value.(string)
+			if !ok || !containsString(def.Options, s) {
+				return fmt.Errorf("attribute %s should be one of %v", def.Name, def.Options)
+			}
+		case dto.AttributeText:
+			s, ok := value.(string)
+			if !ok || len(s) > maxAttributeLength {
+				return fmt.Errorf("attribute %s should be text up to %d bytes", def.Name, maxAttributeLength)
+			}
+		}
+	}
+	for name := range values {
+		if !known[name] {
+			return fmt.Errorf("unknown attribute %s for label %s", name, label.Name)
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func insideImage(e extent, width int, height int) bool {
+	if width <= 0 || height <= 0 {
+		return true
+	}
+	return e.X0 >= -boundsTolerance && e.Y0 >= -boundsTolerance &&
+		e.X1 <= float64(width)+boundsTolerance && e.Y1 <= float64(height)+boundsTolerance
+}
+
+// validateMask 检查游程编码，返回掩码的外接矩形
+func validateMask(mask *dto.MaskRLE, width int, height int) (extent, error) {
+	h, w := mask.Size[0], mask.Size[1]
+	if h <= 0 || w <= 0 {
+		return extent{}, errors.New("mask size should be positive")
+	}
+	if width > 0 && height > 0 && (h != height || w != width) {
+		return extent{}, fmt.Errorf("mask size %dx%d does not match image %dx%d", h, w, height, width)
+	}
+	total := 0
+	for _, c := range mask.Counts {
+		if c < 0 {
+			return extent{}, errors.New("mask counts should not be negative")
+		}
+		total += c
+	}
+	if total != h*w {
+		return extent{}, fmt.Errorf("mask counts sum to %d, expected %d", total, h*w)
+	}
+	e, ok := maskExtent(mask)
+	if !ok {
+		return extent{}, errors.New("mask is empty")
+	}
+	return e, nil
+}
+
+// validateKeypoints 检查关键点和骨架，返回已标注关键点的外接矩形
+func validateKeypoints(keypoints []dto.Keypoint, skeleton [][2]int) (extent, error) {
+	if len(keypoints) == 0 || len(keypoints) > maxKeypoints {
+		return extent{}, fmt.Errorf("keypoints should have 1 to %d points", maxKeypoints)
+	}
+	var points [][]float64
+	for _, kp := range keypoints {
+		if kp.Visibility < 0 || kp.Visibility > 2 {
+			return extent{}, errors.New("keypoint visibility should be 0, 1 or 2")
+		}
+		if kp.Visibility > 0 {
+			points = append(points, []float64{kp.X, kp.Y})
+		}
+	}
+	if len(points) == 0 {
+		return extent{}, errors.New("at least one keypoint should be labeled")
+	}
+	for _, edge := range skeleton {
+		if edge[0] < 0 || edge[0] >= len(keypoints) || edge[1] < 0 || edge[1] >= len(keypoints) || edge[0] == edge[1] {
+			return extent{}, fmt.Errorf("invalid skeleton edge %v", edge)
+		}
+	}
+	return pointsExtent(points), nil
+}
+
+func setExtent(m *dto.AnnotationResult, e extent) {
+	m.CenterX = (e.X0 + e.X1) / 2
+	m.CenterY = (e.Y0 + e.Y1) / 2
+	m.Width = e.X1 - e.X0
+	m.Height = e.Y1 - e.Y0
+}
+
+// markExtent 标注的外接矩形，图片类别没有外接矩形
+func markExtent(m *dto.AnnotationResult) extent {
+	if markType(m) == dto.ShapeRotatedBBox {
+		return pointsExtent(rboxCorners(m))
+	}
+	return extent{
+		X0: m.CenterX - m.Width/2,
+		Y0: m.CenterY - m.Height/2,
+		X1: m.CenterX + m.Width/2,
+		Y1: m.CenterY + m.Height/2,
+	}
+}
+
+// markArea 标注的面积，多边形和掩码按实际形状计算
+func markArea(m *dto.AnnotationResult) float64 {
+	switch markType(m) {
+	case dto.ShapePolygon:
+		return polygonArea(m.Points)
+	case dto.ShapeMask:
+		return float64(maskArea(m.Mask))
+	case dto.ShapeClass:
+		return 0
+	default:
+		return m.Width * m.Height
+	}
+}
+
+// rboxCorners 旋转矩形框的四个角，从左上角开始顺时针
+func rboxCorners(m *dto.AnnotationResult) [][]float64 {
+	sin, cos := math.Sincos(m.Angle * math.Pi / 180)
+	offsets := [4][2]float64{{-1, -1}, {1, -1}, {1, 1}, {-1, 1}}
+	corners := make([][]float64, 4)
+	for i, o := range offsets {
+		dx, dy := o[0]*m.Width/2, o[1]*m.Height/2
+		corners[i] = []float64{m.CenterX + dx*cos - dy*sin, m.CenterY + dx*sin + dy*cos}
+	}
+	return corners
+}
+
+func pointsExtent(points [][]float64) extent {
+	e := extent{X0: math.Inf(1), Y0: math.Inf(1), X1: math.Inf(-1), Y1: math.Inf(-1)}
+	for _, p := range points {
+		e.X0, e.X1 = math.Min(e.X0, p[0]), math.Max(e.X1, p[0])
+		e.Y0, e.Y1 = math.Min(e.Y0, p[1]), math.Max(e.Y1, p[1])
+	}
+	return e
+}
+
+// polygonArea 鞋带公式
+func polygonArea(points [][]float64) float64 {
+	var sum float64
+	for i := range points {
+		j := (i + 1) % len(points)
+		sum += points[i][0]*points[j][1] - points[j][0]*points[i][1]
+	}
+	return math.Abs(sum) / 2
+}
+
+// maskExtent 掩码前景像素的外接矩形，没有前景时返回 false
+func maskExtent(mask *dto.MaskRLE) (extent, bool) {
+	h := mask.Size[0]
+	e := extent{X0: math.Inf(1), Y0: math.Inf(1), X1: math.Inf(-1), Y1: math.Inf(-1)}
+	found := false
+	pos := 0
+	for i, c := range mask.Counts {
+		// 奇数下标为前景的游程
+		if i%2 == 1 && c > 0 {
+			found = true
+			first, last := pos, pos+c-1
+			x0, x1 := first/h, last/h
+			y0, y1 := first%h, last%h
+			if x0 != x1 {
+				// 跨列时覆盖整列的高度
+				y0, y1 = 0, h-1
+			}
+			e.X0, e.X1 = math.Min(e.X0, float64(x0)), math.Max(e.X1, float64(x1+1))
+			e.Y0, e.Y1 = math.Min(e.Y0, float64(y0)), math.Max(e.Y1, float64(y1+1))
+		}
+		pos += c
+	}
+	return e, found
+}
+
+func maskArea(mask *dto.MaskRLE) int {
+	if mask == nil {
+		return 0
+	}
+	area := 0
+	for i := 1; i < len(mask.Counts); i += 2 {
+		area += mask.Counts[i]
+	}
+	return area
+}
@@ -1,10 +1,15 @@
 package domain
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"gorm.io/gorm"
 	"log/slog"
 	"sapphire-server/internal/dao"
 	"sapphire-server/internal/data/dto"
+	"time"
 )
 
 type Task struct {
@@ -14,6 +19,29 @@ type Task struct {
 	EmbeddingURL string `gorm:"column:embedding_url"`
 	Status       int    `gorm:"column:status"`
 	OnnxId       int    `gorm:"column:onnx_id"`
+	// ImageID 需要嵌入的图片，手动创建的任务为 0
+	ImageID uint `gorm:"column:image_id"`
+	// CampaignID 重新嵌入活动创建的任务所属的活动
+	CampaignID uint `gorm:"column:campaign_id"`
+	// WorkerID 当前认领任务的 worker
+	WorkerID string `gorm:"column:worker_id"`
+	// LeaseToken 认领时生成，worker 汇报时需要带上，任务重新排队后旧的 token 失效
+	LeaseToken     string     `gorm:"column:lease_token" json:"-"`
+	LeaseExpiresAt *time.Time `gorm:"column:lease_expires_at"`
+	HeartbeatAt    *time.Time `gorm:"column:heartbeat_at"`
+	// Attempts 已经被认领的次数
+	Attempts    int        `gorm:"column:attempts"`
+	MaxAttempts int        `gorm:"column:max_attempts"`
+	Error       string     `gorm:"column:error"`
+	FinishedAt  *time.Time `gorm:"column:finished_at"`
+}
+
+// TaskLease worker 认领到的任务
+type TaskLease struct {
+	*Task
+	LeaseToken string `json:"leaseToken"`
+	// Model 任务使用的模型，worker 按版本加载，手动创建的任务可能为空
+	Model *Sam `json:"model"`
 }
 
 const (
@@ -23,6 +51,48 @@ const (
 	FAILED  = -1
 )
 
+var (
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrTaskLeaseLost 租约已经过期并重新排队，或者任务已经结束
+	ErrTaskLeaseLost = errors.New("task lease lost")
+)
+
+// TaskQueueOptions 嵌入任务队列的参数
+type TaskQueueOptions struct {
+	// LeaseTTL worker 没有指定时的租约时长
+	LeaseTTL time.Duration
+	// MaxLeaseTTL worker 可以申请的最长租约
+	MaxLeaseTTL time.Duration
+	MaxAttempts int
+}
+
+// taskQueueOptions 由 InitTaskQueue 初始化
+var taskQueueOptions = TaskQueueOptions{
+	LeaseTTL:    2 * time.Minute,
+	MaxLeaseTTL: 30 * time.Minute,
+	MaxAttempts: 3,
+}
+
+// InitTaskQueue 设置嵌入任务队列的参数
+func InitTaskQueue(opts TaskQueueOptions) {
+	taskQueueOptions = opts
+}
+
+// taskPageOptions 任务列表允许的排序和过滤字段
+var taskPageOptions = dao.PageOptions{
+	SortFields: map[string]string{
+		"createdAt": "created_at",
+		"status":    "status",
+	},
+	FilterFields: map[string]string{
+		"status":     "status",
+		"onnxId":     "onnx_id",
+		"imageId":    "image_id",
+		"workerId":   "worker_id",
+		"campaignId": "campaign_id",
+	},
+}
+
 func NewTask() *Task {
 	return &Task{}
 }
@@ -32,27 +102,255 @@ func (t *Task) CreateTask(job dto.NewJob) {
 	t.OnnxId = job.OnnxId
 	t.Status = READY
 	t.EmbeddingURL = ""
-	err := dao.Save(t)
+	t.MaxAttempts = taskQueueOptions.MaxAttempts
+	err := dao.Save(context.Background(), t)
 	if err != nil {
 		return
 	}
 }
 
-// GetLatestTask 获取最新可做的task
-func (t *Task) GetLatestTask() *Task {
-	task, err := dao.First[Task]("status = ?", READY)
+// EnqueueEmbeddingTasks 为最多 limit 张未嵌入且没有进行中任务的图片创建任务，返回创建的数量
+// 任务使用数据集选用的模型，没有选用时使用 defaultOnnxID，为 0 时跳过这些数据集
+// 多个节点同时执行时由 idx_tasks_active_image 唯一索引保证每张图片只有一个进行中的任务
+func EnqueueEmbeddingTasks(ctx context.Context, defaultOnnxID uint, limit int) (int64, error) {
+	return dao.Exec(ctx, `INSERT INTO tasks (created_at, updated_at, img_url, embedding_url, status, onnx_id, image_id, attempts, max_attempts)
+SELECT now(), now(), i.img_url, '', ?, CASE WHEN d.sam_id > 0 THEN d.sam_id ELSE ? END, i.id, 0, ?
+FROM img_datasets i JOIN datasets d ON d.id = i.dataset_id AND d.deleted_at IS NULL
+WHERE i.status = ? AND i.deleted_at IS NULL AND (d.sam_id > 0 OR ? > 0)
+AND NOT EXISTS (SELECT 1 FROM tasks t WHERE t.image_id = i.id AND t.status IN (?, ?) AND t.deleted_at IS NULL)
+ORDER BY i.id LIMIT ?
+ON CONFLICT DO NOTHING`,
+		READY, defaultOnnxID, taskQueueOptions.MaxAttempts, ImgStatusDefault, defaultOnnxID, READY, RUNNING, limit)
+}
+
+// leaseTTL 限制 worker 申请的租约时长，为 0 时使用默认值
+func leaseTTL(requested time.Duration) time.Duration {
+	if requested <= 0 {
+		return taskQueueOptions.LeaseTTL
+	}
+	return min(requested, taskQueueOptions.MaxLeaseTTL)
+}
+
+// ClaimTask 认领最早的一个待执行任务，没有任务时返回 nil
+// 使用 FOR UPDATE SKIP LOCKED，多个 worker 同时认领时不会拿到同一个任务
+func ClaimTask(ctx context.Context, workerID string, ttl time.Duration) (*TaskLease, error) {
+	token, err := newLeaseToken()
 	if err != nil {
+		return nil, err
+	}
+
+	var lease *TaskLease
+	err = dao.WithTx(ctx, func(tx context.Context) error {
+		tasks, err := dao.Query[Task](tx,
+			"SELECT * FROM tasks WHERE status = ? AND deleted_at IS NULL ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED", READY)
+		if err != nil || len(tasks) == 0 {
+			return err
+		}
+		task := &tasks[0]
+		now := time.Now()
+		expiresAt := now.Add(leaseTTL(ttl))
+		_, err = dao.UpdateWhere[Task](tx, map[string]interface{}{
+			"status":           RUNNING,
+			"worker_id":        workerID,
+			"lease_token":      token,
+			"lease_expires_at": expiresAt,
+			"heartbeat_at":     now,
+			"attempts":         gorm.Expr("attempts + 1"),
+			"error":            "",
+		}, "id = ?", task.ID)
+		if err != nil {
+			return err
+		}
+		task.Status = RUNNING
+		task.WorkerID = workerID
+		task.LeaseToken = token
+		task.LeaseExpiresAt = &expiresAt
+		task.HeartbeatAt = &now
+		task.Attempts++
+		model, err := GetSam(tx, uint(task.OnnxId))
+		if err != nil {
+			return err
+		}
+		lease = &TaskLease{Task: task, LeaseToken: token, Model: model}
 		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	slog.Debug("task", task)
+	return lease, nil
+}
 
-	return task
+// HeartbeatTask 延长任务的租约，租约已经失效时返回 ErrTaskLeaseLost
+func HeartbeatTask(ctx context.Context, id int, token string, ttl time.Duration) (*Task, error) {
+	now := time.Now()
+	rows, err := dao.UpdateWhere[Task](ctx, map[string]interface{}{
+		"lease_expires_at": now.Add(leaseTTL(ttl)),
+		"heartbeat_at":     now,
+	}, "id = ? AND status = ? AND lease_token = ?", id, RUNNING, token)
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, leaseError(ctx, id)
+	}
+	return dao.FindOne[Task](ctx, "id = ?", id)
+}
+
+// CompleteTask 记录嵌入结果，并将图片标记为已嵌入
+func CompleteTask(ctx context.Context, id int, token string, embeddingURL string) error {
+	var imageID uint
+	err := dao.WithTx(ctx, func(tx context.Context) error {
+		rows, err := dao.UpdateWhere[Task](tx, map[string]interface{}{
+			"status":           SUCCESS,
+			"embedding_url":    embeddingURL,
+			"lease_token":      "",
+			"lease_expires_at": nil,
+			"error":            "",
+			"finished_at":      time.Now(),
+		}, "id = ? AND status = ? AND lease_token = ?", id, RUNNING, token)
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return leaseError(tx, id)
+		}
+
+		task, err := dao.FindOne[Task](tx, "id = ?", id)
+		if err != nil || task == nil || task.ImageID == 0 {
+			return err
+		}
+		imageID = task.ImageID
+		// 图片在嵌入期间被替换时 img_url 已经变化，结果作废，由新的任务重新嵌入
+		_, err = dao.UpdateWhere[ImgDataset](tx, map[string]interface{}{
+			"embedding_url":    embeddingURL,
+			"embedding_sam_id": task.OnnxId,
+		}, "id = ? AND img_url = ?", task.ImageID, task.ImgURL)
+		if err != nil {
+			return err
+		}
+		_, err = dao.UpdateWhere[ImgDataset](tx, map[string]interface{}{"status": ImgStatusEmbedded},
+			"id = ? AND img_url = ? AND status IN ?", task.ImageID, task.ImgURL, []int{ImgStatusDefault, ImgStatusEmbeddingFailed})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if imageID > 0 {
+		notifyImageProgress(ctx, imageID)
+	}
+	return nil
+}
+
+// FailTask 记录 worker 汇报的失败，retryable 且没有超过次数上限时重新排队
+func FailTask(ctx context.Context, id int, token string, reason string, retryable bool) (*Task, error) {
+	task, err := dao.FindOne[Task](ctx, "id = ? AND status = ? AND lease_token = ?", id, RUNNING, token)
+	if err != nil {
+		return nil, err
+	}
+	if task == nil {
+		return nil, leaseError(ctx, id)
+	}
+	err = failTask(ctx, task, reason, retryable, false)
+	if err != nil {
+		return nil, err
+	}
+	return dao.FindOne[Task](ctx, "id = ?", id)
+}
+
+// RequeueExpiredTasks 将租约过期的任务重新排队，超过次数上限的任务标记为失败，返回处理的任务数
+func RequeueExpiredTasks(ctx context.Context) (int, error) {
+	tasks, err := dao.FindAll[Task](ctx, "status = ? AND lease_expires_at < ?", RUNNING, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := range tasks {
+		slog.Info("task lease expired", "taskID", tasks[i].ID, "workerID", tasks[i].WorkerID, "attempts", tasks[i].Attempts)
+		err = failTask(ctx, &tasks[i], "lease expired", true, true)
+		if errors.Is(err, ErrTaskLeaseLost) {
+			// worker 在此期间汇报了结果或发送了心跳
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// failTask 按 lease_token 条件更新，避免覆盖 worker 同时汇报的结果
+// onlyExpired 为 true 时只处理仍然过期的租约，心跳可能刚刚延长了租约
+func failTask(ctx context.Context, task *Task, reason string, retryable bool, onlyExpired bool) error {
+	values := map[string]interface{}{
+		"status":           READY,
+		"worker_id":        "",
+		"lease_token":      "",
+		"lease_expires_at": nil,
+		"error":            reason,
+	}
+	final := !retryable || task.Attempts >= task.MaxAttempts
+	if final {
+		values["status"] = FAILED
+		values["finished_at"] = time.Now()
+	}
+
+	err := dao.WithTx(ctx, func(tx context.Context) error {
+		condition := "id = ? AND status = ? AND lease_token = ?"
+		args := []interface{}{task.ID, RUNNING, task.LeaseToken}
+		if onlyExpired {
+			condition += " AND lease_expires_at < ?"
+			args = append(args, time.Now())
+		}
+		rows, err := dao.UpdateWhere[Task](tx, values, condition, args...)
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrTaskLeaseLost
+		}
+		if !final || task.ImageID == 0 {
+			return nil
+		}
+		// 不再重试的图片标记为嵌入失败，管理员重置状态后重新嵌入
+		_, err = dao.UpdateWhere[ImgDataset](tx, map[string]interface{}{"status": ImgStatusEmbeddingFailed},
+			"id = ? AND img_url = ? AND status = ?", task.ImageID, task.ImgURL, ImgStatusDefault)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if final && task.ImageID > 0 {
+		notifyImageProgress(ctx, task.ImageID)
+	}
+	return nil
+}
+
+// leaseError 区分任务不存在和租约失效
+func leaseError(ctx context.Context, id int) error {
+	task, err := dao.FindOne[Task](ctx, "id = ?", id)
+	if err != nil {
+		return err
+	}
+	if task == nil {
+		return ErrTaskNotFound
+	}
+	return ErrTaskLeaseLost
+}
+
+func newLeaseToken() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
 }
 
 // UpdateTaskStatus 更新task状态
 func (t *Task) UpdateTaskStatus(status int) {
 	t.Status = status
-	err := dao.Save(t)
+	err := dao.Save(context.Background(), t)
 	if err != nil {
 		return
 	}
@@ -61,7 +359,7 @@ func (t *Task) UpdateTaskStatus(status int) {
 // UpdateTaskEmbeddingURL 更新task的embedding url
 func (t *Task) UpdateTaskEmbeddingURL(embeddingURL string) {
 	t.EmbeddingURL = embeddingURL
-	err := dao.Save(t)
+	err := dao.Save(context.Background(), t)
 	if err != nil {
 		return
 	}
@@ -69,7 +367,7 @@ func (t *Task) UpdateTaskEmbeddingURL(embeddingURL string) {
 
 // GetTaskByID 根据id获取task
 func (t *Task) GetTaskByID(id int) *Task {
-	task, err := dao.First[Task]("id = ?", id)
+	task, err := dao.First[Task](context.Background(), "id = ?", id)
 	if err != nil {
 		return nil
 	}
@@ -78,11 +376,20 @@ func (t *Task) GetTaskByID(id int) *Task {
 
 // GetAllTasks 获取所有task
 func (t *Task) GetAllTasks() []Task {
-	tasks, err := dao.FindAll[Task]()
+	tasks, err := dao.FindAll[Task](context.Background())
 	if err != nil {
 		return nil
 	}
-	slog.Debug("tasks", tasks)
+	slog.Debug("GetAllTasks", "tasks", tasks)
 
 	return tasks
 }
+
+// ListTaskPage 分页获取task
+func (t *Task) ListTaskPage(req dao.PageRequest) (*dao.Page[Task], error) {
+	page, err := dao.FindPage[Task](context.Background(), req, taskPageOptions)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
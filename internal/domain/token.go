@@ -0,0 +1,126 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sapphire-server/internal/infra"
+	"sapphire-server/pkg/util"
+	"time"
+)
+
+const (
+	refreshTokenKeyPrefix = "sapphire:refresh:"
+	revokedTokenKeyPrefix = "sapphire:revoked:"
+)
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// TokenPair 登录后返回的 token
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	// ExpiresIn access token 的有效期，单位秒
+	ExpiresIn int64 `json:"expiresIn"`
+	// RefreshExpiresIn refresh token 的有效期，单位秒
+	RefreshExpiresIn int64 `json:"refreshExpiresIn"`
+}
+
+// IssueTokenPair 签发 access token 和 refresh token，refresh token 的 jti 保存在 Redis 中
+func IssueTokenPair(ctx context.Context, userID uint) (*TokenPair, error) {
+	accessToken, accessClaims, err := util.GenerateJWT(userID, util.TokenTypeAccess)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshClaims, err := util.GenerateJWT(userID, util.TokenTypeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshTTL := time.Until(refreshClaims.ExpiresAt.Time)
+	err = infra.Redis.Set(ctx, refreshTokenKeyPrefix+refreshClaims.RegisteredClaims.ID, userID, refreshTTL).Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		ExpiresIn:        int64(time.Until(accessClaims.ExpiresAt.Time).Seconds()),
+		RefreshExpiresIn: int64(refreshTTL.Seconds()),
+	}, nil
+}
+
+// RefreshTokenPair 使用 refresh token 换取新的 token，旧的 refresh token 立即失效
+func RefreshTokenPair(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	claims, err := util.ParseJWT(refreshToken, util.TokenTypeRefresh)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// 删除成功说明 refresh token 仍然有效，同时保证只能使用一次
+	deleted, err := infra.Redis.Del(ctx, refreshTokenKeyPrefix+claims.RegisteredClaims.ID).Result()
+	if err != nil {
+		return nil, err
+	}
+	if deleted == 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return IssueTokenPair(ctx, claims.ID)
+}
+
+// Logout 吊销 access token，并删除对应的 refresh token
+func Logout(ctx context.Context, accessClaims *util.UserClaims, refreshToken string) error {
+	ttl := time.Until(accessClaims.ExpiresAt.Time)
+	if ttl > 0 {
+		err := infra.Redis.Set(ctx, revokedTokenKeyPrefix+accessClaims.RegisteredClaims.ID, accessClaims.ID, ttl).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+	claims, err := util.ParseJWT(refreshToken, util.TokenTypeRefresh)
+	if err != nil {
+		return ErrInvalidRefreshToken
+	}
+	// 只能注销自己的 refresh token
+	if claims.ID != accessClaims.ID {
+		return ErrInvalidRefreshToken
+	}
+	return infra.Redis.Del(ctx, refreshTokenKeyPrefix+claims.RegisteredClaims.ID).Err()
+}
+
+// IsTokenRevoked 判断 access token 是否已被吊销
+func IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	count, err := infra.Redis.Exists(ctx, revokedTokenKeyPrefix+jti).Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// StreamToken 订阅事件流使用的短期 token
+type StreamToken struct {
+	Token string `json:"token"`
+	// ExpiresIn 有效期，单位秒，只在建立连接时校验
+	ExpiresIn int64 `json:"expiresIn"`
+}
+
+// DatasetStreamAudience 数据集事件流 token 的 audience
+func DatasetStreamAudience(datasetID uint) string {
+	return fmt.Sprintf("dataset:%d", datasetID)
+}
+
+// IssueDatasetStreamToken 签发只能订阅该数据集事件的 token
+// EventSource 不能设置 Authorization 头部，需要通过查询参数或 cookie 传入
+func IssueDatasetStreamToken(userID uint, datasetID uint) (*StreamToken, error) {
+	token, claims, err := util.GenerateStreamToken(userID, DatasetStreamAudience(datasetID))
+	if err != nil {
+		return nil, err
+	}
+	return &StreamToken{Token: token, ExpiresIn: int64(time.Until(claims.ExpiresAt.Time).Seconds())}, nil
+}
@@ -0,0 +1,645 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sapphire-server/internal/dao"
+	"sapphire-server/pkg/misc"
+	"sapphire-server/pkg/util"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 上传任务的状态
+const (
+	UploadJobQueued      = "queued"
+	UploadJobExtracting  = "extracting"
+	UploadJobUploading   = "uploading"
+	UploadJobRegistering = "registering"
+	UploadJobDone        = "done"
+	// UploadJobFailed 整个任务失败，如压缩包损坏、超出限制
+	UploadJobFailed = "failed"
+)
+
+// 上传任务中单个文件的状态
+const (
+	UploadFilePending = "pending"
+	// UploadFileUploaded 已上传到存储，还没有加入数据集
+	UploadFileUploaded = "uploaded"
+	UploadFileAccepted = "accepted"
+	// UploadFileRejected 文件不合法，如不是图片、路径非法、超过大小，重试也不会成功
+	UploadFileRejected = "rejected"
+	// UploadFileFailed 文件合法但上传到存储失败，可以重试
+	UploadFileFailed = "failed"
+	// UploadFileDuplicate 数据集或压缩包中已有相同的图片，按数据集的去重策略跳过
+	UploadFileDuplicate = "duplicate"
+)
+
+// 上传的文件与已有图片重复的类型
+const (
+	// DuplicateExact 内容完全相同
+	DuplicateExact = "exact"
+	// DuplicateLinked 其他数据集中已有相同的图片，直接引用不再上传
+	DuplicateLinked = "linked"
+	// DuplicateNear 感知哈希接近，只标记不跳过
+	DuplicateNear = "near"
+)
+
+// counterFlushInterval 上传过程中刷新任务计数的间隔
+const counterFlushInterval = time.Second
+
+// uploadFileBatchSize 每次插入的文件记录数
+const uploadFileBatchSize = 1000
+
+var ErrUploadJobNotRetryable = errors.New("upload job is not retryable")
+
+// UploadJob 上传压缩包的后台任务
+type UploadJob struct {
+	gorm.Model
+	DatasetID   uint   `gorm:"column:dataset_id" json:"datasetId"`
+	UserID      uint   `gorm:"column:user_id" json:"userId"`
+	Status      string `gorm:"column:status" json:"status"`
+	Error       string `gorm:"column:error" json:"error,omitempty"`
+	ArchiveName string `gorm:"column:archive_name" json:"archiveName"`
+	// Workspace 任务的临时目录，清理后为空，此时不能再重试
+	Workspace string `gorm:"column:workspace" json:"-"`
+	// Node 创建任务的节点，临时目录只在该节点上存在
+	Node           string `gorm:"column:node" json:"-"`
+	TotalFiles     int    `gorm:"column:total_files" json:"totalFiles"`
+	ProcessedFiles int    `gorm:"column:processed_files" json:"processedFiles"`
+	AcceptedFiles  int    `gorm:"column:accepted_files" json:"acceptedFiles"`
+	RejectedFiles  int    `gorm:"column:rejected_files" json:"rejectedFiles"`
+	FailedFiles    int    `gorm:"column:failed_files" json:"failedFiles"`
+	// DuplicateFiles 内容与已有图片相同的文件数，包括跳过和引用的
+	DuplicateFiles     int        `gorm:"column:duplicate_files" json:"duplicateFiles"`
+	NearDuplicateFiles int        `gorm:"column:near_duplicate_files" json:"nearDuplicateFiles"`
+	StartedAt          *time.Time `gorm:"column:started_at" json:"startedAt"`
+	FinishedAt         *time.Time `gorm:"column:finished_at" json:"finishedAt"`
+}
+
+// UploadJobFile 上传任务中的一个文件
+type UploadJobFile struct {
+	gorm.Model
+	JobID uint `gorm:"column:job_id" json:"-"`
+	// Name 压缩包中的路径
+	Name   string `gorm:"column:name" json:"file"`
+	Path   string `gorm:"column:path" json:"-"`
+	Ext    string `gorm:"column:ext" json:"-"`
+	Status string `gorm:"column:status" json:"status"`
+	Reason string `gorm:"column:reason" json:"reason,omitempty"`
+	URL    string `gorm:"column:url" json:"url,omitempty"`
+	// ObjectKey 存储中的 key，加入数据集失败时用于清理
+	ObjectKey string `gorm:"column:object_key" json:"-"`
+	ImageID   uint   `gorm:"column:image_id" json:"imageId,omitempty"`
+	Sha256    string `gorm:"column:sha256" json:"-"`
+	PHash     string `gorm:"column:phash" json:"-"`
+	// Duplicate 与已有图片重复的类型，见 DuplicateExact 等常量
+	Duplicate string `gorm:"column:duplicate" json:"duplicate,omitempty"`
+	// DuplicateOf 重复的数据集图片
+	DuplicateOf uint `gorm:"column:duplicate_of" json:"duplicateOf,omitempty"`
+	// DuplicateFile 重复的压缩包中的文件
+	DuplicateFile string `gorm:"column:duplicate_file" json:"duplicateFile,omitempty"`
+	// Distance 近似重复时感知哈希的汉明距离
+	Distance int `gorm:"column:distance" json:"distance,omitempty"`
+}
+
+// UploadJobDetail 任务及其中有问题或重复的文件
+type UploadJobDetail struct {
+	*UploadJob
+	Files []UploadJobFile `json:"files"`
+}
+
+// UploadWorkspace 一次上传使用的临时目录，并发上传之间互不影响
+type UploadWorkspace struct {
+	Dir string
+}
+
+// NewUploadWorkspace 在 tempDir 下创建临时目录，tempDir 为空时使用系统临时目录
+func NewUploadWorkspace(tempDir string) (*UploadWorkspace, error) {
+	if tempDir != "" {
+		err := os.MkdirAll(tempDir, os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dir, err := os.MkdirTemp(tempDir, "sapphire-upload-")
+	if err != nil {
+		return nil, err
+	}
+	return &UploadWorkspace{Dir: dir}, nil
+}
+
+// ID 临时目录的随机后缀，用于区分不同上传的同名文件
+func (w *UploadWorkspace) ID() string {
+	return strings.TrimPrefix(filepath.Base(w.Dir), "sapphire-upload-")
+}
+
+// ArchivePath 上传的压缩包保存的位置
+func (w *UploadWorkspace) ArchivePath() string {
+	return filepath.Join(w.Dir, "upload.zip")
+}
+
+// ExtractDir 压缩包解压的目录
+func (w *UploadWorkspace) ExtractDir() string {
+	return filepath.Join(w.Dir, "files")
+}
+
+// Remove 删除临时目录
+func (w *UploadWorkspace) Remove() {
+	err := os.RemoveAll(w.Dir)
+	if err != nil {
+		slog.Warn("remove upload workspace failed", "dir", w.Dir, "err", err)
+	}
+}
+
+// nodeName 当前节点的名字，用于认领本节点创建的任务
+func nodeName() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
+
+// CreateUploadJob 为已经保存到 ws 的压缩包创建上传任务，由后台 worker 处理
+func (d *Dataset) CreateUploadJob(ctx context.Context, dataset *Dataset, userID uint, ws *UploadWorkspace, archiveName string) (*UploadJob, error) {
+	job := &UploadJob{
+		DatasetID:   dataset.ID,
+		UserID:      userID,
+		Status:      UploadJobQueued,
+		ArchiveName: archiveName,
+		Workspace:   ws.Dir,
+		Node:        nodeName(),
+	}
+	err := dao.Save(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// GetUploadJob 读取上传任务，不存在时返回 nil
+func GetUploadJob(ctx context.Context, id uint) (*UploadJob, error) {
+	return dao.FindOne[UploadJob](ctx, id)
+}
+
+// GetUploadJobDetail 读取上传任务和其中被拒绝、失败、重复的文件
+func GetUploadJobDetail(ctx context.Context, id uint) (*UploadJobDetail, error) {
+	job, err := GetUploadJob(ctx, id)
+	if err != nil || job == nil {
+		return nil, err
+	}
+	files, err := dao.FindAll[UploadJobFile](ctx, "job_id = ? AND (status IN ? OR duplicate <> '')", job.ID,
+		[]string{UploadFileRejected, UploadFileFailed, UploadFileDuplicate})
+	if err != nil {
+		return nil, err
+	}
+	return &UploadJobDetail{UploadJob: job, Files: files}, nil
+}
+
+// ClaimUploadJob 认领一个本节点排队中的任务，没有任务时返回 nil
+func ClaimUploadJob(ctx context.Context) (*UploadJob, error) {
+	node := nodeName()
+	for {
+		job, err := dao.First[UploadJob](ctx, "status = ? AND node = ?", UploadJobQueued, node)
+		if err != nil || job == nil {
+			return nil, err
+		}
+		// 条件更新，同一节点上的多个 worker 不会认领同一个任务
+		now := time.Now()
+		rows, err := dao.UpdateWhere[UploadJob](ctx, map[string]interface{}{
+			"status":     UploadJobExtracting,
+			"started_at": now,
+		}, "id = ? AND status = ?", job.ID, UploadJobQueued)
+		if err != nil {
+			return nil, err
+		}
+		if rows == 1 {
+			job.Status = UploadJobExtracting
+			job.StartedAt = &now
+			return job, nil
+		}
+	}
+}
+
+// RecoverUploadJobs 服务重启后将本节点上中断的任务重新排队
+// 每个阶段都可以重复执行，已经上传或加入数据集的文件不会重复处理
+func RecoverUploadJobs(ctx context.Context) error {
+	rows, err := dao.UpdateWhere[UploadJob](ctx, map[string]interface{}{"status": UploadJobQueued},
+		"node = ? AND status IN ?", nodeName(), []string{UploadJobExtracting, UploadJobUploading, UploadJobRegistering})
+	if err != nil {
+		return err
+	}
+	if rows > 0 {
+		slog.Info("RecoverUploadJobs", "count", rows)
+	}
+	return nil
+}
+
+// RetryUploadJob 将上传失败的文件重新排队，不需要重新上传压缩包
+func RetryUploadJob(ctx context.Context, job *UploadJob) error {
+	if job.Status != UploadJobDone && job.Status != UploadJobFailed {
+		return ErrUploadJobNotRetryable
+	}
+	if job.Workspace == "" {
+		return fmt.Errorf("%w: workspace expired, upload the archive again", ErrUploadJobNotRetryable)
+	}
+	ws := &UploadWorkspace{Dir: job.Workspace}
+
+	return dao.WithTx(ctx, func(tx context.Context) error {
+		rows, err := dao.UpdateWhere[UploadJobFile](tx, map[string]interface{}{"status": UploadFilePending, "reason": ""},
+			"job_id = ? AND status = ?", job.ID, UploadFileFailed)
+		if err != nil {
+			return err
+		}
+		if rows == 0 && job.Status == UploadJobDone {
+			return fmt.Errorf("%w: no failed files", ErrUploadJobNotRetryable)
+		}
+		if rows == 0 {
+			// 失败的任务从中断的阶段继续，解压前失败的任务没有文件记录，需要压缩包还在
+			existing, err := dao.First[UploadJobFile](tx, "job_id = ?", job.ID)
+			if err != nil {
+				return err
+			}
+			if existing == nil {
+				if _, err := os.Stat(ws.ArchivePath()); err != nil {
+					return fmt.Errorf("%w: archive not found", ErrUploadJobNotRetryable)
+				}
+			}
+		}
+
+		rows, err = dao.UpdateWhere[UploadJob](tx, map[string]interface{}{"status": UploadJobQueued, "error": "", "finished_at": nil},
+			"id = ? AND status = ?", job.ID, job.Status)
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrUploadJobNotRetryable
+		}
+		job.Status = UploadJobQueued
+		return nil
+	})
+}
+
+// CleanupUploadJobs 删除结束超过 ttl 的任务的临时目录
+func CleanupUploadJobs(ctx context.Context, ttl time.Duration) error {
+	jobs, err := dao.FindAll[UploadJob](ctx, "node = ? AND status IN ? AND workspace <> '' AND finished_at < ?",
+		nodeName(), []string{UploadJobDone, UploadJobFailed}, time.Now().Add(-ttl))
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		(&UploadWorkspace{Dir: job.Workspace}).Remove()
+		_, err = dao.UpdateWhere[UploadJob](ctx, map[string]interface{}{"workspace": ""}, "id = ?", job.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run 执行任务的各个阶段，返回时任务为 done 或 failed
+func (j *UploadJob) Run(ctx context.Context, limits util.UnzipLimits) {
+	err := j.run(ctx, limits)
+	if err != nil {
+		slog.Warn("upload job failed", "jobID", j.ID, "err", err)
+		j.finish(ctx, UploadJobFailed, err.Error())
+		return
+	}
+	j.finish(ctx, UploadJobDone, "")
+	slog.Info("upload job done", "jobID", j.ID, "datasetID", j.DatasetID, "accepted", j.AcceptedFiles,
+		"rejected", j.RejectedFiles, "failed", j.FailedFiles, "duplicate", j.DuplicateFiles, "nearDuplicate", j.NearDuplicateFiles)
+}
+
+func (j *UploadJob) run(ctx context.Context, limits util.UnzipLimits) error {
+	if j.Workspace == "" {
+		return errors.New("workspace expired")
+	}
+	ws := &UploadWorkspace{Dir: j.Workspace}
+
+	dataset, err := dao.FindOne[Dataset](ctx, j.DatasetID)
+	if err != nil {
+		return err
+	}
+	if dataset == nil {
+		return errors.New("dataset not found")
+	}
+
+	err = j.extract(ctx, ws, limits, dataset.NearDuplicateThreshold > 0)
+	if err != nil {
+		return err
+	}
+	err = j.dedup(ctx, dataset)
+	if err != nil {
+		return err
+	}
+	err = j.setStatus(ctx, UploadJobUploading)
+	if err != nil {
+		return err
+	}
+	err = j.upload(ctx, ws)
+	if err != nil {
+		return err
+	}
+	err = j.setStatus(ctx, UploadJobRegistering)
+	if err != nil {
+		return err
+	}
+	return j.register(ctx, dataset)
+}
+
+func (j *UploadJob) setStatus(ctx context.Context, status string) error {
+	_, err := dao.UpdateWhere[UploadJob](ctx, map[string]interface{}{"status": status}, "id = ?", j.ID)
+	if err != nil {
+		return err
+	}
+	j.Status = status
+	PublishDatasetEvent(ctx, j.DatasetID, EventUpload, j)
+	return nil
+}
+
+// finish 结束任务，没有失败文件时删除临时目录
+func (j *UploadJob) finish(ctx context.Context, status string, reason string) {
+	err := j.refreshCounters(ctx)
+	if err != nil {
+		slog.Warn("refresh upload job counters failed", "jobID", j.ID, "err", err)
+	}
+
+	values := map[string]interface{}{
+		"status":      status,
+		"error":       reason,
+		"finished_at": time.Now(),
+	}
+	if status == UploadJobDone && j.FailedFiles == 0 && j.Workspace != "" {
+		(&UploadWorkspace{Dir: j.Workspace}).Remove()
+		values["workspace"] = ""
+		j.Workspace = ""
+	}
+	_, err = dao.UpdateWhere[UploadJob](ctx, values, "id = ?", j.ID)
+	if err != nil {
+		slog.Error("finish upload job failed", "jobID", j.ID, "err", err)
+		return
+	}
+	j.Status = status
+	j.Error = reason
+	PublishDatasetEvent(ctx, j.DatasetID, EventUpload, j)
+	if j.AcceptedFiles > 0 {
+		NotifyDatasetProgress(ctx, j.DatasetID)
+	}
+}
+
+// extract 解压压缩包并记录每个文件和它的哈希，已经解压过的任务直接跳过
+// perceptual 为 true 时同时计算感知哈希
+func (j *UploadJob) extract(ctx context.Context, ws *UploadWorkspace, limits util.UnzipLimits, perceptual bool) error {
+	existing, err := dao.First[UploadJobFile](ctx, "job_id = ?", j.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return nil
+	}
+
+	// 上次解压可能中断，清理后重新解压
+	err = os.RemoveAll(ws.ExtractDir())
+	if err != nil {
+		return err
+	}
+	entries, err := util.Unzip(ws.ArchivePath(), ws.ExtractDir(), limits)
+	if err != nil {
+		return err
+	}
+
+	// 按文件头识别图片格式
+	files := make([]UploadJobFile, 0, len(entries))
+	for _, entry := range entries {
+		file := UploadJobFile{JobID: j.ID, Name: entry.Name, Status: UploadFileRejected}
+		if entry.Err != nil {
+			file.Reason = entry.Err.Error()
+			files = append(files, file)
+			continue
+		}
+		contentType, ext, ok, err := misc.SniffImageFile(entry.Path)
+		switch {
+		case err != nil:
+			file.Reason = err.Error()
+		case !ok:
+			file.Reason = "unsupported file type " + contentType
+		default:
+			file.Status = UploadFilePending
+			file.Path = entry.Path
+			file.Ext = ext
+		}
+		files = append(files, file)
+	}
+	hashJobFiles(files, perceptual)
+
+	// 分批插入，避免超过单条语句的参数个数上限
+	for start := 0; start < len(files); start += uploadFileBatchSize {
+		end := min(start+uploadFileBatchSize, len(files))
+		err = dao.SaveAll(ctx, files[start:end])
+		if err != nil {
+			return err
+		}
+	}
+
+	// 文件已经解压，压缩包不再需要
+	err = os.Remove(ws.ArchivePath())
+	if err != nil {
+		slog.Warn("remove upload archive failed", "jobID", j.ID, "err", err)
+	}
+	return j.refreshCounters(ctx)
+}
+
+// upload 并发上传所有待上传的文件，单个文件失败不影响其他文件
+func (j *UploadJob) upload(ctx context.Context, ws *UploadWorkspace) error {
+	files, err := dao.FindAll[UploadJobFile](ctx, "job_id = ? AND status = ?", j.ID, UploadFilePending)
+	if err != nil {
+		return err
+	}
+
+	// 上传过程中定时刷新计数，便于客户端查看进度
+	done := make(chan struct{})
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		ticker := time.NewTicker(counterFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := j.refreshCounters(ctx)
+				if err != nil {
+					slog.Warn("refresh upload job counters failed", "jobID", j.ID, "err", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	ch := make(chan int)
+	for w := 0; w < fetchWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				values := uploadJobFile(ws, &files[i])
+				_, err := dao.UpdateWhere[UploadJobFile](ctx, values, "id = ?", files[i].ID)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range files {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+	close(done)
+	<-flushed
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return j.refreshCounters(ctx)
+}
+
+// uploadJobFile 上传单个文件，返回需要更新的列
+func uploadJobFile(ws *UploadWorkspace, file *UploadJobFile) map[string]interface{} {
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		return map[string]interface{}{"status": UploadFileFailed, "reason": err.Error()}
+	}
+	key, url, err := misc.UploadImage(data, uploadFileName(ws, file))
+	if err != nil {
+		slog.Warn("upload image failed", "file", file.Name, "err", err)
+		return map[string]interface{}{"status": UploadFileFailed, "reason": err.Error()}
+	}
+	return map[string]interface{}{"status": UploadFileUploaded, "reason": "", "url": url, "object_key": key}
+}
+
+// register 将已上传的图片加入数据集，失败时删除这些图片并回到待上传状态
+func (j *UploadJob) register(ctx context.Context, dataset *Dataset) error {
+	files, err := dao.FindAll[UploadJobFile](ctx, "job_id = ? AND status = ?", j.ID, UploadFileUploaded)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	images := make([]ImgDataset, len(files))
+	var keys []string
+	for i, file := range files {
+		images[i] = ImgDataset{ImgUrl: file.URL, Sha256: file.Sha256, PHash: file.PHash}
+		// 引用其他数据集的图片没有自己的存储对象
+		if file.ObjectKey != "" {
+			keys = append(keys, file.ObjectKey)
+		}
+	}
+	err = dao.WithTx(ctx, func(tx context.Context) error {
+		images, err := datasetDomain.AddImages(tx, dataset, images)
+		if err != nil {
+			return err
+		}
+		for i, file := range files {
+			_, err = dao.UpdateWhere[UploadJobFile](tx, map[string]interface{}{
+				"status":   UploadFileAccepted,
+				"image_id": images[i].ID,
+			}, "id = ?", file.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		misc.RemoveImages(keys)
+		_, resetErr := dao.UpdateWhere[UploadJobFile](ctx, map[string]interface{}{"status": UploadFileFailed, "reason": err.Error()},
+			"job_id = ? AND status = ?", j.ID, UploadFileUploaded)
+		if resetErr != nil {
+			slog.Error("reset upload job files failed", "jobID", j.ID, "err", resetErr)
+		}
+		return err
+	}
+	return j.refreshCounters(ctx)
+}
+
+// uploadStatusCount 按状态和重复类型统计的文件数
+type uploadStatusCount struct {
+	Status    string
+	Duplicate string
+	Count     int
+}
+
+// refreshCounters 根据文件记录重新计算任务的计数
+func (j *UploadJob) refreshCounters(ctx context.Context) error {
+	counts, err := dao.Query[uploadStatusCount](ctx,
+		"SELECT status, duplicate, count(*) AS count FROM upload_job_files WHERE job_id = ? AND deleted_at IS NULL GROUP BY status, duplicate", j.ID)
+	if err != nil {
+		return err
+	}
+	var total, pending, accepted, rejected, failed, duplicate, nearDuplicate int
+	for _, c := range counts {
+		total += c.Count
+		switch c.Status {
+		case UploadFilePending:
+			pending += c.Count
+		case UploadFileAccepted:
+			accepted += c.Count
+		case UploadFileRejected:
+			rejected += c.Count
+		case UploadFileFailed:
+			failed += c.Count
+		}
+		switch c.Duplicate {
+		case DuplicateExact, DuplicateLinked:
+			duplicate += c.Count
+		case DuplicateNear:
+			nearDuplicate += c.Count
+		}
+	}
+	j.TotalFiles = total
+	j.ProcessedFiles = total - pending
+	j.AcceptedFiles = accepted
+	j.RejectedFiles = rejected
+	j.FailedFiles = failed
+	j.DuplicateFiles = duplicate
+	j.NearDuplicateFiles = nearDuplicate
+	_, err = dao.UpdateWhere[UploadJob](ctx, map[string]interface{}{
+		"total_files":          j.TotalFiles,
+		"processed_files":      j.ProcessedFiles,
+		"accepted_files":       j.AcceptedFiles,
+		"rejected_files":       j.RejectedFiles,
+		"failed_files":         j.FailedFiles,
+		"duplicate_files":      j.DuplicateFiles,
+		"near_duplicate_files": j.NearDuplicateFiles,
+	}, "id = ?", j.ID)
+	if err != nil {
+		return err
+	}
+	PublishDatasetEvent(ctx, j.DatasetID, EventUpload, j)
+	return nil
+}
+
+// uploadFileName 存储中的文件名，子目录展开为下划线，扩展名以识别出的格式为准
+func uploadFileName(ws *UploadWorkspace, file *UploadJobFile) string {
+	name := strings.TrimSuffix(file.Name, path.Ext(file.Name))
+	name = strings.NewReplacer("/", "_", "\\", "_", " ", "_").Replace(name)
+	return fmt.Sprintf("%s_%s%s", ws.ID(), name, file.Ext)
+}
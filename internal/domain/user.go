@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"context"
 	"errors"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
@@ -9,7 +10,6 @@ import (
 	"regexp"
 	"sapphire-server/internal/dao"
 	"sapphire-server/internal/data/dto"
-	"sapphire-server/pkg/util"
 	"strconv"
 	"time"
 )
@@ -55,9 +55,9 @@ func buildUserResult(user *User, joinedCount, createdCount, annotatedCount int)
 	createScore := math.Pow(float64(createdCount), 1.1)
 	joinScore := math.Pow(float64(joinedCount), 1.2)
 	annotateScore := math.Pow(float64(annotatedCount), 1.3)
-	slog.Debug("createScore", createScore)
-	slog.Debug("joinScore", joinScore)
-	slog.Debug("annotateScore", annotateScore)
+	slog.Debug("buildUserResult", "createScore", createScore)
+	slog.Debug("buildUserResult", "joinScore", joinScore)
+	slog.Debug("buildUserResult", "annotateScore", annotateScore)
 	totalScore := int(10 * (createScore + joinScore + annotateScore) / 3)
 
 	return &UserResult{
@@ -75,16 +75,16 @@ func buildUserResult(user *User, joinedCount, createdCount, annotatedCount int)
 	}
 }
 
-func (u *User) Register(register dto.Register) (token string, user *User, err error) {
+func (u *User) Register(ctx context.Context, register dto.Register) (token *TokenPair, user *User, err error) {
 	// 检查是否有重名用户
 	existedUser := u.loadUser(map[string]interface{}{"name": register.Name})
 	if existedUser != nil {
-		return "", nil, errors.New("existed user")
+		return nil, nil, errors.New("existed user")
 	}
 
 	encryptedPasswd, err := u.hashPassword(register.Passwd)
 	if err != nil {
-		return "", nil, err
+		return nil, nil, err
 	}
 
 	// 插入用户
@@ -93,7 +93,7 @@ func (u *User) Register(register dto.Register) (token string, user *User, err er
 	if u.isValidEmail(register.Email) {
 		u.Email = register.Email
 	} else {
-		return "", nil, errors.New("invalid email")
+		return nil, nil, errors.New("invalid email")
 	}
 	// TODO: 生成 UID
 	u.Uid = strconv.FormatInt(time.Now().Unix(), 10)
@@ -102,25 +102,28 @@ func (u *User) Register(register dto.Register) (token string, user *User, err er
 	// 默认给普通用户权限
 	role, err := u.FindRoleIdByRoleName("USER")
 	if err != nil {
-		return "", nil, err
+		return nil, nil, err
 	} else {
 		u.Role = role
 	}
 
-	err = dao.Save(u)
+	err = dao.Save(ctx, u)
 	if err != nil {
-		return "", nil, err
+		return nil, nil, err
 	}
 
 	// 生成 token
-	token = util.GenerateJWT(u.ID)
+	token, err = IssueTokenPair(ctx, u.ID)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return token, nil, nil
+	return token, u, nil
 }
 
 func (u *User) ChangePasswd(passwd dto.ChangePasswd, userId uint) error {
 	var err error
-	user, err := dao.First[User](userId)
+	user, err := dao.First[User](context.Background(), userId)
 	if err != nil {
 		return err
 	}
@@ -138,7 +141,7 @@ func (u *User) ChangePasswd(passwd dto.ChangePasswd, userId uint) error {
 	}
 	user.Password = encryptedPasswd
 
-	err = dao.Modify(user, "password", encryptedPasswd)
+	err = dao.Modify(context.Background(), user, "password", encryptedPasswd)
 	if err != nil {
 		return err
 	}
@@ -146,30 +149,33 @@ func (u *User) ChangePasswd(passwd dto.ChangePasswd, userId uint) error {
 	return nil
 }
 
-func (u *User) Login(login dto.Login) (token string, user *User, err error) {
+func (u *User) Login(ctx context.Context, login dto.Login) (token *TokenPair, user *User, err error) {
 	// 读取用户
 	user = u.loadUser(map[string]interface{}{"name": login.Name})
 	if user == nil {
-		return "", nil, errors.New("user not found")
+		return nil, nil, errors.New("user not found")
 	}
-	slog.Info("user", user)
+	slog.Info("Login", "user", user)
 	// Redis DEMO
 	// infra.Redis.Set(infra.Ctx, "name", user.Name, time.Duration(10)*time.Second)
 	// 验证口令
 
 	err = user.verifyPassword(user.Password, login.Passwd)
 	if err != nil {
-		return "", nil, errors.New("密码错误")
+		return nil, nil, errors.New("密码错误")
 	}
 
 	// 生成 token
-	token = util.GenerateJWT(user.ID)
+	token, err = IssueTokenPair(ctx, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	return token, user, nil
 }
 
 func (u *User) GetUserInfo(userId uint) (*User, error) {
-	user, err := dao.First[User](userId)
+	user, err := dao.First[User](context.Background(), userId)
 	if err != nil {
 		return nil, err
 	}
@@ -178,7 +184,7 @@ func (u *User) GetUserInfo(userId uint) (*User, error) {
 
 func (u *User) GetUserDetail(userId uint) (*UserResult, error) {
 	var err error
-	user, err := dao.FindOne[User](userId)
+	user, err := dao.FindOne[User](context.Background(), userId)
 	if err != nil {
 		return nil, err
 	}
@@ -188,24 +194,24 @@ func (u *User) GetUserDetail(userId uint) (*UserResult, error) {
 
 	createdDatasets, err := datasetDomain.ListUserCreatedDatasets(userId)
 	if err != nil {
-		slog.Error("ListUserCreatedDatasets", err)
+		slog.Error("ListUserCreatedDatasets", "err", err)
 		return nil, err
 	}
-	slog.Info("createdDatasets", createdDatasets)
+	slog.Info("ListUserCreatedDatasets", "createdDatasets", createdDatasets)
 
 	joinedDatasets, err := datasetDomain.ListUserJoinedDatasetList(userId)
 	if err != nil {
-		slog.Error("ListUserJoinedDatasetList", err)
+		slog.Error("ListUserJoinedDatasetList", "err", err)
 		return nil, err
 	}
-	slog.Info("joinedDatasets", joinedDatasets)
+	slog.Info("ListUserJoinedDatasetList", "joinedDatasets", joinedDatasets)
 
 	annotations, err := annotationDomain.ListAnnotationsByUserID(userId)
 	if err != nil {
-		slog.Error("ListAnnotationsByUserID", err)
+		slog.Error("ListAnnotationsByUserID", "err", err)
 		return nil, err
 	}
-	slog.Info("annotations", annotations)
+	slog.Info("ListAnnotationsByUserID", "annotations", annotations)
 
 	res := buildUserResult(user, len(joinedDatasets), len(createdDatasets), len(annotations))
 
@@ -213,8 +219,8 @@ func (u *User) GetUserDetail(userId uint) (*UserResult, error) {
 }
 
 func (u *User) ChangeInfo(info dto.ChangeUserInfo, userId uint) (user *User, err error) {
-	slog.Info("ChangeInfo", info)
-	user, err = dao.First[User](userId)
+	slog.Info("ChangeInfo", "info", info)
+	user, err = dao.First[User](context.Background(), userId)
 	if err != nil {
 		return nil, err
 	}
@@ -232,17 +238,17 @@ func (u *User) ChangeInfo(info dto.ChangeUserInfo, userId uint) (user *User, err
 		user.Description = info.Description
 	}
 
-	err = dao.Save(user)
+	err = dao.Save(context.Background(), user)
 	if err != nil {
 		return nil, err
 	}
-	slog.Info("ChangeInfo Success: ", user)
+	slog.Info("ChangeInfo Success", "user", user)
 
 	return user, nil
 }
 
 func (u *User) loadUser(param map[string]interface{}) *User {
-	user, err := dao.First[User]("name = ?", param["name"])
+	user, err := dao.First[User](context.Background(), "name = ?", param["name"])
 	if err != nil {
 		return nil
 	}
@@ -261,7 +267,7 @@ func (u *User) hashPassword(password string) (string, error) {
 
 // verifyPassword 验证密码是否匹配哈希
 func (u *User) verifyPassword(hashedPassword, password string) error {
-	slog.Info("CompareHashAndPassword hashedPassword ", hashedPassword)
+	slog.Debug("CompareHashAndPassword")
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 	return err
 }
@@ -281,7 +287,7 @@ func (u *User) isValidEmail(email string) bool {
 
 // FindRoleIdByRoleName 通过权限名查找权限 ID
 func (u *User) FindRoleIdByRoleName(roleName string) (int, error) {
-	role, err := dao.First[UserRole]("role_name = ?", roleName)
+	role, err := dao.First[UserRole](context.Background(), "role_name = ?", roleName)
 	if err != nil {
 		return 0, err
 	} else {
@@ -293,7 +299,7 @@ func (u *User) FindRoleIdByRoleName(roleName string) (int, error) {
 func (u *User) ListUsersByIds(ids []uint) ([]User, error) {
 	var err error
 	var users []User
-	users, err = dao.FindAll[User]("id in (?)", ids)
+	users, err = dao.FindAll[User](context.Background(), "id in (?)", ids)
 	if err != nil {
 		return nil, err
 	}
@@ -304,7 +310,7 @@ func (u *User) ListUsersByIds(ids []uint) ([]User, error) {
 func (u *User) ListUsersByRank() ([]UserResult, error) {
 	var err error
 	var users []User
-	users, err = dao.FindAll[User]("")
+	users, err = dao.FindAll[User](context.Background(), "")
 	if err != nil {
 		return nil, err
 	}
@@ -313,24 +319,24 @@ func (u *User) ListUsersByRank() ([]UserResult, error) {
 	for _, user := range users {
 		createdDatasets, err := datasetDomain.ListUserCreatedDatasets(user.ID)
 		if err != nil {
-			slog.Error("ListUserCreatedDatasets", err)
+			slog.Error("ListUserCreatedDatasets", "err", err)
 			return nil, err
 		}
-		slog.Info("createdDatasets", createdDatasets)
+		slog.Info("ListUserCreatedDatasets", "createdDatasets", createdDatasets)
 
 		joinedDatasets, err := datasetDomain.ListUserJoinedDatasetList(user.ID)
 		if err != nil {
-			slog.Error("ListUserJoinedDatasetList", err)
+			slog.Error("ListUserJoinedDatasetList", "err", err)
 			return nil, err
 		}
-		slog.Info("joinedDatasets", joinedDatasets)
+		slog.Info("ListUserJoinedDatasetList", "joinedDatasets", joinedDatasets)
 
 		annotations, err := annotationDomain.ListAnnotationsByUserID(user.ID)
 		if err != nil {
-			slog.Error("ListAnnotationsByUserID", err)
+			slog.Error("ListAnnotationsByUserID", "err", err)
 			return nil, err
 		}
-		slog.Debug("annotations", annotations)
+		slog.Debug("ListAnnotationsByUserID", "annotations", annotations)
 
 		res := buildUserResult(&user, len(joinedDatasets), len(createdDatasets), len(annotations))
 		userResults = append(userResults, *res)
@@ -344,14 +350,14 @@ func (u *User) ListUsersByRank() ([]UserResult, error) {
 			}
 		}
 	}
-	slog.Debug("userResults", userResults)
+	slog.Debug("ListUsersByRank", "userResults", userResults)
 
 	// 只返回前 10 个用户
 	if len(userResults) > 10 {
 		userResults = userResults[:10]
 	} else {
 		slog.Info("User count less than 10")
-		slog.Debug("userResults", userResults)
+		slog.Debug("ListUsersByRank", "userResults", userResults)
 	}
 
 	return userResults, nil
@@ -0,0 +1,56 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+// Scope 用于拼装查询条件
+type Scope = func(db *gorm.DB) *gorm.DB
+
+// Count 统计满足条件的记录数
+func Count[T any](ctx context.Context, scopes ...Scope) (int64, error) {
+	var count int64
+	res := GetDB(ctx).Model(new(T)).Scopes(scopes...).Count(&count)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	return count, nil
+}
+
+// FindByScopes 使用 Scope 查询数据
+func FindByScopes[T any](ctx context.Context, scopes ...Scope) ([]T, error) {
+	var objs []T
+	res := GetDB(ctx).Scopes(scopes...).Find(&objs)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return objs, nil
+}
+
+// LookUpField 根据列名查找模型字段
+func LookUpField[T any](column string) (*schema.Field, error) {
+	stmt := &gorm.Statement{DB: DB}
+	err := stmt.Parse(new(T))
+	if err != nil {
+		return nil, err
+	}
+	field := stmt.Schema.LookUpField(column)
+	if field == nil {
+		return nil, fmt.Errorf("unknown column %s on %s", column, stmt.Schema.Table)
+	}
+	return field, nil
+}
+
+// ColumnValue 读取对象某一列的值
+func ColumnValue[T any](obj *T, column string) (interface{}, error) {
+	field, err := LookUpField[T](column)
+	if err != nil {
+		return nil, err
+	}
+	value, _ := field.ValueOf(context.Background(), reflect.ValueOf(obj).Elem())
+	return value, nil
+}
@@ -1,6 +1,7 @@
 package infra
 
 import (
+	"context"
 	"errors"
 	"golang.org/x/exp/slog"
 	"gorm.io/driver/postgres"
@@ -13,6 +14,9 @@ var (
 	DB *gorm.DB
 )
 
+// txKey 事务在 context 中的 key
+type txKey struct{}
+
 func InitDB() error {
 	dsn := conf.GetDBConfig()
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
@@ -24,76 +28,109 @@ func InitDB() error {
 	return nil
 }
 
+// GetDB 返回 context 中的事务，没有事务时返回全局连接
+func GetDB(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx
+	}
+	return DB.WithContext(ctx)
+}
+
+// WithTx 在事务中执行 fn，fn 返回错误或 panic 时回滚
+// 嵌套调用时使用 savepoint，内层失败只回滚内层
+func WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return GetDB(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
 func HasID[T any](data T) bool {
 	// 通过反射获取字段值
 	v := reflect.ValueOf(data).Elem()
 	id := v.FieldByName("ID")
-	print(id.IsValid())
-	// 判断字段是否为空
-	return id.IsValid() && id.Uint() > 0
+	// 判断字段是否为空，ID 可能是 uint 也可能是 int
+	return id.IsValid() && !id.IsZero()
 }
 
 // Insert
 // 通过范型实现通用 Insert 函数
-func Insert[T any](data T) error {
-	res := DB.Create(&data)
+func Insert[T any](ctx context.Context, data T) error {
+	res := GetDB(ctx).Create(&data)
 	if res.Error != nil {
 		return res.Error
 	}
 	return nil
 }
 
-func InsertMany[T any](data []T) error {
-	res := DB.Create(&data)
+func InsertMany[T any](ctx context.Context, data []T) error {
+	res := GetDB(ctx).Create(&data)
 	if res.Error != nil {
 		return res.Error
 	}
 	return nil
 }
 
-func InsertManyWithTransaction[T any](data []T) error {
-	tx := DB.Begin()
-	res := tx.Create(&data)
+// Update 通过范型实现通用 Update 函数
+func Update[T any](ctx context.Context, data T) error {
+	res := GetDB(ctx).Save(&data)
 	if res.Error != nil {
-		tx.Rollback()
 		return res.Error
 	}
-	tx.Commit()
 	return nil
 }
 
-// Update 通过范型实现通用 Update 函数
-func Update[T any](data T) error {
-	res := DB.Save(&data)
+func Delete[T any](ctx context.Context, data T) error {
+	res := GetDB(ctx).Delete(&data)
 	if res.Error != nil {
 		return res.Error
 	}
 	return nil
 }
 
-func Delete[T any](data T) error {
-	res := DB.Delete(&data)
+// UpdateSingleColumn
+// 通过范型实现通用 UpdateSingleColumn 函数
+func UpdateSingleColumn[T any](ctx context.Context, data T, column string, value interface{}) error {
+	res := GetDB(ctx).Model(&data).Update(column, value)
 	if res.Error != nil {
 		return res.Error
 	}
 	return nil
 }
 
-// UpdateSingleColumn
-// 通过范型实现通用 UpdateSingleColumn 函数
-func UpdateSingleColumn[T any](data T, column string, value interface{}) error {
-	res := DB.Model(&data).Update(column, value)
+// UpdateWhere 按条件更新多列，返回受影响的行数
+func UpdateWhere[T any](ctx context.Context, values map[string]interface{}, query interface{}, args ...interface{}) (int64, error) {
+	var obj T
+	res := GetDB(ctx).Model(&obj).Where(query, args...).Updates(values)
 	if res.Error != nil {
-		return res.Error
+		return 0, res.Error
 	}
-	return nil
+	return res.RowsAffected, nil
+}
+
+// Exec 执行原生 SQL，返回受影响的行数
+func Exec(ctx context.Context, sql string, args ...interface{}) (int64, error) {
+	res := GetDB(ctx).Exec(sql, args...)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	return res.RowsAffected, nil
+}
+
+// DeleteWhere 按条件删除，有 DeletedAt 字段的模型为软删除，返回受影响的行数
+func DeleteWhere[T any](ctx context.Context, query interface{}, args ...interface{}) (int64, error) {
+	var obj T
+	res := GetDB(ctx).Where(query, args...).Delete(&obj)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	return res.RowsAffected, nil
 }
 
 // FindOne 查询一条数据
-func FindOne[T any](conditions ...interface{}) (*T, error) {
+func FindOne[T any](ctx context.Context, conditions ...interface{}) (*T, error) {
 	var obj T
 	// 这里不使用 `Take()` 方法，因为 `Take()` 方法在没有找到数据时会返回 ErrRecordNotFound 错误
-	res := DB.Take(&obj, conditions...)
+	res := GetDB(ctx).Take(&obj, conditions...)
 	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
 		return nil, nil
 	}
@@ -104,9 +141,9 @@ func FindOne[T any](conditions ...interface{}) (*T, error) {
 }
 
 // First 查询第一条数据
-func First[T any](conditions ...interface{}) (*T, error) {
+func First[T any](ctx context.Context, conditions ...interface{}) (*T, error) {
 	var obj T
-	res := DB.Take(&obj, conditions...)
+	res := GetDB(ctx).Take(&obj, conditions...)
 	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
 		return nil, nil
 	}
@@ -117,9 +154,9 @@ func First[T any](conditions ...interface{}) (*T, error) {
 }
 
 // FindAll 查询所有数据
-func FindAll[T any](conditions ...interface{}) ([]T, error) {
+func FindAll[T any](ctx context.Context, conditions ...interface{}) ([]T, error) {
 	var objs []T
-	res := DB.Find(&objs, conditions...)
+	res := GetDB(ctx).Find(&objs, conditions...)
 	if res.Error != nil {
 		return nil, res.Error
 	}
@@ -127,9 +164,9 @@ func FindAll[T any](conditions ...interface{}) ([]T, error) {
 }
 
 // Query 执行原生 SQL 查询
-func Query[T any](sql string, args ...interface{}) ([]T, error) {
+func Query[T any](ctx context.Context, sql string, args ...interface{}) ([]T, error) {
 	var objs []T
-	res := DB.Raw(sql, args...).Scan(&objs)
+	res := GetDB(ctx).Raw(sql, args...).Scan(&objs)
 	if res.Error != nil {
 		return nil, res.Error
 	}
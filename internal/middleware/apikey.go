@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"sapphire-server/internal/domain"
+	"strings"
+)
+
+// apiKeyContextKey 通过 API key 认证时，密钥在上下文中的键
+const apiKeyContextKey = "apiKey"
+
+// AuthOrAPIKey 同时接受用户的 JWT 和 Authorization: ApiKey 头部中的机器密钥
+// 使用密钥时上下文中没有用户 ID，只能访问 Require 检查的接口，权限由密钥的 scope 决定
+func AuthOrAPIKey() gin.HandlerFunc {
+	auth := AuthMiddleware()
+	return func(c *gin.Context) {
+		key, ok := strings.CutPrefix(c.GetHeader("Authorization"), "ApiKey ")
+		if !ok {
+			auth(c)
+			return
+		}
+
+		apiKey, err := domain.AuthenticateAPIKey(c.Request.Context(), strings.TrimSpace(key))
+		if errors.Is(err, domain.ErrInvalidAPIKey) {
+			c.JSON(401, gin.H{"error": "无效的密钥"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			slog.Error("authenticate api key failed", "err", err)
+			c.JSON(503, gin.H{"error": "服务暂不可用"})
+			c.Abort()
+			return
+		}
+
+		c.Set(apiKeyContextKey, apiKey)
+		slog.Debug("Current API key", "id", apiKey.ID, "name", apiKey.Name)
+		c.Next()
+	}
+}
+
+// CurrentAPIKey 当前请求使用的密钥，使用 JWT 认证时返回 nil
+func CurrentAPIKey(c *gin.Context) *domain.APIKey {
+	apiKey, _ := c.Keys[apiKeyContextKey].(*domain.APIKey)
+	return apiKey
+}
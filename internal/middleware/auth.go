@@ -3,7 +3,10 @@ package middleware
 import (
 	"github.com/gin-gonic/gin"
 	"log/slog"
+	"sapphire-server/internal/domain"
 	"sapphire-server/pkg/util"
+	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -18,23 +21,78 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		// 从 Authorization 头部中获取 JWT
-		tokenString := strings.Split(authHeader, " ")[1]
+		tokenString, ok := strings.CutPrefix(authHeader, "Bearer ")
+		if !ok || tokenString == "" {
+			c.JSON(401, gin.H{"error": "未登录"})
+			c.Abort()
+			return
+		}
+
+		// 解析 JWT，只接受 access token
+		claims, err := util.ParseJWT(tokenString, util.TokenTypeAccess)
+		if err != nil {
+			c.JSON(401, gin.H{"error": "请重新登录"})
+			c.Abort()
+			return
+		}
 
-		// 解析 JWT
-		userId, err := util.ParseJWT(tokenString)
+		// 检查 token 是否已被吊销，Redis 不可用时拒绝请求
+		revoked, err := domain.IsTokenRevoked(c.Request.Context(), claims.RegisteredClaims.ID)
 		if err != nil {
+			slog.Error("check token revocation failed", "err", err)
+			c.JSON(503, gin.H{"error": "服务暂不可用"})
+			c.Abort()
+			return
+		}
+		if revoked {
 			c.JSON(401, gin.H{"error": "请重新登录"})
 			c.Abort()
 			return
 		}
 
-		// 将用户ID保存到Header中
-		c.Set("id", userId)
+		// 将用户ID保存到上下文中
+		c.Set("id", claims.ID)
+		c.Set("claims", claims)
 
-		curID, _ := c.Get("id")
-		slog.Info("Current User ID: ", curID)
+		slog.Debug("Current User", "id", claims.ID)
 
 		// 继续处理请求
 		c.Next()
 	}
 }
+
+// StreamTokenCookie 保存事件流 token 的 cookie
+const StreamTokenCookie = "sapphire_stream_token"
+
+// DatasetStreamAuth 用于数据集的 Server-Sent Events 接口，EventSource 不能设置 Authorization 头部
+// 可以通过查询参数 token 或 cookie 传入该数据集的事件流 token，都没有时与 AuthOrAPIKey 相同
+func DatasetStreamAuth() gin.HandlerFunc {
+	fallback := AuthOrAPIKey()
+	return func(c *gin.Context) {
+		tokenString := c.Query("token")
+		if tokenString == "" {
+			tokenString, _ = c.Cookie(StreamTokenCookie)
+		}
+		if tokenString == "" {
+			fallback(c)
+			return
+		}
+
+		datasetID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid dataset id"})
+			c.Abort()
+			return
+		}
+		// 只接受签发给该数据集的事件流 token，access token 不能放在地址中
+		claims, err := util.ParseJWT(tokenString, util.TokenTypeStream)
+		if err != nil || !slices.Contains(claims.Audience, domain.DatasetStreamAudience(uint(datasetID))) {
+			c.JSON(401, gin.H{"error": "请重新登录"})
+			c.Abort()
+			return
+		}
+
+		c.Set("id", claims.ID)
+		c.Next()
+	}
+}
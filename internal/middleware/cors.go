@@ -8,7 +8,8 @@ func Cors() gin.HandlerFunc {
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS, PATCH")
-		if c.Request.Method == "OPTIONS" {
+		// 只拦截浏览器的预检请求，其余 OPTIONS 交给路由，如 tus 的能力查询
+		if c.Request.Method == "OPTIONS" && c.GetHeader("Access-Control-Request-Method") != "" {
 			c.AbortWithStatus(204)
 			return
 		}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"sapphire-server/internal/dao"
+	"sapphire-server/internal/domain"
+	"strconv"
+)
+
+// DatasetResolver 从请求中读取权限检查针对的数据集 ID
+type DatasetResolver func(c *gin.Context) (uint, error)
+
+// DatasetParam 从路径参数中读取数据集 ID
+func DatasetParam(name string) DatasetResolver {
+	return func(c *gin.Context) (uint, error) {
+		id, err := strconv.ParseUint(c.Param(name), 10, 64)
+		if err != nil {
+			return 0, errors.New("invalid dataset id")
+		}
+		return uint(id), nil
+	}
+}
+
+// ImageParam 从路径参数中读取图片 ID，返回图片所属的数据集 ID
+func ImageParam(name string) DatasetResolver {
+	return func(c *gin.Context) (uint, error) {
+		id, err := strconv.ParseUint(c.Param(name), 10, 64)
+		if err != nil {
+			return 0, errors.New("invalid image id")
+		}
+		img, err := dao.FindOne[domain.ImgDataset](c.Request.Context(), uint(id))
+		if err != nil {
+			return 0, err
+		}
+		if img == nil {
+			return 0, errors.New("image not found")
+		}
+		return img.DatasetId, nil
+	}
+}
+
+// UploadJobParam 从路径参数中读取上传任务 ID，返回任务所属的数据集 ID
+func UploadJobParam(name string) DatasetResolver {
+	return func(c *gin.Context) (uint, error) {
+		id, err := strconv.ParseUint(c.Param(name), 10, 64)
+		if err != nil {
+			return 0, errors.New("invalid job id")
+		}
+		job, err := domain.GetUploadJob(c.Request.Context(), uint(id))
+		if err != nil {
+			return 0, err
+		}
+		if job == nil {
+			return 0, errors.New("upload job not found")
+		}
+		return job.DatasetID, nil
+	}
+}
+
+// Require 检查当前用户是否拥有权限，需要放在 AuthMiddleware 或 AuthOrAPIKey 之后
+// 数据集级权限默认从路径参数 id 中读取数据集 ID，可以传入 resolver 修改
+func Require(perm domain.Permission, resolver ...DatasetResolver) gin.HandlerFunc {
+	resolve := DatasetParam("id")
+	if len(resolver) > 0 {
+		resolve = resolver[0]
+	}
+
+	return func(c *gin.Context) {
+		var datasetID uint
+		if domain.IsDatasetPermission(perm) {
+			id, err := resolve(c)
+			if err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+			datasetID = id
+		}
+
+		if !Check(c, perm, datasetID) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Check 在 handler 中检查权限，数据集 ID 不在路径中时使用，没有权限时写入响应并返回 false
+// 使用 API key 时检查密钥的 scope，数据集级的 scope 只对密钥允许的数据集生效
+func Check(c *gin.Context, perm domain.Permission, datasetID uint) bool {
+	if apiKey := CurrentAPIKey(c); apiKey != nil {
+		if !apiKey.HasScope(perm) || (domain.IsDatasetPermission(perm) && !apiKey.AllowsDataset(datasetID)) {
+			slog.Info("api key scope denied", "keyID", apiKey.ID, "datasetID", datasetID, "perm", perm)
+			c.JSON(403, gin.H{"error": "没有权限"})
+			return false
+		}
+		return true
+	}
+
+	userID, ok := c.Keys["id"].(uint)
+	if !ok {
+		c.JSON(401, gin.H{"error": "未登录"})
+		return false
+	}
+
+	err := domain.Authorize(c.Request.Context(), userID, datasetID, perm)
+	if errors.Is(err, domain.ErrPermissionDenied) {
+		slog.Info("permission denied", "userID", userID, "datasetID", datasetID, "perm", perm)
+		c.JSON(403, gin.H{"error": "没有权限"})
+		return false
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
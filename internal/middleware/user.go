@@ -3,9 +3,11 @@ package middleware
 import (
 	"github.com/gin-gonic/gin"
 	"log/slog"
+	"sapphire-server/internal/domain"
 	"strconv"
 )
 
+// UserIDMiddleware 允许平台管理员通过 Sapphire-User-ID 头部以其他用户身份调试接口
 func UserIDMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var err error
@@ -15,7 +17,6 @@ func UserIDMiddleware() gin.HandlerFunc {
 			c.Next()
 			return
 		}
-		slog.Info(customUserHeader)
 
 		userIDStr := customUserHeader
 		// 读取为uint类型
@@ -26,19 +27,14 @@ func UserIDMiddleware() gin.HandlerFunc {
 			return
 		}
 		userID := uint(userID64)
-		slog.Info("Read ID from header: ", userID)
+		slog.Info("Read ID from header", "userID", userID)
 
-		_, exists := c.Get("id")
-		if exists {
-			slog.Info("User ID already exists, Update it: ", userID)
-			c.Set("id", userID)
-		} else {
-			slog.Info("User ID not exists, Set it: ", userID)
-			c.Set("id", userID)
+		// 只有管理员可以切换身份
+		if !Check(c, domain.PermUserManage, 0) {
+			c.Abort()
+			return
 		}
-
-		curID, _ := c.Get("id")
-		slog.Info("Current User ID: ", curID)
+		c.Set("id", userID)
 
 		// 继续处理请求
 		c.Next()
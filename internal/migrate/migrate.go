@@ -0,0 +1,252 @@
+package migrate
+
+import (
+	"embed"
+	"fmt"
+	"gorm.io/gorm"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// 迁移文件命名规则：<版本号>_<名称>.<up|down>.sql
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// 多个实例同时执行迁移时用于互斥的 advisory lock key
+const lockKey = 7301245
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// SchemaMigration 已执行的迁移记录
+type SchemaMigration struct {
+	Version   int64     `gorm:"column:version;primaryKey"`
+	Name      string    `gorm:"column:name"`
+	AppliedAt time.Time `gorm:"column:applied_at"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Status 迁移状态
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"appliedAt"`
+}
+
+// Load 读取内嵌的所有迁移，按版本号升序
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		matches := fileNamePattern.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+		version, _ := strconv.ParseInt(matches[1], 10, 64)
+		content, err := migrationFS.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		}
+		if m.Name != matches[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names: %s, %s", version, m.Name, matches[2])
+		}
+		if matches[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// ensureTable 创建 schema_migrations 表
+func ensureTable(db *gorm.DB) error {
+	return db.Exec(`CREATE TABLE IF NOT EXISTS "schema_migrations"
+(
+    "version"    bigint       NOT NULL,
+    "name"       VARCHAR(255) NOT NULL,
+    "applied_at" timestamptz  NOT NULL,
+    PRIMARY KEY ("version")
+)`).Error
+}
+
+func appliedMigrations(db *gorm.DB) (map[int64]SchemaMigration, error) {
+	var records []SchemaMigration
+	err := db.Order("version").Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]SchemaMigration)
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// Up 执行所有未执行的迁移，返回本次执行的迁移
+func Up(db *gorm.DB) ([]Migration, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	err = ensureTable(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		// 每个迁移在单独的事务中执行，失败时只回滚当前迁移
+		applied := false
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Exec("SELECT pg_advisory_xact_lock(?)", lockKey).Error
+			if err != nil {
+				return err
+			}
+			// 拿到锁之后再检查一次，避免其他实例已经执行过
+			var count int64
+			err = tx.Model(&SchemaMigration{}).Where("version = ?", m.Version).Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return nil
+			}
+
+			slog.Info("Applying migration", "version", m.Version, "name", m.Name)
+			err = tx.Exec(m.Up).Error
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			applied = true
+			return tx.Create(&SchemaMigration{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return done, err
+		}
+		if applied {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// Down 回滚最近执行的 steps 个迁移，返回本次回滚的迁移
+func Down(db *gorm.DB, steps int) ([]Migration, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	err = ensureTable(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		rolledBack := false
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Exec("SELECT pg_advisory_xact_lock(?)", lockKey).Error
+			if err != nil {
+				return err
+			}
+			var count int64
+			err = tx.Model(&SchemaMigration{}).Where("version = ?", m.Version).Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				return nil
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down script", m.Version, m.Name)
+			}
+
+			slog.Info("Rolling back migration", "version", m.Version, "name", m.Name)
+			err = tx.Exec(m.Down).Error
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			rolledBack = true
+			return tx.Delete(&SchemaMigration{}, "version = ?", m.Version).Error
+		})
+		if err != nil {
+			return done, err
+		}
+		if rolledBack {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// List 列出所有迁移及其执行状态
+func List(db *gorm.DB) ([]Status, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	err = ensureTable(db)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []Status
+	for _, m := range migrations {
+		status := Status{
+			Version: m.Version,
+			Name:    m.Name,
+		}
+		if record, ok := applied[m.Version]; ok {
+			status.Applied = true
+			appliedAt := record.AppliedAt
+			status.AppliedAt = &appliedAt
+		}
+		res = append(res, status)
+	}
+	return res, nil
+}
@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS "tasks";
+DROP TABLE IF EXISTS "sams";
+DROP TABLE IF EXISTS "scores";
+DROP TABLE IF EXISTS "discussions";
+DROP TABLE IF EXISTS "messages";
+DROP TABLE IF EXISTS "annotation_users";
+DROP TABLE IF EXISTS "annotations";
+DROP TABLE IF EXISTS "img_datasets";
+DROP TABLE IF EXISTS "dataset_users";
+DROP TABLE IF EXISTS "datasets";
+DROP TABLE IF EXISTS "dataset_tags";
+DROP TABLE IF EXISTS "dataset_types";
+DROP TABLE IF EXISTS "users";
+DROP TABLE IF EXISTS "user_roles";
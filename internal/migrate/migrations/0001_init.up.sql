@@ -0,0 +1,211 @@
+-- 初始表结构，与 internal/domain 下的 GORM 模型保持一致
+-- 使用 IF NOT EXISTS 以便在手动维护过的旧库上也能执行
+
+CREATE TABLE IF NOT EXISTS "user_roles"
+(
+    "id"        serial       NOT NULL,
+    "role_name" VARCHAR(255) NOT NULL,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_user_roles_role_name" ON "user_roles" ("role_name");
+INSERT INTO "user_roles" ("role_name")
+VALUES ('ADMIN'),
+       ('USER')
+ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS "users"
+(
+    "id"          bigserial NOT NULL,
+    "created_at"  timestamptz,
+    "updated_at"  timestamptz,
+    "deleted_at"  timestamptz,
+    "name"        text,
+    "password"    text,
+    "email"       text,
+    "uid"         text,
+    "description" text,
+    "avatar"      text,
+    "role"        bigint,
+    "score"       bigint,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_users_deleted_at" ON "users" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "dataset_types"
+(
+    "id"          bigserial NOT NULL,
+    "created_at"  timestamptz,
+    "updated_at"  timestamptz,
+    "deleted_at"  timestamptz,
+    "name"        text,
+    "description" text,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_dataset_types_deleted_at" ON "dataset_types" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "dataset_tags"
+(
+    "id"         bigserial NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "tag"        text,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_dataset_tags_deleted_at" ON "dataset_tags" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "datasets"
+(
+    "id"         bigserial NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "name"       text,
+    "creator_id" bigint,
+    "type_id"    bigint,
+    "tags"       text,
+    "size"       bigint,
+    "end_time"   timestamptz,
+    PRIMARY KEY ("id")
+);
+-- 以下字段是后来加到模型里的，旧库里可能没有
+ALTER TABLE "datasets" ADD COLUMN IF NOT EXISTS "description" text;
+ALTER TABLE "datasets" ADD COLUMN IF NOT EXISTS "cover" text;
+ALTER TABLE "datasets" ADD COLUMN IF NOT EXISTS "format" text;
+ALTER TABLE "datasets" ADD COLUMN IF NOT EXISTS "is_public" boolean;
+CREATE INDEX IF NOT EXISTS "idx_datasets_deleted_at" ON "datasets" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_datasets_creator_id" ON "datasets" ("creator_id");
+
+CREATE TABLE IF NOT EXISTS "dataset_users"
+(
+    "id"         bigserial NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "user_id"    bigint,
+    "dataset_id" bigint,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_dataset_users_deleted_at" ON "dataset_users" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_dataset_users_user_dataset" ON "dataset_users" ("user_id", "dataset_id");
+
+CREATE TABLE IF NOT EXISTS "img_datasets"
+(
+    "id"            bigserial NOT NULL,
+    "created_at"    timestamptz,
+    "updated_at"    timestamptz,
+    "deleted_at"    timestamptz,
+    "img_url"       text,
+    "dataset_id"    bigint,
+    "status"        bigint DEFAULT 0,
+    "embedding_url" text,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_img_datasets_deleted_at" ON "img_datasets" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_img_datasets_dataset_status" ON "img_datasets" ("dataset_id", "status");
+
+CREATE TABLE IF NOT EXISTS "annotations"
+(
+    "id"              bigserial NOT NULL,
+    "created_at"      timestamptz,
+    "updated_at"      timestamptz,
+    "deleted_at"      timestamptz,
+    "status"          bigint DEFAULT 0,
+    "content"         jsonb,
+    "dataset_id"      bigint,
+    "replica_count"   bigint,
+    "qualified_count" bigint,
+    "delivered_count" bigint,
+    PRIMARY KEY ("id")
+);
+-- 以下字段是后来加到模型里的，旧库里可能没有
+ALTER TABLE "annotations" ADD COLUMN IF NOT EXISTS "image_id" bigint;
+ALTER TABLE "annotations" ADD COLUMN IF NOT EXISTS "user_id" bigint;
+ALTER TABLE "annotations" ADD COLUMN IF NOT EXISTS "is_qualified" boolean;
+CREATE INDEX IF NOT EXISTS "idx_annotations_deleted_at" ON "annotations" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_annotations_dataset_id" ON "annotations" ("dataset_id");
+CREATE INDEX IF NOT EXISTS "idx_annotations_image_id" ON "annotations" ("image_id");
+CREATE INDEX IF NOT EXISTS "idx_annotations_user_id" ON "annotations" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "annotation_users"
+(
+    "id"            bigserial NOT NULL,
+    "annotation_id" bigint,
+    "user_id"       bigint,
+    "status"        bigint DEFAULT 0,
+    "result"        text,
+    PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "messages"
+(
+    "id"          bigserial NOT NULL,
+    "created_at"  timestamptz,
+    "updated_at"  timestamptz,
+    "deleted_at"  timestamptz,
+    "creator_id"  bigint,
+    "receiver_id" bigint,
+    "content"     text,
+    "title"       text,
+    "type"        bigint,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_messages_deleted_at" ON "messages" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_messages_receiver_id" ON "messages" ("receiver_id");
+
+CREATE TABLE IF NOT EXISTS "discussions"
+(
+    "id"         bigserial NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "title"      text,
+    "content"    text,
+    "dataset_id" bigint,
+    "user_id"    bigint,
+    "reply_id"   bigint,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_discussions_deleted_at" ON "discussions" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_discussions_dataset_id" ON "discussions" ("dataset_id");
+
+CREATE TABLE IF NOT EXISTS "scores"
+(
+    "id"         bigserial NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "dataset_id" bigint,
+    "img_id"     bigint,
+    "user_id"    bigint,
+    "score"      bigint,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_scores_deleted_at" ON "scores" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_scores_user_id" ON "scores" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "sams"
+(
+    "id"         bigserial NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "onnxname"   text,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_sams_deleted_at" ON "sams" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "tasks"
+(
+    "id"            bigserial NOT NULL,
+    "created_at"    timestamptz,
+    "updated_at"    timestamptz,
+    "deleted_at"    timestamptz,
+    "img_url"       text,
+    "embedding_url" text,
+    "status"        bigint DEFAULT 0,
+    "onnx_id"       bigint,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_tasks_deleted_at" ON "tasks" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_tasks_status" ON "tasks" ("status");
@@ -0,0 +1,2 @@
+ALTER TABLE "datasets" ALTER COLUMN "is_public" DROP DEFAULT;
+ALTER TABLE "dataset_users" DROP COLUMN IF EXISTS "role";
@@ -0,0 +1,6 @@
+-- 数据集成员角色，已有成员保持标注员权限
+ALTER TABLE "dataset_users" ADD COLUMN IF NOT EXISTS "role" VARCHAR(32) NOT NULL DEFAULT 'annotator';
+
+-- 之前所有数据集都可以被任何人查看和加入，迁移后保持公开
+UPDATE "datasets" SET "is_public" = true;
+ALTER TABLE "datasets" ALTER COLUMN "is_public" SET DEFAULT true;
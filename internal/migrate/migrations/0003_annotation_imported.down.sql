@@ -0,0 +1 @@
+ALTER TABLE "annotations" DROP COLUMN IF EXISTS "imported";
@@ -0,0 +1,2 @@
+-- 标记从压缩包导入的标注
+ALTER TABLE "annotations" ADD COLUMN IF NOT EXISTS "imported" boolean NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "upload_job_files";
+DROP TABLE IF EXISTS "upload_jobs";
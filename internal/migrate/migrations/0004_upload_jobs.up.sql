@@ -0,0 +1,45 @@
+-- 后台上传任务及其中的文件
+CREATE TABLE IF NOT EXISTS "upload_jobs"
+(
+    "id"              bigserial NOT NULL,
+    "created_at"      timestamptz,
+    "updated_at"      timestamptz,
+    "deleted_at"      timestamptz,
+    "dataset_id"      bigint,
+    "user_id"         bigint,
+    "status"          text,
+    "error"           text,
+    "archive_name"    text,
+    "workspace"       text,
+    "node"            text,
+    "total_files"     bigint NOT NULL DEFAULT 0,
+    "processed_files" bigint NOT NULL DEFAULT 0,
+    "accepted_files"  bigint NOT NULL DEFAULT 0,
+    "rejected_files"  bigint NOT NULL DEFAULT 0,
+    "failed_files"    bigint NOT NULL DEFAULT 0,
+    "started_at"      timestamptz,
+    "finished_at"     timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_upload_jobs_deleted_at" ON "upload_jobs" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_upload_jobs_node_status" ON "upload_jobs" ("node", "status");
+
+CREATE TABLE IF NOT EXISTS "upload_job_files"
+(
+    "id"         bigserial NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "job_id"     bigint,
+    "name"       text,
+    "path"       text,
+    "ext"        text,
+    "status"     text,
+    "reason"     text,
+    "url"        text,
+    "object_key" text,
+    "image_id"   bigint,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_upload_job_files_deleted_at" ON "upload_job_files" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_upload_job_files_job_id_status" ON "upload_job_files" ("job_id", "status");
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS "idx_img_datasets_derivative_status";
+ALTER TABLE "img_datasets" DROP COLUMN IF EXISTS "derivative_claimed_at";
+ALTER TABLE "img_datasets" DROP COLUMN IF EXISTS "derivative_status";
+ALTER TABLE "img_datasets" DROP COLUMN IF EXISTS "preview_url";
+ALTER TABLE "img_datasets" DROP COLUMN IF EXISTS "thumbnails";
+ALTER TABLE "img_datasets" DROP COLUMN IF EXISTS "thumbnail_url";
+ALTER TABLE "img_datasets" DROP COLUMN IF EXISTS "bytes";
+ALTER TABLE "img_datasets" DROP COLUMN IF EXISTS "format";
+ALTER TABLE "img_datasets" DROP COLUMN IF EXISTS "height";
+ALTER TABLE "img_datasets" DROP COLUMN IF EXISTS "width";
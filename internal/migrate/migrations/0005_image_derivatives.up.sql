@@ -0,0 +1,11 @@
+-- 图片的宽高、格式、大小和缩略图，已有的图片 derivative_status 为 0，由后台补齐
+ALTER TABLE "img_datasets" ADD COLUMN IF NOT EXISTS "width" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "img_datasets" ADD COLUMN IF NOT EXISTS "height" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "img_datasets" ADD COLUMN IF NOT EXISTS "format" text NOT NULL DEFAULT '';
+ALTER TABLE "img_datasets" ADD COLUMN IF NOT EXISTS "bytes" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "img_datasets" ADD COLUMN IF NOT EXISTS "thumbnail_url" text NOT NULL DEFAULT '';
+ALTER TABLE "img_datasets" ADD COLUMN IF NOT EXISTS "thumbnails" jsonb;
+ALTER TABLE "img_datasets" ADD COLUMN IF NOT EXISTS "preview_url" text NOT NULL DEFAULT '';
+ALTER TABLE "img_datasets" ADD COLUMN IF NOT EXISTS "derivative_status" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "img_datasets" ADD COLUMN IF NOT EXISTS "derivative_claimed_at" timestamptz;
+CREATE INDEX IF NOT EXISTS "idx_img_datasets_derivative_status" ON "img_datasets" ("derivative_status");
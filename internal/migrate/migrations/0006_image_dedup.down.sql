@@ -0,0 +1,14 @@
+ALTER TABLE "upload_job_files" DROP COLUMN IF EXISTS "distance";
+ALTER TABLE "upload_job_files" DROP COLUMN IF EXISTS "duplicate_file";
+ALTER TABLE "upload_job_files" DROP COLUMN IF EXISTS "duplicate_of";
+ALTER TABLE "upload_job_files" DROP COLUMN IF EXISTS "duplicate";
+ALTER TABLE "upload_job_files" DROP COLUMN IF EXISTS "phash";
+ALTER TABLE "upload_job_files" DROP COLUMN IF EXISTS "sha256";
+ALTER TABLE "upload_jobs" DROP COLUMN IF EXISTS "near_duplicate_files";
+ALTER TABLE "upload_jobs" DROP COLUMN IF EXISTS "duplicate_files";
+ALTER TABLE "datasets" DROP COLUMN IF EXISTS "near_duplicate_threshold";
+ALTER TABLE "datasets" DROP COLUMN IF EXISTS "duplicate_policy";
+DROP INDEX IF EXISTS "idx_img_datasets_dataset_id_sha256";
+DROP INDEX IF EXISTS "idx_img_datasets_sha256";
+ALTER TABLE "img_datasets" DROP COLUMN IF EXISTS "phash";
+ALTER TABLE "img_datasets" DROP COLUMN IF EXISTS "sha256";
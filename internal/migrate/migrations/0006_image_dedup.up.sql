@@ -0,0 +1,17 @@
+-- 图片的内容哈希和感知哈希，已有的图片由生成缩略图的后台任务补齐
+ALTER TABLE "img_datasets" ADD COLUMN IF NOT EXISTS "sha256" text NOT NULL DEFAULT '';
+ALTER TABLE "img_datasets" ADD COLUMN IF NOT EXISTS "phash" text NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS "idx_img_datasets_sha256" ON "img_datasets" ("sha256");
+CREATE INDEX IF NOT EXISTS "idx_img_datasets_dataset_id_sha256" ON "img_datasets" ("dataset_id", "sha256");
+-- 数据集的去重策略，默认 allow 与之前的行为一致
+ALTER TABLE "datasets" ADD COLUMN IF NOT EXISTS "duplicate_policy" text NOT NULL DEFAULT 'allow';
+ALTER TABLE "datasets" ADD COLUMN IF NOT EXISTS "near_duplicate_threshold" bigint NOT NULL DEFAULT 0;
+-- 上传任务中的重复文件
+ALTER TABLE "upload_jobs" ADD COLUMN IF NOT EXISTS "duplicate_files" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "upload_jobs" ADD COLUMN IF NOT EXISTS "near_duplicate_files" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "upload_job_files" ADD COLUMN IF NOT EXISTS "sha256" text NOT NULL DEFAULT '';
+ALTER TABLE "upload_job_files" ADD COLUMN IF NOT EXISTS "phash" text NOT NULL DEFAULT '';
+ALTER TABLE "upload_job_files" ADD COLUMN IF NOT EXISTS "duplicate" text NOT NULL DEFAULT '';
+ALTER TABLE "upload_job_files" ADD COLUMN IF NOT EXISTS "duplicate_of" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "upload_job_files" ADD COLUMN IF NOT EXISTS "duplicate_file" text NOT NULL DEFAULT '';
+ALTER TABLE "upload_job_files" ADD COLUMN IF NOT EXISTS "distance" bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS "idx_scores_img_id";
+DROP INDEX IF EXISTS "idx_annotation_users_annotation_id";
+DROP INDEX IF EXISTS "idx_img_datasets_dataset_position";
+ALTER TABLE "img_datasets" DROP COLUMN IF EXISTS "position";
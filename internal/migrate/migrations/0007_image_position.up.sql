@@ -0,0 +1,7 @@
+-- 图片在数据集中的顺序，已有的图片按 ID 排列
+ALTER TABLE "img_datasets" ADD COLUMN IF NOT EXISTS "position" bigint NOT NULL DEFAULT 0;
+UPDATE "img_datasets" SET "position" = "id" WHERE "position" = 0;
+CREATE INDEX IF NOT EXISTS "idx_img_datasets_dataset_position" ON "img_datasets" ("dataset_id", "position");
+-- 按图片删除标注和评分时使用
+CREATE INDEX IF NOT EXISTS "idx_annotation_users_annotation_id" ON "annotation_users" ("annotation_id");
+CREATE INDEX IF NOT EXISTS "idx_scores_img_id" ON "scores" ("img_id");
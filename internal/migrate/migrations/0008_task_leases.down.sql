@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS "idx_tasks_status_lease";
+DROP INDEX IF EXISTS "idx_tasks_active_image";
+ALTER TABLE "tasks" DROP COLUMN IF EXISTS "finished_at";
+ALTER TABLE "tasks" DROP COLUMN IF EXISTS "error";
+ALTER TABLE "tasks" DROP COLUMN IF EXISTS "max_attempts";
+ALTER TABLE "tasks" DROP COLUMN IF EXISTS "attempts";
+ALTER TABLE "tasks" DROP COLUMN IF EXISTS "heartbeat_at";
+ALTER TABLE "tasks" DROP COLUMN IF EXISTS "lease_expires_at";
+ALTER TABLE "tasks" DROP COLUMN IF EXISTS "lease_token";
+ALTER TABLE "tasks" DROP COLUMN IF EXISTS "worker_id";
+ALTER TABLE "tasks" DROP COLUMN IF EXISTS "image_id";
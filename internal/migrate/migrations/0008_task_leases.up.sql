@@ -0,0 +1,16 @@
+-- 嵌入任务的租约、心跳和重试次数
+ALTER TABLE "tasks" ADD COLUMN IF NOT EXISTS "image_id" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "tasks" ADD COLUMN IF NOT EXISTS "worker_id" text NOT NULL DEFAULT '';
+ALTER TABLE "tasks" ADD COLUMN IF NOT EXISTS "lease_token" text NOT NULL DEFAULT '';
+ALTER TABLE "tasks" ADD COLUMN IF NOT EXISTS "lease_expires_at" timestamptz;
+ALTER TABLE "tasks" ADD COLUMN IF NOT EXISTS "heartbeat_at" timestamptz;
+ALTER TABLE "tasks" ADD COLUMN IF NOT EXISTS "attempts" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "tasks" ADD COLUMN IF NOT EXISTS "max_attempts" bigint NOT NULL DEFAULT 3;
+ALTER TABLE "tasks" ADD COLUMN IF NOT EXISTS "error" text NOT NULL DEFAULT '';
+ALTER TABLE "tasks" ADD COLUMN IF NOT EXISTS "finished_at" timestamptz;
+-- 旧版本中被认领后没有结果的任务没有租约，重新排队
+UPDATE "tasks" SET "status" = 0 WHERE "status" = 1 AND "lease_expires_at" IS NULL;
+-- 每张图片最多一个排队中或执行中的任务
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_tasks_active_image" ON "tasks" ("image_id")
+    WHERE "image_id" > 0 AND "status" IN (0, 1) AND "deleted_at" IS NULL;
+CREATE INDEX IF NOT EXISTS "idx_tasks_status_lease" ON "tasks" ("status", "lease_expires_at");
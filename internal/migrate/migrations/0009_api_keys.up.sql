@@ -0,0 +1,19 @@
+-- 管理员签发给 worker 等机器的 API key，只保存哈希
+CREATE TABLE IF NOT EXISTS "api_keys"
+(
+    "id"           bigserial NOT NULL,
+    "created_at"   timestamptz,
+    "updated_at"   timestamptz,
+    "deleted_at"   timestamptz,
+    "name"         text      NOT NULL DEFAULT '',
+    "prefix"       text      NOT NULL DEFAULT '',
+    "key_hash"     text      NOT NULL,
+    "scopes"       jsonb     NOT NULL DEFAULT '[]',
+    "creator_id"   bigint    NOT NULL DEFAULT 0,
+    "expires_at"   timestamptz,
+    "revoked_at"   timestamptz,
+    "last_used_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_api_keys_key_hash" ON "api_keys" ("key_hash");
+CREATE INDEX IF NOT EXISTS "idx_api_keys_deleted_at" ON "api_keys" ("deleted_at");
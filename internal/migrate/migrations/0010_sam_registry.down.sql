@@ -0,0 +1,10 @@
+ALTER TABLE "img_datasets" DROP COLUMN IF EXISTS "embedding_sam_id";
+ALTER TABLE "datasets" DROP COLUMN IF EXISTS "sam_id";
+DROP INDEX IF EXISTS "idx_sams_name_version";
+ALTER TABLE "sams" DROP COLUMN IF EXISTS "deprecated_at";
+ALTER TABLE "sams" DROP COLUMN IF EXISTS "status";
+ALTER TABLE "sams" DROP COLUMN IF EXISTS "storage_url";
+ALTER TABLE "sams" DROP COLUMN IF EXISTS "input_size";
+ALTER TABLE "sams" DROP COLUMN IF EXISTS "checksum";
+ALTER TABLE "sams" DROP COLUMN IF EXISTS "version";
+ALTER TABLE "sams" DROP COLUMN IF EXISTS "name";
@@ -0,0 +1,18 @@
+-- 嵌入模型注册表，数据集可以选用模型，图片记录生成嵌入的模型
+ALTER TABLE "sams" ADD COLUMN IF NOT EXISTS "name" text NOT NULL DEFAULT '';
+ALTER TABLE "sams" ADD COLUMN IF NOT EXISTS "version" text NOT NULL DEFAULT '';
+ALTER TABLE "sams" ADD COLUMN IF NOT EXISTS "checksum" text NOT NULL DEFAULT '';
+ALTER TABLE "sams" ADD COLUMN IF NOT EXISTS "input_size" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "sams" ADD COLUMN IF NOT EXISTS "storage_url" text NOT NULL DEFAULT '';
+ALTER TABLE "sams" ADD COLUMN IF NOT EXISTS "status" text NOT NULL DEFAULT 'active';
+ALTER TABLE "sams" ADD COLUMN IF NOT EXISTS "deprecated_at" timestamptz;
+-- 旧版本只有文件名，使用 ID 作为版本号避免冲突
+UPDATE "sams" SET "name" = COALESCE("onnxname", ''), "version" = "id"::text WHERE "name" = '';
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_sams_name_version" ON "sams" ("name", "version") WHERE "deleted_at" IS NULL;
+
+ALTER TABLE "datasets" ADD COLUMN IF NOT EXISTS "sam_id" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "img_datasets" ADD COLUMN IF NOT EXISTS "embedding_sam_id" bigint NOT NULL DEFAULT 0;
+-- 已完成的任务记录了使用的模型
+UPDATE "img_datasets" i SET "embedding_sam_id" = t."onnx_id"
+FROM "tasks" t
+WHERE t."image_id" = i."id" AND t."status" = 2 AND t."img_url" = i."img_url" AND i."embedding_sam_id" = 0;
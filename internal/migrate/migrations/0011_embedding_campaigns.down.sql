@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS "idx_tasks_campaign_id";
+ALTER TABLE "tasks" DROP COLUMN IF EXISTS "campaign_id";
+DROP TABLE IF EXISTS "embedding_campaigns";
@@ -0,0 +1,21 @@
+-- 更换模型后重新嵌入数据集的活动
+CREATE TABLE IF NOT EXISTS "embedding_campaigns"
+(
+    "id"              bigserial NOT NULL,
+    "created_at"      timestamptz,
+    "updated_at"      timestamptz,
+    "deleted_at"      timestamptz,
+    "dataset_id"      bigint    NOT NULL,
+    "sam_id"          bigint    NOT NULL,
+    "previous_sam_id" bigint    NOT NULL DEFAULT 0,
+    "creator_id"      bigint    NOT NULL DEFAULT 0,
+    "status"          text      NOT NULL DEFAULT 'running',
+    "total"           bigint    NOT NULL DEFAULT 0,
+    "finished_at"     timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_embedding_campaigns_dataset_id" ON "embedding_campaigns" ("dataset_id", "status");
+CREATE INDEX IF NOT EXISTS "idx_embedding_campaigns_deleted_at" ON "embedding_campaigns" ("deleted_at");
+
+ALTER TABLE "tasks" ADD COLUMN IF NOT EXISTS "campaign_id" bigint NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS "idx_tasks_campaign_id" ON "tasks" ("campaign_id", "status") WHERE "campaign_id" > 0;
@@ -0,0 +1,18 @@
+-- 标注中的 id 改回标签在数据集中的下标
+UPDATE "annotations" a
+SET "content" = (
+    SELECT coalesce(jsonb_agg(CASE WHEN l."id" IS NULL THEN e."mark" ELSE jsonb_set(e."mark", '{id}', to_jsonb(l."idx")) END ORDER BY e."ord"), '[]'::jsonb)
+    FROM jsonb_array_elements(a."content") WITH ORDINALITY AS e("mark", "ord")
+             LEFT JOIN (SELECT "id", "dataset_id", row_number() OVER (PARTITION BY "dataset_id" ORDER BY "position", "id") - 1 AS "idx"
+                        FROM "labels"
+                        WHERE "deleted_at" IS NULL) l ON l."dataset_id" = a."dataset_id" AND l."id" = (e."mark" ->> 'id')::bigint
+)
+WHERE jsonb_typeof(a."content") = 'array';
+
+UPDATE "datasets" d
+SET "tags" = (SELECT string_agg(l."name" || ',', '' ORDER BY l."position", l."id")
+              FROM "labels" l
+              WHERE l."dataset_id" = d."id" AND l."deleted_at" IS NULL)
+WHERE EXISTS (SELECT 1 FROM "labels" l WHERE l."dataset_id" = d."id" AND l."deleted_at" IS NULL);
+
+DROP TABLE IF EXISTS "labels";
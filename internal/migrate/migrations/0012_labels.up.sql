@@ -0,0 +1,47 @@
+-- 数据集的标签，替代 datasets.tags 中逗号分隔的类别名
+CREATE TABLE IF NOT EXISTS "labels"
+(
+    "id"         bigserial NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "dataset_id" bigint    NOT NULL,
+    "name"       text      NOT NULL,
+    "color"      text      NOT NULL DEFAULT '',
+    "parent_id"  bigint    NOT NULL DEFAULT 0,
+    "position"   bigint    NOT NULL DEFAULT 0,
+    "shapes"     jsonb     NOT NULL DEFAULT '[]',
+    "attributes" jsonb     NOT NULL DEFAULT '[]',
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_labels_dataset_id" ON "labels" ("dataset_id", "position");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_labels_dataset_name" ON "labels" ("dataset_id", "name") WHERE "deleted_at" IS NULL;
+CREATE INDEX IF NOT EXISTS "idx_labels_deleted_at" ON "labels" ("deleted_at");
+
+-- 原来标注中的 id 是类别在 tags 中的下标，空的 tag 不占下标；没有 tags 的数据集使用默认类别 object
+CREATE TEMPORARY TABLE "label_tags" ON COMMIT DROP AS
+SELECT d."id" AS "dataset_id", trim(tag."name") AS "name", row_number() OVER (PARTITION BY d."id" ORDER BY tag."ord") - 1 AS "idx"
+FROM "datasets" d, unnest(string_to_array(d."tags", ',')) WITH ORDINALITY AS tag("name", "ord")
+WHERE trim(tag."name") <> '';
+
+INSERT INTO "label_tags" ("dataset_id", "name", "idx")
+SELECT d."id", 'object', 0
+FROM "datasets" d
+WHERE NOT EXISTS (SELECT 1 FROM "label_tags" t WHERE t."dataset_id" = d."id");
+
+-- 重复的 tag 合并为一个标签，位置取第一次出现的下标
+INSERT INTO "labels" ("created_at", "updated_at", "dataset_id", "name", "position")
+SELECT now(), now(), t."dataset_id", t."name", min(t."idx")
+FROM "label_tags" t
+WHERE NOT EXISTS (SELECT 1 FROM "labels" l WHERE l."dataset_id" = t."dataset_id")
+GROUP BY t."dataset_id", t."name";
+
+-- 标注中的 id 改为标签 ID
+UPDATE "annotations" a
+SET "content" = (
+    SELECT coalesce(jsonb_agg(CASE WHEN l."id" IS NULL THEN e."mark" ELSE jsonb_set(e."mark", '{id}', to_jsonb(l."id")) END ORDER BY e."ord"), '[]'::jsonb)
+    FROM jsonb_array_elements(a."content") WITH ORDINALITY AS e("mark", "ord")
+             LEFT JOIN "label_tags" t ON t."dataset_id" = a."dataset_id" AND t."idx" = (e."mark" ->> 'id')::bigint
+             LEFT JOIN "labels" l ON l."dataset_id" = t."dataset_id" AND l."name" = t."name" AND l."deleted_at" IS NULL
+)
+WHERE jsonb_typeof(a."content") = 'array';
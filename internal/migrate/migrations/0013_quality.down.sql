@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "annotator_qualities";
+DROP TABLE IF EXISTS "image_qualities";
@@ -0,0 +1,44 @@
+-- 图片上各标注员与合并结果的一致性，新标注提交时按图片重新计算
+CREATE TABLE IF NOT EXISTS "image_qualities"
+(
+    "id"                 bigserial        NOT NULL,
+    "updated_at"         timestamptz,
+    "dataset_id"         bigint           NOT NULL,
+    "image_id"           bigint           NOT NULL,
+    "annotators"         bigint           NOT NULL DEFAULT 0,
+    "objects"            bigint           NOT NULL DEFAULT 0,
+    "matched"            bigint           NOT NULL DEFAULT 0,
+    "false_positives"    bigint           NOT NULL DEFAULT 0,
+    "false_negatives"    bigint           NOT NULL DEFAULT 0,
+    "iou_sum"            double precision NOT NULL DEFAULT 0,
+    "iou_count"          bigint           NOT NULL DEFAULT 0,
+    "alpha_disagreement" double precision NOT NULL DEFAULT 0,
+    "alpha_present"      double precision NOT NULL DEFAULT 0,
+    "alpha_absent"       double precision NOT NULL DEFAULT 0,
+    "mean_iou"           double precision NOT NULL DEFAULT 0,
+    "precision"          double precision NOT NULL DEFAULT 0,
+    "recall"             double precision NOT NULL DEFAULT 0,
+    "alpha"              double precision,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_image_qualities_image_id" ON "image_qualities" ("image_id");
+CREATE INDEX IF NOT EXISTS "idx_image_qualities_dataset_id" ON "image_qualities" ("dataset_id");
+
+CREATE TABLE IF NOT EXISTS "annotator_qualities"
+(
+    "id"              bigserial        NOT NULL,
+    "updated_at"      timestamptz,
+    "dataset_id"      bigint           NOT NULL,
+    "image_id"        bigint           NOT NULL,
+    "user_id"         bigint           NOT NULL,
+    "annotation_id"   bigint           NOT NULL,
+    "matched"         bigint           NOT NULL DEFAULT 0,
+    "false_positives" bigint           NOT NULL DEFAULT 0,
+    "false_negatives" bigint           NOT NULL DEFAULT 0,
+    "iou_sum"         double precision NOT NULL DEFAULT 0,
+    "iou_count"       bigint           NOT NULL DEFAULT 0,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_annotator_qualities_image_user" ON "annotator_qualities" ("image_id", "user_id");
+CREATE INDEX IF NOT EXISTS "idx_annotator_qualities_dataset_id" ON "annotator_qualities" ("dataset_id", "user_id");
+CREATE INDEX IF NOT EXISTS "idx_annotator_qualities_user_id" ON "annotator_qualities" ("user_id");
@@ -0,0 +1,2 @@
+ALTER TABLE "img_datasets" DROP COLUMN IF EXISTS "derivative_retry_at";
+ALTER TABLE "img_datasets" DROP COLUMN IF EXISTS "derivative_attempts";
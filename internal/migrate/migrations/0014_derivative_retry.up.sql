@@ -0,0 +1,3 @@
+-- 下载原图或上传缩略图失败时按退避时间重试，超过次数后才标记为失败
+ALTER TABLE "img_datasets" ADD COLUMN IF NOT EXISTS "derivative_attempts" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "img_datasets" ADD COLUMN IF NOT EXISTS "derivative_retry_at" timestamptz;
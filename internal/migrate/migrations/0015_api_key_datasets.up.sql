@@ -0,0 +1,2 @@
+-- API key 可以访问的数据集，为空时不能使用数据集级的 scope
+ALTER TABLE "api_keys" ADD COLUMN IF NOT EXISTS "dataset_ids" jsonb NOT NULL DEFAULT '[]';
@@ -7,6 +7,7 @@ import (
 	"sapphire-server/internal/domain"
 	"sapphire-server/internal/middleware"
 	"strconv"
+	"strings"
 )
 
 type AnnotationRouter struct {
@@ -15,9 +16,10 @@ type AnnotationRouter struct {
 func NewAnnotationRouter(engine *gin.Engine) {
 	router := &AnnotationRouter{}
 	annotationGroup := engine.Group("/annotate").Use(middleware.AuthMiddleware()).Use(middleware.UserIDMiddleware())
-	annotationGroup.GET("/:set_id", router.HandleGetAnnotation)
+	annotationGroup.GET("/:set_id", middleware.Require(domain.PermDatasetRead, middleware.DatasetParam("set_id")), router.HandleGetAnnotation)
 	annotationGroup.POST("/make", router.HandleMake)
-	annotationGroup.GET("/result/:id", router.HandleAnnotationResult)
+	annotationGroup.GET("/result/:id", middleware.Require(domain.PermDatasetRead, middleware.ImageParam("id")), router.HandleAnnotationResult)
+	annotationGroup.GET("/:set_id/query", middleware.Require(domain.PermDatasetRead, middleware.DatasetParam("set_id")), router.HandleQueryByAttributes)
 }
 
 var datasetDomain = domain.NewDatasetDomain()
@@ -26,13 +28,13 @@ var annotationDomain = domain.NewAnnotationDomain()
 // HandleGetAnnotation godoc
 //
 //	@Summary		获取标注图片信息
-//	@Description	根据数据集ID获取标注图片信息
+//	@Description	根据数据集ID获取标注图片信息，包含缩略图和预览图地址
 //	@Tags			annotation
 //	@Accept			json
 //	@Produce		json
 //	@Param			set_id	path		int	true	"Dataset ID"
 //	@Param			size	query		int	false	"Number of images"
-//	@Success		200		{object}	dto.Response{data=[]interface{}}
+//	@Success		200		{object}	dto.Response{data=[]domain.ImgDataset}
 //	@Router			/annotate/{set_id} [get]
 func (a *AnnotationRouter) HandleGetAnnotation(ctx *gin.Context) {
 	datasetID, _ := strconv.Atoi(ctx.Param("set_id"))
@@ -64,6 +66,9 @@ func (a *AnnotationRouter) HandleMake(ctx *gin.Context) {
 	}
 
 	userID := ctx.Keys["id"].(uint)
+	if !middleware.Check(ctx, domain.PermAnnotate, body.DatasetID) {
+		return
+	}
 
 	annotation, err := annotationDomain.CreateAnnotation(userID, body)
 	if err != nil {
@@ -73,7 +78,21 @@ func (a *AnnotationRouter) HandleMake(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(annotation))
 }
 
-// HandleGetAnnotation godoc
+// HandleAnnotationResult godoc
+//
+//	@Summary		获取图片的标注结果
+//	@Description	根据图片ID分页获取所有用户的标注
+//	@Tags			annotation
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int		true	"Image ID"
+//	@Param			page		query		int		false	"Page number"
+//	@Param			pageSize	query		int		false	"Page size"
+//	@Param			sort		query		string	false	"Sort fields, e.g. -createdAt"
+//	@Param			cursor		query		string	false	"Cursor for cursor pagination"
+//	@Param			filter		query		[]string	false	"Filters, e.g. userId:eq:1"
+//	@Success		200			{object}	dto.Response{data=[]domain.Annotation}
+//	@Router			/annotate/result/{id} [get]
 func (a *AnnotationRouter) HandleAnnotationResult(ctx *gin.Context) {
 	var err error
 	imageID, err := strconv.Atoi(ctx.Param("id"))
@@ -81,12 +100,67 @@ func (a *AnnotationRouter) HandleAnnotationResult(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
 		return
 	}
+	req, ok := bindPageRequest(ctx)
+	if !ok {
+		return
+	}
 
-	annotations, err := annotationDomain.ListAnnotationsByImageID(uint(imageID))
+	page, err := annotationDomain.ListAnnotationPageByImageID(uint(imageID), req)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		ctx.JSON(pageErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.NewPageResponse(page.Items, page.PageMeta))
+}
+
+// HandleQueryByAttributes godoc
+//
+//	@Summary		按属性查询标注
+//	@Description	分页查询数据集中含有指定标签的标注，attr 为 name:value，多个 attr 需要同时满足
+//	@Tags			annotation
+//	@Produce		json
+//	@Param			set_id		path		int			true	"Dataset ID"
+//	@Param			labelId		query		int			true	"Label ID"
+//	@Param			attr		query		[]string	false	"Attribute values, e.g. color:red"
+//	@Param			page		query		int			false	"Page number"
+//	@Param			pageSize	query		int			false	"Page size"
+//	@Param			sort		query		string		false	"Sort fields, e.g. -createdAt"
+//	@Success		200			{object}	dto.Response{data=[]domain.Annotation}
+//	@Router			/annotate/{set_id}/query [get]
+func (a *AnnotationRouter) HandleQueryByAttributes(ctx *gin.Context) {
+	datasetID, err := strconv.Atoi(ctx.Param("set_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	labelID, err := strconv.Atoi(ctx.Query("labelId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid label id"))
+		return
+	}
+	attrs := make(map[string]string)
+	for _, attr := range ctx.QueryArray("attr") {
+		name, value, ok := strings.Cut(attr, ":")
+		if !ok || name == "" {
+			ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("attr should be name:value"))
+			return
+		}
+		attrs[name] = value
+	}
+	req, ok := bindPageRequest(ctx)
+	if !ok {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(annotations))
+	page, err := domain.ListAnnotationPageByAttributes(ctx.Request.Context(), uint(datasetID), uint(labelID), attrs, req)
+	if err != nil {
+		status := labelErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			status = pageErrorStatus(err)
+		}
+		ctx.JSON(status, dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewPageResponse(page.Items, page.PageMeta))
 }
@@ -0,0 +1,119 @@
+package router
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"sapphire-server/internal/data/dto"
+	"sapphire-server/internal/domain"
+	"sapphire-server/internal/middleware"
+	"strconv"
+	"time"
+)
+
+// APIKeyRouter 机器密钥管理路由，只有平台管理员可以访问
+type APIKeyRouter struct {
+}
+
+func NewAPIKeyRouter(engine *gin.Engine) *APIKeyRouter {
+	router := &APIKeyRouter{}
+	apiKeyGroup := engine.Group("/apikey").
+		Use(middleware.AuthMiddleware()).
+		Use(middleware.Require(domain.PermUserManage))
+	apiKeyGroup.POST("/create", router.HandleCreate)
+	apiKeyGroup.GET("/list", router.HandleList)
+	apiKeyGroup.POST("/:id/revoke", router.HandleRevoke)
+	return router
+}
+
+// HandleCreate godoc
+//
+//	@Summary		签发 API key
+//	@Description	签发机器密钥，可用的 scope 为 embedding:write、task:manage、dataset:read、dataset:export；dataset:read 和 dataset:export 只对 datasetIds 中的数据集生效；明文只在响应中返回一次
+//	@Tags			apikey
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		dto.NewAPIKey	true	"Name, scopes and expiry"
+//	@Success		200		{object}	dto.Response{data=domain.IssuedAPIKey}
+//	@Router			/apikey/create [post]
+func (a *APIKeyRouter) HandleCreate(ctx *gin.Context) {
+	var req dto.NewAPIKey
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("expiresAt should be in the future"))
+		return
+	}
+
+	creatorID := ctx.Keys["id"].(uint)
+	key, err := domain.CreateAPIKey(ctx.Request.Context(), creatorID, req.Name, req.Scopes, req.DatasetIDs, req.ExpiresAt)
+	if errors.Is(err, domain.ErrInvalidScope) {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleCreateAPIKey", "id", key.ID, "name", key.Name, "scopes", req.Scopes, "datasetIDs", req.DatasetIDs, "creatorID", creatorID)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(key))
+}
+
+// HandleList godoc
+//
+//	@Summary		获取 API key 列表
+//	@Description	包括已吊销和已过期的密钥，不返回密钥明文
+//	@Tags			apikey
+//	@Accept			json
+//	@Produce		json
+//	@Param			page		query		int		false	"Page number"
+//	@Param			pageSize	query		int		false	"Page size"
+//	@Param			sort		query		string	false	"Sort fields, e.g. -lastUsedAt"
+//	@Param			cursor		query		string	false	"Cursor for cursor pagination"
+//	@Param			filter		query		[]string	false	"Filters, e.g. name:like:worker"
+//	@Success		200	{object}	dto.Response{data=[]domain.APIKey}
+//	@Router			/apikey/list [get]
+func (a *APIKeyRouter) HandleList(ctx *gin.Context) {
+	req, ok := bindPageRequest(ctx)
+	if !ok {
+		return
+	}
+	page, err := domain.ListAPIKeyPage(ctx.Request.Context(), req)
+	if err != nil {
+		ctx.JSON(pageErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewPageResponse(page.Items, page.PageMeta))
+}
+
+// HandleRevoke godoc
+//
+//	@Summary		吊销 API key
+//	@Description	吊销后立即失效，已吊销的密钥重复吊销不报错
+//	@Tags			apikey
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		int	true	"API key ID"
+//	@Success		200	{object}	dto.Response{data=domain.APIKey}
+//	@Router			/apikey/{id}/revoke [post]
+func (a *APIKeyRouter) HandleRevoke(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid api key id"))
+		return
+	}
+	key, err := domain.RevokeAPIKey(ctx.Request.Context(), uint(id))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	if key == nil {
+		ctx.JSON(http.StatusNotFound, dto.NewFailResponse("api key not found"))
+		return
+	}
+	slog.Info("HandleRevokeAPIKey", "id", key.ID, "name", key.Name)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(key))
+}
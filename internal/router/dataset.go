@@ -1,21 +1,22 @@
 package router
 
 import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
-	"io/ioutil"
 	"log/slog"
 	"net/http"
-	"os"
-	"path/filepath"
+	"sapphire-server/internal/conf"
 	"sapphire-server/internal/dao"
 	"sapphire-server/internal/data/dto"
 	"sapphire-server/internal/domain"
 	"sapphire-server/internal/middleware"
 	"sapphire-server/internal/service"
-	"sapphire-server/pkg/misc"
 	"sapphire-server/pkg/util"
 	"strconv"
-	"sync"
+	"strings"
 )
 
 type DatasetRouter struct {
@@ -32,20 +33,56 @@ func NewDatasetRouter(engine *gin.Engine) *DatasetRouter {
 		authRouter.GET("/joined/list", router.HandleJoinedList)
 		authRouter.GET("/user/list", router.HandleUserList)
 
-		authRouter.GET("/joined/users/:id", router.ListDatasetJoinedUsers)
+		authRouter.GET("/joined/users/:id", middleware.Require(domain.PermDatasetRead), router.ListDatasetJoinedUsers)
+		authRouter.PUT("/:id/members", middleware.Require(domain.PermDatasetManageMembers), router.HandleSetMemberRole)
+		authRouter.DELETE("/:id/members/:userId", middleware.Require(domain.PermDatasetManageMembers), router.HandleRemoveMember)
+
+		authRouter.DELETE("/:id/images/:imgId", middleware.Require(domain.PermImageWrite), router.HandleDeleteImage)
+		authRouter.PUT("/:id/images/:imgId/file", middleware.Require(domain.PermImageWrite), router.HandleReplaceImage)
+		authRouter.POST("/:id/images/delete", middleware.Require(domain.PermImageWrite), router.HandleDeleteImages)
+		authRouter.PUT("/:id/images/order", middleware.Require(domain.PermImageWrite), router.HandleReorderImages)
+		// 重置嵌入状态会触发重新嵌入，只有平台管理员可以操作
+		authRouter.POST("/:id/images/status", middleware.Require(domain.PermTaskManage), router.HandleResetImageStatus)
+		authRouter.POST("/:id/embedding/campaigns", middleware.Require(domain.PermTaskManage), router.HandleStartCampaign)
+		authRouter.GET("/:id/embedding/campaigns", middleware.Require(domain.PermDatasetRead), router.HandleListCampaigns)
+		authRouter.GET("/:id/embedding/campaigns/:campaignId", middleware.Require(domain.PermDatasetRead), router.HandleGetCampaign)
+		authRouter.POST("/:id/embedding/campaigns/:campaignId/cancel", middleware.Require(domain.PermTaskManage), router.HandleCancelCampaign)
+		authRouter.POST("/:id/labels", middleware.Require(domain.PermDatasetUpdate), router.HandleCreateLabel)
+		authRouter.PUT("/:id/labels/:labelId", middleware.Require(domain.PermDatasetUpdate), router.HandleUpdateLabel)
+		authRouter.DELETE("/:id/labels/:labelId", middleware.Require(domain.PermDatasetUpdate), router.HandleDeleteLabel)
+		authRouter.POST("/:id/quality/recompute", middleware.Require(domain.PermDatasetUpdate), router.HandleRecomputeQuality)
+		authRouter.POST("/:id/events/token", middleware.Require(domain.PermDatasetRead), router.HandleEventsToken)
 
 		authRouter.POST("/query", router.HandleQuery)
 		authRouter.POST("/create", router.HandleCreate)
-		authRouter.PUT("/update/:id", router.HandleUpdate)
-		authRouter.POST("/upload/:id", router.HandleUploadImg)
+		authRouter.PUT("/update/:id", middleware.Require(domain.PermDatasetUpdate), router.HandleUpdate)
+		authRouter.POST("/upload/:id", middleware.Require(domain.PermImageWrite), router.HandleUploadImg)
 		authRouter.POST("/upload/images", router.HandleAddImagesToDataset)
-		authRouter.POST("/download/:id", router.HandleDownloadDataset)
-		authRouter.DELETE("/:id", router.HandleDelete)
+		authRouter.GET("/upload/jobs/:id", middleware.Require(domain.PermImageWrite, middleware.UploadJobParam("id")), router.HandleGetUploadJob)
+		authRouter.POST("/upload/jobs/:id/retry", middleware.Require(domain.PermImageWrite, middleware.UploadJobParam("id")), router.HandleRetryUploadJob)
+		authRouter.POST("/import/:id", middleware.Require(domain.PermImageWrite), router.HandleImport)
+		authRouter.DELETE("/:id", middleware.Require(domain.PermDatasetDelete), router.HandleDelete)
 		//authRouter.POST("/register", router.HandleRegister)
-		authRouter.GET("/:id", router.HandleGetByID)
 		authRouter.POST("/join/:id", router.HandleJoin)
 		authRouter.POST("/quit/:id", router.HandleQuit)
 	}
+
+	// 读取和导出数据集也可以使用 API key，供训练等离线任务拉取数据
+	keyRouter := datasetGroup.Group("/").Use(middleware.AuthOrAPIKey()).Use(middleware.UserIDMiddleware())
+	{
+		keyRouter.POST("/download/:id", middleware.Require(domain.PermDatasetExport), router.HandleDownloadDataset)
+		keyRouter.GET("/download/:id", middleware.Require(domain.PermDatasetExport), router.HandleDownloadDataset)
+		keyRouter.GET("/:id", middleware.Require(domain.PermDatasetRead), router.HandleGetByID)
+		keyRouter.GET("/:id/labels", middleware.Require(domain.PermDatasetRead), router.HandleListLabels)
+		keyRouter.GET("/:id/quality", middleware.Require(domain.PermDatasetRead), router.HandleGetQuality)
+		keyRouter.GET("/:id/quality/images", middleware.Require(domain.PermDatasetRead), router.HandleListImageQuality)
+	}
+
+	// 浏览器的 EventSource 不能设置请求头，事件流还可以使用查询参数或 cookie 中的短期 token
+	streamRouter := datasetGroup.Group("/").Use(middleware.DatasetStreamAuth()).Use(middleware.UserIDMiddleware())
+	{
+		streamRouter.GET("/:id/events", middleware.Require(domain.PermDatasetRead), router.HandleEvents)
+	}
 	return router
 }
 
@@ -58,15 +95,28 @@ var datasetService = service.NewDatasetService()
 //	@Tags			dataset
 //	@Accept			json
 //	@Produce		json
+//	@Param			page		query		int		false	"Page number"
+//	@Param			pageSize	query		int		false	"Page size"
+//	@Param			sort		query		string	false	"Sort fields, e.g. -createdAt,name"
+//	@Param			cursor		query		string	false	"Cursor for cursor pagination"
+//	@Param			filter		query		[]string	false	"Filters, e.g. name:like:car"
 //	@Success		200	{object}	dto.Response{data=[]domain.Dataset}
 //	@Router			/dataset/list [get]
 func (t *DatasetRouter) HandleList(ctx *gin.Context) {
 	// 读取用户ID为str
 	userID := ctx.Keys["id"].(uint)
 	slog.Info("HandleList", "userID", userID)
+	req, ok := bindPageRequest(ctx)
+	if !ok {
+		return
+	}
 
-	datasets := datasetService.GetAllDatasetList(userID)
-	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(datasets))
+	datasets, page, err := datasetService.GetAllDatasetPage(userID, req)
+	if err != nil {
+		ctx.JSON(pageErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewPageResponse(datasets, page))
 }
 
 // HandleCreatedList godoc
@@ -134,7 +184,11 @@ func (t *DatasetRouter) HandleJoin(ctx *gin.Context) {
 	}
 	creatorID := ctx.Keys["id"].(uint)
 
-	err = domain.NewDatasetDomain().AddUserToDataset(creatorID, uint(setID))
+	err = domain.NewDatasetDomain().JoinDataset(ctx.Request.Context(), creatorID, uint(setID))
+	if errors.Is(err, domain.ErrPermissionDenied) {
+		ctx.JSON(http.StatusForbidden, dto.NewFailResponse("dataset is not public"))
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
 		return
@@ -198,9 +252,9 @@ func (t *DatasetRouter) HandleCreate(ctx *gin.Context) {
 	}
 
 	// 创建数据集
-	dataset, err := datasetDomain.CreateDataset(creatorID, body)
+	dataset, err := datasetDomain.CreateDataset(ctx.Request.Context(), creatorID, body)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		ctx.JSON(samSelectionStatus(err), dto.NewFailResponse(err.Error()))
 		return
 	}
 
@@ -237,9 +291,9 @@ func (t *DatasetRouter) HandleUpdate(ctx *gin.Context) {
 	}
 
 	// 创建数据集
-	dataset, err := datasetDomain.UpdateDataset(creatorID, uint(datasetID), body)
+	dataset, err := datasetDomain.UpdateDataset(uint(datasetID), body)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		ctx.JSON(samSelectionStatus(err), dto.NewFailResponse(err.Error()))
 		return
 	}
 
@@ -284,6 +338,70 @@ func (t *DatasetRouter) HandleDelete(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(nil))
 }
 
+// HandleSetMemberRole godoc
+//
+//	@Summary		设置成员角色
+//	@Description	数据集所有者设置成员的角色，不是成员时直接加入
+//	@Tags			dataset
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int					true	"Dataset ID"
+//	@Param			body	body		dto.SetMemberRole	true	"Member Role"
+//	@Success		200		{object}	dto.Response
+//	@Router			/dataset/{id}/members [put]
+func (t *DatasetRouter) HandleSetMemberRole(ctx *gin.Context) {
+	var err error
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	body := dto.SetMemberRole{}
+	if err = ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+
+	err = datasetDomain.SetMemberRole(ctx.Request.Context(), uint(datasetID), body.UserID, body.Role)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(nil))
+}
+
+// HandleRemoveMember godoc
+//
+//	@Summary		移除成员
+//	@Description	数据集所有者将成员移出数据集
+//	@Tags			dataset
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int	true	"Dataset ID"
+//	@Param			userId	path		int	true	"User ID"
+//	@Success		200		{object}	dto.Response
+//	@Router			/dataset/{id}/members/{userId} [delete]
+func (t *DatasetRouter) HandleRemoveMember(ctx *gin.Context) {
+	var err error
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	userID, err := strconv.Atoi(ctx.Param("userId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid user id"))
+		return
+	}
+
+	err = datasetDomain.RemoveUserFromDataset(uint(userID), uint(datasetID))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(nil))
+}
+
 // ListDatasetJoinedUsers godoc
 //
 //	@Summary		列出加入数据集的用户
@@ -336,13 +454,13 @@ func (t *DatasetRouter) ListDatasetJoinedUsers(ctx *gin.Context) {
 // HandleUploadImg godoc
 //
 //	@Summary		上传图片
-//	@Description	上传图片
+//	@Description	上传包含图片的 zip，支持多层目录和 jpg、png、webp、bmp，返回后台上传任务，通过 /dataset/upload/jobs/{id} 查询进度
 //	@Tags			dataset
-//	@Accept			json
+//	@Accept			multipart/form-data
 //	@Produce		json
 //	@Param			id		path		int		true	"Dataset ID"
-//	@Param			file	formData	file	true	"File"
-//	@Success		200		{object}	dto.Response
+//	@Param			file	formData	file	true	"Zip file"
+//	@Success		202		{object}	dto.Response{data=domain.UploadJob}
 //	@Router			/dataset/upload/{id} [post]
 func (t *DatasetRouter) HandleUploadImg(ctx *gin.Context) {
 	var err error
@@ -352,7 +470,15 @@ func (t *DatasetRouter) HandleUploadImg(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
 		return
 	}
-	datasetID64 := uint(datasetID)
+	dataset, err := datasetDomain.GetDatasetByID(uint(datasetID))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	if dataset == nil {
+		ctx.JSON(http.StatusNotFound, dto.NewFailResponse("dataset not found"))
+		return
+	}
 
 	// 读取表单的文件
 	file, err := ctx.FormFile("file")
@@ -361,143 +487,228 @@ func (t *DatasetRouter) HandleUploadImg(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("文件不存在"))
 		return
 	}
+	uploadConf := conf.GetUploadConfig()
+	if file.Size > uploadConf.MaxArchiveSize {
+		ctx.JSON(http.StatusRequestEntityTooLarge, dto.NewFailResponse("archive too large"))
+		return
+	}
 
-	// 将文件保存到本地
-	savePath := "./files/" + file.Filename
-	saveDir := "./files/"
-	//saveDir := strings.Replace(savePath, ".zip", "", 1)
-	// 检查是否存在该文件
-	if _, err := os.Stat(savePath); err == nil {
-		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("文件已存在"))
+	// 每次上传使用单独的临时目录，由后台任务解压和上传
+	ws, err := domain.NewUploadWorkspace(uploadConf.TempDir)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
 		return
 	}
-	err = ctx.SaveUploadedFile(file, savePath)
+	err = ctx.SaveUploadedFile(file, ws.ArchivePath())
 	if err != nil {
+		ws.Remove()
 		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
 		return
 	}
 
-	// 结束后删除文件
-	//defer func() {
-	//	err := os.Remove(savePath)
-	//	if err != nil {
-	//		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
-	//		return
-	//	}
-	//}()
+	userID := ctx.Keys["id"].(uint)
+	job, err := startUploadJob(ctx.Request.Context(), dataset, userID, ws, file.Filename)
+	if err != nil {
+		ctx.JSON(uploadErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, dto.NewSuccessResponse(job))
+}
 
-	// 解压缩文件
-	// 先将文件读取为[]byte
-	bytes, err := os.ReadFile(savePath)
+// startUploadJob 为已经保存到工作目录的压缩包创建上传任务，失败时删除工作目录
+// 在返回之前检查压缩包格式，明显损坏的文件不创建任务
+func startUploadJob(ctx context.Context, dataset *domain.Dataset, userID uint, ws *domain.UploadWorkspace, archiveName string) (*domain.UploadJob, error) {
+	zr, err := zip.OpenReader(ws.ArchivePath())
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		ws.Remove()
+		return nil, err
+	}
+	zr.Close()
+
+	job, err := datasetDomain.CreateUploadJob(ctx, dataset, userID, ws, archiveName)
+	if err != nil {
+		ws.Remove()
+		return nil, err
+	}
+	return job, nil
+}
+
+// HandleGetUploadJob godoc
+//
+//	@Summary		查询上传任务
+//	@Description	返回上传任务的状态、文件计数和被拒绝、失败的文件
+//	@Tags			dataset
+//	@Produce		json
+//	@Param			id	path		int	true	"Upload job ID"
+//	@Success		200	{object}	dto.Response{data=domain.UploadJobDetail}
+//	@Router			/dataset/upload/jobs/{id} [get]
+func (t *DatasetRouter) HandleGetUploadJob(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid job id"))
 		return
 	}
-	// 创建对应的目录
-	err = os.MkdirAll(saveDir, os.ModePerm)
-	// 检查目录是否创建成果
+	detail, err := domain.GetUploadJobDetail(ctx.Request.Context(), uint(id))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
 		return
 	}
+	if detail == nil {
+		ctx.JSON(http.StatusNotFound, dto.NewFailResponse("upload job not found"))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(detail))
+}
 
-	// 解压文件
-	err = util.Unzip(bytes, "./files/")
+// HandleRetryUploadJob godoc
+//
+//	@Summary		重试上传任务
+//	@Description	重新上传任务中失败的文件，不需要重新上传压缩包
+//	@Tags			dataset
+//	@Produce		json
+//	@Param			id	path		int	true	"Upload job ID"
+//	@Success		202	{object}	dto.Response{data=domain.UploadJob}
+//	@Router			/dataset/upload/jobs/{id}/retry [post]
+func (t *DatasetRouter) HandleRetryUploadJob(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid job id"))
+		return
+	}
+	job, err := domain.GetUploadJob(ctx.Request.Context(), uint(id))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
 		return
 	}
+	if job == nil {
+		ctx.JSON(http.StatusNotFound, dto.NewFailResponse("upload job not found"))
+		return
+	}
+	err = domain.RetryUploadJob(ctx.Request.Context(), job)
+	if errors.Is(err, domain.ErrUploadJobNotRetryable) {
+		ctx.JSON(http.StatusConflict, dto.NewFailResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusAccepted, dto.NewSuccessResponse(job))
+}
 
-	// 取出该目录下的所有文件
-	files, err := os.ReadDir(saveDir)
-
-	println("files: ", files, saveDir)
+// uploadErrorStatus 压缩包错误对应的状态码
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, util.ErrTooManyEntries), errors.Is(err, util.ErrArchiveTooBig):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, zip.ErrFormat), errors.Is(err, zip.ErrAlgorithm), errors.Is(err, zip.ErrChecksum):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
 
+// HandleImport godoc
+//
+//	@Summary		导入带标注的数据
+//	@Description	上传包含图片和 COCO JSON 或 YOLO 标注文件的 zip，返回导入报告
+//	@Tags			dataset
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			id				path		int		true	"Dataset ID"
+//	@Param			file			formData	file	true	"Zip file"
+//	@Param			format			formData	string	false	"auto, coco or yolo, default auto"
+//	@Param			sourceUserId	formData	int		false	"User the imported annotations belong to, must be an annotator of the dataset, default current user"
+//	@Success		200				{object}	dto.Response{data=domain.ImportReport}
+//	@Router			/dataset/import/{id} [post]
+func (t *DatasetRouter) HandleImport(ctx *gin.Context) {
+	var err error
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	dataset, err := datasetDomain.GetDatasetByID(uint(datasetID))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
 		return
 	}
+	if dataset == nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("dataset not found"))
+		return
+	}
 
-	// 上传完成后删除文件
-	defer func() {
-		err := os.RemoveAll(saveDir)
+	// 标注默认记在当前用户名下
+	sourceUserID := ctx.Keys["id"].(uint)
+	if str := ctx.PostForm("sourceUserId"); str != "" {
+		id, err := strconv.ParseUint(str, 10, 64)
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+			ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid sourceUserId"))
 			return
 		}
-	}()
-
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	var imageUrls []string
-
-	// 创建一个通道来接收错误
-	errChan := make(chan error, len(files))
-
-	// 遍历文件，将文件上传到图床
-	for _, f := range files {
-		if f.IsDir() {
-			continue
-		}
-		if filepath.Ext(f.Name()) != ".jpg" {
-			continue
+		user, err := userDomain.GetUserInfo(uint(id))
+		if err != nil || user == nil {
+			ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("source user not found"))
+			return
 		}
-		wg.Add(1)
-		go func(f os.DirEntry) {
-			defer wg.Done()
-
-			// 读取文件
-			filePath := filepath.Join(saveDir, f.Name())
-			bytes, err := ioutil.ReadFile(filePath)
-			if err != nil {
-				errChan <- err
+		// 标注会计入该用户的积分和质量指标，只能记在可以标注该数据集的成员名下，平台管理员不受限制
+		if user.ID != sourceUserID {
+			err = domain.Authorize(ctx.Request.Context(), user.ID, dataset.ID, domain.PermAnnotate)
+			if errors.Is(err, domain.ErrPermissionDenied) {
+				err = domain.Authorize(ctx.Request.Context(), sourceUserID, 0, domain.PermUserManage)
+			}
+			if errors.Is(err, domain.ErrPermissionDenied) {
+				ctx.JSON(http.StatusForbidden, dto.NewFailResponse("source user is not an annotator of the dataset"))
 				return
 			}
-
-			// 上传图片
-			directUrl, err := misc.UploadImage(bytes, f.Name()+".jpg")
 			if err != nil {
-				errChan <- err
+				ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
 				return
 			}
-			slog.Info("HandleUploadImg", "directUrl", directUrl)
-
-			// 使用互斥锁来保护对共享变量的访问
-			mu.Lock()
-			imageUrls = append(imageUrls, directUrl)
-			mu.Unlock()
-		}(f)
-	}
-
-	// 等待所有 goroutines 完成
-	wg.Wait()
-	close(errChan)
-
-	println("touch here")
-	// 检查是否有错误
-	for err := range errChan {
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
-			return
 		}
+		sourceUserID = user.ID
 	}
-	println("touch here2")
 
-	// 读取数据集
-	dataset, err := datasetDomain.GetDatasetByID(datasetID64)
+	fileHeader, err := ctx.FormFile("file")
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("文件不存在"))
+		return
+	}
+	uploadConf := conf.GetUploadConfig()
+	if fileHeader.Size > uploadConf.MaxArchiveSize {
+		ctx.JSON(http.StatusRequestEntityTooLarge, dto.NewFailResponse("archive too large"))
 		return
 	}
-	// 插入数据库
-	err = datasetDomain.AddImageList(dataset, imageUrls)
+	file, err := fileHeader.Open()
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
 		return
 	}
+	defer file.Close()
+	zr, err := zip.NewReader(file, fileHeader.Size)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid zip file"))
+		return
+	}
 
-	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(nil))
-	return
+	format := ctx.DefaultPostForm("format", domain.ImportFormatAuto)
+	if format != domain.ImportFormatAuto && format != domain.ImportFormatCOCO && format != domain.ImportFormatYOLO {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid format"))
+		return
+	}
+	// 与上传压缩包使用相同的限制
+	limits := util.UnzipLimits{
+		MaxEntries:   uploadConf.MaxEntries,
+		MaxFileSize:  uploadConf.MaxFileSize,
+		MaxTotalSize: uploadConf.MaxTotalSize,
+	}
+	report, err := datasetDomain.ImportArchive(ctx.Request.Context(), dataset, sourceUserID, zr, format, limits)
+	if err != nil {
+		ctx.JSON(uploadErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(report))
 }
 
 func (t *DatasetRouter) HandleAddImagesToDataset(ctx *gin.Context) {
@@ -511,7 +722,11 @@ func (t *DatasetRouter) HandleAddImagesToDataset(ctx *gin.Context) {
 	}
 	slog.Info("AddImagesToDataset", "body", body)
 
-	err = datasetService.AddImagesByDataset(body, userID)
+	err = datasetService.AddImagesByDataset(ctx.Request.Context(), body, userID)
+	if errors.Is(err, domain.ErrPermissionDenied) {
+		ctx.JSON(http.StatusForbidden, dto.NewFailResponse(err.Error()))
+		return
+	}
 	if err != nil {
 		slog.Error("AddImagesToDataset", "err", err)
 		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
@@ -528,7 +743,7 @@ func (t *DatasetRouter) HandleRegister(ctx *gin.Context) {
 	imgUrl := ctx.PostForm("img_url")
 	datasetId, _ := strconv.Atoi(ctx.PostForm("dataset_id"))
 	// Check if the dataset exists
-	dataset, err := dao.First[domain.Dataset]("id = ?", datasetId)
+	dataset, err := dao.First[domain.Dataset](ctx.Request.Context(), "id = ?", datasetId)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse("dataset not found"))
 		return
@@ -564,12 +779,16 @@ func (t *DatasetRouter) HandleGetByID(ctx *gin.Context) {
 // HandleDownloadDataset godoc
 //
 //	@Summary		下载数据集
-//	@Description	下载数据集
+//	@Description	coco 格式返回标注文件地址，yolo 和 voc 格式直接返回包含图片和标注的 zip
 //	@Tags			dataset
 //	@Accept			json
-//	@Produce		json
-//	@Param			id	path		int	true	"Dataset ID"
-//	@Success		200	{object}	dto.Response{data=map[string]string}
+//	@Produce		json,application/zip
+//	@Param			id		path		int		true	"Dataset ID"
+//	@Param			format	query		string	false	"coco, yolo or voc, default coco"
+//	@Param			mode	query		string	false	"consensus or raw, default consensus"
+//	@Param			split	query		string	false	"train,val,test ratios for yolo and voc, e.g. 0.8,0.1,0.1"
+//	@Param			seed	query		int		false	"Random seed for split"
+//	@Success		200		{object}	dto.Response{data=map[string]string}
 //	@Router			/dataset/download/{id} [post]
 func (t *DatasetRouter) HandleDownloadDataset(ctx *gin.Context) {
 	var err error
@@ -578,14 +797,49 @@ func (t *DatasetRouter) HandleDownloadDataset(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
 		return
 	}
-	slog.Info("HandleDownloadDataset", "datasetID", datasetID)
+	mode := ctx.DefaultQuery("mode", domain.ExportModeConsensus)
+	if !domain.IsValidExportMode(mode) {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid mode"))
+		return
+	}
+	format := ctx.DefaultQuery("format", domain.ExportFormatCOCO)
+	if !domain.IsValidExportFormat(format) {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid format"))
+		return
+	}
+	opts := domain.ExportOptions{Mode: mode, Format: format}
+	if split := ctx.Query("split"); split != "" {
+		for _, part := range strings.Split(split, ",") {
+			ratio, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid split"))
+				return
+			}
+			opts.Split = append(opts.Split, ratio)
+		}
+		if err := domain.ValidateSplit(opts.Split); err != nil {
+			ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+			return
+		}
+		// COCO 导出只有一个标注文件，不支持划分
+		if format == domain.ExportFormatCOCO {
+			ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("split is only supported by yolo and voc"))
+			return
+		}
+		opts.Seed, err = strconv.ParseInt(ctx.DefaultQuery("seed", "0"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid seed"))
+			return
+		}
+	}
+	slog.Info("HandleDownloadDataset", "datasetID", datasetID, "opts", opts)
 
-	// 先读出数据集
-	//userID, _ := ctx.Keys["id"].(uint)
-	//dataset := datasetService.GetDatasetDetail(userID, uint(datasetID))
+	if format != domain.ExportFormatCOCO {
+		t.streamArchive(ctx, uint(datasetID), opts)
+		return
+	}
 
-	// 默认下载所有数据
-	url, err := datasetDomain.GetResultArchive(uint(datasetID))
+	url, err := datasetDomain.GetResultArchive(uint(datasetID), opts)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
 		return
@@ -599,6 +853,37 @@ func (t *DatasetRouter) HandleDownloadDataset(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(res))
 }
 
+// archiveErrorStatus 准备导出压缩包的错误对应的状态码
+func archiveErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrInvalidSplit):
+		return http.StatusBadRequest
+	case errors.Is(err, domain.ErrDatasetNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// streamArchive 直接将 zip 写入响应
+func (t *DatasetRouter) streamArchive(ctx *gin.Context, datasetID uint, opts domain.ExportOptions) {
+	archive, err := datasetDomain.PrepareArchive(ctx.Request.Context(), datasetID, opts)
+	if err != nil {
+		ctx.JSON(archiveErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+
+	ctx.Header("Content-Type", "application/zip")
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", archive.FileName()))
+	ctx.Status(http.StatusOK)
+	// 响应头已经发出，出错时只能中断连接
+	err = archive.WriteTo(ctx.Request.Context(), ctx.Writer)
+	if err != nil {
+		slog.Error("stream archive failed", "datasetID", datasetID, "err", err)
+		_ = ctx.Error(err)
+	}
+}
+
 // HandleQuery godoc
 //
 //	@Summary		查询数据集
@@ -0,0 +1,152 @@
+package router
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"sapphire-server/internal/data/dto"
+	"sapphire-server/internal/domain"
+	"strconv"
+)
+
+// campaignErrorStatus 重新嵌入活动错误对应的状态码
+func campaignErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrDatasetNotFound), errors.Is(err, domain.ErrCampaignNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrCampaignRunning), errors.Is(err, domain.ErrCampaignFinished):
+		return http.StatusConflict
+	case errors.Is(err, domain.ErrSamNotFound), errors.Is(err, domain.ErrSamDeprecated):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// datasetCampaignParams 读取路径中的数据集 ID 和活动 ID
+func datasetCampaignParams(ctx *gin.Context) (uint, uint, bool) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return 0, 0, false
+	}
+	campaignID, err := strconv.Atoi(ctx.Param("campaignId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid campaign id"))
+		return 0, 0, false
+	}
+	return uint(datasetID), uint(campaignID), true
+}
+
+// HandleStartCampaign godoc
+//
+//	@Summary		启动重新嵌入活动
+//	@Description	数据集切换到新模型，满足条件的图片重新排队嵌入，新的嵌入完成前保留原来的结果；过滤条件为空时处理所有图片
+//	@Tags			dataset
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int							true	"Dataset ID"
+//	@Param			campaign	body		dto.NewEmbeddingCampaign	true	"Model and image filter"
+//	@Success		200			{object}	dto.Response{data=domain.CampaignDetail}
+//	@Router			/dataset/{id}/embedding/campaigns [post]
+func (t *DatasetRouter) HandleStartCampaign(ctx *gin.Context) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	var req dto.NewEmbeddingCampaign
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+	creatorID, _ := ctx.Keys["id"].(uint)
+	campaign, err := domain.StartEmbeddingCampaign(ctx.Request.Context(), uint(datasetID), creatorID, req)
+	if err != nil {
+		ctx.JSON(campaignErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleStartCampaign", "datasetID", datasetID, "campaignID", campaign.ID, "samID", req.SamID, "total", campaign.Total)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(campaign))
+}
+
+// HandleListCampaigns godoc
+//
+//	@Summary		获取重新嵌入活动列表
+//	@Description	获取数据集的重新嵌入活动和进度
+//	@Tags			dataset
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int		true	"Dataset ID"
+//	@Param			page		query		int		false	"Page number"
+//	@Param			pageSize	query		int		false	"Page size"
+//	@Param			sort		query		string	false	"Sort fields, e.g. -createdAt"
+//	@Param			cursor		query		string	false	"Cursor for cursor pagination"
+//	@Param			filter		query		[]string	false	"Filters, e.g. status:eq:running"
+//	@Success		200	{object}	dto.Response{data=[]domain.CampaignDetail}
+//	@Router			/dataset/{id}/embedding/campaigns [get]
+func (t *DatasetRouter) HandleListCampaigns(ctx *gin.Context) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	req, ok := bindPageRequest(ctx)
+	if !ok {
+		return
+	}
+	campaigns, page, err := domain.ListEmbeddingCampaignPage(ctx.Request.Context(), uint(datasetID), req)
+	if err != nil {
+		ctx.JSON(pageErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewPageResponse(campaigns, page.PageMeta))
+}
+
+// HandleGetCampaign godoc
+//
+//	@Summary		获取重新嵌入活动
+//	@Description	获取活动和按任务状态统计的进度
+//	@Tags			dataset
+//	@Produce		json
+//	@Param			id			path		int	true	"Dataset ID"
+//	@Param			campaignId	path		int	true	"Campaign ID"
+//	@Success		200			{object}	dto.Response{data=domain.CampaignDetail}
+//	@Router			/dataset/{id}/embedding/campaigns/{campaignId} [get]
+func (t *DatasetRouter) HandleGetCampaign(ctx *gin.Context) {
+	datasetID, campaignID, ok := datasetCampaignParams(ctx)
+	if !ok {
+		return
+	}
+	campaign, err := domain.GetEmbeddingCampaign(ctx.Request.Context(), datasetID, campaignID)
+	if err != nil {
+		ctx.JSON(campaignErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(campaign))
+}
+
+// HandleCancelCampaign godoc
+//
+//	@Summary		取消重新嵌入活动
+//	@Description	排队中的任务一起取消，执行中的任务照常完成，数据集不会切换回原来的模型
+//	@Tags			dataset
+//	@Produce		json
+//	@Param			id			path		int	true	"Dataset ID"
+//	@Param			campaignId	path		int	true	"Campaign ID"
+//	@Success		200			{object}	dto.Response{data=domain.CampaignDetail}
+//	@Router			/dataset/{id}/embedding/campaigns/{campaignId}/cancel [post]
+func (t *DatasetRouter) HandleCancelCampaign(ctx *gin.Context) {
+	datasetID, campaignID, ok := datasetCampaignParams(ctx)
+	if !ok {
+		return
+	}
+	campaign, err := domain.CancelEmbeddingCampaign(ctx.Request.Context(), datasetID, campaignID)
+	if err != nil {
+		ctx.JSON(campaignErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleCancelCampaign", "datasetID", datasetID, "campaignID", campaignID)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(campaign))
+}
@@ -0,0 +1,113 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"sapphire-server/internal/data/dto"
+	"sapphire-server/internal/domain"
+	"sapphire-server/internal/middleware"
+	"strconv"
+	"time"
+)
+
+// eventHeartbeatInterval 没有事件时发送注释行的间隔，避免代理因为空闲断开连接
+const eventHeartbeatInterval = 15 * time.Second
+
+// HandleEvents godoc
+//
+//	@Summary		订阅数据集事件
+//	@Description	Server-Sent Events，连接后先推送一次 progress，之后推送 progress、status、upload、annotation 和 campaign 事件
+//	@Tags			dataset
+//	@Produce		text/event-stream
+//	@Param			id		path	int		true	"Dataset ID"
+//	@Param			token	query	string	false	"Stream token from /dataset/{id}/events/token, for EventSource which can not set headers"
+//	@Success		200		{object}	domain.DatasetEvent
+//	@Router			/dataset/{id}/events [get]
+func (t *DatasetRouter) HandleEvents(ctx *gin.Context) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	reqCtx := ctx.Request.Context()
+
+	// 先订阅再读取进度，两者之间的变化不会丢失
+	events, stop, err := domain.SubscribeDatasetEvents(reqCtx, uint(datasetID))
+	if err != nil {
+		slog.Error("subscribe dataset events failed", "datasetID", datasetID, "err", err)
+		ctx.JSON(http.StatusServiceUnavailable, dto.NewFailResponse("服务暂不可用"))
+		return
+	}
+	defer stop()
+	progress, err := domain.GetDatasetProgress(reqCtx, uint(datasetID))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.SSEvent(domain.EventProgress, &domain.DatasetEvent{
+		Type:      domain.EventProgress,
+		DatasetID: uint(datasetID),
+		Data:      progress,
+		Time:      time.Now(),
+	})
+	ctx.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-reqCtx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			ctx.SSEvent(event.Type, event)
+		case <-heartbeat.C:
+			_, err = ctx.Writer.WriteString(": ping\n\n")
+			if err != nil {
+				return
+			}
+		}
+		ctx.Writer.Flush()
+	}
+}
+
+// HandleEventsToken godoc
+//
+//	@Summary		获取订阅数据集事件的 token
+//	@Description	EventSource 不能设置 Authorization 头部，返回的短期 token 可以放在事件流地址的 token 参数中，同时写入只发送给事件流地址的 cookie
+//	@Tags			dataset
+//	@Produce		json
+//	@Param			id	path		int	true	"Dataset ID"
+//	@Success		200	{object}	dto.Response{data=domain.StreamToken}
+//	@Router			/dataset/{id}/events/token [post]
+func (t *DatasetRouter) HandleEventsToken(ctx *gin.Context) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	userID := ctx.Keys["id"].(uint)
+	token, err := domain.IssueDatasetStreamToken(userID, uint(datasetID))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+
+	// 不设置 Path，浏览器默认使用当前地址的上一级 .../events，经过反向代理加前缀后也只发送给事件流
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     middleware.StreamTokenCookie,
+		Value:    token.Token,
+		MaxAge:   int(token.ExpiresIn),
+		Secure:   ctx.Request.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(token))
+}
@@ -0,0 +1,218 @@
+package router
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log/slog"
+	"net/http"
+	"sapphire-server/internal/conf"
+	"sapphire-server/internal/data/dto"
+	"sapphire-server/internal/domain"
+	"sapphire-server/pkg/misc"
+	"strconv"
+)
+
+// imageErrorStatus 图片管理错误对应的状态码
+func imageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrImageNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrEmptyImageFilter), errors.Is(err, domain.ErrDuplicateImageID), errors.Is(err, misc.ErrUnsupportedImage):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// datasetImageParams 读取路径中的数据集 ID 和图片 ID
+func datasetImageParams(ctx *gin.Context) (uint, uint, bool) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return 0, 0, false
+	}
+	imageID, err := strconv.Atoi(ctx.Param("imgId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid image id"))
+		return 0, 0, false
+	}
+	return uint(datasetID), uint(imageID), true
+}
+
+// HandleDeleteImage godoc
+//
+//	@Summary		删除图片
+//	@Description	删除数据集中的图片，图片上的标注一并删除
+//	@Tags			dataset
+//	@Produce		json
+//	@Param			id		path		int	true	"Dataset ID"
+//	@Param			imgId	path		int	true	"Image ID"
+//	@Success		200		{object}	dto.Response
+//	@Router			/dataset/{id}/images/{imgId} [delete]
+func (t *DatasetRouter) HandleDeleteImage(ctx *gin.Context) {
+	datasetID, imageID, ok := datasetImageParams(ctx)
+	if !ok {
+		return
+	}
+	err := datasetDomain.DeleteImage(ctx.Request.Context(), datasetID, imageID)
+	if err != nil {
+		ctx.JSON(imageErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleDeleteImage", "datasetID", datasetID, "imageID", imageID)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(nil))
+}
+
+// HandleDeleteImages godoc
+//
+//	@Summary		批量删除图片
+//	@Description	删除数据集中满足条件的图片，图片上的标注一并删除，返回删除的数量
+//	@Tags			dataset
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int				true	"Dataset ID"
+//	@Param			filter	body		dto.ImageFilter	true	"Image filter"
+//	@Success		200		{object}	dto.Response{data=map[string]int64}
+//	@Router			/dataset/{id}/images/delete [post]
+func (t *DatasetRouter) HandleDeleteImages(ctx *gin.Context) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	var filter dto.ImageFilter
+	if err := ctx.ShouldBindJSON(&filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+	deleted, err := datasetDomain.DeleteImages(ctx.Request.Context(), uint(datasetID), filter)
+	if err != nil {
+		ctx.JSON(imageErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleDeleteImages", "datasetID", datasetID, "deleted", deleted)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(gin.H{"deleted": deleted}))
+}
+
+// HandleReplaceImage godoc
+//
+//	@Summary		替换图片
+//	@Description	替换图片文件，图片回到未嵌入状态并重新生成缩略图，默认删除图片上已有的标注
+//	@Tags			dataset
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			id				path		int		true	"Dataset ID"
+//	@Param			imgId			path		int		true	"Image ID"
+//	@Param			file			formData	file	true	"Image file"
+//	@Param			keepAnnotations	query		bool	false	"Keep existing annotations"
+//	@Success		200				{object}	dto.Response{data=domain.ImgDataset}
+//	@Router			/dataset/{id}/images/{imgId}/file [put]
+func (t *DatasetRouter) HandleReplaceImage(ctx *gin.Context) {
+	datasetID, imageID, ok := datasetImageParams(ctx)
+	if !ok {
+		return
+	}
+	keepAnnotations, _ := strconv.ParseBool(ctx.DefaultQuery("keepAnnotations", "false"))
+
+	img, err := domain.GetDatasetImage(ctx.Request.Context(), datasetID, imageID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	if img == nil {
+		ctx.JSON(http.StatusNotFound, dto.NewFailResponse(domain.ErrImageNotFound.Error()))
+		return
+	}
+
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+	maxSize := conf.GetUploadConfig().MaxFileSize
+	if fileHeader.Size > maxSize {
+		ctx.JSON(http.StatusRequestEntityTooLarge, dto.NewFailResponse("image too large"))
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxSize))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+
+	img, err = datasetDomain.ReplaceImage(ctx.Request.Context(), img, data, keepAnnotations)
+	if err != nil {
+		ctx.JSON(imageErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleReplaceImage", "datasetID", datasetID, "imageID", imageID, "keepAnnotations", keepAnnotations)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(img))
+}
+
+// HandleReorderImages godoc
+//
+//	@Summary		调整图片顺序
+//	@Description	按给定的顺序排列这些图片，它们占用的位置不变，其他图片的顺序不受影响
+//	@Tags			dataset
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int					true	"Dataset ID"
+//	@Param			order	body		dto.ReorderImages	true	"Image IDs in the new order"
+//	@Success		200		{object}	dto.Response
+//	@Router			/dataset/{id}/images/order [put]
+func (t *DatasetRouter) HandleReorderImages(ctx *gin.Context) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	var req dto.ReorderImages
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+	err = datasetDomain.ReorderImages(ctx.Request.Context(), uint(datasetID), req.ImageIDs)
+	if err != nil {
+		ctx.JSON(imageErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(nil))
+}
+
+// HandleResetImageStatus godoc
+//
+//	@Summary		重置图片状态
+//	@Description	管理员将满足条件的图片重置为未嵌入或已嵌入，重置为未嵌入时重新嵌入，返回重置的数量
+//	@Tags			dataset
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int						true	"Dataset ID"
+//	@Param			reset	body		dto.ResetImageStatus	true	"Image filter and target status"
+//	@Success		200		{object}	dto.Response{data=map[string]int64}
+//	@Router			/dataset/{id}/images/status [post]
+func (t *DatasetRouter) HandleResetImageStatus(ctx *gin.Context) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	var req dto.ResetImageStatus
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+	updated, err := datasetDomain.ResetImageStatus(ctx.Request.Context(), uint(datasetID), req)
+	if err != nil {
+		ctx.JSON(imageErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleResetImageStatus", "datasetID", datasetID, "target", req.Target, "updated", updated)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(gin.H{"updated": updated}))
+}
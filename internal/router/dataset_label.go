@@ -0,0 +1,148 @@
+package router
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"sapphire-server/internal/data/dto"
+	"sapphire-server/internal/domain"
+	"strconv"
+)
+
+// labelErrorStatus 标签错误对应的状态码
+func labelErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrDatasetNotFound), errors.Is(err, domain.ErrLabelNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrLabelExists), errors.Is(err, domain.ErrLabelInUse):
+		return http.StatusConflict
+	case errors.Is(err, domain.ErrInvalidLabel):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// datasetLabelParams 读取路径中的数据集 ID 和标签 ID
+func datasetLabelParams(ctx *gin.Context) (uint, uint, bool) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return 0, 0, false
+	}
+	labelID, err := strconv.Atoi(ctx.Param("labelId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid label id"))
+		return 0, 0, false
+	}
+	return uint(datasetID), uint(labelID), true
+}
+
+// HandleListLabels godoc
+//
+//	@Summary		获取标签列表
+//	@Description	按导出顺序列出数据集的标签，导出时类别的下标即为该顺序
+//	@Tags			dataset
+//	@Produce		json
+//	@Param			id	path		int	true	"Dataset ID"
+//	@Success		200	{object}	dto.Response{data=[]domain.Label}
+//	@Router			/dataset/{id}/labels [get]
+func (t *DatasetRouter) HandleListLabels(ctx *gin.Context) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	labels, err := domain.ListLabels(ctx.Request.Context(), uint(datasetID))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(labels))
+}
+
+// HandleCreateLabel godoc
+//
+//	@Summary		创建标签
+//	@Description	标签名在数据集中不能重复，没有指定位置时排在最后
+//	@Tags			dataset
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int				true	"Dataset ID"
+//	@Param			label	body		dto.NewLabel	true	"Label"
+//	@Success		200		{object}	dto.Response{data=domain.Label}
+//	@Router			/dataset/{id}/labels [post]
+func (t *DatasetRouter) HandleCreateLabel(ctx *gin.Context) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	var req dto.NewLabel
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+	label, err := domain.CreateLabel(ctx.Request.Context(), uint(datasetID), req)
+	if err != nil {
+		ctx.JSON(labelErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleCreateLabel", "datasetID", datasetID, "labelID", label.ID, "name", label.Name)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(label))
+}
+
+// HandleUpdateLabel godoc
+//
+//	@Summary		修改标签
+//	@Description	修改标签的名称、颜色、上级标签、允许的形状和属性定义，位置为空时保持不变
+//	@Tags			dataset
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int				true	"Dataset ID"
+//	@Param			labelId	path		int				true	"Label ID"
+//	@Param			label	body		dto.NewLabel	true	"Label"
+//	@Success		200		{object}	dto.Response{data=domain.Label}
+//	@Router			/dataset/{id}/labels/{labelId} [put]
+func (t *DatasetRouter) HandleUpdateLabel(ctx *gin.Context) {
+	datasetID, labelID, ok := datasetLabelParams(ctx)
+	if !ok {
+		return
+	}
+	var req dto.NewLabel
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+	label, err := domain.UpdateLabel(ctx.Request.Context(), datasetID, labelID, req)
+	if err != nil {
+		ctx.JSON(labelErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(label))
+}
+
+// HandleDeleteLabel godoc
+//
+//	@Summary		删除标签
+//	@Description	已经被标注使用或有下级标签的标签不能删除
+//	@Tags			dataset
+//	@Produce		json
+//	@Param			id		path		int	true	"Dataset ID"
+//	@Param			labelId	path		int	true	"Label ID"
+//	@Success		200		{object}	dto.Response
+//	@Router			/dataset/{id}/labels/{labelId} [delete]
+func (t *DatasetRouter) HandleDeleteLabel(ctx *gin.Context) {
+	datasetID, labelID, ok := datasetLabelParams(ctx)
+	if !ok {
+		return
+	}
+	err := domain.DeleteLabel(ctx.Request.Context(), datasetID, labelID)
+	if err != nil {
+		ctx.JSON(labelErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleDeleteLabel", "datasetID", datasetID, "labelID", labelID)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(nil))
+}
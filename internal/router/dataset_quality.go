@@ -0,0 +1,92 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"sapphire-server/internal/data/dto"
+	"sapphire-server/internal/domain"
+	"strconv"
+)
+
+// HandleGetQuality godoc
+//
+//	@Summary		获取数据集标注质量
+//	@Description	以多人标注的合并结果为参考，汇总平均 IoU、准确率、召回率、图片类别的 Krippendorff's alpha 和各标注员的指标
+//	@Tags			dataset
+//	@Produce		json
+//	@Param			id	path		int	true	"Dataset ID"
+//	@Success		200	{object}	dto.Response{data=domain.DatasetQuality}
+//	@Router			/dataset/{id}/quality [get]
+func (t *DatasetRouter) HandleGetQuality(ctx *gin.Context) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	quality, err := domain.GetDatasetQuality(ctx.Request.Context(), uint(datasetID))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(quality))
+}
+
+// HandleListImageQuality godoc
+//
+//	@Summary		获取各图片的标注质量
+//	@Description	分页获取至少两个标注员标注过的图片的一致性，可以按 meanIou 排序找出分歧较大的图片
+//	@Tags			dataset
+//	@Produce		json
+//	@Param			id			path		int		true	"Dataset ID"
+//	@Param			page		query		int		false	"Page number"
+//	@Param			pageSize	query		int		false	"Page size"
+//	@Param			sort		query		string	false	"Sort fields, e.g. meanIou"
+//	@Success		200			{object}	dto.Response{data=[]domain.ImageQuality}
+//	@Router			/dataset/{id}/quality/images [get]
+func (t *DatasetRouter) HandleListImageQuality(ctx *gin.Context) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	req, ok := bindPageRequest(ctx)
+	if !ok {
+		return
+	}
+	page, err := domain.ListImageQualityPage(ctx.Request.Context(), uint(datasetID), req)
+	if err != nil {
+		ctx.JSON(pageErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewPageResponse(page.Items, page.PageMeta))
+}
+
+// HandleRecomputeQuality godoc
+//
+//	@Summary		重新计算标注质量
+//	@Description	新标注提交时会自动计算所在图片，修改合并参数后可以调用该接口重新计算整个数据集
+//	@Tags			dataset
+//	@Produce		json
+//	@Param			id	path		int	true	"Dataset ID"
+//	@Success		200	{object}	dto.Response{data=domain.DatasetQuality}
+//	@Router			/dataset/{id}/quality/recompute [post]
+func (t *DatasetRouter) HandleRecomputeQuality(ctx *gin.Context) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	count, err := domain.RecomputeDatasetQuality(ctx.Request.Context(), uint(datasetID))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleRecomputeQuality", "datasetID", datasetID, "images", count)
+	quality, err := domain.GetDatasetQuality(ctx.Request.Context(), uint(datasetID))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(quality))
+}
@@ -22,7 +22,7 @@ func NewDiscussionRouter(engine *gin.Engine) *DiscussionRouter {
 		Use(middleware.UserIDMiddleware())
 	{
 		routerGroup.POST("/create", router.HandleCreate)
-		routerGroup.GET("/list/:id", router.HandleList)
+		routerGroup.GET("/list/:id", middleware.Require(domain.PermDatasetRead), router.HandleList)
 	}
 
 	return router
@@ -38,7 +38,10 @@ func (r *DiscussionRouter) HandleCreate(ctx *gin.Context) {
 		return
 	}
 
-	slog.Info("create discussion, userID: %d, body: %+v", userID, body)
+	slog.Info("create discussion", "userID", userID, "body", body)
+	if !middleware.Check(ctx, domain.PermDiscuss, body.DatasetID) {
+		return
+	}
 
 	// Create discussion
 	res := discussionDomain.CreateDiscussion(userID, body)
@@ -47,15 +50,22 @@ func (r *DiscussionRouter) HandleCreate(ctx *gin.Context) {
 
 func (r *DiscussionRouter) HandleList(ctx *gin.Context) {
 	var err error
-	userID := ctx.Keys["id"].(uint)
 	datasetID64, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
 	if err != nil {
 		ctx.JSON(400, gin.H{"error": "参数错误"})
 		return
 	}
 	datasetID := uint(datasetID64)
+	req, ok := bindPageRequest(ctx)
+	if !ok {
+		return
+	}
 
 	// List discussion
-	res := discussionDomain.ListDiscussionsByDatasetID(userID, datasetID)
-	ctx.JSON(200, dto.NewSuccessResponse(res))
+	res, page, err := discussionDomain.ListDiscussionPageByDatasetID(datasetID, req)
+	if err != nil {
+		ctx.JSON(pageErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(200, dto.NewPageResponse(res, page))
 }
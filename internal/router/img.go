@@ -1,18 +1,18 @@
 package router
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"io"
 	"mime/multipart"
 	"net/http"
-	"regexp"
 	"sapphire-server/internal/conf"
 	"sapphire-server/internal/data/dto"
-	"strconv"
-	"strings"
-	"time"
+	"sapphire-server/internal/middleware"
+	"sapphire-server/internal/storage"
+	"sapphire-server/pkg/misc"
 )
 
 type ImgRouter struct {
@@ -20,12 +20,22 @@ type ImgRouter struct {
 
 func NewImgRouter(engine *gin.Engine) *ImgRouter {
 	router := &ImgRouter{}
-	imgGroup := engine.Group("/img")
+	// 头像和封面在数据集之外上传，只要求登录，对象 key 中带有用户 ID
+	imgGroup := engine.Group("/img").Use(middleware.AuthMiddleware()).Use(middleware.UserIDMiddleware())
 	imgGroup.POST("/upload", router.HandleUpload)
 	return router
 }
 
-// HandleUpload Upload a picture
+// HandleUpload godoc
+//
+//	@Summary		上传图片
+//	@Description	上传单张图片到存储，用于头像和封面，返回访问地址，图片格式按文件内容判断
+//	@Tags			img
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			file	formData	file	true	"Image"
+//	@Success		200		{object}	map[string]string
+//	@Router			/img/upload [post]
 func (t *ImgRouter) HandleUpload(ctx *gin.Context) {
 	// Read from form-data
 	file, err := ctx.FormFile("file")
@@ -33,14 +43,19 @@ func (t *ImgRouter) HandleUpload(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
 		return
 	}
-	// Judge if the file is a picture
-	if file.Header.Get("Content-Type") != "image/jpeg" && file.Header.Get("Content-Type") != "image/png" {
-		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("only support jpg and png"))
+
+	maxSize := conf.GetUploadConfig().MaxFileSize
+	if file.Size > maxSize {
+		ctx.JSON(http.StatusRequestEntityTooLarge, dto.NewFailResponse(fmt.Sprintf("image should not exceed %d bytes", maxSize)))
 		return
 	}
 
 	// Start uploading
-	directUrl, err := t.Upload(file)
+	directUrl, err := t.Upload(ctx.Keys["id"].(uint), file, maxSize)
+	if errors.Is(err, misc.ErrUnsupportedImage) || errors.Is(err, errImageTooLarge) {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
 		return
@@ -50,92 +65,33 @@ func (t *ImgRouter) HandleUpload(ctx *gin.Context) {
 	})
 }
 
-// Upload Implement the upload function
-func (t *ImgRouter) Upload(fileHeader *multipart.FileHeader) (string, error) {
-	info := conf.GetImgConfig()
-	re := regexp.MustCompile(`svrUrl: (.*?); directUrl: (.*?); auth string: (.*?);`)
-	matches := re.FindStringSubmatch(info)
-	var svrUrl, directUrl, auth string
-	if len(matches) == 4 {
-		svrUrl = matches[1]
-		directUrl = matches[2]
-		auth = matches[3]
-	} else {
-		fmt.Println("String format is not valid.")
-	}
+// errImageTooLarge 文件实际内容超过大小上限
+var errImageTooLarge = errors.New("image too large")
 
-	filename := "sapphire_" + strconv.FormatInt(time.Now().Unix(), 10) + t.getExtension(fileHeader.Header.Get("Content-Type"))
-
-	// 打开文件
+// Upload Implement the upload function
+func (t *ImgRouter) Upload(userID uint, fileHeader *multipart.FileHeader, maxSize int64) (string, error) {
 	file, err := fileHeader.Open()
 	if err != nil {
 		return "", err
 	}
-	defer func(file multipart.File) {
-		err := file.Close()
-		if err != nil {
-			fmt.Println("Failed to close file:", err)
-		}
-	}(file)
-
-	fileBytes, err := io.ReadAll(file)
+	defer file.Close()
+	// 多读一个字节判断是否超过上限
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
 	if err != nil {
 		return "", err
 	}
-
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	req, err := http.NewRequest("PUT", svrUrl+filename, bytes.NewReader(fileBytes))
-	if err != nil {
-		return "", err
+	if int64(len(data)) > maxSize {
+		return "", errImageTooLarge
 	}
 
-	req.Header.Set("Authorization", auth)
-
-	// 发送请求
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			fmt.Println("Failed to close response body:", err)
-		}
-	}(resp.Body)
-
-	// 检查响应状态
-	if resp.StatusCode != http.StatusCreated {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	// 不信任客户端的 Content-Type，按文件头判断格式
+	contentType, ext, ok := misc.SniffImage(data)
+	if !ok {
+		return "", misc.ErrUnsupportedImage
 	}
-
-	// 读取响应体
-	_, err = io.ReadAll(resp.Body)
+	key, err := misc.UniqueKey(fmt.Sprintf("user%d%s", userID, ext))
 	if err != nil {
 		return "", err
 	}
-
-	return directUrl + filename, nil
-}
-
-func (t *ImgRouter) getExtension(contentType string) string {
-	fileTypeMap := map[string]string{
-		"image/jpeg": ".jpg",
-		"image/png":  ".png",
-		"image/gif":  ".gif",
-	}
-
-	parts := strings.Split(contentType, "/")
-	if len(parts) != 2 {
-		return ""
-	}
-
-	extension, ok := fileTypeMap[contentType]
-	if !ok {
-		return ""
-	}
-
-	return extension
+	return storage.PutBytes(context.Background(), key, data, contentType)
 }
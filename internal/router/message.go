@@ -33,9 +33,9 @@ func (t *MessageRouter) HandleCreate(ctx *gin.Context) {
 		return
 	}
 
-	message := messageDomain.CreateMessage(creatorID, body)
-	if message == nil {
-		ctx.JSON(500, dto.NewFailResponse("create message failed"))
+	_, err = messageDomain.CreateMessage(ctx.Request.Context(), creatorID, body)
+	if err != nil {
+		ctx.JSON(500, dto.NewFailResponse(err.Error()))
 		return
 	}
 
@@ -43,14 +43,17 @@ func (t *MessageRouter) HandleCreate(ctx *gin.Context) {
 }
 
 func (t *MessageRouter) HandleList(ctx *gin.Context) {
-	var _ error
 	receiverID := ctx.Keys["id"].(uint)
-	messages := messageDomain.ListMessageByReceiverID(receiverID)
-	if messages == nil {
-		ctx.JSON(500, dto.NewFailResponse("list message failed"))
+	req, ok := bindPageRequest(ctx)
+	if !ok {
+		return
+	}
+	page, err := messageDomain.ListMessagePageByReceiverID(receiverID, req)
+	if err != nil {
+		ctx.JSON(pageErrorStatus(err), dto.NewFailResponse(err.Error()))
 		return
 	}
-	ctx.JSON(200, dto.NewSuccessResponse(messages))
+	ctx.JSON(200, dto.NewPageResponse(page.Items, page.PageMeta))
 }
 
 func (t *MessageRouter) HandleRead(ctx *gin.Context) {
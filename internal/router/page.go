@@ -0,0 +1,32 @@
+package router
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sapphire-server/internal/dao"
+	"sapphire-server/internal/data/dto"
+)
+
+// bindPageRequest 读取分页参数，参数不合法时直接返回 400
+func bindPageRequest(ctx *gin.Context) (dao.PageRequest, bool) {
+	query := dto.PageQuery{}
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return dao.PageRequest{}, false
+	}
+	req, err := dao.NewPageRequest(query)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return dao.PageRequest{}, false
+	}
+	return req, true
+}
+
+// pageErrorStatus 分页参数错误返回 400，其余返回 500
+func pageErrorStatus(err error) int {
+	if errors.Is(err, dao.ErrInvalidPageRequest) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
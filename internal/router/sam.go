@@ -0,0 +1,184 @@
+package router
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"sapphire-server/internal/data/dto"
+	"sapphire-server/internal/domain"
+	"sapphire-server/internal/middleware"
+	"strconv"
+)
+
+// SamRouter 嵌入模型注册表路由
+type SamRouter struct {
+}
+
+func NewSamRouter(engine *gin.Engine) *SamRouter {
+	router := &SamRouter{}
+	samGroup := engine.Group("/model").
+		Use(middleware.AuthOrAPIKey()).
+		Use(middleware.Require(domain.PermTaskManage))
+	samGroup.POST("/register", router.HandleRegister)
+	samGroup.GET("/list", router.HandleList)
+	samGroup.GET("/:id", router.HandleGet)
+	samGroup.POST("/:id/deprecate", router.HandleDeprecate)
+	samGroup.DELETE("/:id", router.HandleDelete)
+	return router
+}
+
+// HandleRegister godoc
+//
+//	@Summary		注册模型
+//	@Description	注册 SAM/ONNX 嵌入模型，同名模型的新版本需要单独注册
+//	@Tags			model
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		dto.NewSam	true	"Name, version, checksum, input size and storage URL"
+//	@Success		200		{object}	dto.Response{data=domain.Sam}
+//	@Router			/model/register [post]
+func (s *SamRouter) HandleRegister(ctx *gin.Context) {
+	var req dto.NewSam
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+	sam, err := domain.RegisterSam(ctx.Request.Context(), req)
+	if err != nil {
+		ctx.JSON(samErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleRegisterModel", "id", sam.ID, "name", sam.Name, "version", sam.Version)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(sam))
+}
+
+// HandleList godoc
+//
+//	@Summary		获取模型列表
+//	@Description	包括已废弃的模型
+//	@Tags			model
+//	@Accept			json
+//	@Produce		json
+//	@Param			page		query		int		false	"Page number"
+//	@Param			pageSize	query		int		false	"Page size"
+//	@Param			sort		query		string	false	"Sort fields, e.g. -createdAt"
+//	@Param			cursor		query		string	false	"Cursor for cursor pagination"
+//	@Param			filter		query		[]string	false	"Filters, e.g. status:eq:active"
+//	@Success		200	{object}	dto.Response{data=[]domain.Sam}
+//	@Router			/model/list [get]
+func (s *SamRouter) HandleList(ctx *gin.Context) {
+	req, ok := bindPageRequest(ctx)
+	if !ok {
+		return
+	}
+	page, err := domain.ListSamPage(ctx.Request.Context(), req)
+	if err != nil {
+		ctx.JSON(pageErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewPageResponse(page.Items, page.PageMeta))
+}
+
+// HandleGet godoc
+//
+//	@Summary		获取模型
+//	@Tags			model
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		int	true	"Model ID"
+//	@Success		200	{object}	dto.Response{data=domain.Sam}
+//	@Router			/model/{id} [get]
+func (s *SamRouter) HandleGet(ctx *gin.Context) {
+	id, ok := samID(ctx)
+	if !ok {
+		return
+	}
+	sam, err := domain.GetSam(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	if sam == nil {
+		ctx.JSON(http.StatusNotFound, dto.NewFailResponse(domain.ErrSamNotFound.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(sam))
+}
+
+// HandleDeprecate godoc
+//
+//	@Summary		废弃模型
+//	@Description	废弃的模型不再作为默认模型，也不能被数据集选用，已选用的数据集继续使用
+//	@Tags			model
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		int	true	"Model ID"
+//	@Success		200	{object}	dto.Response{data=domain.Sam}
+//	@Router			/model/{id}/deprecate [post]
+func (s *SamRouter) HandleDeprecate(ctx *gin.Context) {
+	id, ok := samID(ctx)
+	if !ok {
+		return
+	}
+	sam, err := domain.DeprecateSam(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.JSON(samErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleDeprecateModel", "id", sam.ID, "name", sam.Name, "version", sam.Version)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(sam))
+}
+
+// HandleDelete godoc
+//
+//	@Summary		删除模型
+//	@Description	仍有数据集选用时返回 409，排队中的任务一起取消
+//	@Tags			model
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		int	true	"Model ID"
+//	@Success		200	{object}	dto.Response
+//	@Router			/model/{id} [delete]
+func (s *SamRouter) HandleDelete(ctx *gin.Context) {
+	id, ok := samID(ctx)
+	if !ok {
+		return
+	}
+	err := domain.DeleteSam(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.JSON(samErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleDeleteModel", "id", id)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(nil))
+}
+
+func samID(ctx *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid model id"))
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// samErrorStatus 模型管理错误对应的状态码
+func samErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrSamNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrSamExists), errors.Is(err, domain.ErrSamInUse):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// samSelectionStatus 数据集选用的模型不存在或已废弃时返回 400
+func samSelectionStatus(err error) int {
+	if errors.Is(err, domain.ErrSamNotFound) || errors.Is(err, domain.ErrSamDeprecated) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
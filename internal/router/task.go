@@ -1,13 +1,16 @@
 package router
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
+	"log/slog"
 	"net/http"
 	"sapphire-server/internal/dao"
 	"sapphire-server/internal/data/dto"
 	"sapphire-server/internal/domain"
 	"sapphire-server/internal/middleware"
 	"strconv"
+	"time"
 )
 
 type TaskRouter struct {
@@ -15,11 +18,21 @@ type TaskRouter struct {
 
 func NewTaskRouter(engine *gin.Engine) *TaskRouter {
 	router := &TaskRouter{}
-	taskGroup := engine.Group("/task").Use(middleware.UserIDMiddleware())
-	taskGroup.GET("/list", router.HandleList)
-	taskGroup.GET("/next", router.HandleNext)
-	taskGroup.POST("/create", router.HandleCreate)
-	taskGroup.POST("/update", router.HandleUpdate)
+	// worker 使用 API key 认证，管理接口和 worker 接口分别检查权限
+	taskGroup := engine.Group("/task").
+		Use(middleware.AuthOrAPIKey()).
+		Use(middleware.UserIDMiddleware())
+	manage := middleware.Require(domain.PermTaskManage)
+	taskGroup.GET("/list", manage, router.HandleList)
+	taskGroup.POST("/create", manage, router.HandleCreate)
+	taskGroup.POST("/update", manage, router.HandleUpdate)
+
+	worker := middleware.Require(domain.PermEmbeddingWrite)
+	taskGroup.GET("/next", worker, router.HandleNext)
+	taskGroup.POST("/claim", worker, router.HandleClaim)
+	taskGroup.POST("/:id/heartbeat", worker, router.HandleHeartbeat)
+	taskGroup.POST("/:id/complete", worker, router.HandleComplete)
+	taskGroup.POST("/:id/fail", worker, router.HandleFail)
 	return router
 }
 
@@ -30,11 +43,24 @@ func NewTaskRouter(engine *gin.Engine) *TaskRouter {
 //	@Tags			task
 //	@Accept			json
 //	@Produce		json
+//	@Param			page		query		int		false	"Page number"
+//	@Param			pageSize	query		int		false	"Page size"
+//	@Param			sort		query		string	false	"Sort fields, e.g. -createdAt"
+//	@Param			cursor		query		string	false	"Cursor for cursor pagination"
+//	@Param			filter		query		[]string	false	"Filters, e.g. status:eq:0"
 //	@Success		200	{object}	dto.Response{data=[]interface{}}
 //	@Router			/task/list [get]
 func (t *TaskRouter) HandleList(ctx *gin.Context) {
-	tasks, _ := dao.FindAll[domain.Task]()
-	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(tasks))
+	req, ok := bindPageRequest(ctx)
+	if !ok {
+		return
+	}
+	page, err := domain.NewTask().ListTaskPage(req)
+	if err != nil {
+		ctx.JSON(pageErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewPageResponse(page.Items, page.PageMeta))
 }
 
 // HandleCreate godoc
@@ -54,8 +80,8 @@ func (t *TaskRouter) HandleCreate(ctx *gin.Context) {
 	// Convert onnxId to int
 	onnxIdInt, _ := strconv.Atoi(onnxId)
 	// Check if the onnxId is valid
-	_, err := dao.First[domain.Sam]("id = ?", onnxIdInt)
-	if err != nil {
+	sam, err := dao.First[domain.Sam](ctx.Request.Context(), "id = ?", onnxIdInt)
+	if err != nil || sam == nil {
 		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse("onnxId is invalid"))
 		return
 	}
@@ -68,20 +94,160 @@ func (t *TaskRouter) HandleCreate(ctx *gin.Context) {
 // HandleNext godoc
 //
 //	@Summary		获取下一个任务
-//	@Description	获取下一个任务
+//	@Description	兼容旧的 worker，与 /task/claim 相同，使用默认的租约时长
 //	@Tags			task
 //	@Accept			json
 //	@Produce		json
-//	@Success		200	{object}	domain.Task
+//	@Param			workerId	query		string	false	"Worker ID, default client IP"
+//	@Success		200			{object}	dto.Response{data=domain.TaskLease}
 //	@Router			/task/next [get]
 func (t *TaskRouter) HandleNext(ctx *gin.Context) {
-	task := domain.NewTask()
-	nextTask := task.GetLatestTask()
-	if nextTask == nil {
+	workerID := ctx.DefaultQuery("workerId", ctx.ClientIP())
+	lease, err := domain.ClaimTask(ctx.Request.Context(), workerID, 0)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	if lease == nil {
 		ctx.JSON(http.StatusOK, dto.NewFailResponse("No task available"))
 		return
 	}
-	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(nextTask))
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(lease))
+}
+
+// HandleClaim godoc
+//
+//	@Summary		认领任务
+//	@Description	认领最早的待执行任务，worker 需要在租约到期前发送心跳，否则任务重新排队；没有任务时返回 204
+//	@Tags			task
+//	@Accept			json
+//	@Produce		json
+//	@Param			claim	body		dto.ClaimTask	true	"Worker and lease"
+//	@Success		200		{object}	dto.Response{data=domain.TaskLease}
+//	@Success		204
+//	@Router			/task/claim [post]
+func (t *TaskRouter) HandleClaim(ctx *gin.Context) {
+	var req dto.ClaimTask
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+	lease, err := domain.ClaimTask(ctx.Request.Context(), req.WorkerID, time.Duration(req.LeaseSeconds)*time.Second)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	if lease == nil {
+		ctx.Status(http.StatusNoContent)
+		return
+	}
+	slog.Info("HandleClaim", "taskID", lease.ID, "workerID", req.WorkerID, "attempts", lease.Attempts)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(lease))
+}
+
+// HandleHeartbeat godoc
+//
+//	@Summary		任务心跳
+//	@Description	延长任务的租约，租约已经失效时返回 409，worker 应放弃该任务
+//	@Tags			task
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int					true	"Task ID"
+//	@Param			heartbeat	body		dto.TaskHeartbeat	true	"Lease token"
+//	@Success		200			{object}	dto.Response{data=domain.Task}
+//	@Router			/task/{id}/heartbeat [post]
+func (t *TaskRouter) HandleHeartbeat(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid task id"))
+		return
+	}
+	var req dto.TaskHeartbeat
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+	task, err := domain.HeartbeatTask(ctx.Request.Context(), id, req.LeaseToken, time.Duration(req.LeaseSeconds)*time.Second)
+	if err != nil {
+		ctx.JSON(taskErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(task))
+}
+
+// HandleComplete godoc
+//
+//	@Summary		完成任务
+//	@Description	汇报嵌入结果，图片标记为已嵌入；租约已经失效时返回 409
+//	@Tags			task
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int					true	"Task ID"
+//	@Param			complete	body		dto.CompleteTask	true	"Lease token and embedding URL"
+//	@Success		200			{object}	dto.Response
+//	@Router			/task/{id}/complete [post]
+func (t *TaskRouter) HandleComplete(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid task id"))
+		return
+	}
+	var req dto.CompleteTask
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+	err = domain.CompleteTask(ctx.Request.Context(), id, req.LeaseToken, req.EmbeddingURL)
+	if err != nil {
+		ctx.JSON(taskErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleComplete", "taskID", id)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(nil))
+}
+
+// HandleFail godoc
+//
+//	@Summary		任务失败
+//	@Description	汇报失败，可以重试且没有超过次数上限时重新排队，否则任务和图片标记为失败；租约已经失效时返回 409
+//	@Tags			task
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int				true	"Task ID"
+//	@Param			fail	body		dto.FailTask	true	"Lease token and error"
+//	@Success		200		{object}	dto.Response{data=domain.Task}
+//	@Router			/task/{id}/fail [post]
+func (t *TaskRouter) HandleFail(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid task id"))
+		return
+	}
+	var req dto.FailTask
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+	retryable := req.Retryable == nil || *req.Retryable
+	task, err := domain.FailTask(ctx.Request.Context(), id, req.LeaseToken, req.Error, retryable)
+	if err != nil {
+		ctx.JSON(taskErrorStatus(err), dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleFail", "taskID", id, "status", task.Status, "attempts", task.Attempts, "err", req.Error)
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(task))
+}
+
+// taskErrorStatus 任务汇报错误对应的状态码
+func taskErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrTaskNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrTaskLeaseLost):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 func (t *TaskRouter) HandleUpdate(ctx *gin.Context) {
@@ -92,7 +258,7 @@ func (t *TaskRouter) HandleUpdate(ctx *gin.Context) {
 	// Convert status to int
 	statusInt, _ := strconv.Atoi(status)
 	// Check if the task exists
-	task, _ := dao.First[domain.Task]("id = ?", taskIdInt)
+	task, _ := dao.First[domain.Task](ctx.Request.Context(), "id = ?", taskIdInt)
 	if task == nil {
 		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse("task not found"))
 		return
@@ -103,7 +269,7 @@ func (t *TaskRouter) HandleUpdate(ctx *gin.Context) {
 		return
 	}
 	// Update the task status
-	err := dao.Modify(task, "status", strconv.Itoa(statusInt))
+	err := dao.Modify(ctx.Request.Context(), task, "status", strconv.Itoa(statusInt))
 	if err != nil {
 		return
 	}
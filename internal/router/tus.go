@@ -0,0 +1,270 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"sapphire-server/internal/conf"
+	"sapphire-server/internal/data/dto"
+	"sapphire-server/internal/domain"
+	"sapphire-server/internal/middleware"
+	"strconv"
+)
+
+// tus 1.0 协议，见 https://tus.io/protocols/resumable-upload
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+)
+
+// TusRouter 数据集压缩包的可续传上传，上传完成后交给上传任务处理
+type TusRouter struct {
+}
+
+func NewTusRouter(engine *gin.Engine) *TusRouter {
+	router := &TusRouter{}
+	tusGroup := engine.Group("/dataset")
+	tusGroup.OPTIONS("/:id/uploads", router.HandleOptions)
+	tusGroup.OPTIONS("/:id/uploads/:uploadId", router.HandleOptions)
+
+	authRouter := tusGroup.Group("", middleware.AuthMiddleware(), middleware.UserIDMiddleware(), router.tusResumable)
+	{
+		authRouter.POST("/:id/uploads", middleware.Require(domain.PermImageWrite), router.HandleCreate)
+		authRouter.HEAD("/:id/uploads/:uploadId", middleware.Require(domain.PermImageWrite), router.HandleHead)
+		authRouter.PATCH("/:id/uploads/:uploadId", middleware.Require(domain.PermImageWrite), router.HandlePatch)
+		authRouter.DELETE("/:id/uploads/:uploadId", middleware.Require(domain.PermImageWrite), router.HandleTerminate)
+	}
+	return router
+}
+
+// tusResumable 检查客户端的协议版本，并在响应中带上服务端的版本
+func (t *TusRouter) tusResumable(ctx *gin.Context) {
+	ctx.Header("Tus-Resumable", tusVersion)
+	ctx.Header("Access-Control-Expose-Headers", "Location, Upload-Offset, Upload-Length, Upload-Expires, Tus-Resumable, Upload-Job-Id")
+	if ctx.GetHeader("Tus-Resumable") != tusVersion {
+		ctx.Header("Tus-Version", tusVersion)
+		ctx.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	}
+	ctx.Next()
+}
+
+// HandleOptions godoc
+//
+//	@Summary		可续传上传的服务端能力
+//	@Description	返回支持的 tus 版本、扩展和最大上传大小
+//	@Tags			dataset
+//	@Param			id	path	int	true	"Dataset ID"
+//	@Success		204
+//	@Router			/dataset/{id}/uploads [options]
+func (t *TusRouter) HandleOptions(ctx *gin.Context) {
+	ctx.Header("Tus-Resumable", tusVersion)
+	ctx.Header("Tus-Version", tusVersion)
+	ctx.Header("Tus-Extension", tusExtensions)
+	ctx.Header("Tus-Max-Size", strconv.FormatInt(conf.GetUploadConfig().MaxArchiveSize, 10))
+	ctx.Status(http.StatusNoContent)
+}
+
+// HandleCreate godoc
+//
+//	@Summary		创建可续传上传
+//	@Description	tus creation 扩展，Upload-Length 为压缩包大小，Upload-Metadata 中的 filename 为文件名，返回的 Location 用于后续的 HEAD 和 PATCH
+//	@Tags			dataset
+//	@Param			id				path	int		true	"Dataset ID"
+//	@Param			Upload-Length	header	int		true	"Archive size"
+//	@Param			Upload-Metadata	header	string	false	"tus metadata"
+//	@Success		201
+//	@Router			/dataset/{id}/uploads [post]
+func (t *TusRouter) HandleCreate(ctx *gin.Context) {
+	datasetID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid dataset id"))
+		return
+	}
+	length, err := strconv.ParseInt(ctx.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid Upload-Length"))
+		return
+	}
+	if length > conf.GetUploadConfig().MaxArchiveSize {
+		ctx.JSON(http.StatusRequestEntityTooLarge, dto.NewFailResponse("archive too large"))
+		return
+	}
+	metadata, err := domain.ParseUploadMetadata(ctx.GetHeader("Upload-Metadata"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+
+	userID := ctx.Keys["id"].(uint)
+	upload, err := domain.DefaultResumableStore.Create(uint(datasetID), userID, length, metadata)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	slog.Info("HandleCreate resumable upload", "id", upload.ID, "datasetID", datasetID, "length", length)
+
+	ctx.Header("Location", fmt.Sprintf("%s/%s", ctx.Request.URL.Path, upload.ID))
+	ctx.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	ctx.Status(http.StatusCreated)
+}
+
+// HandleHead godoc
+//
+//	@Summary		查询可续传上传的进度
+//	@Description	Upload-Offset 为服务端已经收到的字节数，客户端从该位置继续上传；上传完成后 Upload-Job-Id 为上传任务 ID
+//	@Tags			dataset
+//	@Param			id			path	int		true	"Dataset ID"
+//	@Param			uploadId	path	string	true	"Upload ID"
+//	@Success		200
+//	@Router			/dataset/{id}/uploads/{uploadId} [head]
+func (t *TusRouter) HandleHead(ctx *gin.Context) {
+	upload, ok := t.loadUpload(ctx)
+	if !ok {
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	t.writeUploadHeaders(ctx, upload)
+	ctx.Status(http.StatusOK)
+}
+
+// HandlePatch godoc
+//
+//	@Summary		上传一段数据
+//	@Description	Upload-Offset 必须等于服务端已经收到的字节数，全部收到后创建上传任务，Upload-Job-Id 为任务 ID
+//	@Tags			dataset
+//	@Accept			application/offset+octet-stream
+//	@Param			id				path	int		true	"Dataset ID"
+//	@Param			uploadId		path	string	true	"Upload ID"
+//	@Param			Upload-Offset	header	int		true	"Current offset"
+//	@Success		204
+//	@Router			/dataset/{id}/uploads/{uploadId} [patch]
+func (t *TusRouter) HandlePatch(ctx *gin.Context) {
+	if ctx.ContentType() != "application/offset+octet-stream" {
+		ctx.JSON(http.StatusUnsupportedMediaType, dto.NewFailResponse("content type should be application/offset+octet-stream"))
+		return
+	}
+	offset, err := strconv.ParseInt(ctx.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid Upload-Offset"))
+		return
+	}
+	upload, ok := t.loadUpload(ctx)
+	if !ok {
+		return
+	}
+
+	upload, err = domain.DefaultResumableStore.WriteChunk(upload.ID, offset, ctx.Request.Body)
+	switch {
+	case errors.Is(err, domain.ErrResumableNotFound):
+		ctx.JSON(http.StatusNotFound, dto.NewFailResponse(err.Error()))
+		return
+	case errors.Is(err, domain.ErrResumableOffsetMismatch):
+		ctx.JSON(http.StatusConflict, dto.NewFailResponse(err.Error()))
+		return
+	case errors.Is(err, domain.ErrResumableTooLarge):
+		ctx.JSON(http.StatusRequestEntityTooLarge, dto.NewFailResponse(err.Error()))
+		return
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+
+	// 全部收到后交给上传任务，与 /dataset/upload/:id 走同样的处理流程
+	if upload.Completed() && upload.JobID == 0 {
+		job, err := t.startJob(ctx, upload)
+		if err != nil {
+			// 文件已经不完整或不是合法的压缩包，客户端需要重新上传
+			domain.DefaultResumableStore.Terminate(upload.ID)
+			ctx.JSON(uploadErrorStatus(err), dto.NewFailResponse(err.Error()))
+			return
+		}
+		slog.Info("HandlePatch resumable upload completed", "id", upload.ID, "jobID", job.ID)
+	}
+
+	t.writeUploadHeaders(ctx, upload)
+	ctx.Status(http.StatusNoContent)
+}
+
+// HandleTerminate godoc
+//
+//	@Summary		取消可续传上传
+//	@Description	tus termination 扩展，删除已经收到的数据
+//	@Tags			dataset
+//	@Param			id			path	int		true	"Dataset ID"
+//	@Param			uploadId	path	string	true	"Upload ID"
+//	@Success		204
+//	@Router			/dataset/{id}/uploads/{uploadId} [delete]
+func (t *TusRouter) HandleTerminate(ctx *gin.Context) {
+	upload, ok := t.loadUpload(ctx)
+	if !ok {
+		return
+	}
+	err := domain.DefaultResumableStore.Terminate(upload.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// loadUpload 读取路径中的上传，只有创建者可以访问
+func (t *TusRouter) loadUpload(ctx *gin.Context) (*domain.ResumableUpload, bool) {
+	upload, err := domain.DefaultResumableStore.Get(ctx.Param("uploadId"))
+	if errors.Is(err, domain.ErrResumableNotFound) {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return nil, false
+	}
+	userID := ctx.Keys["id"].(uint)
+	if strconv.FormatUint(uint64(upload.DatasetID), 10) != ctx.Param("id") || upload.UserID != userID {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return nil, false
+	}
+	return upload, true
+}
+
+// startJob 将上传完成的文件移到新的工作目录并创建上传任务
+func (t *TusRouter) startJob(ctx *gin.Context, upload *domain.ResumableUpload) (*domain.UploadJob, error) {
+	dataset, err := datasetDomain.GetDatasetByID(upload.DatasetID)
+	if err != nil {
+		return nil, err
+	}
+	if dataset == nil {
+		return nil, errors.New("dataset not found")
+	}
+
+	ws, err := domain.NewUploadWorkspace(conf.GetUploadConfig().TempDir)
+	if err != nil {
+		return nil, err
+	}
+	err = domain.DefaultResumableStore.Complete(upload, ws)
+	if err != nil {
+		ws.Remove()
+		return nil, err
+	}
+	job, err := startUploadJob(ctx.Request.Context(), dataset, upload.UserID, ws, upload.FileName())
+	if err != nil {
+		return nil, err
+	}
+	err = domain.DefaultResumableStore.SetJob(upload, job.ID)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (t *TusRouter) writeUploadHeaders(ctx *gin.Context, upload *domain.ResumableUpload) {
+	ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	ctx.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.JobID != 0 {
+		ctx.Header("Upload-Job-Id", strconv.FormatUint(uint64(upload.JobID), 10))
+	} else {
+		ctx.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
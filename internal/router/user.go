@@ -1,12 +1,14 @@
 package router
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"sapphire-server/internal/dao"
 	"sapphire-server/internal/data/dto"
 	"sapphire-server/internal/domain"
 	"sapphire-server/internal/middleware"
+	"sapphire-server/pkg/util"
 	"strconv"
 )
 
@@ -23,14 +25,17 @@ func NewUserRouter(engine *gin.Engine) *UserRouter {
 	userGroup := engine.Group("/user")
 	userGroup.POST("/register", router.HandleRegister)
 	userGroup.POST("/login", router.HandleLogin)
-	userGroup.POST("/change-role", router.HandleChangeRole)
+	userGroup.POST("/token/refresh", router.HandleRefreshToken)
 	userGroup.GET("/profile/:id", router.HandleProfile)
 
 	authGroup := userGroup.Group("").Use(middleware.AuthMiddleware())
 	{
+		authGroup.POST("/logout", router.HandleLogout)
+		authGroup.POST("/change-role", middleware.Require(domain.PermUserManage), router.HandleChangeRole)
 		authGroup.POST("/passwd/change", router.HandleChangePasswd)
 		authGroup.POST("/info/change", router.HandleChangeInfo)
 		authGroup.GET("/rank/list", router.HandleUserRankList)
+		authGroup.GET("/:id/quality", router.HandleQuality)
 	}
 
 	statisticGroup := userGroup.Group("/statistic")
@@ -86,7 +91,7 @@ func (u *UserRouter) HandleRegister(ctx *gin.Context) {
 		return
 	}
 
-	token, user, err := userDomain.Register(body)
+	token, user, err := userDomain.Register(ctx.Request.Context(), body)
 	if err != nil {
 		if err.Error() == "existed user" {
 			ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("existed user"))
@@ -97,8 +102,11 @@ func (u *UserRouter) HandleRegister(ctx *gin.Context) {
 	}
 	// 复杂的话在 service 层处理
 	payload := map[string]interface{}{
-		"token": token,
-		"user":  user,
+		"token":            token.AccessToken,
+		"refreshToken":     token.RefreshToken,
+		"expiresIn":        token.ExpiresIn,
+		"refreshExpiresIn": token.RefreshExpiresIn,
+		"user":             user,
 	}
 	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(payload))
 }
@@ -121,7 +129,7 @@ func (u *UserRouter) HandleLogin(ctx *gin.Context) {
 		return
 	}
 
-	token, user, err := userDomain.Login(*body)
+	token, user, err := userDomain.Login(ctx.Request.Context(), *body)
 	if err != nil {
 		if err.Error() == "wrong password" {
 			ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("wrong password"))
@@ -133,13 +141,80 @@ func (u *UserRouter) HandleLogin(ctx *gin.Context) {
 	}
 	// 复杂的话在 service 层处理
 	payload := map[string]interface{}{
-		"token": token,
-		"user":  user,
+		"token":            token.AccessToken,
+		"refreshToken":     token.RefreshToken,
+		"expiresIn":        token.ExpiresIn,
+		"refreshExpiresIn": token.RefreshExpiresIn,
+		"user":             user,
 	}
 
 	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(payload))
 }
 
+// HandleRefreshToken godoc
+//
+//	@Summary		刷新 token
+//	@Description	使用 refresh token 换取新的 access token 和 refresh token，旧的 refresh token 失效
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		dto.RefreshToken	true	"Refresh Token"
+//	@Success		200		{object}	dto.Response{data=domain.TokenPair}
+//	@Router			/user/token/refresh [post]
+func (u *UserRouter) HandleRefreshToken(ctx *gin.Context) {
+	body := dto.RefreshToken{}
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		return
+	}
+
+	token, err := domain.RefreshTokenPair(ctx.Request.Context(), body.RefreshToken)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidRefreshToken) {
+			ctx.JSON(http.StatusUnauthorized, dto.NewFailResponse(err.Error()))
+		} else {
+			ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(token))
+}
+
+// HandleLogout godoc
+//
+//	@Summary		登出
+//	@Description	吊销当前 access token 和传入的 refresh token
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		dto.Logout	false	"Logout"
+//	@Success		200		{object}	dto.Response{data=interface{}}
+//	@Router			/user/logout [post]
+func (u *UserRouter) HandleLogout(ctx *gin.Context) {
+	body := dto.Logout{}
+	// 请求体可以为空
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+			return
+		}
+	}
+	claims := ctx.MustGet("claims").(*util.UserClaims)
+
+	err := domain.Logout(ctx.Request.Context(), claims, body.RefreshToken)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidRefreshToken) {
+			ctx.JSON(http.StatusBadRequest, dto.NewFailResponse(err.Error()))
+		} else {
+			ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(nil))
+}
+
 // HandleChangeRole godoc
 //
 //	@Summary		修改用户角色
@@ -163,7 +238,7 @@ func (u *UserRouter) HandleChangeRole(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("userId is required"))
 		return
 	}
-	user, err := dao.First[domain.User]("id = ?", userId)
+	user, err := dao.First[domain.User](ctx.Request.Context(), "id = ?", userId)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
 		return
@@ -171,10 +246,19 @@ func (u *UserRouter) HandleChangeRole(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("user not found"))
 		return
 	}
-	err = dao.Modify(user, "role", role)
+	// 角色必须存在于 user_roles 表中
+	userRole, err := dao.FindOne[domain.UserRole](ctx.Request.Context(), "id = ?", role)
+	if err != nil || userRole == nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid role"))
+		return
+	}
+	err = dao.Modify(ctx.Request.Context(), user, "role", role)
 	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
 		return
 	}
+	user.Role = userRole.ID
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(user))
 }
 
 // HandleCredit godoc
@@ -189,7 +273,7 @@ func (u *UserRouter) HandleChangeRole(ctx *gin.Context) {
 //	@Router			/user/statistic/credit [get]
 func (u *UserRouter) HandleCredit(ctx *gin.Context) {
 	userId := ctx.Query("userId")
-	user, err := dao.First[domain.User]("id = ?", userId)
+	user, err := dao.First[domain.User](ctx.Request.Context(), "id = ?", userId)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
 		return
@@ -267,3 +351,30 @@ func (u *UserRouter) HandleUserRankList(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(users))
 }
+
+// HandleQuality godoc
+//
+//	@Summary		获取标注员的标注质量
+//	@Description	以多人标注的合并结果为参考，汇总标注员在各数据集中的平均 IoU、准确率和召回率，只能查看自己的，平台管理员可以查看所有人
+//	@Tags			user
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		200	{object}	dto.Response{data=domain.UserQuality}
+//	@Router			/user/{id}/quality [get]
+func (u *UserRouter) HandleQuality(ctx *gin.Context) {
+	userID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.NewFailResponse("invalid user id"))
+		return
+	}
+	if uint(userID) != ctx.Keys["id"].(uint) && !middleware.Check(ctx, domain.PermUserManage, 0) {
+		return
+	}
+
+	quality, err := domain.GetUserQuality(ctx.Request.Context(), uint(userID))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.NewFailResponse(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, dto.NewSuccessResponse(quality))
+}
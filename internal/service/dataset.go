@@ -1,7 +1,10 @@
 package service
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
+	"sapphire-server/internal/dao"
 	"sapphire-server/internal/data/dto"
 	"sapphire-server/internal/domain"
 	"sort"
@@ -21,6 +24,12 @@ type DatasetItem struct {
 	EmbeddingUrl string `json:"embeddingUrl"`
 	Status       string `json:"status"`
 	Id           int    `json:"id"`
+	// ThumbnailUrl 和 PreviewUrl 在缩略图生成之前为空，此时使用 ImgUrl
+	ThumbnailUrl string                 `json:"thumbnailUrl"`
+	Thumbnails   map[string]interface{} `json:"thumbnails"`
+	PreviewUrl   string                 `json:"previewUrl"`
+	Width        int                    `json:"width"`
+	Height       int                    `json:"height"`
 }
 
 type DatasetResult struct {
@@ -38,20 +47,21 @@ type DatasetResult struct {
 	EmbeddingCount  int           `json:"embeddingCount"`
 	AnnotationCount int           `json:"annotationCount"`
 	Finished        int           `json:"finished"`
+	// SamID 选用的嵌入模型，为 0 时使用默认模型
+	SamID uint `json:"samId"`
+	// Labels 数据集的标签，Objects 为按相同顺序排列的标签名
+	Labels []domain.Label `json:"labels"`
 }
 
 func NewDatasetResult(dataset *domain.Dataset, isOwner bool, isClaim bool) *DatasetResult {
 	var err error
-	objects := make([]string, 0)
-	if dataset.Tags != "" {
-		tags := strings.Split(dataset.Tags, ",")
-		for _, tag := range tags {
-			if strings.TrimSpace(tag) == "" {
-				continue
-			} else {
-				objects = append(objects, tag)
-			}
-		}
+	labels, err := domain.ListLabels(context.Background(), dataset.ID)
+	if err != nil {
+		return nil
+	}
+	objects := make([]string, len(labels))
+	for i, label := range labels {
+		objects[i] = label.Name
 	}
 
 	allImages, err := datasetDomain.ListImagesByStatusAndDatasetID(dataset.ID, -1)
@@ -69,24 +79,18 @@ func NewDatasetResult(dataset *domain.Dataset, isOwner bool, isClaim bool) *Data
 		return nil
 	}
 
+	statusStr := domain.DatasetStatus(int64(len(allImages)), int64(len(embeddingImages)), int64(len(annotationImages)))
+
 	// 将所有标注完成的图片加入到embeddingImages中
 	embeddingImages = append(embeddingImages, annotationImages...)
 
-	var statusStr string
-	if len(allImages) == len(annotationImages) {
-		statusStr = "annotationSuccess"
-	} else if len(embeddingImages) == len(allImages) {
-		statusStr = "Ready"
-	} else {
-		statusStr = "default"
-	}
-
 	return &DatasetResult{
 		DatasetId:       dataset.ID,
 		DatasetName:     dataset.Name,
 		TaskInfo:        dataset.Description,
 		ObjectCnt:       len(objects),
 		Objects:         objects,
+		Labels:          labels,
 		Owner:           isOwner,
 		Claim:           isClaim,
 		Schedule:        dataset.EndTime.Format("2006-01-02 15:04:05"),
@@ -95,6 +99,7 @@ func NewDatasetResult(dataset *domain.Dataset, isOwner bool, isClaim bool) *Data
 		AnnotationCount: len(annotationImages),
 		Status:          statusStr,
 		Finished:        0,
+		SamID:           dataset.SamID,
 	}
 }
 
@@ -107,6 +112,8 @@ func newDatasetItem(data *domain.ImgDataset) DatasetItem {
 		statusStr = "default"
 	case domain.ImgStatusAnnotated:
 		statusStr = "annotated"
+	case domain.ImgStatusEmbeddingFailed:
+		statusStr = "failed"
 	default:
 		statusStr = "default"
 	}
@@ -116,54 +123,33 @@ func newDatasetItem(data *domain.ImgDataset) DatasetItem {
 		EmbeddingUrl: data.EmbeddingUrl,
 		Status:       statusStr,
 		Id:           int(data.ID),
+		ThumbnailUrl: data.ThumbnailUrl,
+		Thumbnails:   data.Thumbnails,
+		PreviewUrl:   data.PreviewUrl,
+		Width:        data.Width,
+		Height:       data.Height,
 	}
 }
 
 var datasetDomain = domain.NewDatasetDomain()
 
-// GetAllDatasetList 获取数据集列表
-func (s *DatasetService) GetAllDatasetList(userID uint) []*DatasetResult {
+// GetAllDatasetPage 分页获取数据集列表
+func (s *DatasetService) GetAllDatasetPage(userID uint, req dao.PageRequest) ([]*DatasetResult, dto.PageMeta, error) {
 	var err error
-	datasets, err := datasetDomain.GetDatasetList()
+	page, err := datasetDomain.ListDatasetPage(userID, req)
 	if err != nil {
-		// 返回空列表
-		return make([]*DatasetResult, 0)
+		return nil, dto.PageMeta{}, err
 	}
 
-	// 读取用户创建的数据集和加入的数据集
-	userCreatedDatasets, err := datasetDomain.ListUserCreatedDatasets(userID)
-	if err != nil {
-		return make([]*DatasetResult, 0)
-	}
-	userCreatedMap := make(map[int]bool)
-	for _, dataset := range userCreatedDatasets {
-		userCreatedMap[int(dataset.ID)] = true
-	}
-	userJoinedDatasets, err := datasetDomain.ListUserJoinedDatasetList(userID)
-	if err != nil {
-		return make([]*DatasetResult, 0)
-	}
-	userJoinedMap := make(map[int]bool)
-	for _, dataset := range userJoinedDatasets {
-		userJoinedMap[int(dataset.ID)] = true
-	}
-	//isOwner := dataset.CreatorID == userId
-	//isClaim := datasetDomain.IsUserClaimDataset(userId, id)
-	//result := NewDatasetResult(dataset, isOwner, isClaim)
-	//result.Datas = make([]DatasetItem, 0)
-	//for _, data := range datas {
-	//	result.Datas = append(result.Datas, newDatasetItem(&data))
-	//}
-
 	// 构建结果列表
 	results := make([]*DatasetResult, 0)
-	for _, dataset := range datasets {
+	for _, dataset := range page.Items {
 		isOwner := dataset.CreatorID == userID
 		isClaim := datasetDomain.IsUserClaimDataset(userID, dataset.ID)
 		result := NewDatasetResult(&dataset, isOwner, isClaim)
 		results = append(results, result)
 	}
-	return results
+	return results, page.PageMeta, nil
 }
 
 // GetUserCreatedDatasetList 获取用户创建的数据集列表
@@ -255,7 +241,7 @@ func (s *DatasetService) QueryDatasetList(userID uint, query *dto.DatasetQuery)
 		// 对关键字进行分割
 		keywords := strings.Split(query.Keyword, " ")
 		// 查询关键字
-		keyDatasets, err := datasetDomain.ListByKeywords(keywords)
+		keyDatasets, err := datasetDomain.ListByKeywords(userID, keywords)
 		if err != nil {
 			return make([]*DatasetResult, 0)
 		}
@@ -345,12 +331,12 @@ func (s *DatasetService) buildResultList(datasets []domain.Dataset, isOwner bool
 }
 
 // AddImagesByDataset 添加图片到数据集
-func (s *DatasetService) AddImagesByDataset(dto dto.AddImage, userID uint) error {
+func (s *DatasetService) AddImagesByDataset(ctx context.Context, dto dto.AddImage, userID uint) error {
 	var err error
 
 	datasetId := dto.DatasetID
 	images := dto.Images
-	slog.Info("AddImagesByDataset", datasetId, images)
+	slog.Info("AddImagesByDataset", "datasetId", datasetId, "images", images)
 
 	// 获取数据集
 	dataset, err := datasetDomain.GetDatasetByID(datasetId)
@@ -358,20 +344,23 @@ func (s *DatasetService) AddImagesByDataset(dto dto.AddImage, userID uint) error
 		return err
 	}
 	if dataset == nil {
-		slog.Warn("AddImagesByDataset", "dataset not found")
-		return nil
+		slog.Warn("AddImagesByDataset: dataset not found", "datasetId", datasetId)
+		return fmt.Errorf("dataset not found")
 	}
-	slog.Info("AddImagesByDataset", dataset)
+	slog.Info("AddImagesByDataset", "dataset", dataset)
 
 	// 判断用户是否有权限添加图片
-	if dataset.CreatorID != userID {
-		slog.Warn("AddImagesByDataset", "user has no permission")
+	err = domain.Authorize(ctx, userID, datasetId, domain.PermImageWrite)
+	if err != nil {
+		slog.Warn("AddImagesByDataset: user has no permission", "userID", userID, "err", err)
+		return err
 	}
 
-	err = datasetDomain.AddImageList(dataset, images)
+	_, err = datasetDomain.AddImageList(ctx, dataset, images)
 	if err != nil {
 		return err
 	}
+	domain.NotifyDatasetProgress(ctx, datasetId)
 
 	return nil
 }
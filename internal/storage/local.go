@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore 本地文件系统存储，适合离线开发
+type LocalStore struct {
+	Root    string
+	BaseUrl string
+}
+
+func NewLocalStore(root string, baseUrl string) (*LocalStore, error) {
+	if root == "" {
+		root = "./data/storage"
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(abs, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalStore{
+		Root:    abs,
+		BaseUrl: baseUrl,
+	}, nil
+}
+
+// path 将 key 转换为根目录下的路径，拒绝跳出根目录的 key
+func (s *LocalStore) path(key string) (string, error) {
+	clean := filepath.Clean("/" + filepath.FromSlash(key))
+	p := filepath.Join(s.Root, clean)
+	if p == s.Root || !strings.HasPrefix(p, s.Root+string(os.PathSeparator)) {
+		return "", fmt.Errorf("storage: invalid key %q", key)
+	}
+	return p, nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(p), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	// 先写临时文件再重命名，避免读到写了一半的文件
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         info.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(p)),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+func (s *LocalStore) URL(key string) string {
+	return joinURL(s.BaseUrl, key)
+}
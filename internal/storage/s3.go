@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sapphire-server/internal/conf"
+	"sort"
+	"strings"
+	"time"
+)
+
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Store S3 兼容的对象存储，使用 AWS Signature V4 签名
+// 兼容 AWS S3、MinIO、阿里云 OSS 等
+type S3Store struct {
+	Endpoint  *url.URL
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	BaseUrl   string
+	PathStyle bool
+	Client    *http.Client
+}
+
+func NewS3Store(cfg conf.S3StorageConfig) (*S3Store, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("storage: s3 endpoint and bucket are required")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3Store{
+		Endpoint:  endpoint,
+		Region:    region,
+		Bucket:    cfg.Bucket,
+		AccessKey: cfg.AccessKey,
+		SecretKey: cfg.SecretKey,
+		BaseUrl:   cfg.BaseUrl,
+		PathStyle: cfg.PathStyle,
+		Client: &http.Client{
+			Timeout: 5 * time.Minute,
+		},
+	}, nil
+}
+
+// objectURL 返回对象的请求地址
+func (s *S3Store) objectURL(key string) *url.URL {
+	u := *s.Endpoint
+	key = strings.TrimPrefix(key, "/")
+	base := strings.TrimSuffix(u.Path, "/")
+	if s.PathStyle {
+		base += "/" + s.Bucket
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	u.Path = base + "/" + key
+	u.RawPath = s3EscapePath(base) + "/" + s3EscapePath(key)
+	return &u
+}
+
+func (s *S3Store) do(ctx context.Context, method string, key string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	u := s.objectURL(key)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	s.sign(req, time.Now().UTC())
+	return s.Client.Do(req)
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	// S3 的 PUT 必须带 Content-Length，未知大小时先读入内存
+	if size < 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+		size = int64(len(data))
+	}
+
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, r, size, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, -1, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s3Error(resp)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, -1, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, -1, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	info := &ObjectInfo{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.LastModified = modified
+	}
+	return info, nil
+}
+
+func (s *S3Store) URL(key string) string {
+	if s.BaseUrl != "" {
+		return joinURL(s.BaseUrl, key)
+	}
+	return s.objectURL(key).String()
+}
+
+// sign 使用 AWS Signature V4 为请求签名
+// 参考 https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (s *S3Store) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+
+	// 参与签名的请求头
+	var names []string
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "host" || lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name)
+		canonicalHeaders.WriteByte(':')
+		canonicalHeaders.WriteString(strings.TrimSpace(req.Header.Get(name)))
+		canonicalHeaders.WriteByte('\n')
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		s3UnsignedPayload,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+	// Host 由 net/http 根据 URL 自动填写
+	req.Header.Del("Host")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3EscapePath 按 S3 的规则转义对象路径，非保留字符全部转义，保留 /
+func s3EscapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("unexpected status code: %d, %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
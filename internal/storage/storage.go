@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"sapphire-server/internal/conf"
+	"strings"
+	"time"
+)
+
+const (
+	DriverLocal  = "local"
+	DriverWebDAV = "webdav"
+	DriverS3     = "s3"
+)
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("storage: object not found")
+
+// ObjectInfo 对象的元信息
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// Store 对象存储的通用接口
+// key 为对象在存储中的相对路径，不以 / 开头
+type Store interface {
+	// Put 写入对象，size 未知时传 -1
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get 读取对象，调用方负责关闭
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete 删除对象，对象不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// Stat 获取对象信息，对象不存在时返回 ErrNotFound
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// URL 返回对象的外部访问地址
+	URL(key string) string
+}
+
+var Default Store
+
+// InitStorage 根据配置初始化默认存储
+func InitStorage() error {
+	store, err := New(conf.GetStorageConfig(), conf.GetImgConfig())
+	if err != nil {
+		return err
+	}
+	Default = store
+	slog.Info("Storage initialized", "driver", driverName(conf.GetStorageConfig()))
+	return nil
+}
+
+// New 根据配置创建存储驱动
+func New(cfg conf.StorageConfig, img conf.ImgConfig) (Store, error) {
+	switch driverName(cfg) {
+	case DriverLocal:
+		return NewLocalStore(cfg.Local.Root, cfg.Local.BaseUrl)
+	case DriverWebDAV:
+		return NewWebDAVStore(img.SvrUrl, img.DirectUrl, img.Auth), nil
+	case DriverS3:
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("storage: unknown driver %q", cfg.Driver)
+	}
+}
+
+func driverName(cfg conf.StorageConfig) string {
+	// 未配置时沿用原来的图床上传方式
+	if cfg.Driver == "" {
+		return DriverWebDAV
+	}
+	return strings.ToLower(cfg.Driver)
+}
+
+// PutBytes 写入一段字节并返回外部访问地址
+func PutBytes(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	err := Default.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType)
+	if err != nil {
+		return "", err
+	}
+	return Default.URL(key), nil
+}
+
+// AllowedURL 判断服务端是否可以下载该地址，只允许 http(s) 访问存储自身和配置的域名
+func AllowedURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	if Default != nil {
+		if base, err := url.Parse(Default.URL("")); err == nil && strings.EqualFold(base.Host, u.Host) {
+			return true
+		}
+	}
+	for _, host := range conf.GetStorageConfig().FetchHosts {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinURL 拼接地址前缀和 key
+func joinURL(base string, key string) string {
+	if base == "" {
+		return key
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(key, "/")
+}
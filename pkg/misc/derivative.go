@@ -0,0 +1,56 @@
+package misc
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrUnsupportedImage 当前的图片处理后端无法解码该格式
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// ImageInfo 图片的基本信息
+type ImageInfo struct {
+	Width  int
+	Height int
+	// Format 图片格式，如 jpeg、png、webp、bmp
+	Format string
+	Bytes  int64
+}
+
+// ProbeImage 读取图片的格式、宽高和大小，格式按文件头判断
+// 后端无法解码时仍然返回格式和大小，宽高为 0，同时返回 ErrUnsupportedImage
+func ProbeImage(data []byte) (ImageInfo, error) {
+	info := ImageInfo{Bytes: int64(len(data))}
+	contentType, _, _ := SniffImage(data)
+	info.Format = strings.TrimPrefix(contentType, "image/")
+
+	width, height, err := imageSize(data)
+	if err != nil {
+		return info, err
+	}
+	info.Width = width
+	info.Height = height
+	return info, nil
+}
+
+// ResizeImage 等比缩放到 maxWidth x maxHeight 以内，不放大，输出为 JPEG
+// maxWidth 或 maxHeight 为 0 时只限制另一边
+func ResizeImage(data []byte, maxWidth int, maxHeight int, quality int) ([]byte, error) {
+	return resizeImage(data, maxWidth, maxHeight, quality)
+}
+
+// fitSize 计算等比缩放后的尺寸
+func fitSize(width int, height int, maxWidth int, maxHeight int) (int, int) {
+	scale := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		scale = float64(maxWidth) / float64(width)
+	}
+	if maxHeight > 0 && height > maxHeight {
+		if s := float64(maxHeight) / float64(height); s < scale {
+			scale = s
+		}
+	}
+	w := int(float64(width)*scale + 0.5)
+	h := int(float64(height)*scale + 0.5)
+	return max(w, 1), max(h, 1)
+}
@@ -0,0 +1,34 @@
+//go:build bimg
+
+package misc
+
+import (
+	"github.com/h2non/bimg"
+)
+
+// 使用 libvips 处理图片，需要 cgo 和 libvips，通过 -tags bimg 启用
+
+func imageSize(data []byte) (int, int, error) {
+	size, err := bimg.NewImage(data).Size()
+	if err != nil {
+		return 0, 0, ErrUnsupportedImage
+	}
+	return size.Width, size.Height, nil
+}
+
+func resizeImage(data []byte, maxWidth int, maxHeight int, quality int) ([]byte, error) {
+	img := bimg.NewImage(data)
+	size, err := img.Size()
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	width, height := fitSize(size.Width, size.Height, maxWidth, maxHeight)
+	return img.Process(bimg.Options{
+		Width:         width,
+		Height:        height,
+		Quality:       quality,
+		Type:          bimg.JPEG,
+		Interlace:     true,
+		StripMetadata: true,
+	})
+}
@@ -0,0 +1,76 @@
+//go:build !bimg
+
+package misc
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/jpeg"
+)
+
+// 默认使用标准库处理图片，只支持 jpeg、png、gif，不需要 cgo
+// 需要 webp、bmp 等格式时使用 -tags bimg 编译
+
+func imageSize(data []byte) (int, int, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, ErrUnsupportedImage
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+func resizeImage(data []byte, maxWidth int, maxHeight int, quality int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	bounds := src.Bounds()
+	width, height := fitSize(bounds.Dx(), bounds.Dy(), maxWidth, maxHeight)
+
+	// 先转换为 RGBA，便于直接读取像素，透明部分使用白色背景
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Over)
+	dst := boxResize(rgba, width, height)
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// boxResize 区域平均缩小，每个目标像素取对应源区域的平均值
+func boxResize(src *image.RGBA, width int, height int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * sh / height
+		y1 := max((y+1)*sh/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := x * sw / width
+			x1 := max((x+1)*sw/width, x0+1)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				off := sy*src.Stride + x0*4
+				for sx := x0; sx < x1; sx++ {
+					r += int(src.Pix[off])
+					g += int(src.Pix[off+1])
+					b += int(src.Pix[off+2])
+					a += int(src.Pix[off+3])
+					off += 4
+					n++
+				}
+			}
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
@@ -1,70 +1,163 @@
 package misc
 
 import (
-	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
+	"log/slog"
 	"net/http"
-	"regexp"
+	"os"
 	"sapphire-server/internal/conf"
+	"sapphire-server/internal/storage"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func UploadImage(src []byte, fileName string) (string, error) {
-	var err error
-	info := conf.GetImgConfig()
-	re := regexp.MustCompile(`svrUrl: (.*?); directUrl: (.*?); auth string: (.*?);`)
-	matches := re.FindStringSubmatch(info)
-	var svrUrl, directUrl, auth string
-	if len(matches) == 4 {
-		svrUrl = matches[1]
-		directUrl = matches[2]
-		auth = matches[3]
-	} else {
-		fmt.Println("String format is not valid.")
+// UniqueKey 生成带随机部分的对象 key，避免同一秒内上传的同名文件相互覆盖
+func UniqueKey(fileName string) (string, error) {
+	buf := make([]byte, 8)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
 	}
+	return "sapphire_" + strconv.FormatInt(time.Now().Unix(), 10) + "_" + hex.EncodeToString(buf) + "_" + fileName, nil
+}
 
-	filename := "sapphire_" + strconv.FormatInt(time.Now().Unix(), 10) + "_" + fileName
+// UploadImage 上传图片，key 带随机部分，同名文件不会相互覆盖，返回对象的 key 和访问地址
+func UploadImage(src []byte, fileName string) (key string, url string, err error) {
+	key, err = UniqueKey(fileName)
+	if err != nil {
+		return "", "", err
+	}
 
-	fileBytes := src
+	contentType := http.DetectContentType(src)
+	url, err = storage.PutBytes(context.Background(), key, src, contentType)
+	if err != nil {
+		return "", "", err
+	}
+	return key, url, nil
+}
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+// RemoveImages 删除已上传的图片，用于失败时清理
+func RemoveImages(keys []string) {
+	for _, key := range keys {
+		err := storage.Default.Delete(context.Background(), key)
+		if err != nil {
+			slog.Warn("RemoveImages failed", "key", key, "err", err)
+		}
 	}
+}
+
+// ErrImageURLNotAllowed 图片地址不是存储或允许的域名
+var ErrImageURLNotAllowed = errors.New("image url is not allowed")
+
+// ErrImageTooLarge 下载的图片超过单个文件的大小上限
+var ErrImageTooLarge = errors.New("image too large")
+
+// fetchTimeout 下载一张图片的最长时间
+const fetchTimeout = 2 * time.Minute
+
+// fetchClient 跳转后的地址同样需要是允许的域名
+var fetchClient = &http.Client{
+	Timeout: fetchTimeout,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 || !storage.AllowedURL(req.URL.String()) {
+			return fmt.Errorf("%w: redirect to %s", ErrImageURLNotAllowed, req.URL)
+		}
+		return nil
+	},
+}
 
-	req, err := http.NewRequest("PUT", svrUrl+filename, bytes.NewReader(fileBytes))
+// FetchImage 下载图片，大小不超过上传配置中单个文件的上限
+func FetchImage(ctx context.Context, url string) ([]byte, error) {
+	body, err := openImage(ctx, url)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer body.Close()
+	maxSize := conf.GetUploadConfig().MaxFileSize
+	data, err := io.ReadAll(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: %s", ErrImageTooLarge, url)
+	}
+	return data, nil
+}
 
-	req.Header.Set("Authorization", auth)
-
-	// 发送请求
-	resp, err := client.Do(req)
+// FetchImageConfig 读取图片的宽高和格式，只下载解析头部所需的数据
+func FetchImageConfig(ctx context.Context, url string) (image.Config, string, error) {
+	body, err := openImage(ctx, url)
 	if err != nil {
-		return "", err
+		return image.Config{}, "", err
 	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			fmt.Println("Failed to close response body:", err)
-		}
-	}(resp.Body)
+	defer body.Close()
+	return image.DecodeConfig(body)
+}
 
-	// 检查响应状态
-	if resp.StatusCode != http.StatusCreated {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+// openImage 打开图片地址，只允许下载存储和配置的域名，避免服务端请求内网地址
+func openImage(ctx context.Context, url string) (io.ReadCloser, error) {
+	if !storage.AllowedURL(url) {
+		return nil, fmt.Errorf("%w: %s", ErrImageURLNotAllowed, url)
 	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetch image %s: %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// imageTypes 允许上传的图片格式及其扩展名
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+}
 
-	// 读取响应体
-	_, err = io.ReadAll(resp.Body)
+// SniffImage 根据文件头判断图片格式，不信任扩展名
+// 返回 content type 和对应的扩展名，不是允许的格式时 ok 为 false
+func SniffImage(head []byte) (contentType string, ext string, ok bool) {
+	contentType = http.DetectContentType(head)
+	ext, ok = imageTypes[contentType]
+	return contentType, ext, ok
+}
+
+// SniffImageFile 读取文件头判断图片格式
+func SniffImageFile(filePath string) (contentType string, ext string, ok bool, err error) {
+	f, err := os.Open(filePath)
 	if err != nil {
-		return "", err
+		return "", "", false, err
 	}
+	defer f.Close()
 
-	return directUrl + filename, nil
+	// DetectContentType 最多使用前 512 字节
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", "", false, err
+	}
+	contentType, ext, ok = SniffImage(head[:n])
+	return contentType, ext, ok, nil
 }
 
 func getExtension(contentType string) string {
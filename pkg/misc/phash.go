@@ -0,0 +1,126 @@
+package misc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+// phashSize pHash 先缩放到 phashSize x phashSize 的灰度图再做 DCT
+const phashSize = 32
+
+// ContentHash 图片内容的 SHA-256，十六进制
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// PerceptualHash 计算图片的 64 位感知哈希（DCT pHash），十六进制
+// 相似的图片哈希之间的汉明距离小，缩放、重新压缩后基本不变
+func PerceptualHash(data []byte) (string, error) {
+	// 先用图片处理后端缩小，支持的格式与缩略图一致
+	small, err := ResizeImage(data, 256, 256, 90)
+	if err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(bytes.NewReader(small))
+	if err != nil {
+		return "", err
+	}
+
+	gray := grayscale(img, phashSize)
+	coeffs := dct2D(gray, phashSize)
+
+	// 取左上角 8x8 的低频系数，不包括直流分量
+	values := make([]float64, 0, 64)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			values = append(values, coeffs[y*phashSize+x])
+		}
+	}
+	sorted := append([]float64(nil), values[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for i, v := range values {
+		if v > median {
+			hash |= 1 << uint(63-i)
+		}
+	}
+	return fmt.Sprintf("%016x", hash), nil
+}
+
+// HammingDistance 两个感知哈希之间不同的位数，哈希不合法时返回 -1
+func HammingDistance(a string, b string) int {
+	x, err := strconv.ParseUint(a, 16, 64)
+	if err != nil {
+		return -1
+	}
+	y, err := strconv.ParseUint(b, 16, 64)
+	if err != nil {
+		return -1
+	}
+	return bits.OnesCount64(x ^ y)
+}
+
+// grayscale 缩放为 size x size 的灰度图，每个像素取对应区域的平均亮度
+func grayscale(img image.Image, size int) []float64 {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	out := make([]float64, size*size)
+	for y := 0; y < size; y++ {
+		y0 := y * h / size
+		y1 := max((y+1)*h/size, y0+1)
+		for x := 0; x < size; x++ {
+			x0 := x * w / size
+			x1 := max((x+1)*w/size, x0+1)
+			var sum float64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, b, _ := img.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			out[y*size+x] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return out
+}
+
+// dct2D 二维 DCT-II，先对行再对列
+func dct2D(in []float64, n int) []float64 {
+	cos := make([]float64, n*n)
+	for k := 0; k < n; k++ {
+		for i := 0; i < n; i++ {
+			cos[k*n+i] = math.Cos(math.Pi / float64(n) * (float64(i) + 0.5) * float64(k))
+		}
+	}
+	tmp := make([]float64, n*n)
+	for y := 0; y < n; y++ {
+		for k := 0; k < n; k++ {
+			var sum float64
+			for i := 0; i < n; i++ {
+				sum += in[y*n+i] * cos[k*n+i]
+			}
+			tmp[y*n+k] = sum
+		}
+	}
+	out := make([]float64, n*n)
+	for x := 0; x < n; x++ {
+		for k := 0; k < n; k++ {
+			var sum float64
+			for i := 0; i < n; i++ {
+				sum += tmp[i*n+x] * cos[k*n+i]
+			}
+			out[k*n+x] = sum
+		}
+	}
+	return out
+}
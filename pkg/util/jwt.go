@@ -1,41 +1,257 @@
 package util
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/exp/slog"
+	"os"
+	"sapphire-server/internal/conf"
+	"strings"
+	"time"
 )
 
-var JWTSecret = "111"
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+	// TokenTypeStream 只能用于建立事件流连接的短期 token
+	TokenTypeStream = "stream"
 
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 7 * 24 * time.Hour
+	// streamTokenTTL 事件流 token 只在建立连接时校验，断线重连时需要重新获取
+	streamTokenTTL = time.Minute
+)
+
+var (
+	ErrUnknownKey       = errors.New("unknown signing key")
+	ErrInvalidTokenType = errors.New("invalid token type")
+)
+
+// UserClaims 中 ID 为用户 ID，token 自身的 jti 为 RegisteredClaims.ID
 type UserClaims struct {
 	ID uint `json:"user_id"`
+	// Type 区分 access token 和 refresh token
+	Type string `json:"typ"`
 	jwt.RegisteredClaims
 }
 
-// GenerateJWT TODO: 这里肯定不可能只以 user_id 签发 token 的，之后再改
-func GenerateJWT(userID uint) string {
-	claims := UserClaims{
-		ID:               userID,
-		RegisteredClaims: jwt.RegisteredClaims{},
+// SigningKey 一个签名 key，SignKey 为空时只能用于校验
+type SigningKey struct {
+	Kid       string
+	Method    jwt.SigningMethod
+	SignKey   interface{}
+	VerifyKey interface{}
+}
+
+// KeySet 签名 key 集合，新 token 使用 active key 签发，校验时根据 kid 查找 key
+type KeySet struct {
+	Issuer          string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	active          string
+	keys            map[string]*SigningKey
+}
+
+var keySet *KeySet
+
+// InitJWT 根据配置加载签名 key
+func InitJWT(cfg conf.AuthConfig) error {
+	ks, err := NewKeySet(cfg)
+	if err != nil {
+		return err
+	}
+	keySet = ks
+	return nil
+}
+
+func NewKeySet(cfg conf.AuthConfig) (*KeySet, error) {
+	ks := &KeySet{
+		Issuer:          cfg.Issuer,
+		AccessTokenTTL:  cfg.AccessTokenTTL,
+		RefreshTokenTTL: cfg.RefreshTokenTTL,
+		active:          cfg.ActiveKid,
+		keys:            make(map[string]*SigningKey),
+	}
+	if ks.AccessTokenTTL <= 0 {
+		ks.AccessTokenTTL = defaultAccessTokenTTL
+	}
+	if ks.RefreshTokenTTL <= 0 {
+		ks.RefreshTokenTTL = defaultRefreshTokenTTL
+	}
+
+	for _, keyCfg := range cfg.Keys {
+		key, err := loadSigningKey(keyCfg)
+		if err != nil {
+			return nil, fmt.Errorf("load key %s: %w", keyCfg.Kid, err)
+		}
+		ks.keys[key.Kid] = key
+	}
+
+	// 没有配置 key 时生成一个随机 key，重启后之前签发的 token 全部失效
+	if len(ks.keys) == 0 {
+		slog.Warn("No JWT signing key configured, using a random key")
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			return nil, err
+		}
+		ks.active = "random"
+		ks.keys[ks.active] = &SigningKey{
+			Kid:       ks.active,
+			Method:    jwt.SigningMethodHS256,
+			SignKey:   secret,
+			VerifyKey: secret,
+		}
+	}
+	if ks.active == "" && len(cfg.Keys) > 0 {
+		ks.active = cfg.Keys[0].Kid
+	}
+
+	active, ok := ks.keys[ks.active]
+	if !ok {
+		return nil, fmt.Errorf("active key %s not found", ks.active)
+	}
+	if active.SignKey == nil {
+		return nil, fmt.Errorf("active key %s can not sign tokens", ks.active)
+	}
+	return ks, nil
+}
+
+func loadSigningKey(cfg conf.SigningKeyConfig) (*SigningKey, error) {
+	if cfg.Kid == "" {
+		return nil, errors.New("kid is required")
+	}
+	key := &SigningKey{Kid: cfg.Kid}
+
+	switch strings.ToUpper(cfg.Algorithm) {
+	case "", "HS256":
+		if len(cfg.Secret) < 16 {
+			return nil, errors.New("HS256 secret should be at least 16 characters")
+		}
+		key.Method = jwt.SigningMethodHS256
+		key.SignKey = []byte(cfg.Secret)
+		key.VerifyKey = []byte(cfg.Secret)
+	case "RS256":
+		key.Method = jwt.SigningMethodRS256
+		if cfg.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(cfg.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.SignKey = privateKey
+			key.VerifyKey = &privateKey.PublicKey
+		}
+		if cfg.PublicKeyFile != "" {
+			pem, err := os.ReadFile(cfg.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			publicKey, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.VerifyKey = publicKey
+		}
+		if key.VerifyKey == nil {
+			return nil, errors.New("RS256 key needs privateKeyFile or publicKeyFile")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", cfg.Algorithm)
+	}
+	return key, nil
+}
+
+// newTokenID 生成 token 的 jti
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GenerateJWT 使用当前 active key 签发 token
+func GenerateJWT(userID uint, tokenType string) (string, *UserClaims, error) {
+	ttl := keySet.AccessTokenTTL
+	if tokenType == TokenTypeRefresh {
+		ttl = keySet.RefreshTokenTTL
+	}
+	return generateJWT(userID, tokenType, ttl, nil)
+}
+
+// GenerateStreamToken 签发事件流 token，audience 为可以订阅的资源
+func GenerateStreamToken(userID uint, audience string) (string, *UserClaims, error) {
+	return generateJWT(userID, TokenTypeStream, streamTokenTTL, jwt.ClaimStrings{audience})
+}
+
+func generateJWT(userID uint, tokenType string, ttl time.Duration, audience jwt.ClaimStrings) (string, *UserClaims, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	claims := &UserClaims{
+		ID:   userID,
+		Type: tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    keySet.Issuer,
+			Subject:   fmt.Sprintf("%d", userID),
+			Audience:  audience,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	str, err := token.SignedString([]byte(JWTSecret))
+	key := keySet.keys[keySet.active]
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.Kid
+	str, err := token.SignedString(key.SignKey)
 	if err != nil {
-		slog.Warn("GenerateJWT error: ", err)
-		return ""
+		return "", nil, err
 	}
-	return str
+	return str, claims, nil
 }
 
-func ParseJWT(str string) (id uint, err error) {
+// ParseJWT 校验 token 的签名、有效期和类型
+func ParseJWT(str string, tokenType string) (*UserClaims, error) {
+	options := []jwt.ParserOption{jwt.WithExpirationRequired()}
+	if keySet.Issuer != "" {
+		options = append(options, jwt.WithIssuer(keySet.Issuer))
+	}
+
 	token, err := jwt.ParseWithClaims(str, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(JWTSecret), nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keySet.keys[kid]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		// 防止算法混淆攻击
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return key.VerifyKey, nil
+	}, options...)
+	if err != nil {
+		return nil, err
+	}
 
-	if claims, ok := token.Claims.(*UserClaims); ok && token.Valid {
-		return claims.ID, nil
-	} else {
-		return 0, err
+	claims, ok := token.Claims.(*UserClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	if claims.Type != tokenType {
+		return nil, ErrInvalidTokenType
 	}
+	return claims, nil
 }
@@ -8,7 +8,7 @@ import (
 
 func FormatTimeStr(time time.Time) string {
 	sub := time.Sub(time.UTC())
-	slog.Info("sub: ", sub)
+	slog.Info("FormatTimeStr", "sub", sub)
 
 	// 超过一周
 	// 返回"x月x日"
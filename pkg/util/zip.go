@@ -2,31 +2,115 @@ package util
 
 import (
 	"archive/zip"
-	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 )
 
-// Unzip 解压zip文件
-func Unzip(zipData []byte, destDir string) error {
-	zipReader, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+var (
+	ErrTooManyEntries = errors.New("zip: too many entries")
+	ErrArchiveTooBig  = errors.New("zip: uncompressed size exceeds limit")
+)
+
+// UnzipLimits 解压限制，为 0 时不限制
+type UnzipLimits struct {
+	// MaxEntries 压缩包中文件的最大数量，不包括目录
+	MaxEntries int
+	// MaxFileSize 单个文件解压后的最大大小
+	MaxFileSize int64
+	// MaxTotalSize 所有文件解压后的总大小
+	MaxTotalSize int64
+}
+
+// UnzipEntry 压缩包中一个文件的解压结果
+type UnzipEntry struct {
+	// Name 压缩包中的路径
+	Name string
+	// Path 解压后的本地路径，解压失败时为空
+	Path string
+	Size int64
+	// Err 解压失败的原因，不影响其他文件
+	Err error
+}
+
+// Unzip 将 zipPath 流式解压到 destDir，保留子目录
+// 单个文件的问题记录在返回的 UnzipEntry 中，超出数量或总大小限制时返回错误
+func Unzip(zipPath string, destDir string, limits UnzipLimits) ([]UnzipEntry, error) {
+	zr, err := zip.OpenReader(zipPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// 解压读取到的文件
-	for _, f := range zipReader.File {
+	defer zr.Close()
+
+	destDir, err = filepath.Abs(destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []UnzipEntry
+	var total int64
+	for _, f := range zr.File {
 		if isIgnoreFile(f) {
 			continue
 		}
-		err := writeUnzipFile(f, destDir)
+		if limits.MaxEntries > 0 && len(entries) >= limits.MaxEntries {
+			return entries, ErrTooManyEntries
+		}
+
+		entry := UnzipEntry{Name: f.Name}
+		destPath, err := SafeJoin(destDir, f.Name)
 		if err != nil {
-			return err
+			entry.Err = err
+			entries = append(entries, entry)
+			continue
+		}
+
+		if limits.MaxTotalSize > 0 && total >= limits.MaxTotalSize {
+			return entries, ErrArchiveTooBig
+		}
+		// 按实际读取的字节数限制大小，不信任压缩包头部记录的大小
+		maxSize := limits.MaxFileSize
+		if limits.MaxTotalSize > 0 && (maxSize <= 0 || limits.MaxTotalSize-total < maxSize) {
+			maxSize = limits.MaxTotalSize - total
+		}
+		size, err := writeUnzipFile(f, destPath, maxSize)
+		if err != nil {
+			if limits.MaxTotalSize > 0 && total+size > limits.MaxTotalSize {
+				return entries, ErrArchiveTooBig
+			}
+			entry.Err = err
+			entries = append(entries, entry)
+			continue
 		}
+		total += size
+		entry.Path = destPath
+		entry.Size = size
+		entries = append(entries, entry)
 	}
-	slog.Debug("Unzip file success")
-	return nil
+	slog.Debug("Unzip file success", "entries", len(entries), "size", total)
+	return entries, nil
+}
+
+// SafeJoin 将压缩包中的路径拼接到 destDir 下，拒绝绝对路径和跳出 destDir 的路径
+func SafeJoin(destDir string, name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("zip: illegal path %q", name)
+	}
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("zip: illegal path %q", name)
+	}
+	p := filepath.Join(destDir, filepath.FromSlash(clean))
+	if !strings.HasPrefix(p, filepath.Clean(destDir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("zip: illegal path %q", name)
+	}
+	return p, nil
 }
 
 func isIgnoreFile(f *zip.File) bool {
@@ -39,61 +123,49 @@ func isIgnoreFile(f *zip.File) bool {
 	if strings.Contains(f.Name, "__MACOSX") {
 		return true
 	}
+	// macOS 的资源文件，如 ._a.jpg
+	if strings.HasPrefix(path.Base(f.Name), "._") {
+		return true
+	}
+	// 符号链接可能指向解压目录之外
+	if f.Mode()&os.ModeSymlink != 0 {
+		return true
+	}
 	return false
 }
 
-func writeUnzipFile(f *zip.File, destDir string) error {
-	var err error
-	fName := f.Name
-	destPath := destDir + fName
-	println("unzip file: ", fName, " to ", destPath)
-	// 判断文件夹是否存在，主要是处理zip包含多层文件目录的情况
-	//if f.FileInfo().IsDir() && !isFileExist(destPath) {
-	//	err := os.MkdirAll(destPath, os.ModePerm)
-	//	return err
-	//}
-
-	// 创建文件
-	file, err := os.Create(destPath)
+// writeUnzipFile 写入单个文件，maxSize 大于 0 时超过大小返回错误
+func writeUnzipFile(f *zip.File, destPath string, maxSize int64) (int64, error) {
+	// 创建多层目录
+	err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			println("close file error: ", err)
-		}
-	}(file)
 
-	// 打开文件
 	rc, err := f.Open()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer func(rc io.ReadCloser) {
-		err := rc.Close()
-		if err != nil {
-			println("close file error: ", err)
-		}
-	}(rc)
+	defer rc.Close()
 
-	// 写入文件
-	_, err = io.Copy(file, rc)
+	file, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	defer file.Close()
 
-	return nil
+	var r io.Reader = rc
+	if maxSize > 0 {
+		r = io.LimitReader(rc, maxSize+1)
+	}
+	n, err := io.Copy(file, r)
+	if err == nil && maxSize > 0 && n > maxSize {
+		err = fmt.Errorf("zip: %s exceeds size limit", f.Name)
+	}
+	if err != nil {
+		// 删除写了一半的文件
+		os.Remove(destPath)
+		return n, err
+	}
+	return n, nil
 }
-
-//// isFileExist 文件或目录是否存在
-//func isFileExist(filePath string) bool {
-//	_, err := os.Stat(filePath)
-//	if err != nil {
-//		if os.IsExist(err) {
-//			return true
-//		}
-//		return false
-//	}
-//	return true
-//}